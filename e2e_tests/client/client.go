@@ -0,0 +1,199 @@
+// Package client calls the message API for the end-to-end tests. It lives outside the test
+// package so that its own tests run without a deployed API.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPStatusError is returned when the API responds with an unexpected status code
+type HTTPStatusError struct {
+	StatusCode int
+	Expected   int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("expected status code %d, got %d. Response: %s", e.Expected, e.StatusCode, e.Body)
+}
+
+// Message represents a message from the API
+type Message struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Client calls the API at BaseURL
+type Client struct {
+	BaseURL string
+
+	// RetryBackoff is the delay before the first retry; it doubles after each attempt
+	RetryBackoff time.Duration
+
+	// Logf reports progress, typically the running test's t.Logf
+	Logf func(format string, args ...any)
+}
+
+// New returns a client for the API at baseURL that reports progress to logf
+func New(baseURL string, logf func(format string, args ...any)) *Client {
+	return &Client{BaseURL: baseURL, RetryBackoff: 1 * time.Second, Logf: logf}
+}
+
+// CreateMessage creates a new message with the given text
+func (c *Client) CreateMessage(text string) (*Message, error) {
+	c.Logf("Creating message with text: %s", text)
+
+	requestBody, err := json.Marshal(map[string]string{
+		"text": text,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	c.Logf("POST request to: %s/messages", c.BaseURL)
+	c.Logf("Request body: %s", string(requestBody))
+
+	// Create a custom HTTP client
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	req, err := http.NewRequest("POST", c.BaseURL+"/messages", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &HTTPStatusError{
+			StatusCode: resp.StatusCode,
+			Expected:   http.StatusCreated,
+			Body:       string(bodyBytes),
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	c.Logf("Response body: %s", string(body))
+
+	var message Message
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	c.Logf("Created message with ID: %s", message.ID)
+
+	return &message, nil
+}
+
+// CreateMessageWithRetry creates a message, retrying with exponential backoff on transient
+// network errors and 5xx responses. It returns the last error once all attempts are exhausted.
+func (c *Client) CreateMessageWithRetry(text string, attempts int) (*Message, error) {
+	backoff := c.RetryBackoff
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		message, err := c.CreateMessage(text)
+		if err == nil {
+			return message, nil
+		}
+		lastErr = err
+
+		if !isTransientError(err) {
+			return nil, err
+		}
+
+		if attempt < attempts {
+			c.Logf("Attempt %d/%d to create message failed: %v (retrying in %s)", attempt, attempts, err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return nil, fmt.Errorf("failed to create message after %d attempts: %w", attempts, lastErr)
+}
+
+// isTransientError reports whether a failed request is worth retrying
+func isTransientError(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// GetMessages retrieves all messages from the API
+func (c *Client) GetMessages() ([]Message, error) {
+	// Add a cache-busting query parameter to prevent caching
+	cacheBuster := fmt.Sprintf("nocache=%d", time.Now().UnixNano())
+	url := fmt.Sprintf("%s/messages?%s", c.BaseURL, cacheBuster)
+
+	c.Logf("Fetching messages from: %s", url)
+
+	// Create a custom HTTP client with no caching
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+		},
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add headers to prevent caching
+	req.Header.Add("Cache-Control", "no-cache, no-store, must-revalidate")
+	req.Header.Add("Pragma", "no-cache")
+	req.Header.Add("Expires", "0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	c.Logf("Response body: %s", string(body))
+
+	// List responses wrap the messages in an envelope
+	var list struct {
+		Items []Message `json:"items"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	c.Logf("Unmarshalled %d messages", len(list.Items))
+
+	return list.Items, nil
+}
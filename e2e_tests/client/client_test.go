@@ -0,0 +1,84 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCreateMessageWithRetry tests that message creation is retried after a transient failure
+func TestCreateMessageWithRetry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first request, then succeed
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"retry-test","text":"hello"}`))
+	}))
+	defer server.Close()
+
+	api := New(server.URL, t.Logf)
+	api.RetryBackoff = time.Millisecond
+
+	message, err := api.CreateMessageWithRetry("hello", 3)
+	if err != nil {
+		t.Fatalf("Expected message creation to succeed after retry, got: %v", err)
+	}
+	if message.ID != "retry-test" {
+		t.Fatalf("Expected message ID 'retry-test', got '%s'", message.ID)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("Expected 2 requests, got %d", got)
+	}
+
+	// A server that keeps failing exhausts the attempts and returns the last error
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	api.BaseURL = failing.URL
+
+	_, err = api.CreateMessageWithRetry("hello", 2)
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Expected the last %d error after exhausting attempts, got: %v", http.StatusBadGateway, err)
+	}
+
+	// Client errors are not retried
+	requests.Store(0)
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	api.BaseURL = rejecting.URL
+
+	if _, err := api.CreateMessageWithRetry("hello", 3); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a %d error, got: %v", http.StatusBadRequest, err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("Expected 1 request for a client error, got %d", got)
+	}
+}
+
+// TestGetMessagesDecodesList tests that the messages are read from the list envelope
+func TestGetMessagesDecodesList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[{"id":"one","text":"hello"},{"id":"two","text":"world"}],"count":2}`))
+	}))
+	defer server.Close()
+
+	messages, err := New(server.URL, t.Logf).GetMessages()
+	if err != nil {
+		t.Fatalf("Expected messages, got: %v", err)
+	}
+	if len(messages) != 2 || messages[0].ID != "one" || messages[1].Text != "world" {
+		t.Fatalf("Expected messages one and two, got %+v", messages)
+	}
+}
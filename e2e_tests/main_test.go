@@ -1,7 +1,6 @@
 package e2e_tests
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -10,19 +9,15 @@ import (
 	"os"
 	"testing"
 	"time"
+
+	"github.com/awse2e/e2e_tests/client"
 )
 
 var (
-	apiURL = flag.String("api-url", "", "The URL of the API service")
+	apiURL         = flag.String("api-url", "", "The URL of the API service")
+	createAttempts = flag.Int("create-attempts", 3, "Number of attempts when creating a message")
 )
 
-// Message represents a message from the API
-type Message struct {
-	ID        string    `json:"id"`
-	Text      string    `json:"text"`
-	Timestamp time.Time `json:"timestamp"`
-}
-
 func TestMain(m *testing.M) {
 	flag.Parse()
 
@@ -94,21 +89,18 @@ func TestCreateAndGetMessages(t *testing.T) {
 		time.Now().Format(time.RFC3339), randomSuffix)
 
 	t.Logf("Creating message with text: %s", messageText)
-	message, err := createMessage(messageText)
+	api := client.New(*apiURL, t.Logf)
+	message, err := api.CreateMessageWithRetry(messageText, *createAttempts)
 	if err != nil {
 		t.Fatalf("Failed to create message: %v", err)
 	}
 
 	t.Logf("Created message with ID: %s", message.ID)
 
-	// Add a longer delay to ensure the message is stored in DynamoDB
-	// This helps with eventual consistency in distributed systems
-	t.Log("Waiting for message to be stored...")
-	time.Sleep(5 * time.Second) // Increased from 2 to 5 seconds
-
-	// Get all messages
+	// Get all messages. No delay is needed: creation returns after the write is confirmed
+	// and listing uses strongly consistent reads.
 	t.Log("Retrieving all messages...")
-	messages, err := getMessages()
+	messages, err := api.GetMessages()
 	if err != nil {
 		t.Fatalf("Failed to get messages: %v", err)
 	}
@@ -136,7 +128,7 @@ func TestCreateAndGetMessages(t *testing.T) {
 		t.Log("Message not found, waiting longer and trying again...")
 		time.Sleep(5 * time.Second)
 
-		messages, err = getMessages()
+		messages, err = api.GetMessages()
 		if err != nil {
 			t.Fatalf("Failed to get messages on second attempt: %v", err)
 		}
@@ -161,111 +153,3 @@ func TestCreateAndGetMessages(t *testing.T) {
 
 	t.Log("Message creation and retrieval test passed!")
 }
-
-// createMessage creates a new message with the given text
-func createMessage(text string) (*Message, error) {
-	fmt.Printf("Creating message with text: %s\n", text)
-
-	requestBody, err := json.Marshal(map[string]string{
-		"text": text,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %w", err)
-	}
-
-	fmt.Printf("POST request to: %s/messages\n", *apiURL)
-	fmt.Printf("Request body: %s\n", string(requestBody))
-
-	// Create a custom HTTP client
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	req, err := http.NewRequest("POST", *apiURL+"/messages", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("expected status code %d, got %d. Response: %s",
-			http.StatusCreated, resp.StatusCode, string(bodyBytes))
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	fmt.Printf("Response body: %s\n", string(body))
-
-	var message Message
-	if err := json.Unmarshal(body, &message); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-	}
-
-	fmt.Printf("Created message with ID: %s\n", message.ID)
-
-	return &message, nil
-}
-
-// getMessages retrieves all messages from the API
-func getMessages() ([]Message, error) {
-	// Add a cache-busting query parameter to prevent caching
-	cacheBuster := fmt.Sprintf("nocache=%d", time.Now().UnixNano())
-	url := fmt.Sprintf("%s/messages?%s", *apiURL, cacheBuster)
-
-	fmt.Printf("Fetching messages from: %s\n", url)
-
-	// Create a custom HTTP client with no caching
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-		},
-	}
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Add headers to prevent caching
-	req.Header.Add("Cache-Control", "no-cache, no-store, must-revalidate")
-	req.Header.Add("Pragma", "no-cache")
-	req.Header.Add("Expires", "0")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	fmt.Printf("Response body: %s\n", string(body))
-
-	var messages []Message
-	if err := json.Unmarshal(body, &messages); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-	}
-
-	fmt.Printf("Unmarshalled %d messages\n", len(messages))
-
-	return messages, nil
-}
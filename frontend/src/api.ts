@@ -58,9 +58,15 @@ export interface Message {
 }
 
 // API functions
+// List endpoints wrap their items in an envelope
+interface ListResponse<T> {
+  items: T[];
+  count: number;
+}
+
 export const getMessages = async (): Promise<Message[]> => {
-  const response = await api.get<Message[]>('/messages');
-  return response.data;
+  const response = await api.get<ListResponse<Message>>('/messages');
+  return response.data.items;
 };
 
 export const createMessage = async (text: string): Promise<Message> => {
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/aws_e2e_test/msgsvc/internal/config"
+	"github.com/aws_e2e_test/msgsvc/internal/logging"
 	"github.com/aws_e2e_test/msgsvc/internal/msgsvc"
 )
 
@@ -12,8 +13,15 @@ func main() {
 	// Get configuration from environment variables
 	cfg := config.New()
 
+	// Configure debug logging and sampling of high-volume logs
+	logging.Configure(cfg.LogLevel, cfg.LogSampleRate)
+
+	// Record the configuration in effect
+	cfg.LogEffective()
+	cfg.Features.LogEnabledFeatures()
+
 	// Log storage configuration
-	if cfg.UseDynamoDB {
+	if cfg.StorageBackend == "dynamodb" {
 		log.Printf("Storage configuration: DynamoDB (table: %s)", cfg.DynamoDBTableName)
 	} else {
 		log.Printf("Storage configuration: In-memory (local development mode)")
@@ -0,0 +1,80 @@
+package attachment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws_e2e_test/shared/awsutil"
+)
+
+// S3Presigner creates pre-signed S3 PUT URLs for uploading attachments
+type S3Presigner struct {
+	client *s3.PresignClient
+	bucket string
+	region string
+}
+
+// NewS3Presigner creates a new presigner for uploads to the given bucket
+func NewS3Presigner(bucket string) (*S3Presigner, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket name cannot be empty")
+	}
+
+	// Resolve the region consistently with the other AWS clients
+	region := awsutil.ResolveRegion("")
+
+	// Load AWS configuration
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(region),
+	)
+	if err != nil {
+		log.Printf("Failed to load AWS config: %v", err)
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// Fail at startup rather than on the first call if there are no credentials
+	if err := awsutil.CheckCredentials(context.TODO(), cfg); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Initialized S3 presigner for bucket %s in region: %s", bucket, region)
+
+	return &S3Presigner{
+		client: s3.NewPresignClient(s3.NewFromConfig(cfg)),
+		bucket: bucket,
+		region: region,
+	}, nil
+}
+
+// PresignPut returns a URL that allows a PUT of exactly size bytes of contentType to key until
+// it expires. Content-Type and Content-Length are signed, so S3 rejects uploads that differ.
+func (p *S3Presigner) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (string, error) {
+	request, err := p.client.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(p.bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		log.Printf("Failed to presign upload for key %s: %v", key, err)
+		return "", fmt.Errorf("failed to presign upload: %w", err)
+	}
+	return request.URL, nil
+}
+
+// ObjectURL returns the permanent URL of the object with the given key
+func (p *S3Presigner) ObjectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", p.bucket, p.region, strings.Join(segments, "/"))
+}
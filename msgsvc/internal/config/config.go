@@ -1,31 +1,328 @@
 package config
 
 import (
+	"log"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws_e2e_test/shared/awsutil"
 )
 
 // Config holds all configuration for the server
 type Config struct {
-	ServerAddress     string
-	CorsOrigins       string
-	UseDynamoDB       bool
-	DynamoDBTableName string
-	JWKSUrl           string
-	JWTIssuer         string
+	ServerAddress           string
+	CorsOrigins             string
+	Environment             string
+	StorageBackend          string // "memory" or "dynamodb"
+	StoreMetrics            bool
+	DynamoDBTableName       string
+	DynamoDBTablePrefix     string
+	DynamoDBTableSuffix     string
+	DynamoDBAutoCreateTable bool
+	DynamoDBEndpoint        string // local endpoint such as DynamoDB Local; skips the credential check
+
+	// Connection pool of the DynamoDB HTTP client. The SDK keeps only 10 idle connections per
+	// host by default, which causes connection churn under bursty load.
+	DynamoDBHTTPClient awsutil.HTTPClientConfig
+
+	// CORS methods and headers for routes under /messages, and for all other routes
+	CorsMessagesMethods []string
+	CorsMessagesHeaders []string
+	CorsMethods         []string
+	CorsHeaders         []string
+
+	StoreBreakerThreshold int
+	StoreBreakerWindow    time.Duration
+	StoreBreakerCooldown  time.Duration
+	DefaultSort           string
+	TimestampFormat       string
+	JSONCase              string
+	StrictJSON            bool
+	StartupSelfTest       bool
+	JWKSUrl               string
+	JWTIssuer             string
+	JWTSkipIssuerCheck    bool
+	JWKSCABundle          string
+	JWKSCacheTTL          time.Duration
+	JWKSStaleOK           bool
+	JWKSRefreshInterval   time.Duration
+	JWKSWaitTimeout       time.Duration
+	JWKSFetchTimeout      time.Duration
+	DefaultAuth           string
+
+	MaxConcurrentRequests  int
+	MaxReactionsPerMessage int
+	MaxPinnedPerOwner      int
+	EditHistoryLimit       int
+	NormalizeWhitespace    bool
+	EnforceAcceptJSON      bool
+	RequireHTTPS           bool
+	HTTPSRedirect          bool
+
+	// Per-minute request rate limits (0 = off): per user for authenticated requests, per
+	// client IP otherwise
+	RateLimitUserPerMinute int
+	RateLimitUserBurst     int
+	RateLimitIPPerMinute   int
+	RateLimitIPBurst       int
+
+	// Proxies, as IPs or CIDRs, trusted to give the client IP in X-Forwarded-For. Requests from
+	// anywhere else are limited by their own address, so the header cannot be spoofed.
+	TrustedProxies []string
+
+	LogLevel       string
+	LogSampleRate  int
+	LogMaxFieldLen int
+
+	RequestTimeout time.Duration
+	ListScanBudget time.Duration
+
+	AttachmentAllowedHosts []string
+	AttachmentBucket       string
+	AttachmentContentTypes []string
+	AttachmentMaxBytes     int
+	AttachmentURLExpiry    time.Duration
+
+	ModerationWordlist string
+	ModerationFailOpen bool
+
+	Version         string
+	DisableRootInfo bool
+
+	UsersTableName string
+
+	// OwnerCountersTableName is the table of running message counts per owner. The memory
+	// backend keeps its counters in memory instead.
+	OwnerCountersTableName string
+
+	// CounterReconcileInterval is how often the owner counters are corrected against a count
+	// of the stored messages. Zero disables the correction.
+	CounterReconcileInterval time.Duration
+
+	// MaxBatchSize caps the number of items every batch endpoint accepts in one request
+	MaxBatchSize int
+
+	// DisplayNameFallback names owners without a first or last name by their email local part,
+	// or failing that their sub
+	DisplayNameFallback bool
+
+	ShutdownDrainDelay time.Duration
+	ShutdownTimeout    time.Duration
+
+	// ShutdownFlushTimeout bounds how long buffered store writes may take to flush after the
+	// server has stopped
+	ShutdownFlushTimeout time.Duration
+
+	// Features holds every boolean flag. The bool fields above are read from it.
+	Features Features
 }
 
+// defaultTrustedProxies is the CIDR of the VPC in iac/networking.yaml, which the ALB runs in
+var defaultTrustedProxies = []string{"10.0.0.0/16"}
+
 // New returns a new Config struct
 func New() *Config {
+	features := loadFeatures()
 	return &Config{
-		ServerAddress:     getEnv("SERVER_ADDRESS", ":8080"),
-		CorsOrigins:       getEnv("CORS_ORIGINS", "*"),
-		UseDynamoDB:       getEnvBool("USE_DYNAMODB", false),
-		DynamoDBTableName: getEnv("DYNAMODB_TABLE_NAME", "messages"),
-		JWKSUrl:           getEnv("JWKS_URL", ""),
-		JWTIssuer:         getEnv("JWT_ISSUER", ""),
+		ServerAddress:           getEnv("SERVER_ADDRESS", ":8080"),
+		CorsOrigins:             getEnv("CORS_ORIGINS", "*"),
+		CorsMessagesMethods:     getEnvList("CORS_MESSAGES_METHODS", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+		CorsMessagesHeaders:     getEnvList("CORS_MESSAGES_HEADERS", []string{"Origin", "Content-Type", "Authorization"}),
+		CorsMethods:             getEnvList("CORS_METHODS", []string{"GET", "POST", "DELETE", "OPTIONS"}),
+		CorsHeaders:             getEnvList("CORS_HEADERS", []string{"Origin", "Content-Type", "Authorization"}),
+		Environment:             getEnv("ENVIRONMENT", "prod"),
+		StorageBackend:          getStorageBackend(),
+		StoreMetrics:            features.IsEnabled("STORE_METRICS"),
+		DynamoDBTableName:       getEnv("DYNAMODB_TABLE_NAME", "messages"),
+		DynamoDBTablePrefix:     getEnv("DYNAMODB_TABLE_PREFIX", ""),
+		DynamoDBTableSuffix:     getEnv("DYNAMODB_TABLE_SUFFIX", ""),
+		DynamoDBAutoCreateTable: features.IsEnabled("DYNAMODB_AUTO_CREATE_TABLE"),
+		DynamoDBEndpoint:        getEnv("DYNAMODB_ENDPOINT", ""),
+
+		DynamoDBHTTPClient: awsutil.HTTPClientConfig{
+			MaxIdleConns:        getEnvInt("DYNAMODB_MAX_IDLE_CONNS", 100),
+			MaxIdleConnsPerHost: getEnvInt("DYNAMODB_MAX_IDLE_CONNS_PER_HOST", 100),
+			MaxConnsPerHost:     getEnvInt("DYNAMODB_MAX_CONNS_PER_HOST", 0),
+			IdleConnTimeout:     getEnvDuration("DYNAMODB_IDLE_CONN_TIMEOUT", 90*time.Second),
+		},
+
+		StoreBreakerThreshold: getEnvInt("STORE_BREAKER_THRESHOLD", 5),
+		StoreBreakerWindow:    getEnvDuration("STORE_BREAKER_WINDOW", 30*time.Second),
+		StoreBreakerCooldown:  getEnvDuration("STORE_BREAKER_COOLDOWN", 15*time.Second),
+		DefaultSort:           getEnvSortOrder("DEFAULT_SORT", "asc"),
+		TimestampFormat:       getEnv("TIMESTAMP_FORMAT", "rfc3339"),
+		JSONCase:              getEnv("JSON_CASE", "camel"),
+		StrictJSON:            features.IsEnabled("STRICT_JSON"),
+		StartupSelfTest:       features.IsEnabled("STARTUP_SELFTEST"),
+		JWKSUrl:               getEnv("JWKS_URL", ""),
+		JWTIssuer:             getEnv("JWT_ISSUER", ""),
+		JWTSkipIssuerCheck:    features.IsEnabled("JWT_SKIP_ISSUER_CHECK"),
+		JWKSCABundle:          getEnv("JWKS_CA_BUNDLE", ""),
+		JWKSCacheTTL:          getEnvDuration("JWKS_CACHE_TTL", time.Hour),
+		JWKSStaleOK:           features.IsEnabled("JWKS_STALE_OK"),
+		JWKSRefreshInterval:   getEnvDuration("JWKS_BACKGROUND_REFRESH_INTERVAL", 0),
+		JWKSWaitTimeout:       getEnvDuration("JWKS_WAIT_TIMEOUT", 0),
+		JWKSFetchTimeout:      getEnvDuration("JWKS_FETCH_TIMEOUT", 5*time.Second),
+		DefaultAuth:           getEnv("DEFAULT_AUTH", "required"),
+
+		MaxConcurrentRequests:  getEnvInt("MAX_CONCURRENT_REQUESTS", 0),
+		MaxReactionsPerMessage: getEnvInt("MAX_REACTIONS_PER_MESSAGE", 20),
+		MaxPinnedPerOwner:      getEnvInt("MAX_PINNED_PER_OWNER", 10),
+		EditHistoryLimit:       getEnvInt("MESSAGE_EDIT_HISTORY_LIMIT", 10),
+		NormalizeWhitespace:    features.IsEnabled("NORMALIZE_WHITESPACE"),
+		EnforceAcceptJSON:      features.IsEnabled("ENFORCE_ACCEPT_JSON"),
+		RequireHTTPS:           features.IsEnabled("REQUIRE_HTTPS"),
+		HTTPSRedirect:          features.IsEnabled("HTTPS_REDIRECT"),
+
+		RateLimitUserPerMinute: getEnvInt("RATE_LIMIT_USER_PER_MINUTE", 0),
+		RateLimitUserBurst:     getEnvInt("RATE_LIMIT_USER_BURST", 20),
+		RateLimitIPPerMinute:   getEnvInt("RATE_LIMIT_IP_PER_MINUTE", 0),
+		RateLimitIPBurst:       getEnvInt("RATE_LIMIT_IP_BURST", 20),
+		TrustedProxies:         getEnvList("TRUSTED_PROXIES", defaultTrustedProxies),
+
+		LogLevel:       getEnv("LOG_LEVEL", "info"),
+		LogSampleRate:  getEnvInt("LOG_SAMPLE_RATE", 100),
+		LogMaxFieldLen: getEnvInt("LOG_MAX_FIELD_LEN", 120),
+
+		RequestTimeout: getEnvDuration("REQUEST_TIMEOUT", 15*time.Second),
+		ListScanBudget: getEnvDuration("LIST_SCAN_BUDGET", 10*time.Second),
+
+		AttachmentAllowedHosts: getEnvList("ATTACHMENT_ALLOWED_HOSTS", nil),
+		AttachmentBucket:       getEnv("ATTACHMENT_BUCKET", ""),
+		AttachmentContentTypes: getEnvList("ATTACHMENT_CONTENT_TYPES", []string{"image/png", "image/jpeg", "image/gif", "image/webp"}),
+		AttachmentMaxBytes:     getEnvInt("ATTACHMENT_MAX_BYTES", 5*1024*1024),
+		AttachmentURLExpiry:    getEnvDuration("ATTACHMENT_URL_EXPIRY", 5*time.Minute),
+
+		ModerationWordlist: getEnv("MODERATION_WORDLIST", ""),
+		ModerationFailOpen: features.IsEnabled("MODERATION_FAIL_OPEN"),
+
+		Version:         getEnv("SERVICE_VERSION", "dev"),
+		DisableRootInfo: features.IsEnabled("DISABLE_ROOT_INFO"),
+
+		UsersTableName: getEnv("USERS_TABLE_NAME", ""),
+
+		OwnerCountersTableName:   getEnv("OWNER_COUNTERS_TABLE_NAME", ""),
+		CounterReconcileInterval: getEnvDuration("COUNTER_RECONCILE_INTERVAL", time.Hour),
+
+		MaxBatchSize: getEnvInt("MAX_BATCH_SIZE", 25),
+
+		DisplayNameFallback: features.IsEnabled("DISPLAY_NAME_FALLBACK"),
+
+		// Long enough for the ALB to see /ready fail: two failed checks 5s apart, plus up to one
+		// interval before the first
+		ShutdownDrainDelay: getEnvDuration("SHUTDOWN_DRAIN_DELAY", 15*time.Second),
+		ShutdownTimeout:    getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+
+		ShutdownFlushTimeout: getEnvDuration("SHUTDOWN_FLUSH_TIMEOUT", 5*time.Second),
+
+		Features: features,
 	}
 }
 
+// LogEffective logs every resolved setting at startup, so there is a record of the
+// configuration actually in effect. Values that may carry credentials are redacted.
+func (c *Config) LogEffective() {
+	c.logEffective(log.Default())
+}
+
+// logEffective writes the resolved settings to logger
+func (c *Config) logEffective(logger *log.Logger) {
+	settings := []struct {
+		name  string
+		value interface{}
+	}{
+		{"ServerAddress", c.ServerAddress},
+		{"CorsOrigins", c.CorsOrigins},
+		{"CorsMessagesMethods", c.CorsMessagesMethods},
+		{"CorsMessagesHeaders", c.CorsMessagesHeaders},
+		{"CorsMethods", c.CorsMethods},
+		{"CorsHeaders", c.CorsHeaders},
+		{"Environment", c.Environment},
+		{"StorageBackend", c.StorageBackend},
+		{"StoreMetrics", c.StoreMetrics},
+		{"DynamoDBTableName", c.DynamoDBTableName},
+		{"DynamoDBTablePrefix", c.DynamoDBTablePrefix},
+		{"DynamoDBTableSuffix", c.DynamoDBTableSuffix},
+		{"DynamoDBAutoCreateTable", c.DynamoDBAutoCreateTable},
+		{"DynamoDBEndpoint", c.DynamoDBEndpoint},
+		{"DynamoDBMaxIdleConns", c.DynamoDBHTTPClient.MaxIdleConns},
+		{"DynamoDBMaxIdleConnsPerHost", c.DynamoDBHTTPClient.MaxIdleConnsPerHost},
+		{"DynamoDBMaxConnsPerHost", c.DynamoDBHTTPClient.MaxConnsPerHost},
+		{"DynamoDBIdleConnTimeout", c.DynamoDBHTTPClient.IdleConnTimeout},
+		{"StoreBreakerThreshold", c.StoreBreakerThreshold},
+		{"StoreBreakerWindow", c.StoreBreakerWindow},
+		{"StoreBreakerCooldown", c.StoreBreakerCooldown},
+		{"DefaultSort", c.DefaultSort},
+		{"TimestampFormat", c.TimestampFormat},
+		{"JSONCase", c.JSONCase},
+		{"StrictJSON", c.StrictJSON},
+		{"StartupSelfTest", c.StartupSelfTest},
+		{"JWKSUrl", redactURL(c.JWKSUrl)},
+		{"JWTIssuer", redactURL(c.JWTIssuer)},
+		{"JWTSkipIssuerCheck", c.JWTSkipIssuerCheck},
+		{"JWKSCABundle", c.JWKSCABundle},
+		{"JWKSCacheTTL", c.JWKSCacheTTL},
+		{"JWKSStaleOK", c.JWKSStaleOK},
+		{"JWKSRefreshInterval", c.JWKSRefreshInterval},
+		{"JWKSWaitTimeout", c.JWKSWaitTimeout},
+		{"JWKSFetchTimeout", c.JWKSFetchTimeout},
+		{"DefaultAuth", c.DefaultAuth},
+		{"MaxConcurrentRequests", c.MaxConcurrentRequests},
+		{"MaxReactionsPerMessage", c.MaxReactionsPerMessage},
+		{"MaxPinnedPerOwner", c.MaxPinnedPerOwner},
+		{"EditHistoryLimit", c.EditHistoryLimit},
+		{"NormalizeWhitespace", c.NormalizeWhitespace},
+		{"EnforceAcceptJSON", c.EnforceAcceptJSON},
+		{"RequireHTTPS", c.RequireHTTPS},
+		{"HTTPSRedirect", c.HTTPSRedirect},
+		{"RateLimitUserPerMinute", c.RateLimitUserPerMinute},
+		{"RateLimitUserBurst", c.RateLimitUserBurst},
+		{"RateLimitIPPerMinute", c.RateLimitIPPerMinute},
+		{"RateLimitIPBurst", c.RateLimitIPBurst},
+		{"TrustedProxies", c.TrustedProxies},
+		{"LogLevel", c.LogLevel},
+		{"LogSampleRate", c.LogSampleRate},
+		{"LogMaxFieldLen", c.LogMaxFieldLen},
+		{"RequestTimeout", c.RequestTimeout},
+		{"ListScanBudget", c.ListScanBudget},
+		{"AttachmentAllowedHosts", c.AttachmentAllowedHosts},
+		{"AttachmentBucket", c.AttachmentBucket},
+		{"AttachmentContentTypes", c.AttachmentContentTypes},
+		{"AttachmentMaxBytes", c.AttachmentMaxBytes},
+		{"AttachmentURLExpiry", c.AttachmentURLExpiry},
+		{"ModerationWordlist", c.ModerationWordlist},
+		{"ModerationFailOpen", c.ModerationFailOpen},
+		{"Version", c.Version},
+		{"DisableRootInfo", c.DisableRootInfo},
+		{"UsersTableName", c.UsersTableName},
+		{"OwnerCountersTableName", c.OwnerCountersTableName},
+		{"CounterReconcileInterval", c.CounterReconcileInterval},
+		{"MaxBatchSize", c.MaxBatchSize},
+		{"DisplayNameFallback", c.DisplayNameFallback},
+		{"ShutdownDrainDelay", c.ShutdownDrainDelay},
+		{"ShutdownTimeout", c.ShutdownTimeout},
+		{"ShutdownFlushTimeout", c.ShutdownFlushTimeout},
+	}
+	for _, setting := range settings {
+		logger.Printf("CONFIG: %s=%v", setting.name, setting.value)
+	}
+}
+
+// redactURL removes any credentials embedded in a URL
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(unparseable URL redacted)"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	return u.String()
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -35,11 +332,92 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
-// getEnvBool gets an environment variable as a boolean or returns a default value
+// getEnvBool gets an environment variable as a boolean ("true", "1", "yes" and so on) or returns
+// a default value
 func getEnvBool(key string, defaultValue bool) bool {
 	value := os.Getenv(key)
 	if value == "" {
 		return defaultValue
 	}
-	return value == "true" || value == "1" || value == "yes"
+	switch strings.ToLower(value) {
+	case "yes":
+		return true
+	case "no":
+		return false
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("WARNING: Invalid %s value: %s, defaulting to %t", key, value, defaultValue)
+		return defaultValue
+	}
+	return boolValue
+}
+
+// getEnvInt gets an environment variable as an integer or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("WARNING: Invalid %s value: %s, defaulting to %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return intValue
+}
+
+// getEnvDuration gets an environment variable as a duration (e.g. "15s") or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("WARNING: Invalid %s value: %s, defaulting to %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+// getEnvList gets an environment variable as a comma-separated list of lowercase values
+// or returns a default value
+func getEnvList(key string, defaultValue []string) []string {
+	if os.Getenv(key) == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getStorageBackend returns STORAGE_BACKEND, or the backend selected by the deprecated
+// USE_DYNAMODB flag when it is not set
+func getStorageBackend() string {
+	if backend := os.Getenv("STORAGE_BACKEND"); backend != "" {
+		return strings.ToLower(backend)
+	}
+	if os.Getenv("USE_DYNAMODB") != "" {
+		log.Printf("WARNING: USE_DYNAMODB is deprecated, set STORAGE_BACKEND=dynamodb or STORAGE_BACKEND=memory instead")
+	}
+	if getEnvBool("USE_DYNAMODB", false) {
+		return "dynamodb"
+	}
+	return "memory"
+}
+
+// getEnvSortOrder gets an environment variable as a sort order ("asc" or "desc") or returns a default value
+func getEnvSortOrder(key, defaultValue string) string {
+	value := getEnv(key, defaultValue)
+	if value != "asc" && value != "desc" {
+		log.Printf("WARNING: Invalid %s value: %s, defaulting to %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return value
 }
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"log"
+	"strings"
+)
+
+// featureFlags lists every boolean flag read from the environment, with its default. New flags
+// belong here rather than in their own getEnvBool call, so that they are all parsed the same
+// way and listed in one place.
+var featureFlags = []struct {
+	name         string
+	defaultValue bool
+}{
+	{"STORE_METRICS", false},
+	{"DYNAMODB_AUTO_CREATE_TABLE", true},
+	{"STRICT_JSON", false},
+	{"STARTUP_SELFTEST", false},
+	{"JWT_SKIP_ISSUER_CHECK", false},
+	{"JWKS_STALE_OK", true},
+	{"NORMALIZE_WHITESPACE", false},
+	{"ENFORCE_ACCEPT_JSON", false},
+	{"REQUIRE_HTTPS", false},
+	{"HTTPS_REDIRECT", false},
+	{"MODERATION_FAIL_OPEN", false},
+	{"DISABLE_ROOT_INFO", false},
+	{"DISPLAY_NAME_FALLBACK", true},
+}
+
+// Features holds the boolean flags in featureFlags, by environment variable name
+type Features struct {
+	enabled map[string]bool
+}
+
+// loadFeatures reads every flag in featureFlags from the environment
+func loadFeatures() Features {
+	features := Features{enabled: make(map[string]bool, len(featureFlags))}
+	for _, flag := range featureFlags {
+		features.enabled[flag.name] = getEnvBool(flag.name, flag.defaultValue)
+	}
+	return features
+}
+
+// IsEnabled reports whether the flag with the given environment variable name is on. Names not
+// in featureFlags are always off.
+func (f Features) IsEnabled(name string) bool {
+	return f.enabled[name]
+}
+
+// LogEnabledFeatures logs the flags that are on at startup
+func (f Features) LogEnabledFeatures() {
+	f.logEnabled(log.Default())
+}
+
+// logEnabled writes the flags that are on to logger, in the order of featureFlags
+func (f Features) logEnabled(logger *log.Logger) {
+	var names []string
+	for _, flag := range featureFlags {
+		if f.enabled[flag.name] {
+			names = append(names, flag.name)
+		}
+	}
+	if len(names) == 0 {
+		logger.Printf("FEATURES: none enabled")
+		return
+	}
+	logger.Printf("FEATURES: %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	debugEnabled atomic.Bool
+	sampleRate   atomic.Int64
+)
+
+func init() {
+	sampleRate.Store(1)
+}
+
+// Configure sets the log level ("debug" enables debug logs; anything else logs at info)
+// and the sample rate for sampled debug logs (every Nth entry is logged)
+func Configure(level string, rate int) {
+	debugEnabled.Store(strings.EqualFold(level, "debug"))
+	if rate < 1 {
+		rate = 1
+	}
+	sampleRate.Store(int64(rate))
+}
+
+// DebugEnabled reports whether debug logging is enabled
+func DebugEnabled() bool {
+	return debugEnabled.Load()
+}
+
+// Debugf logs a message when debug logging is enabled
+func Debugf(format string, args ...interface{}) {
+	if debugEnabled.Load() {
+		log.Printf("DEBUG: "+format, args...)
+	}
+}
+
+// SampledDebugf logs a message for every Nth index when debug logging is enabled.
+// It is intended for per-item logs inside loops over large result sets.
+func SampledDebugf(index int, format string, args ...interface{}) {
+	if debugEnabled.Load() && int64(index)%sampleRate.Load() == 0 {
+		log.Printf("DEBUG: "+format, args...)
+	}
+}
@@ -1,23 +1,119 @@
 package model
 
 import (
+	"maps"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/aws_e2e_test/shared/httputil"
 	"github.com/google/uuid"
 )
 
 // Message represents a message in the system
 type Message struct {
-	ID        string    `json:"id"`
-	Text      string    `json:"text"`
-	Timestamp time.Time `json:"timestamp"`
+	ID        string             `json:"id"`
+	Text      string             `json:"text"`
+	Owner     string             `json:"owner"`
+	ParentID  string             `json:"parentId,omitempty" dynamodbav:",omitempty"`
+	Pinned    bool               `json:"pinned"`
+	Reactions map[string]int     `json:"reactions"`
+	Timestamp httputil.Timestamp `json:"timestamp"`
+
+	// AttachmentURL is an optional link to an image attached to the message
+	AttachmentURL string `json:"attachmentUrl,omitempty" dynamodbav:",omitempty"`
+
+	// ContentType tells clients how to render the text, one of ContentTypes
+	ContentType string `json:"contentType"`
+
+	// TextLower is Text in lower case, for prefix search. It is the sort key of the DynamoDB
+	// text index and is kept in sync with Text by the stores.
+	TextLower string `json:"-" dynamodbav:",omitempty"`
+
+	// EditHistory holds the most recent prior versions of the text, oldest first. It is served
+	// separately by the history endpoint rather than with every message.
+	EditHistory []MessageEdit `json:"-" dynamodbav:",omitempty"`
 }
 
-// NewMessage creates a new message with the given text
-func NewMessage(text string) *Message {
+// MessageEdit is a prior version of a message's text, replaced by an edit at EditedAt
+type MessageEdit struct {
+	Text     string             `json:"text"`
+	EditedAt httputil.Timestamp `json:"editedAt"`
+}
+
+// Content types of message text. The server only records the type; clients render the text.
+const (
+	ContentTypePlain    = "text/plain"
+	ContentTypeMarkdown = "text/markdown"
+)
+
+// ContentTypes are the content types a message may have
+var ContentTypes = []string{ContentTypePlain, ContentTypeMarkdown}
+
+// maxTextLowerBytes is the longest TextLower stored. DynamoDB limits index sort keys to 1024
+// bytes, and a prefix search never needs more than the start of the text.
+const maxTextLowerBytes = 1024
+
+// LowerText returns the TextLower of the given text: the text in lower case, cut to at most
+// maxTextLowerBytes without splitting a character
+func LowerText(text string) string {
+	lower := strings.ToLower(text)
+	if len(lower) <= maxTextLowerBytes {
+		return lower
+	}
+	cut := maxTextLowerBytes
+	for cut > 0 && !utf8.RuneStart(lower[cut]) {
+		cut--
+	}
+	return lower[:cut]
+}
+
+// NewMessage creates a new message with the given text and owner.
+// parentID is the ID of the message being replied to, or empty for a top-level message.
+func NewMessage(text, owner, parentID string) *Message {
 	return &Message{
-		ID:        uuid.New().String(),
-		Text:      text,
-		Timestamp: time.Now(),
+		ID:          uuid.New().String(),
+		Text:        text,
+		TextLower:   LowerText(text),
+		Owner:       owner,
+		ParentID:    parentID,
+		Reactions:   make(map[string]int),
+		Timestamp:   httputil.Timestamp(time.Now().UTC()),
+		ContentType: ContentTypePlain,
+	}
+}
+
+// Clone returns a copy of the message that shares no reactions or edit history with it
+func (m *Message) Clone() *Message {
+	clone := *m
+	clone.Reactions = maps.Clone(m.Reactions)
+	clone.EditHistory = slices.Clone(m.EditHistory)
+	return &clone
+}
+
+// MessageOwner identifies the owner of a message along with their display name
+type MessageOwner struct {
+	Sub  string `json:"sub"`
+	Name string `json:"name,omitempty"`
+}
+
+// MessageWithOwner is a message whose owner is expanded from the flat sub to a MessageOwner
+type MessageWithOwner struct {
+	*Message
+	Owner MessageOwner `json:"owner"`
+}
+
+// MinimalMessage is the lean representation of a message, for bandwidth-constrained clients
+type MinimalMessage struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// ToMinimal converts a Message to a MinimalMessage
+func (m *Message) ToMinimal() *MinimalMessage {
+	return &MinimalMessage{
+		ID:   m.ID,
+		Text: m.Text,
 	}
 }
@@ -0,0 +1,81 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Moderator checks whether message text may be stored
+type Moderator interface {
+	// Check reports whether text is allowed and, if not, the reason. An error means the check
+	// could not be performed.
+	Check(ctx context.Context, text string) (allowed bool, reason string, err error)
+}
+
+// AllowAll is a Moderator that allows all content
+type AllowAll struct{}
+
+// Check allows all content
+func (AllowAll) Check(context.Context, string) (bool, string, error) {
+	return true, "", nil
+}
+
+// WordlistModerator is a Moderator that rejects text containing any word from a list.
+// Matching is case-insensitive and on whole words.
+type WordlistModerator struct {
+	words map[string]struct{}
+}
+
+// NewWordlistModerator creates a moderator that rejects text containing any of the given words
+func NewWordlistModerator(words []string) *WordlistModerator {
+	m := &WordlistModerator{words: make(map[string]struct{}, len(words))}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" {
+			m.words[word] = struct{}{}
+		}
+	}
+	return m
+}
+
+// LoadWordlistModerator creates a moderator from a file with one word per line.
+// Blank lines and lines starting with # are ignored.
+func LoadWordlistModerator(path string) (*WordlistModerator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open moderation wordlist: %w", err)
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read moderation wordlist: %w", err)
+	}
+
+	return NewWordlistModerator(words), nil
+}
+
+// Check rejects text containing a word from the list
+func (m *WordlistModerator) Check(_ context.Context, text string) (bool, string, error) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if _, blocked := m.words[word]; blocked {
+			return false, "Message contains disallowed language", nil
+		}
+	}
+	return true, "", nil
+}
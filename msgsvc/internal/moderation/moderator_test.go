@@ -0,0 +1,41 @@
+package moderation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWordlistModerator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wordlist.txt")
+	if err := os.WriteFile(path, []byte("# blocked words\ndarn\n\nHeck\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadWordlistModerator(path)
+	if err != nil {
+		t.Fatalf("LoadWordlistModerator: %v", err)
+	}
+
+	tests := []struct {
+		text    string
+		allowed bool
+	}{
+		{"Hello, world!", true},
+		{"Darndest thing", true},
+		{"Well, darn.", false},
+		{"What the HECK", false},
+	}
+	for _, tt := range tests {
+		allowed, reason, err := m.Check(context.Background(), tt.text)
+		if err != nil {
+			t.Fatalf("Check(%q): %v", tt.text, err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("Check(%q) allowed = %v, want %v", tt.text, allowed, tt.allowed)
+		}
+		if !allowed && reason == "" {
+			t.Errorf("Check(%q) returned no reason", tt.text)
+		}
+	}
+}
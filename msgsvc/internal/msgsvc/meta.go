@@ -0,0 +1,173 @@
+package msgsvc
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+// limitParam describes the limit query parameter of a list endpoint. The handlers parse limits
+// with it and /_meta describes them from it, so the two cannot drift apart.
+type limitParam struct {
+	Default int
+	Max     int
+}
+
+var (
+	// topOwnersLimit is the limit accepted by the top owners endpoint
+	topOwnersLimit = limitParam{Default: 10, Max: 100}
+
+	// recentMessagesLimit is the limit accepted by the recent messages endpoint
+	recentMessagesLimit = limitParam{Default: 50, Max: 200}
+
+	// prefixSearchLimit is the limit accepted by GET /messages with prefix
+	prefixSearchLimit = limitParam{Default: 50, Max: 200}
+
+	// snapshotPageLimit is the page size accepted by GET /messages when paging a snapshot
+	snapshotPageLimit = limitParam{Default: 50, Max: 200}
+
+	// recentFeedLimit is the number of messages accepted by GET /messages/recent, as n
+	recentFeedLimit = limitParam{Default: 50, Max: 200}
+)
+
+// parse returns the limit query parameter, or its default if absent. If it is not a number in
+// range, parse writes a 400 response and returns false.
+func (p limitParam) parse(c *gin.Context) (int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(p.Default)))
+	if err != nil || limit < 1 || limit > p.Max {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_LIMIT", fmt.Sprintf("Invalid limit, expected a number from 1 to %d", p.Max))
+		return 0, false
+	}
+	return limit, true
+}
+
+// clamp returns the named query parameter clamped to 1..Max, or the default if absent. If it is
+// not a number, clamp writes a 400 response and returns false.
+func (p limitParam) clamp(c *gin.Context, name string) (int, bool) {
+	value, err := strconv.Atoi(c.DefaultQuery(name, strconv.Itoa(p.Default)))
+	if err != nil {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_PARAMETER", fmt.Sprintf("Invalid %s, expected a number", name))
+		return 0, false
+	}
+	return min(max(value, 1), p.Max), true
+}
+
+// describe returns the description of the parameter served by /_meta
+func (p limitParam) describe() metaParam {
+	return metaParam{
+		Name:        "limit",
+		Type:        "integer",
+		Default:     strconv.Itoa(p.Default),
+		Min:         1,
+		Max:         p.Max,
+		Description: "Maximum number of results",
+	}
+}
+
+// messagesLimitParam describes the limit of GET /messages, which limits prefix results or sets
+// the page size of a snapshot. Both use the same default and maximum.
+func messagesLimitParam() metaParam {
+	param := prefixSearchLimit.describe()
+	param.Description = "Maximum number of results with prefix, or page size when paging a snapshot"
+	return param
+}
+
+// recentFeedParam describes the n parameter of GET /messages/recent, which is clamped to its
+// range rather than rejected
+func recentFeedParam() metaParam {
+	param := recentFeedLimit.describe()
+	param.Name = "n"
+	param.Description = "Number of newest messages, clamped to the range"
+	return param
+}
+
+// messageViews are the response shapes accepted by the view parameter of GET /messages
+var messageViews = []string{"full", "minimal"}
+
+// metaEndpoint describes a list endpoint and the query parameters it supports
+type metaEndpoint struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Order  string      `json:"order"`
+	Params []metaParam `json:"params"`
+}
+
+// metaParam describes a query parameter of a list endpoint
+type metaParam struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Default     string   `json:"default,omitempty"`
+	Min         int      `json:"min,omitempty"`
+	Max         int      `json:"max,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	Description string   `json:"description"`
+}
+
+// listEndpoints describes the list endpoints, from the same values the handlers use
+func (s *Server) listEndpoints() []metaEndpoint {
+	return []metaEndpoint{
+		{
+			Method: http.MethodGet,
+			Path:   "/messages",
+			Order:  "pinned first, then timestamp " + s.config.DefaultSort + " (timestamp asc with since, text asc with prefix, timestamp " + s.config.DefaultSort + " when paging)",
+			Params: []metaParam{
+				{Name: "view", Type: "string", Default: messageViews[0], Values: slices.Clone(messageViews), Description: "Response shape"},
+				{Name: "since", Type: "timestamp", Max: maxMessagesSince, Description: "Only messages newer than this RFC 3339 timestamp, at most max per request"},
+				{Name: "prefix", Type: "string", Description: "Only messages whose text starts with this, ignoring case"},
+				messagesLimitParam(),
+				{Name: "snapshotAt", Type: "timestamp", Description: "Page through only messages at or before this RFC 3339 timestamp (default: request time)"},
+				{Name: "cursor", Type: "string", Description: "Resume paging from the X-Next-Cursor of the previous page"},
+				{Name: "topLevelOnly", Type: "boolean", Default: "false", Description: "Exclude replies"},
+			},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/messages/recent",
+			Order:  "timestamp desc",
+			Params: []metaParam{recentFeedParam()},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/messages/:id/replies",
+			Order:  "timestamp " + s.config.DefaultSort,
+			Params: []metaParam{},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/messages/:id/history",
+			Order:  "edit time asc",
+			Params: []metaParam{},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/admin/messages/top-owners",
+			Order:  "message count desc",
+			Params: []metaParam{topOwnersLimit.describe()},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/admin/messages/recent",
+			Order:  "timestamp desc",
+			Params: []metaParam{recentMessagesLimit.describe()},
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/admin/messages/daily",
+			Order:  "date asc",
+			Params: []metaParam{
+				{Name: "from", Type: "date", Description: fmt.Sprintf("First UTC day counted, YYYY-MM-DD (default: %d days ending on to), at most max days before to", defaultDailyCountDays), Max: maxDailyCountDays},
+				{Name: "to", Type: "date", Description: "Last UTC day counted, YYYY-MM-DD (default: today)"},
+			},
+		},
+	}
+}
+
+// getMeta describes the list endpoints and their query parameters, so clients can discover
+// limits and options. It is only registered in the dev environment.
+func (s *Server) getMeta(c *gin.Context) {
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"endpoints": s.listEndpoints()})
+}
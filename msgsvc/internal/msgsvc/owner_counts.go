@@ -0,0 +1,217 @@
+package msgsvc
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/msgsvc/internal/store"
+)
+
+// OwnerCounterStore is an interface for running message counts per owner
+type OwnerCounterStore interface {
+	Add(ctx context.Context, owner string, delta int64) error
+	GetAll(ctx context.Context) (map[string]int64, error)
+	Top(ctx context.Context, limit int) ([]store.OwnerCount, error)
+	Set(ctx context.Context, owner string, expected, count int64) (bool, error)
+}
+
+// countingStore is a MessageStore decorator that keeps a running message count per owner, so
+// that CountByOwner reads the counters instead of every message. The counters are updated after
+// the write they follow, so a failure in between leaves them off until the next reconcile.
+type countingStore struct {
+	next     MessageStore
+	counters OwnerCounterStore
+}
+
+// Unwrap returns the decorated store
+func (c *countingStore) Unwrap() MessageStore {
+	return c.next
+}
+
+func (c *countingStore) GetAll(ctx context.Context) ([]*model.Message, error) {
+	return c.next.GetAll(ctx)
+}
+
+func (c *countingStore) GetAllSorted(ctx context.Context) ([]*model.Message, error) {
+	return c.next.GetAllSorted(ctx)
+}
+
+func (c *countingStore) GetAllWithBudget(ctx context.Context, startCursor string, maxDuration time.Duration) ([]*model.Message, bool, string, error) {
+	return c.next.GetAllWithBudget(ctx, startCursor, maxDuration)
+}
+
+func (c *countingStore) GetSince(ctx context.Context, since time.Time, limit int32) ([]*model.Message, error) {
+	return c.next.GetSince(ctx, since, limit)
+}
+
+func (c *countingStore) GetRecent(ctx context.Context, limit int32) ([]*model.Message, error) {
+	return c.next.GetRecent(ctx, limit)
+}
+
+func (c *countingStore) GetByPrefix(ctx context.Context, prefix string, limit int32) ([]*model.Message, error) {
+	return c.next.GetByPrefix(ctx, prefix, limit)
+}
+
+func (c *countingStore) GetPage(ctx context.Context, snapshotAt time.Time, cursor string, limit int32) ([]*model.Message, string, error) {
+	return c.next.GetPage(ctx, snapshotAt, cursor, limit)
+}
+
+func (c *countingStore) GetByID(ctx context.Context, id string) (*model.Message, error) {
+	return c.next.GetByID(ctx, id)
+}
+
+func (c *countingStore) GetByIDs(ctx context.Context, ids []string) ([]*model.Message, error) {
+	return c.next.GetByIDs(ctx, ids)
+}
+
+func (c *countingStore) Exists(ctx context.Context, id string) (bool, error) {
+	return c.next.Exists(ctx, id)
+}
+
+func (c *countingStore) GetReplies(ctx context.Context, parentID string) ([]*model.Message, error) {
+	return c.next.GetReplies(ctx, parentID)
+}
+
+// Add stores the message, then counts it for its owner. A failed count is logged rather than
+// returned, since the message is already stored.
+func (c *countingStore) Add(ctx context.Context, message *model.Message) error {
+	if err := c.next.Add(ctx, message); err != nil {
+		return err
+	}
+	if err := c.counters.Add(ctx, message.Owner, 1); err != nil {
+		log.Printf("WARNING: Failed to count message %s for owner %s: %v", message.ID, message.Owner, err)
+	}
+	return nil
+}
+
+func (c *countingStore) Update(ctx context.Context, id, text string, maxHistory int) (*model.Message, error) {
+	return c.next.Update(ctx, id, text, maxHistory)
+}
+
+func (c *countingStore) SetPinned(ctx context.Context, id string, pinned bool) error {
+	return c.next.SetPinned(ctx, id, pinned)
+}
+
+func (c *countingStore) AddReaction(ctx context.Context, id, emoji string, maxReactions int) (*model.Message, error) {
+	return c.next.AddReaction(ctx, id, emoji, maxReactions)
+}
+
+func (c *countingStore) RemoveReaction(ctx context.Context, id, emoji string) (*model.Message, error) {
+	return c.next.RemoveReaction(ctx, id, emoji)
+}
+
+// DeleteByOwner deletes the owner's messages, then uncounts those deleted, including those
+// deleted before an error
+func (c *countingStore) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	deleted, err := c.next.DeleteByOwner(ctx, owner)
+	if deleted > 0 {
+		if countErr := c.counters.Add(ctx, owner, -int64(deleted)); countErr != nil {
+			log.Printf("WARNING: Failed to uncount %d deleted messages for owner %s: %v", deleted, owner, countErr)
+		}
+	}
+	return deleted, err
+}
+
+// CountByOwner returns the owners with the most messages according to the counters
+func (c *countingStore) CountByOwner(ctx context.Context, limit int) ([]store.OwnerCount, error) {
+	return c.counters.Top(ctx, limit)
+}
+
+func (c *countingStore) CountPinnedByOwner(ctx context.Context, owner string) (int, error) {
+	return c.next.CountPinnedByOwner(ctx, owner)
+}
+
+func (c *countingStore) CountByDay(ctx context.Context, start, end time.Time) (map[string]int64, error) {
+	return c.next.CountByDay(ctx, start, end)
+}
+
+// reconcile corrects every counter that differs from a count of the stored messages, and
+// returns the number corrected. The counters are read before the messages, and each correction
+// only applies if its counter is unchanged since, so a counter updated during the pass is left
+// to the next one. A write can still straddle the pass: if its message is stored before the
+// count but its counter is updated after the correction, the write is counted twice, and the
+// counter stays one off until the next pass.
+func (c *countingStore) reconcile(ctx context.Context) (int, error) {
+	counters, err := c.counters.GetAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	owners, err := c.next.CountByOwner(ctx, math.MaxInt)
+	if err != nil {
+		return 0, err
+	}
+
+	actual := make(map[string]int64, len(owners))
+	for _, owner := range owners {
+		actual[owner.Owner] = owner.Count
+	}
+	for owner := range counters {
+		if _, ok := actual[owner]; !ok {
+			actual[owner] = 0
+		}
+	}
+
+	corrected := 0
+	for owner, count := range actual {
+		if counters[owner] == count {
+			continue
+		}
+		set, err := c.counters.Set(ctx, owner, counters[owner], count)
+		if err != nil {
+			return corrected, err
+		}
+		if !set {
+			log.Printf("Counter for owner %s changed during reconciliation, leaving it to the next pass", owner)
+			continue
+		}
+		log.Printf("Corrected message counter for owner %s from %d to %d", owner, counters[owner], count)
+		corrected++
+	}
+	return corrected, nil
+}
+
+// startReconciler reconciles the counters once, then every interval, until the returned stop
+// function is called. Stopping cancels any pass in progress and waits for it to return, or for
+// the stop function's ctx to expire. A non-positive interval starts nothing.
+func (c *countingStore) startReconciler(interval time.Duration) func(ctx context.Context) {
+	if interval <= 0 {
+		return func(context.Context) {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			corrected, err := c.reconcile(ctx)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				log.Printf("ERROR: Message counter reconciliation failed after %d corrections: %v", corrected, err)
+			case corrected > 0:
+				log.Printf("Message counter reconciliation corrected %d counters", corrected)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func(stopCtx context.Context) {
+		cancel()
+		select {
+		case <-done:
+		case <-stopCtx.Done():
+			log.Printf("WARNING: Message counter reconciliation did not stop before the shutdown deadline")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package msgsvc
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/msgsvc/internal/store"
+)
+
+func TestCountingStore(t *testing.T) {
+	ctx := context.Background()
+	counters := store.NewOwnerCounters()
+	counting := &countingStore{next: store.NewMessageStore("asc"), counters: counters}
+
+	for _, owner := range []string{"alice", "alice", "alice", "bob"} {
+		if err := counting.Add(ctx, model.NewMessage("hello", owner, "")); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if deleted, err := counting.DeleteByOwner(ctx, "bob"); err != nil || deleted != 1 {
+		t.Fatalf("DeleteByOwner = %d, %v, want 1", deleted, err)
+	}
+
+	owners, err := counting.CountByOwner(ctx, 10)
+	if err != nil {
+		t.Fatalf("CountByOwner: %v", err)
+	}
+	if len(owners) != 1 || owners[0] != (store.OwnerCount{Owner: "alice", Count: 3}) {
+		t.Errorf("CountByOwner = %v, want alice with 3", owners)
+	}
+	if _, ok := baseStore(counting).(*store.MessageStore); !ok {
+		t.Errorf("base store is %T, want *store.MessageStore", baseStore(counting))
+	}
+}
+
+func TestCounterReconciler(t *testing.T) {
+	ctx := context.Background()
+	messageStore := store.NewMessageStore("asc")
+	counters := store.NewOwnerCounters()
+	counting := &countingStore{next: messageStore, counters: counters}
+
+	// Messages added underneath the decorator are missing from the counters
+	for _, owner := range []string{"alice", "alice", "bob"} {
+		if err := messageStore.Add(ctx, model.NewMessage("hello", owner, "")); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	// Deliberately wrong counters: too high, and for an owner with no messages
+	if err := counters.Add(ctx, "bob", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := counters.Add(ctx, "carol", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := counting.startReconciler(10 * time.Millisecond)
+	want := map[string]int64{"alice": 2, "bob": 1}
+	var got map[string]int64
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		got, _ = counters.GetAll(ctx)
+		if maps.Equal(got, want) {
+			break
+		}
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("counters = %v, want %v", got, want)
+	}
+
+	// Stopping waits for the reconciler to exit, after which nothing is corrected
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	stop(stopCtx)
+	if stopCtx.Err() != nil {
+		t.Fatal("reconciler did not stop before the deadline")
+	}
+	if err := counters.Add(ctx, "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := counters.GetAll(ctx); got["alice"] != 3 {
+		t.Errorf("alice's counter = %d after stopping, want the uncorrected 3", got["alice"])
+	}
+}
+
+func TestCounterReconcilerDisabled(t *testing.T) {
+	counting := &countingStore{next: store.NewMessageStore("asc"), counters: store.NewOwnerCounters()}
+	if err := counting.counters.Add(context.Background(), "alice", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := counting.startReconciler(0)
+	time.Sleep(20 * time.Millisecond)
+	stop(context.Background())
+	if got, _ := counting.counters.GetAll(context.Background()); got["alice"] != 1 {
+		t.Errorf("alice's counter = %d with reconciliation disabled, want 1", got["alice"])
+	}
+}
@@ -1,125 +1,671 @@
 package msgsvc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/aws_e2e_test/msgsvc/internal/attachment"
 	"github.com/aws_e2e_test/msgsvc/internal/config"
+	"github.com/aws_e2e_test/msgsvc/internal/logging"
 	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/msgsvc/internal/moderation"
 	"github.com/aws_e2e_test/msgsvc/internal/store"
 	"github.com/aws_e2e_test/shared/auth"
-	"github.com/gin-contrib/cors"
+	"github.com/aws_e2e_test/shared/awsutil"
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/aws_e2e_test/shared/middleware"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // MessageStore is an interface for message storage
 type MessageStore interface {
-	GetAll() ([]*model.Message, error)
-	Add(message *model.Message) error
+	GetAll(ctx context.Context) ([]*model.Message, error)
+	GetAllSorted(ctx context.Context) ([]*model.Message, error)
+	GetAllWithBudget(ctx context.Context, startCursor string, maxDuration time.Duration) ([]*model.Message, bool, string, error)
+	GetSince(ctx context.Context, since time.Time, limit int32) ([]*model.Message, error)
+	GetRecent(ctx context.Context, limit int32) ([]*model.Message, error)
+	GetByPrefix(ctx context.Context, prefix string, limit int32) ([]*model.Message, error)
+	GetPage(ctx context.Context, snapshotAt time.Time, cursor string, limit int32) ([]*model.Message, string, error)
+	GetByID(ctx context.Context, id string) (*model.Message, error)
+	GetByIDs(ctx context.Context, ids []string) ([]*model.Message, error)
+	Exists(ctx context.Context, id string) (bool, error)
+	GetReplies(ctx context.Context, parentID string) ([]*model.Message, error)
+	Add(ctx context.Context, message *model.Message) error
+	Update(ctx context.Context, id, text string, maxHistory int) (*model.Message, error)
+	SetPinned(ctx context.Context, id string, pinned bool) error
+	AddReaction(ctx context.Context, id, emoji string, maxReactions int) (*model.Message, error)
+	RemoveReaction(ctx context.Context, id, emoji string) (*model.Message, error)
+	DeleteByOwner(ctx context.Context, owner string) (int, error)
+	CountByOwner(ctx context.Context, limit int) ([]store.OwnerCount, error)
+	CountPinnedByOwner(ctx context.Context, owner string) (int, error)
+	CountByDay(ctx context.Context, start, end time.Time) (map[string]int64, error)
+}
+
+// AttachmentPresigner is an interface for creating attachment upload URLs
+type AttachmentPresigner interface {
+	PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (string, error)
+	ObjectURL(key string) string
+}
+
+// OwnerDirectory is an interface for resolving message owners to user profiles
+type OwnerDirectory interface {
+	// GetOwners returns the profiles of the users with the given subs, keyed by sub. Unknown
+	// users are left out. On error, the profiles that could be resolved are still returned.
+	GetOwners(ctx context.Context, subs []string) (map[string]*store.OwnerProfile, error)
 }
 
 // Server represents the API server
 type Server struct {
-	router       *gin.Engine
-	config       *config.Config
-	messageStore MessageStore
-	jwtValidator *auth.JWTValidator
+	router              *gin.Engine
+	config              *config.Config
+	messageStore        MessageStore
+	moderator           moderation.Moderator
+	ownerDirectory      OwnerDirectory
+	attachmentPresigner AttachmentPresigner
+	attachmentHosts     []string
+	jwtValidator        *auth.JWTValidator
+	defaultAuth         auth.RouteAuth
+
+	// draining is set once shutdown begins, so readiness checks fail while traffic drains
+	draining atomic.Bool
+
+	// stopReconciler stops the owner counter reconciler, if one was started
+	stopReconciler func(ctx context.Context)
 }
 
 // NewServer creates a new API server
 func NewServer(cfg *config.Config) (*Server, error) {
-	var messageStore MessageStore
-	var err error
+	messageStore, err := NewStore(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	// Initialize the appropriate message store based on configuration
-	if cfg.UseDynamoDB {
-		messageStore, err = store.NewDynamoDBMessageStore(cfg.DynamoDBTableName)
+	// Keep a running message count per owner, in a shared table if one is configured
+	var ownerCounters OwnerCounterStore
+	switch {
+	case cfg.OwnerCountersTableName != "":
+		countersTableName := awsutil.TableName(cfg.OwnerCountersTableName, cfg.DynamoDBTablePrefix, cfg.DynamoDBTableSuffix)
+		dynamoDBCounters, err := store.NewDynamoDBOwnerCounters(countersTableName, cfg.DynamoDBEndpoint, cfg.DynamoDBHTTPClient)
 		if err != nil {
-			log.Printf("ERROR: Failed to create DynamoDB message store: %v", err)
-			log.Printf("ERROR: Stack trace: %+v", err)
-			log.Printf("CRITICAL: Falling back to in-memory message store (WARNING: not suitable for multiple instances)")
-			messageStore = store.NewMessageStore()
+			log.Printf("ERROR: Failed to create owner counters: %v", err)
+			return nil, err
 		}
-	} else {
-		log.Println("STORAGE: Using in-memory message store (suitable for local development only)")
-		log.Println("STORAGE: Set USE_DYNAMODB=true for production/multi-instance deployments")
-		messageStore = store.NewMessageStore()
+		ownerCounters = dynamoDBCounters
+	case cfg.StorageBackend == "memory":
+		ownerCounters = store.NewOwnerCounters()
+	}
+	var counting *countingStore
+	if ownerCounters != nil {
+		counting = &countingStore{next: messageStore, counters: ownerCounters}
+		messageStore = counting
+	}
+
+	// Check messages against a wordlist if one is configured
+	var moderator moderation.Moderator = moderation.AllowAll{}
+	if cfg.ModerationWordlist != "" {
+		wordlistModerator, err := moderation.LoadWordlistModerator(cfg.ModerationWordlist)
+		if err != nil {
+			log.Printf("ERROR: Failed to load moderation wordlist: %v", err)
+			return nil, err
+		}
+		moderator = wordlistModerator
+	}
+
+	// Optionally resolve owner display names from the usersvc users table
+	var ownerDirectory OwnerDirectory
+	if cfg.UsersTableName != "" {
+		usersTableName := awsutil.TableName(cfg.UsersTableName, cfg.DynamoDBTablePrefix, cfg.DynamoDBTableSuffix)
+		dynamoDBDirectory, err := store.NewDynamoDBOwnerDirectory(usersTableName, cfg.DynamoDBEndpoint, cfg.DynamoDBHTTPClient)
+		if err != nil {
+			log.Printf("ERROR: Failed to create owner directory: %v", err)
+			return nil, err
+		}
+		ownerDirectory = dynamoDBDirectory
+	}
+
+	// Fetch the JWKS through a client that trusts the configured CA bundle, if any
+	var jwksClient *http.Client
+	if cfg.JWKSCABundle != "" {
+		caBundleClient, err := auth.NewHTTPClientWithCABundle(cfg.JWKSCABundle)
+		if err != nil {
+			log.Printf("ERROR: Failed to load JWKS CA bundle: %v", err)
+			return nil, err
+		}
+		jwksClient = caBundleClient
 	}
 
 	// Initialize JWT validator
 	jwtValidator := auth.NewJWTValidator(auth.JWTValidatorConfig{
-		JWKSURL: cfg.JWKSUrl,
-		Issuer:  cfg.JWTIssuer,
+		JWKSURL:         cfg.JWKSUrl,
+		Issuer:          cfg.JWTIssuer,
+		SkipIssuerCheck: cfg.JWTSkipIssuerCheck,
+		Environment:     cfg.Environment,
+		HTTPClient:      jwksClient,
+		CacheTTL:        cfg.JWKSCacheTTL,
+		StaleOK:         cfg.JWKSStaleOK,
+		FetchTimeout:    cfg.JWKSFetchTimeout,
 	})
 
+	// Optionally wait for the JWKS, which can briefly 404 after a user pool is created
+	if cfg.JWKSWaitTimeout > 0 {
+		if err := jwtValidator.Prime(context.Background(), cfg.JWKSWaitTimeout); err != nil {
+			log.Printf("ERROR: JWKS unavailable at startup: %v", err)
+			return nil, err
+		}
+	}
+
+	// Routes that don't declare whether they require authentication use this default
+	defaultAuth, err := auth.ParseRouteAuth(cfg.DefaultAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps in responses are RFC 3339 strings or epoch milliseconds
+	timestampFormat, err := httputil.ParseTimestampFormat(cfg.TimestampFormat)
+	if err != nil {
+		return nil, err
+	}
+	httputil.SetTimestampFormat(timestampFormat)
+
+	// Keys in responses are camelCase, snake_case or PascalCase
+	jsonCase, err := httputil.ParseJSONCase(cfg.JSONCase)
+	if err != nil {
+		return nil, err
+	}
+	httputil.SetJSONCase(jsonCase)
+
+	// Keep logged DynamoDB items to a readable size
+	awsutil.SetLogMaxFieldLen(cfg.LogMaxFieldLen)
+
 	server := &Server{
-		router:       gin.Default(),
-		config:       cfg,
-		messageStore: messageStore,
-		jwtValidator: jwtValidator,
+		router:          gin.Default(),
+		config:          cfg,
+		messageStore:    messageStore,
+		moderator:       moderator,
+		ownerDirectory:  ownerDirectory,
+		attachmentHosts: cfg.AttachmentAllowedHosts,
+		jwtValidator:    jwtValidator,
+		defaultAuth:     defaultAuth,
+	}
+
+	// Optionally allow clients to upload attachments directly to S3. Uploaded objects can
+	// always be attached to messages, in addition to ATTACHMENT_ALLOWED_HOSTS.
+	if cfg.AttachmentBucket != "" {
+		presigner, err := attachment.NewS3Presigner(cfg.AttachmentBucket)
+		if err != nil {
+			log.Printf("ERROR: Failed to create attachment presigner: %v", err)
+			return nil, err
+		}
+		server.attachmentPresigner = presigner
+
+		bucketURL, err := url.Parse(presigner.ObjectURL(""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse attachment bucket URL: %w", err)
+		}
+		server.attachmentHosts = append(slices.Clone(cfg.AttachmentAllowedHosts), bucketURL.Hostname())
 	}
 
-	// Configure CORS
-	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowOrigins = []string{cfg.CorsOrigins}
-	corsConfig.AllowMethods = []string{"GET", "POST", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
-	corsConfig.ExposeHeaders = []string{"Content-Length"}
-	corsConfig.AllowCredentials = true
-	server.router.Use(cors.New(corsConfig))
+	// Take the client IP from X-Forwarded-For only on requests from the ALB, so that clients
+	// cannot pick their own IP rate limit
+	if err := server.router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	// Respond 405 with an Allow header, rather than 404, for known paths with unsupported methods
+	server.router.HandleMethodNotAllowed = true
+	server.router.NoMethod(httputil.MethodNotAllowed)
+
+	// Respond with JSON rather than gin's plain text for unknown paths
+	server.router.NoRoute(httputil.NotFound)
+
+	// Configure CORS, with a separate policy for the message endpoints
+	server.router.Use(middleware.CORS([]string{cfg.CorsOrigins}, corsPolicies(cfg)...))
+
+	// Optionally enforce TLS for clients (probes reach the service directly over HTTP)
+	if cfg.RequireHTTPS {
+		server.router.Use(middleware.RequireHTTPS(cfg.HTTPSRedirect, "/health", "/ready", "/metrics"))
+	}
+
+	// Shed load beyond the configured number of in-flight requests (health checks are exempt)
+	server.router.Use(middleware.ConcurrencyLimit(cfg.MaxConcurrentRequests, "/health", "/ready", "/metrics"))
+
+	// Bound the time any handler may spend on a request
+	server.router.Use(middleware.RequestTimeout(cfg.RequestTimeout))
+
+	// Optionally reject clients that cannot accept JSON responses
+	if cfg.EnforceAcceptJSON {
+		server.router.Use(middleware.RequireAcceptJSON())
+	}
+
+	// Reject oversized path parameters before they are logged or used as keys
+	server.router.Use(middleware.MaxPathParamLength(middleware.DefaultMaxPathParamLength))
 
 	// Register routes
 	server.registerRoutes()
 
+	// Correct the owner counters against the stored messages in the background, until shutdown
+	if counting != nil {
+		server.stopReconciler = counting.startReconciler(cfg.CounterReconcileInterval)
+	}
+
 	return server, nil
 }
 
-// Run starts the server
+// corsPolicies returns the CORS policy of each group of routes
+func corsPolicies(cfg *config.Config) []middleware.CORSPolicy {
+	return []middleware.CORSPolicy{
+		{PathPrefix: "/", AllowMethods: cfg.CorsMethods, AllowHeaders: cfg.CorsHeaders},
+		{
+			PathPrefix:   "/messages",
+			AllowMethods: cfg.CorsMessagesMethods,
+			AllowHeaders: cfg.CorsMessagesHeaders,
+			// Paging headers set by GET /messages
+			ExposeHeaders: []string{"X-Result-Truncated", "X-Result-Cursor", "X-Snapshot-At", "X-Next-Cursor"},
+		},
+	}
+}
+
+// readinessChecker is implemented by stores that can report whether their backend is available
+type readinessChecker interface {
+	Ready(ctx context.Context) error
+}
+
+// indexBackfiller is implemented by stores whose older messages may be missing index keys.
+// BackfillIndexKeys adds them, returning how many messages it updated.
+type indexBackfiller interface {
+	BackfillIndexKeys(ctx context.Context) (int, error)
+}
+
+// storeCloser is implemented by stores that buffer writes. Close flushes the buffer, returning
+// how many items were written, and releases the store.
+type storeCloser interface {
+	Close(ctx context.Context) (int, error)
+}
+
+// circuitReporter is implemented by stores that guard their backend with a circuit breaker
+type circuitReporter interface {
+	CircuitState() store.CircuitState
+}
+
+// Run starts the server and blocks until it has shut down gracefully after SIGINT or SIGTERM
 func (s *Server) Run(addr string) error {
-	return s.router.Run(addr)
+	// Keep the JWKS fresh for as long as the server runs
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+	s.jwtValidator.StartBackgroundRefresh(refreshCtx, s.config.JWKSRefreshInterval)
+
+	return httputil.ListenAndServeGraceful(addr, s.router, httputil.ShutdownOptions{
+		OnSignal:    func() { s.draining.Store(true) },
+		DrainDelay:  s.config.ShutdownDrainDelay,
+		Timeout:     s.config.ShutdownTimeout,
+		OnStop:      s.stop,
+		StopTimeout: s.config.ShutdownFlushTimeout,
+	})
+}
+
+// stop stops the background work that uses the message store, then closes the store
+func (s *Server) stop(ctx context.Context) error {
+	if s.stopReconciler != nil {
+		s.stopReconciler(ctx)
+	}
+	return s.closeStore(ctx)
+}
+
+// closeStore flushes and closes the message store if it buffers writes. It runs once the server
+// has stopped, so nothing can be added to the buffer after the flush.
+func (s *Server) closeStore(ctx context.Context) error {
+	closer, ok := baseStore(s.messageStore).(storeCloser)
+	if !ok {
+		return nil
+	}
+
+	flushed, err := closer.Close(ctx)
+	if err != nil {
+		log.Printf("ERROR: Failed to flush the message store after %d items: %v", flushed, err)
+		return err
+	}
+	log.Printf("Flushed %d buffered items from the message store", flushed)
+	return nil
+}
+
+// ready reports whether the server should receive traffic. It fails as soon as shutdown begins,
+// so the load balancer stops routing requests here before the server stops accepting them.
+func (s *Server) ready(c *gin.Context) {
+	if s.draining.Load() {
+		httputil.RespondJSON(c, http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	response := gin.H{"status": "ready"}
+	messageStore := baseStore(s.messageStore)
+	if reporter, ok := messageStore.(circuitReporter); ok {
+		response["circuit"] = reporter.CircuitState()
+	}
+	if checker, ok := messageStore.(readinessChecker); ok {
+		if err := checker.Ready(c.Request.Context()); err != nil {
+			log.Printf("Readiness check failed: %v", err)
+			response["status"] = "unavailable"
+			httputil.RespondJSON(c, http.StatusServiceUnavailable, response)
+			return
+		}
+	}
+	httputil.RespondJSON(c, http.StatusOK, response)
 }
 
 // registerRoutes registers all API routes
 func (s *Server) registerRoutes() {
 	// Health check endpoint
 	s.router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		httputil.RespondJSON(c, http.StatusOK, gin.H{"status": "ok"})
 	})
 
-	// API endpoints
-	api := s.router.Group("/")
-	{
-		// Protected message endpoints (require authentication)
-		protected := api.Group("/messages")
-		protected.Use(auth.JWTAuthMiddleware(s.jwtValidator))
-		{
-			protected.GET("", s.getMessages)
-			protected.POST("", s.createMessage)
-		}
+	// Readiness check endpoint (fails while draining during shutdown)
+	s.router.GET("/ready", s.ready)
+
+	// Prometheus metrics
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Service descriptor for uptime checks pointed at the root
+	if !s.config.DisableRootInfo {
+		s.router.GET("/", httputil.ServiceInfoHandler("msgsvc", s.config.Version))
+	}
+
+	// Description of the list endpoints for client developers
+	if s.config.Environment == "dev" {
+		s.router.GET("/_meta", s.getMeta)
 	}
+
+	// Middleware for endpoints operating on a single message
+	byID := []gin.HandlerFunc{validateMessageID}
+	adminOnly := []gin.HandlerFunc{auth.RequireAdminMiddleware()}
+
+	// API endpoints. Routes without an explicit Auth use the DEFAULT_AUTH setting.
+	routes := []auth.Route{
+		// Message endpoints (require authentication)
+		{Method: http.MethodGet, Path: "/messages", Auth: auth.AuthRequired, Handler: s.getMessages},
+		{Method: http.MethodPost, Path: "/messages", Auth: auth.AuthRequired, Handler: s.createMessage},
+		{Method: http.MethodPost, Path: "/messages/batch-get", Auth: auth.AuthRequired, Handler: s.batchGetMessages},
+		{Method: http.MethodGet, Path: "/messages/recent", Auth: auth.AuthRequired, Handler: s.getRecentFeed},
+		{Method: http.MethodDelete, Path: "/messages/mine", Auth: auth.AuthRequired, Handler: s.deleteMyMessages},
+		{Method: http.MethodGet, Path: "/messages/:id", Auth: auth.AuthRequired, Middleware: byID, Handler: s.getMessage},
+		{Method: http.MethodPut, Path: "/messages/:id", Auth: auth.AuthRequired, Middleware: byID, Handler: s.updateMessage},
+		{Method: http.MethodGet, Path: "/messages/:id/history", Auth: auth.AuthRequired, Middleware: byID, Handler: s.getMessageHistory},
+		{Method: http.MethodGet, Path: "/messages/:id/replies", Auth: auth.AuthRequired, Middleware: byID, Handler: s.getReplies},
+		{Method: http.MethodPost, Path: "/messages/:id/pin", Auth: auth.AuthRequired, Middleware: byID, Handler: s.pinMessage},
+		{Method: http.MethodPost, Path: "/messages/:id/unpin", Auth: auth.AuthRequired, Middleware: byID, Handler: s.unpinMessage},
+		{Method: http.MethodPost, Path: "/messages/:id/reactions", Auth: auth.AuthRequired, Middleware: byID, Handler: s.addReaction},
+		{Method: http.MethodDelete, Path: "/messages/:id/reactions/:emoji", Auth: auth.AuthRequired, Middleware: byID, Handler: s.removeReaction},
+
+		// Attachment endpoints (require authentication)
+		{Method: http.MethodPost, Path: "/attachments/presign", Auth: auth.AuthRequired, Handler: s.presignAttachment},
+
+		// Admin endpoints (require the admin group)
+		{Method: http.MethodGet, Path: "/admin/messages/top-owners", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.getTopOwners},
+		{Method: http.MethodGet, Path: "/admin/messages/recent", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.getRecentMessages},
+		{Method: http.MethodGet, Path: "/admin/messages/daily", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.getDailyCounts},
+		{Method: http.MethodPost, Path: "/admin/messages/backfill", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.backfillIndexKeys},
+	}
+	// Rate limit after authentication, so that authenticated requests are counted per user
+	// rather than per IP
+	rateLimit := middleware.RateLimit(
+		middleware.NewRateLimiter(s.config.RateLimitUserPerMinute, s.config.RateLimitUserBurst),
+		middleware.NewRateLimiter(s.config.RateLimitIPPerMinute, s.config.RateLimitIPBurst),
+		auth.GetUserSubFromContext,
+	)
+	for i := range routes {
+		routes[i].Middleware = append([]gin.HandlerFunc{rateLimit}, routes[i].Middleware...)
+	}
+	auth.RegisterRoutes(s.router, routes, s.jwtValidator, s.defaultAuth)
 }
 
-// getMessages returns all messages
-func (s *Server) getMessages(c *gin.Context) {
-	// Add cache control headers to prevent caching
-	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
-	c.Header("Pragma", "no-cache")
-	c.Header("Expires", "0")
+// maxMessagesSince is the maximum number of messages returned by an incremental poll. Clients
+// with more to catch up on poll again using the timestamp of the last message received.
+const maxMessagesSince = 100
 
+// getMessages returns all messages, only those newer than the since query parameter, those
+// matching a text prefix, or one page of a snapshot
+func (s *Server) getMessages(c *gin.Context) {
 	log.Printf("Handling GET /messages request")
-	messages, err := s.messageStore.GetAll()
+
+	// The view selects the response shape: full (default) or minimal (id and text only)
+	view := c.DefaultQuery("view", messageViews[0])
+	if !slices.Contains(messageViews, view) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_VIEW", "Invalid view, expected 'minimal' or 'full'")
+		return
+	}
+
+	// With since, return only newer messages, oldest first, for incremental polling. With prefix,
+	// return the messages whose text starts with it, in text order. With snapshotAt, cursor or
+	// limit, return one page of the messages as of the snapshot. Otherwise scan every message,
+	// resuming a truncated scan from scanCursor.
+	var messages []*model.Message
+	var err error
+	sinceStr, prefix := c.Query("since"), c.Query("prefix")
+	snapshotAtStr, cursor := c.Query("snapshotAt"), c.Query("cursor")
+	scanCursor := c.Query("scanCursor")
+	if sinceStr != "" && prefix != "" {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_QUERY", "since and prefix cannot be combined")
+		return
+	}
+	paged := snapshotAtStr != "" || cursor != ""
+	if paged && (sinceStr != "" || prefix != "") {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_QUERY", "snapshotAt and cursor cannot be combined with since or prefix")
+		return
+	}
+	if c.Query("limit") != "" && sinceStr == "" && prefix == "" {
+		paged = true
+	}
+	if scanCursor != "" && (paged || sinceStr != "" || prefix != "") {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_QUERY", "scanCursor cannot be combined with since, prefix, snapshotAt, cursor or limit")
+		return
+	}
+	if paged {
+		limit, ok := snapshotPageLimit.parse(c)
+		if !ok {
+			return
+		}
+		// Without snapshotAt the snapshot is taken now; clients pass X-Snapshot-At back with the
+		// cursor to keep paging through the same snapshot
+		snapshotAt := time.Now().UTC()
+		if snapshotAtStr != "" {
+			var parseErr error
+			if snapshotAt, parseErr = time.Parse(time.RFC3339Nano, snapshotAtStr); parseErr != nil {
+				httputil.RespondError(c, http.StatusBadRequest, "INVALID_TIMESTAMP", "Invalid snapshotAt timestamp, expected RFC 3339")
+				return
+			}
+		}
+		var next string
+		messages, next, err = s.messageStore.GetPage(c.Request.Context(), snapshotAt, cursor, int32(limit))
+		if errors.Is(err, store.ErrInvalidCursor) {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_CURSOR", "Invalid cursor")
+			return
+		}
+		if err == nil {
+			c.Header("X-Snapshot-At", snapshotAt.Format(time.RFC3339Nano))
+			if next != "" {
+				c.Header("X-Next-Cursor", next)
+			}
+		}
+	} else if prefix != "" {
+		limit, ok := prefixSearchLimit.parse(c)
+		if !ok {
+			return
+		}
+		messages, err = s.messageStore.GetByPrefix(c.Request.Context(), prefix, int32(limit))
+	} else if sinceStr != "" {
+		since, parseErr := time.Parse(time.RFC3339Nano, sinceStr)
+		if parseErr != nil {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_TIMESTAMP", "Invalid since timestamp, expected RFC 3339")
+			return
+		}
+		messages, err = s.messageStore.GetSince(c.Request.Context(), since, maxMessagesSince)
+	} else {
+		// Return what a scan can read within the budget rather than timing out on a large table
+		var truncated bool
+		var cursor string
+		messages, truncated, cursor, err = s.messageStore.GetAllWithBudget(c.Request.Context(), scanCursor, s.config.ListScanBudget)
+		if errors.Is(err, store.ErrInvalidCursor) {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_CURSOR", "Invalid scan cursor")
+			return
+		}
+		if err == nil {
+			store.SortPinnedFirst(messages, store.SortOrder(s.config.DefaultSort))
+		}
+		if truncated {
+			c.Header("X-Result-Truncated", "true")
+			if cursor != "" {
+				c.Header("X-Result-Cursor", cursor)
+			}
+		}
+	}
 	if err != nil {
 		log.Printf("Error getting messages: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
+		s.respondStoreError(c, err, "Failed to retrieve messages")
 		return
 	}
 
+	// Optionally exclude replies, leaving only top-level messages
+	if c.Query("topLevelOnly") == "true" {
+		topLevel := make([]*model.Message, 0, len(messages))
+		for _, msg := range messages {
+			if msg.ParentID == "" {
+				topLevel = append(topLevel, msg)
+			}
+		}
+		messages = topLevel
+	}
+
 	log.Printf("Returning %d messages", len(messages))
 	for i, msg := range messages {
-		log.Printf("Message %d: ID=%s, Text=%s", i, msg.ID, msg.Text)
+		logging.SampledDebugf(i, "Message %d: ID=%s, Text=%s", i, msg.ID, msg.Text)
+	}
+
+	if view == "minimal" {
+		minimal := make([]*model.MinimalMessage, len(messages))
+		for i, msg := range messages {
+			minimal[i] = msg.ToMinimal()
+		}
+		httputil.RespondList(c, minimal)
+		return
+	}
+	if s.ownerDirectory != nil {
+		httputil.RespondList(c, s.expandOwners(c.Request.Context(), messages))
+		return
+	}
+	httputil.RespondList(c, messages)
+}
+
+// getRecentFeed returns the newest n messages, newest first. Unlike GET /messages it reads only
+// those messages rather than scanning them all.
+func (s *Server) getRecentFeed(c *gin.Context) {
+	n, ok := recentFeedLimit.clamp(c, "n")
+	if !ok {
+		return
+	}
+	log.Printf("Handling GET /messages/recent request for %d messages", n)
+
+	messages, err := s.messageStore.GetRecent(c.Request.Context(), int32(n))
+	if err != nil {
+		log.Printf("Error getting recent messages: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve messages")
+		return
+	}
+
+	if s.ownerDirectory != nil {
+		httputil.RespondList(c, s.expandOwners(c.Request.Context(), messages))
+		return
+	}
+	httputil.RespondList(c, messages)
+}
+
+// getMessage returns a single message by ID
+func (s *Server) getMessage(c *gin.Context) {
+	id := c.Param("id")
+	log.Printf("Handling GET /messages/%s request", id)
+
+	message, err := s.messageStore.GetByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Error getting message: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve message")
+		return
+	}
+	if message == nil {
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, message)
+}
+
+// batchGetMessages returns the messages with the IDs in the request body, in the order requested,
+// omitting IDs that do not exist
+func (s *Server) batchGetMessages(c *gin.Context) {
+	var request struct {
+		IDs []string `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		log.Printf("Error binding JSON: %v", err)
+		httputil.RespondBindError(c, err)
+		return
+	}
+	log.Printf("Handling POST /messages/batch-get request for %d IDs", len(request.IDs))
+
+	if !s.checkBatchSize(c, len(request.IDs)) {
+		return
+	}
+	for _, id := range request.IDs {
+		if _, err := uuid.Parse(id); err != nil || len(id) != 36 {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_ID", "Invalid message ID: "+id)
+			return
+		}
+	}
+
+	messages, err := s.messageStore.GetByIDs(c.Request.Context(), request.IDs)
+	if err != nil {
+		log.Printf("Error getting messages by ID: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve messages")
+		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	httputil.RespondList(c, messages)
+}
+
+// getReplies returns the replies to a message
+func (s *Server) getReplies(c *gin.Context) {
+	id := c.Param("id")
+
+	exists, err := s.messageStore.Exists(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Error getting message: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve message")
+		return
+	}
+	if !exists {
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	}
+
+	replies, err := s.messageStore.GetReplies(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Error getting replies: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve replies")
+		return
+	}
+
+	httputil.RespondList(c, replies)
 }
 
 // createMessage creates a new message
@@ -127,32 +673,695 @@ func (s *Server) createMessage(c *gin.Context) {
 	log.Printf("Handling POST /messages request")
 
 	var request struct {
-		Text string `json:"text" binding:"required"`
+		Text          string `json:"text" binding:"required"`
+		ParentID      string `json:"parentId"`
+		AttachmentURL string `json:"attachmentUrl"`
+		ContentType   string `json:"contentType"`
 	}
 
-	if err := c.ShouldBindJSON(&request); err != nil {
+	if err := httputil.BindJSON(c, &request, s.config.StrictJSON); err != nil {
 		log.Printf("Error binding JSON: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	request.Text = s.normalizeText(request.Text)
+	if request.Text == "" {
+		httputil.RespondError(c, http.StatusBadRequest, "EMPTY_MESSAGE", "Message text cannot be empty")
+		return
+	}
+
+	// The content type defaults to plain text and must be one of the known types
+	contentType := strings.ToLower(strings.TrimSpace(request.ContentType))
+	if contentType == "" {
+		contentType = model.ContentTypePlain
+	}
+	if !slices.Contains(model.ContentTypes, contentType) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_CONTENT_TYPE",
+			"Invalid content type, expected one of "+strings.Join(model.ContentTypes, ", "))
+		return
+	}
+
+	// A reply must refer to an existing message
+	if request.ParentID != "" {
+		if _, err := uuid.Parse(request.ParentID); err != nil || len(request.ParentID) != 36 {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_PARENT", "Invalid parent message ID")
+			return
+		}
+
+		parentExists, err := s.messageStore.Exists(c.Request.Context(), request.ParentID)
+		if err != nil {
+			log.Printf("Error getting parent message: %v", err)
+			s.respondStoreError(c, err, "Failed to retrieve parent message")
+			return
+		}
+		if !parentExists {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_PARENT", "Parent message does not exist")
+			return
+		}
+	}
+
+	// Attachments may only link to allowlisted hosts
+	if request.AttachmentURL != "" {
+		if err := validateAttachmentURL(request.AttachmentURL, s.attachmentHosts); err != nil {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_ATTACHMENT", "Invalid attachment URL: "+err.Error())
+			return
+		}
+	}
+
+	if !s.moderate(c, request.Text) {
 		return
 	}
 
+	// The owner of the message is the authenticated user
+	owner, _ := auth.GetUserSubFromContext(c)
+
 	log.Printf("Creating new message with text: %s", request.Text)
-	message := model.NewMessage(request.Text)
+	message := model.NewMessage(request.Text, owner, request.ParentID)
+	message.AttachmentURL = request.AttachmentURL
+	message.ContentType = contentType
 	log.Printf("Generated message with ID: %s", message.ID)
 
-	err := s.messageStore.Add(message)
+	err := s.messageStore.Add(c.Request.Context(), message)
 	if err != nil {
 		log.Printf("Error adding message: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store message"})
+		s.respondStoreError(c, err, "Failed to store message")
 		return
 	}
 
 	log.Printf("Successfully added message with ID: %s", message.ID)
 
-	// Add cache control headers to prevent caching
-	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
-	c.Header("Pragma", "no-cache")
-	c.Header("Expires", "0")
+	if s.ownerDirectory != nil {
+		httputil.RespondCreated(c, s.expandOwners(c.Request.Context(), []*model.Message{message})[0])
+		return
+	}
+	httputil.RespondCreated(c, message)
+}
+
+// respondStoreError writes the response for a failed store call: 503 while the store's circuit
+// breaker is open, so that clients back off, and 500 otherwise
+func (s *Server) respondStoreError(c *gin.Context, err error, message string) {
+	if errors.Is(err, store.ErrStoreUnavailable) {
+		c.Header("Retry-After", strconv.Itoa(int(s.config.StoreBreakerCooldown.Seconds())))
+		httputil.RespondError(c, http.StatusServiceUnavailable, "STORE_UNAVAILABLE", message)
+		return
+	}
+	httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", message)
+}
+
+// checkBatchSize reports whether a batch of size items is within MAX_BATCH_SIZE, writing a 400
+// response if not. Every batch endpoint checks its batch with it so they share one limit.
+func (s *Server) checkBatchSize(c *gin.Context, size int) bool {
+	if size <= s.config.MaxBatchSize {
+		return true
+	}
+	httputil.RespondErrorWith(c, http.StatusBadRequest, "BATCH_TOO_LARGE",
+		fmt.Sprintf("At most %d items can be sent in one batch", s.config.MaxBatchSize),
+		gin.H{"max": s.config.MaxBatchSize})
+	return false
+}
+
+// normalizeText returns message text trimmed, and with runs of whitespace collapsed when
+// NORMALIZE_WHITESPACE is set. Text that is only whitespace becomes empty.
+func (s *Server) normalizeText(text string) string {
+	text = strings.TrimSpace(text)
+	if s.config.NormalizeWhitespace {
+		text = strings.Join(strings.Fields(text), " ")
+	}
+	return text
+}
+
+// moderate reports whether text passes content moderation, writing the error response if not.
+// If the check itself fails, the text is accepted or rejected depending on MODERATION_FAIL_OPEN.
+func (s *Server) moderate(c *gin.Context, text string) bool {
+	allowed, reason, err := s.moderator.Check(c.Request.Context(), text)
+	if err != nil {
+		if !s.config.ModerationFailOpen {
+			log.Printf("Error checking message content (failing closed): %v", err)
+			httputil.RespondError(c, http.StatusServiceUnavailable, "MODERATION_UNAVAILABLE", "Content moderation is unavailable, please retry")
+			return false
+		}
+		log.Printf("Error checking message content (failing open): %v", err)
+		allowed = true
+	}
+	if !allowed {
+		httputil.RespondErrorWith(c, http.StatusUnprocessableEntity, "MODERATION_REJECTED",
+			"Message was rejected by content moderation", gin.H{"reason": reason})
+		return false
+	}
+	return true
+}
+
+// updateMessage replaces the text of a message, keeping the previous text in its edit history
+func (s *Server) updateMessage(c *gin.Context) {
+	id := c.Param("id")
+	log.Printf("Handling PUT /messages/%s request", id)
+
+	var request struct {
+		Text string `json:"text" binding:"required"`
+	}
+
+	if err := httputil.BindJSON(c, &request, s.config.StrictJSON); err != nil {
+		log.Printf("Error binding JSON: %v", err)
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	request.Text = s.normalizeText(request.Text)
+	if request.Text == "" {
+		httputil.RespondError(c, http.StatusBadRequest, "EMPTY_MESSAGE", "Message text cannot be empty")
+		return
+	}
+
+	message, err := s.messageStore.GetByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Error getting message: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve message")
+		return
+	}
+	if message == nil {
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	}
+
+	// Only the owner of the message or an administrator may change it
+	if !canModifyMessage(c, message) {
+		httputil.RespondError(c, http.StatusForbidden, "FORBIDDEN", "Not allowed to modify this message")
+		return
+	}
+
+	if !s.moderate(c, request.Text) {
+		return
+	}
+
+	message, err = s.messageStore.Update(c.Request.Context(), id, request.Text, s.config.EditHistoryLimit)
+	if errors.Is(err, store.ErrMessageNotFound) {
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating message: %v", err)
+		s.respondStoreError(c, err, "Failed to update message")
+		return
+	}
+
+	log.Printf("Successfully updated message with ID: %s", id)
+	httputil.RespondJSON(c, http.StatusOK, message)
+}
+
+// getMessageHistory returns the prior versions of a message's text, oldest first. The history
+// is only visible to the owner of the message and to administrators.
+func (s *Server) getMessageHistory(c *gin.Context) {
+	id := c.Param("id")
+
+	message, err := s.messageStore.GetByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Error getting message: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve message")
+		return
+	}
+	if message == nil {
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	}
+	if !canModifyMessage(c, message) {
+		httputil.RespondError(c, http.StatusForbidden, "FORBIDDEN", "Not allowed to view the history of this message")
+		return
+	}
+
+	history := message.EditHistory
+	if history == nil {
+		history = []model.MessageEdit{}
+	}
+	httputil.RespondList(c, history)
+}
+
+// expandOwners returns the messages with each owner sub expanded to include the owner's display
+// name. The distinct owners are looked up in one call. If a lookup fails or the user record is
+// missing, the owner is returned with only the sub, unless display name fallback is enabled, in
+// which case the sub is also used as the name.
+func (s *Server) expandOwners(ctx context.Context, messages []*model.Message) []*model.MessageWithOwner {
+	subs := make([]string, 0, len(messages))
+	seen := make(map[string]bool)
+	for _, message := range messages {
+		if message.Owner != "" && !seen[message.Owner] {
+			seen[message.Owner] = true
+			subs = append(subs, message.Owner)
+		}
+	}
+
+	var profiles map[string]*store.OwnerProfile
+	if len(subs) > 0 {
+		var err error
+		profiles, err = s.ownerDirectory.GetOwners(ctx, subs)
+		if err != nil {
+			log.Printf("Error resolving owners: %v", err)
+		}
+	}
+	names := make(map[string]string, len(subs))
+	for _, sub := range subs {
+		names[sub] = s.ownerDisplayName(sub, profiles[sub])
+	}
+
+	expanded := make([]*model.MessageWithOwner, len(messages))
+	for i, message := range messages {
+		expanded[i] = &model.MessageWithOwner{
+			Message: message,
+			Owner:   model.MessageOwner{Sub: message.Owner, Name: names[message.Owner]},
+		}
+	}
+	return expanded
+}
+
+// ownerDisplayName returns the display name of the owner with the given sub and profile, which
+// is nil if the owner could not be resolved
+func (s *Server) ownerDisplayName(sub string, profile *store.OwnerProfile) string {
+	if profile == nil {
+		profile = &store.OwnerProfile{}
+	}
+	return auth.DisplayName(profile.FirstName, profile.LastName, profile.Email, sub, s.config.DisplayNameFallback)
+}
+
+// deleteMyMessages deletes every message owned by the authenticated user, e.g. when they delete
+// their account
+func (s *Server) deleteMyMessages(c *gin.Context) {
+	sub, ok := auth.GetUserSubFromContext(c)
+	if !ok || sub == "" {
+		httputil.RespondError(c, http.StatusUnauthorized, "UNAUTHORIZED", "User identity not found in token")
+		return
+	}
+
+	deleted, err := s.messageStore.DeleteByOwner(c.Request.Context(), sub)
+	if err != nil {
+		log.Printf("Error deleting messages owned by %s after deleting %d: %v", sub, deleted, err)
+		s.respondStoreError(c, err, "Failed to delete messages")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// getTopOwners returns the owners with the most messages, most first. Without owner counters,
+// counting reads every message, so this is meant for the admin dashboard rather than frequent
+// polling.
+func (s *Server) getTopOwners(c *gin.Context) {
+	limit, ok := topOwnersLimit.parse(c)
+	if !ok {
+		return
+	}
+
+	owners, err := s.messageStore.CountByOwner(c.Request.Context(), limit)
+	if err != nil {
+		log.Printf("Error counting messages by owner: %v", err)
+		s.respondStoreError(c, err, "Failed to count messages by owner")
+		return
+	}
+
+	httputil.RespondList(c, owners)
+}
+
+// getRecentMessages returns the newest messages, newest first
+func (s *Server) getRecentMessages(c *gin.Context) {
+	limit, ok := recentMessagesLimit.parse(c)
+	if !ok {
+		return
+	}
+
+	messages, err := s.messageStore.GetRecent(c.Request.Context(), int32(limit))
+	if err != nil {
+		log.Printf("Error getting recent messages: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve messages")
+		return
+	}
+
+	httputil.RespondList(c, messages)
+}
+
+// backfillIndexKeys adds the index keys missing from messages stored before the timestamp and
+// text indexes existed, so they are found by the queries that use them. Stores without such
+// messages report none updated.
+func (s *Server) backfillIndexKeys(c *gin.Context) {
+	backfiller, ok := baseStore(s.messageStore).(indexBackfiller)
+	if !ok {
+		httputil.RespondJSON(c, http.StatusOK, gin.H{"updated": 0})
+		return
+	}
+
+	updated, err := backfiller.BackfillIndexKeys(c.Request.Context())
+	if err != nil {
+		log.Printf("Error backfilling index keys after %d messages: %v", updated, err)
+		httputil.RespondErrorWith(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to backfill index keys", gin.H{"updated": updated})
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"updated": updated})
+}
+
+// defaultDailyCountDays is the number of days counted by GET /admin/messages/daily when from is
+// not given
+const defaultDailyCountDays = 30
+
+// maxDailyCountDays is the most days GET /admin/messages/daily counts in one request. Each
+// request reads every message in the range.
+const maxDailyCountDays = 366
+
+// getDailyCounts returns the number of messages posted on each UTC day from the from date to the
+// to date, both inclusive, as {"2024-06-01": 12, ...}. Days without messages count 0. to
+// defaults to today and from to the 30 days ending on to.
+func (s *Server) getDailyCounts(c *gin.Context) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if toStr := c.Query("to"); toStr != "" {
+		var err error
+		if to, err = time.Parse(time.DateOnly, toStr); err != nil {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_DATE", "Invalid to date, expected YYYY-MM-DD")
+			return
+		}
+	}
+	from := to.AddDate(0, 0, 1-defaultDailyCountDays)
+	if fromStr := c.Query("from"); fromStr != "" {
+		var err error
+		if from, err = time.Parse(time.DateOnly, fromStr); err != nil {
+			httputil.RespondError(c, http.StatusBadRequest, "INVALID_DATE", "Invalid from date, expected YYYY-MM-DD")
+			return
+		}
+	}
+	if to.Before(from) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_RANGE", "from must not be after to")
+		return
+	}
+	end := to.AddDate(0, 0, 1)
+	if days := int(end.Sub(from) / (24 * time.Hour)); days > maxDailyCountDays {
+		httputil.RespondErrorWith(c, http.StatusBadRequest, "RANGE_TOO_LARGE",
+			fmt.Sprintf("Range covers %d days, at most %d are allowed", days, maxDailyCountDays), gin.H{"max": maxDailyCountDays})
+		return
+	}
+
+	counts, err := s.messageStore.CountByDay(c.Request.Context(), from, end)
+	if err != nil {
+		log.Printf("Error counting messages by day: %v", err)
+		s.respondStoreError(c, err, "Failed to count messages by day")
+		return
+	}
+
+	// Every day in the range is present, so graphs need not fill gaps
+	for day := from; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if _, ok := counts[day.Format(time.DateOnly)]; !ok {
+			counts[day.Format(time.DateOnly)] = 0
+		}
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, counts)
+}
+
+// pinMessage pins a message to the top of the message list
+func (s *Server) pinMessage(c *gin.Context) {
+	s.setMessagePinned(c, true)
+}
+
+// unpinMessage removes the pin from a message
+func (s *Server) unpinMessage(c *gin.Context) {
+	s.setMessagePinned(c, false)
+}
+
+// setMessagePinned updates the pinned flag of the message identified by the :id path param
+func (s *Server) setMessagePinned(c *gin.Context, pinned bool) {
+	id := c.Param("id")
+	log.Printf("Handling pinned=%t request for message %s", pinned, id)
+
+	message, err := s.messageStore.GetByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("Error getting message: %v", err)
+		s.respondStoreError(c, err, "Failed to retrieve message")
+		return
+	}
+	if message == nil {
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	}
+
+	// Only the owner of the message or an administrator may change it
+	if !canModifyMessage(c, message) {
+		httputil.RespondError(c, http.StatusForbidden, "FORBIDDEN", "Not allowed to modify this message")
+		return
+	}
+
+	// Pinning a message the owner has not pinned yet must leave them within MAX_PINNED_PER_OWNER.
+	// The count and the pin are separate calls, so concurrent pins may briefly exceed it.
+	if pinned && !message.Pinned && s.config.MaxPinnedPerOwner > 0 {
+		count, err := s.messageStore.CountPinnedByOwner(c.Request.Context(), message.Owner)
+		if err != nil {
+			log.Printf("Error counting pinned messages: %v", err)
+			s.respondStoreError(c, err, "Failed to update message")
+			return
+		}
+		if count >= s.config.MaxPinnedPerOwner {
+			httputil.RespondErrorWith(c, http.StatusConflict, "PIN_LIMIT_REACHED",
+				fmt.Sprintf("At most %d messages can be pinned per owner", s.config.MaxPinnedPerOwner),
+				gin.H{"max": s.config.MaxPinnedPerOwner})
+			return
+		}
+	}
+
+	err = s.messageStore.SetPinned(c.Request.Context(), id, pinned)
+	if errors.Is(err, store.ErrMessageNotFound) {
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating message: %v", err)
+		s.respondStoreError(c, err, "Failed to update message")
+		return
+	}
+
+	message.Pinned = pinned
+	httputil.RespondJSON(c, http.StatusOK, message)
+}
+
+// addReaction adds an emoji reaction to a message
+func (s *Server) addReaction(c *gin.Context) {
+	id := c.Param("id")
+
+	var request struct {
+		Emoji string `json:"emoji" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	if !isSingleGrapheme(request.Emoji) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_REACTION", "Reaction must be a single emoji")
+		return
+	}
+
+	message, err := s.messageStore.AddReaction(c.Request.Context(), id, request.Emoji, s.config.MaxReactionsPerMessage)
+	switch {
+	case errors.Is(err, store.ErrMessageNotFound):
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	case errors.Is(err, store.ErrReactionLimitReached):
+		httputil.RespondError(c, http.StatusConflict, "TOO_MANY_REACTIONS", "Message has reached the maximum number of distinct reactions")
+		return
+	case err != nil:
+		log.Printf("Error adding reaction: %v", err)
+		s.respondStoreError(c, err, "Failed to add reaction")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, message)
+}
+
+// removeReaction removes one emoji reaction from a message
+func (s *Server) removeReaction(c *gin.Context) {
+	id := c.Param("id")
+	emoji := c.Param("emoji")
+
+	if !isSingleGrapheme(emoji) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_REACTION", "Reaction must be a single emoji")
+		return
+	}
+
+	message, err := s.messageStore.RemoveReaction(c.Request.Context(), id, emoji)
+	switch {
+	case errors.Is(err, store.ErrMessageNotFound):
+		httputil.RespondError(c, http.StatusNotFound, "MESSAGE_NOT_FOUND", "Message not found")
+		return
+	case errors.Is(err, store.ErrReactionNotFound):
+		httputil.RespondError(c, http.StatusNotFound, "REACTION_NOT_FOUND", "Reaction not found")
+		return
+	case err != nil:
+		log.Printf("Error removing reaction: %v", err)
+		s.respondStoreError(c, err, "Failed to remove reaction")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, message)
+}
+
+// presignAttachment returns a pre-signed URL the client can use to upload an attachment to S3,
+// along with the URL to store on the message once the upload completes
+func (s *Server) presignAttachment(c *gin.Context) {
+	if s.attachmentPresigner == nil {
+		httputil.RespondError(c, http.StatusServiceUnavailable, "UPLOADS_NOT_CONFIGURED", "Attachment uploads are not configured")
+		return
+	}
+
+	var request struct {
+		ContentType string `json:"contentType" binding:"required"`
+		Filename    string `json:"filename" binding:"required"`
+		Size        int64  `json:"size" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	contentType := strings.ToLower(request.ContentType)
+	if !slices.Contains(s.config.AttachmentContentTypes, contentType) {
+		httputil.RespondError(c, http.StatusBadRequest, "CONTENT_TYPE_NOT_ALLOWED", "Content type is not allowed")
+		return
+	}
+	if request.Size > int64(s.config.AttachmentMaxBytes) {
+		httputil.RespondError(c, http.StatusRequestEntityTooLarge, "ATTACHMENT_TOO_LARGE", fmt.Sprintf("Attachment exceeds the maximum size of %d bytes", s.config.AttachmentMaxBytes))
+		return
+	}
+
+	// Uploads are namespaced by the authenticated user
+	owner, ok := auth.GetUserSubFromContext(c)
+	if !ok || owner == "" {
+		httputil.RespondError(c, http.StatusUnauthorized, "UNAUTHORIZED", "User identity not found in token")
+		return
+	}
+	key := fmt.Sprintf("attachments/%s/%s-%s", owner, uuid.New().String(), sanitizeFilename(request.Filename))
+
+	expiresAt := time.Now().Add(s.config.AttachmentURLExpiry)
+	uploadURL, err := s.attachmentPresigner.PresignPut(c.Request.Context(), key, contentType, request.Size, s.config.AttachmentURLExpiry)
+	if err != nil {
+		log.Printf("Error presigning attachment upload: %v", err)
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to create upload URL")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{
+		"uploadUrl": uploadURL,
+		"method":    http.MethodPut,
+		"headers": gin.H{
+			"Content-Type":   contentType,
+			"Content-Length": strconv.FormatInt(request.Size, 10),
+		},
+		"objectUrl": s.attachmentPresigner.ObjectURL(key),
+		"expiresAt": expiresAt,
+	})
+}
+
+// sanitizeFilename reduces a client-supplied filename to a safe S3 key segment
+func sanitizeFilename(filename string) string {
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	sanitized := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_') {
+			return r
+		}
+		return '_'
+	}, filename)
+	sanitized = strings.Trim(sanitized, ".")
+	if len(sanitized) > 100 {
+		sanitized = sanitized[len(sanitized)-100:]
+	}
+	if sanitized == "" {
+		return "file"
+	}
+	return sanitized
+}
+
+// validateAttachmentURL checks that raw is an absolute http(s) URL without credentials whose
+// host is in allowedHosts
+func validateAttachmentURL(raw string, allowedHosts []string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("malformed URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+	if u.Host == "" || u.User != nil {
+		return errors.New("URL must have a host and no credentials")
+	}
+	if !slices.Contains(allowedHosts, strings.ToLower(u.Hostname())) {
+		return errors.New("host is not allowed")
+	}
+	return nil
+}
+
+// isSingleGrapheme reports whether s is exactly one user-perceived character, including emoji
+// built from modifiers, variation selectors, zero-width joiner sequences, flags and tags. It is a
+// simplification of the grapheme cluster rules of UAX #29, enough to validate a reaction:
+//   - the first rune may not be a control, space or combining mark
+//   - a flag is exactly two regional indicators, such as U+1F1FA U+1F1F8 for the US
+//   - every later rune must extend the first: a variation selector, skin tone modifier, tag
+//     character (as in the subdivision flag of Scotland), keycap or other combining mark
+//   - a zero-width joiner (U+200D) adds the rune after it to the character, as in the woman
+//     technologist U+1F469 U+200D U+1F4BB, so it may neither end s nor follow another joiner
+//
+// Whether a joined sequence is one that fonts draw as a single emoji is not checked.
+func isSingleGrapheme(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+
+	runes := []rune(s)
+	if unicode.IsControl(runes[0]) || unicode.IsSpace(runes[0]) || unicode.Is(unicode.Mn, runes[0]) {
+		return false
+	}
+
+	// A flag is a pair of regional indicator symbols
+	if isRegionalIndicator(runes[0]) {
+		return len(runes) == 2 && isRegionalIndicator(runes[1])
+	}
+
+	for i := 1; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == 0x200D:
+			// A zero-width joiner must join two characters
+			if i == len(runes)-1 || runes[i+1] == 0x200D {
+				return false
+			}
+			i++
+		case r == 0xFE0E || r == 0xFE0F, // Variation selectors
+			r >= 0x1F3FB && r <= 0x1F3FF, // Skin tone modifiers
+			r >= 0xE0020 && r <= 0xE007F, // Tag characters
+			r == 0x20E3,                  // Combining enclosing keycap
+			unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isRegionalIndicator reports whether r is a regional indicator symbol
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// validateMessageID rejects requests whose :id path param is not a well-formed UUID
+func validateMessageID(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil || len(id) != 36 {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_ID", "Invalid message ID")
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// canModifyMessage reports whether the authenticated user owns the message or is an administrator
+func canModifyMessage(c *gin.Context, message *model.Message) bool {
+	if auth.IsAdminFromContext(c) {
+		return true
+	}
 
-	c.JSON(http.StatusCreated, message)
+	sub, ok := auth.GetUserSubFromContext(c)
+	return ok && sub != "" && sub == message.Owner
 }
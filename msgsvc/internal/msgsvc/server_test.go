@@ -0,0 +1,1633 @@
+package msgsvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws_e2e_test/msgsvc/internal/config"
+	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/msgsvc/internal/moderation"
+	"github.com/aws_e2e_test/msgsvc/internal/store"
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/aws_e2e_test/shared/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidateAttachmentURL(t *testing.T) {
+	allowedHosts := []string{"images.example.com"}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"allowlisted host", "https://images.example.com/cat.png", false},
+		{"allowlisted host with different case", "https://Images.Example.com/cat.png", false},
+		{"disallowed host", "https://evil.example.net/cat.png", true},
+		{"credentials before allowlisted host", "https://images.example.com@evil.example.net/cat.png", true},
+		{"non-http scheme", "file:///etc/passwd", true},
+		{"relative URL", "/cat.png", true},
+		{"malformed URL", "https://images.example.com/%zz", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAttachmentURL(tt.url, allowedHosts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAttachmentURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakePresigner records the last upload it was asked to presign
+type fakePresigner struct {
+	key         string
+	contentType string
+	size        int64
+}
+
+func (p *fakePresigner) PresignPut(_ context.Context, key, contentType string, size int64, _ time.Duration) (string, error) {
+	p.key, p.contentType, p.size = key, contentType, size
+	return "https://bucket.s3.us-east-1.amazonaws.com/" + key + "?X-Amz-Signature=fake", nil
+}
+
+func (p *fakePresigner) ObjectURL(key string) string {
+	return "https://bucket.s3.us-east-1.amazonaws.com/" + key
+}
+
+func TestPresignAttachment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"allowed upload", `{"contentType":"image/png","filename":"../cat photo.png","size":1024}`, http.StatusOK},
+		{"disallowed content type", `{"contentType":"text/html","filename":"page.html","size":1024}`, http.StatusBadRequest},
+		{"too large", `{"contentType":"image/png","filename":"cat.png","size":10485760}`, http.StatusRequestEntityTooLarge},
+		{"missing filename", `{"contentType":"image/png","size":1024}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			presigner := &fakePresigner{}
+			s := &Server{
+				config: &config.Config{
+					AttachmentContentTypes: []string{"image/png"},
+					AttachmentMaxBytes:     5 * 1024 * 1024,
+					AttachmentURLExpiry:    5 * time.Minute,
+				},
+				attachmentPresigner: presigner,
+			}
+			router := gin.New()
+			router.POST("/attachments/presign", func(c *gin.Context) { c.Set("user_sub", "user-1") }, s.presignAttachment)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/attachments/presign", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if presigner.key != "" {
+					t.Errorf("presigner called for rejected request")
+				}
+				return
+			}
+
+			if !strings.HasPrefix(presigner.key, "attachments/user-1/") || !strings.HasSuffix(presigner.key, "-cat_photo.png") {
+				t.Errorf("unexpected object key %q", presigner.key)
+			}
+			if presigner.contentType != "image/png" || presigner.size != 1024 {
+				t.Errorf("presigned content type %q size %d, want image/png 1024", presigner.contentType, presigner.size)
+			}
+
+			var response struct {
+				UploadURL string `json:"uploadUrl"`
+				ObjectURL string `json:"objectUrl"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if response.ObjectURL != presigner.ObjectURL(presigner.key) || response.UploadURL == "" {
+				t.Errorf("unexpected response %+v", response)
+			}
+		})
+	}
+}
+
+// failingModerator is a Moderator whose check always fails
+type failingModerator struct{}
+
+func (failingModerator) Check(context.Context, string) (bool, string, error) {
+	return false, "", errors.New("moderation service unavailable")
+}
+
+func TestCreateMessageModeration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		moderator  moderation.Moderator
+		failOpen   bool
+		text       string
+		wantStatus int
+	}{
+		{"allowed content", moderation.NewWordlistModerator([]string{"darn"}), false, "hello there", http.StatusCreated},
+		{"blocked content", moderation.NewWordlistModerator([]string{"darn"}), false, "well darn", http.StatusUnprocessableEntity},
+		{"check fails closed", failingModerator{}, false, "hello there", http.StatusServiceUnavailable},
+		{"check fails open", failingModerator{}, true, "hello there", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				config:       &config.Config{ModerationFailOpen: tt.failOpen},
+				messageStore: store.NewMessageStore(store.SortAscending),
+				moderator:    tt.moderator,
+			}
+			router := gin.New()
+			router.POST("/messages", s.createMessage)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"text":"`+tt.text+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusUnprocessableEntity && !strings.Contains(rec.Body.String(), `"code":"MODERATION_REJECTED"`) {
+				t.Errorf("unexpected response body %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+// fakeOwnerDirectory resolves subs from a map, recording each batch looked up
+type fakeOwnerDirectory struct {
+	owners  map[string]*store.OwnerProfile
+	batches [][]string
+}
+
+func (d *fakeOwnerDirectory) GetOwners(_ context.Context, subs []string) (map[string]*store.OwnerProfile, error) {
+	d.batches = append(d.batches, subs)
+	profiles := make(map[string]*store.OwnerProfile)
+	for _, sub := range subs {
+		if profile, ok := d.owners[sub]; ok {
+			profiles[sub] = profile
+		}
+	}
+	return profiles, nil
+}
+
+func TestGetMessagesOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newServer := func(directory OwnerDirectory, owners ...string) *Server {
+		if len(owners) == 0 {
+			owners = []string{"sub-alice", "sub-alice", "sub-unknown"}
+		}
+		messageStore := store.NewMessageStore(store.SortAscending)
+		for _, owner := range owners {
+			if err := messageStore.Add(context.Background(), model.NewMessage("hello", owner, "")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return &Server{config: &config.Config{}, messageStore: messageStore, ownerDirectory: directory}
+	}
+	getMessages := func(s *Server) []map[string]json.RawMessage {
+		router := gin.New()
+		router.GET("/messages", s.getMessages)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+		}
+		var messages []map[string]json.RawMessage
+		if err := decodeList(rec.Body.Bytes(), &messages); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return messages
+	}
+
+	t.Run("with owner directory", func(t *testing.T) {
+		directory := &fakeOwnerDirectory{owners: map[string]*store.OwnerProfile{
+			"sub-alice": {FirstName: "Alice", LastName: "Smith", Email: "alice@example.com"},
+		}}
+		messages := getMessages(newServer(directory))
+
+		want := []string{
+			`{"sub":"sub-alice","name":"Alice Smith"}`,
+			`{"sub":"sub-alice","name":"Alice Smith"}`,
+			`{"sub":"sub-unknown"}`,
+		}
+		for i, message := range messages {
+			if string(message["owner"]) != want[i] {
+				t.Errorf("message %d owner = %s, want %s", i, message["owner"], want[i])
+			}
+		}
+		if len(directory.batches) != 1 || !slices.Equal(directory.batches[0], []string{"sub-alice", "sub-unknown"}) {
+			t.Errorf("got owner lookups %v, want one batch of the distinct owners", directory.batches)
+		}
+	})
+
+	t.Run("with display name fallback", func(t *testing.T) {
+		directory := &fakeOwnerDirectory{owners: map[string]*store.OwnerProfile{
+			"sub-full":  {FirstName: "Alice", LastName: "Smith", Email: "alice@example.com"},
+			"sub-email": {Email: "bob.jones@example.com"},
+			"sub-bare":  {},
+		}}
+		s := newServer(directory, "sub-full", "sub-email", "sub-bare", "sub-unknown")
+		s.config.DisplayNameFallback = true
+		messages := getMessages(s)
+
+		want := []string{
+			`{"sub":"sub-full","name":"Alice Smith"}`,
+			`{"sub":"sub-email","name":"bob.jones"}`,
+			`{"sub":"sub-bare","name":"sub-bare"}`,
+			`{"sub":"sub-unknown","name":"sub-unknown"}`,
+		}
+		for i, message := range messages {
+			if string(message["owner"]) != want[i] {
+				t.Errorf("message %d owner = %s, want %s", i, message["owner"], want[i])
+			}
+		}
+	})
+
+	t.Run("without owner directory", func(t *testing.T) {
+		messages := getMessages(newServer(nil))
+		if string(messages[0]["owner"]) != `"sub-alice"` {
+			t.Errorf("owner = %s, want flat sub", messages[0]["owner"])
+		}
+	})
+}
+
+func TestGetMessagesView(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	if err := messageStore.Add(context.Background(), model.NewMessage("hello", "sub-alice", "")); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: &config.Config{}, messageStore: messageStore}
+	router := gin.New()
+	router.GET("/messages", s.getMessages)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantFields []string
+	}{
+		{"", http.StatusOK, []string{"id", "text", "owner", "pinned", "reactions", "timestamp", "contentType"}},
+		{"?view=full", http.StatusOK, []string{"id", "text", "owner", "pinned", "reactions", "timestamp", "contentType"}},
+		{"?view=minimal", http.StatusOK, []string{"id", "text"}},
+		{"?view=compact", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantFields == nil {
+				return
+			}
+
+			var messages []map[string]json.RawMessage
+			if err := decodeList(rec.Body.Bytes(), &messages); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if len(messages) != 1 {
+				t.Fatalf("got %d messages, want 1", len(messages))
+			}
+			fields := make([]string, 0, len(messages[0]))
+			for field := range messages[0] {
+				fields = append(fields, field)
+			}
+			slices.Sort(fields)
+			want := slices.Clone(tt.wantFields)
+			slices.Sort(want)
+			if !slices.Equal(fields, want) {
+				t.Errorf("got fields %v, want %v", fields, want)
+			}
+		})
+	}
+}
+
+func TestReadyWhileDraining(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{config: &config.Config{}, messageStore: store.NewMessageStore(store.SortAscending)}
+	router := gin.New()
+	router.GET("/ready", s.ready)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("before shutdown: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	s.draining.Store(true)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("while draining: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if cacheControl := rec.Header().Get("Cache-Control"); cacheControl != "no-cache, no-store, must-revalidate" {
+		t.Errorf("while draining: got Cache-Control %q", cacheControl)
+	}
+}
+
+func TestEmptyListResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	parent := model.NewMessage("no replies yet", "sub-alice", "")
+	if err := messageStore.Add(context.Background(), parent); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: &config.Config{}, messageStore: messageStore}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_sub", "sub-alice") })
+	router.GET("/messages", s.getMessages)
+	router.GET("/messages/:id/replies", s.getReplies)
+	router.GET("/messages/:id/history", s.getMessageHistory)
+
+	// Every list endpoint answers with the envelope, even when there is nothing to list
+	for _, path := range []string{"/messages?prefix=bye", "/messages/" + parent.ID + "/replies", "/messages/" + parent.ID + "/history"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"items":[],"count":0}` {
+			t.Errorf("GET %s: got status %d and body %s, want an empty list", path, rec.Code, rec.Body.String())
+		}
+		if cacheControl := rec.Header().Get("Cache-Control"); cacheControl != "no-cache, no-store, must-revalidate" {
+			t.Errorf("GET %s: got Cache-Control %q", path, cacheControl)
+		}
+	}
+}
+
+func TestGetTopOwners(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	for _, owner := range []string{"sub-alice", "sub-bob", "sub-bob"} {
+		if err := messageStore.Add(context.Background(), model.NewMessage("hello", owner, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{config: &config.Config{}, messageStore: messageStore}
+	router := gin.New()
+	router.GET("/admin/messages/top-owners", s.getTopOwners)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantOwners []string
+	}{
+		{"", http.StatusOK, []string{"sub-bob", "sub-alice"}},
+		{"?limit=1", http.StatusOK, []string{"sub-bob"}},
+		{"?limit=0", http.StatusBadRequest, nil},
+		{"?limit=101", http.StatusBadRequest, nil},
+		{"?limit=ten", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/messages/top-owners"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantOwners == nil {
+				return
+			}
+
+			var owners []store.OwnerCount
+			if err := decodeList(rec.Body.Bytes(), &owners); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			got := make([]string, len(owners))
+			for i, owner := range owners {
+				got[i] = owner.Owner
+			}
+			if !slices.Equal(got, tt.wantOwners) {
+				t.Errorf("got owners %v, want %v", got, tt.wantOwners)
+			}
+		})
+	}
+}
+
+func TestGetMessagesPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	for _, text := range []string{"Hello there", "help", "oh hello"} {
+		if err := messageStore.Add(context.Background(), model.NewMessage(text, "sub-alice", "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{config: &config.Config{}, messageStore: messageStore}
+	router := gin.New()
+	router.GET("/messages", s.getMessages)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantTexts  []string
+	}{
+		{"?prefix=hel", http.StatusOK, []string{"Hello there", "help"}},
+		{"?prefix=HELLO", http.StatusOK, []string{"Hello there"}},
+		{"?prefix=hel&limit=1", http.StatusOK, []string{"Hello there"}},
+		{"?prefix=bye", http.StatusOK, []string{}},
+		{"?prefix=hel&limit=0", http.StatusBadRequest, nil},
+		{"?prefix=hel&since=2024-01-01T00:00:00Z", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantTexts == nil {
+				return
+			}
+
+			var messages []model.Message
+			if err := decodeList(rec.Body.Bytes(), &messages); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			texts := make([]string, len(messages))
+			for i, message := range messages {
+				texts[i] = message.Text
+			}
+			if !slices.Equal(texts, tt.wantTexts) {
+				t.Errorf("got %v, want %v", texts, tt.wantTexts)
+			}
+		})
+	}
+}
+
+func TestGetMessagesSnapshotPaging(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	base := time.Now().UTC().Add(-time.Hour)
+	addMessage := func(text string, at time.Time) {
+		message := model.NewMessage(text, "sub-alice", "")
+		message.Timestamp = httputil.Timestamp(at)
+		if err := messageStore.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, text := range []string{"one", "two", "three", "four", "five"} {
+		addMessage(text, base.Add(time.Duration(i)*time.Minute))
+	}
+	s := &Server{config: &config.Config{}, messageStore: messageStore}
+	router := gin.New()
+	router.GET("/messages", s.getMessages)
+
+	getPage := func(query url.Values) ([]string, http.Header) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages?"+query.Encode(), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+		}
+		var messages []model.Message
+		if err := decodeList(rec.Body.Bytes(), &messages); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		texts := make([]string, len(messages))
+		for i, message := range messages {
+			texts[i] = message.Text
+		}
+		return texts, rec.Header()
+	}
+
+	// The first page takes the snapshot at request time
+	texts, header := getPage(url.Values{"limit": {"2"}})
+	snapshotAt := header.Get("X-Snapshot-At")
+	if snapshotAt == "" {
+		t.Fatal("missing X-Snapshot-At header")
+	}
+	snapshot, err := time.Parse(time.RFC3339Nano, snapshotAt)
+	if err != nil {
+		t.Fatalf("parsing X-Snapshot-At: %v", err)
+	}
+
+	// Messages posted between page fetches must not appear in the snapshot
+	seen := texts
+	for cursor := header.Get("X-Next-Cursor"); cursor != ""; cursor = header.Get("X-Next-Cursor") {
+		addMessage(fmt.Sprintf("new %d", len(seen)), snapshot.Add(time.Duration(len(seen))*time.Millisecond))
+		texts, header = getPage(url.Values{"limit": {"2"}, "snapshotAt": {snapshotAt}, "cursor": {cursor}})
+		seen = append(seen, texts...)
+	}
+	if want := []string{"one", "two", "three", "four", "five"}; !slices.Equal(seen, want) {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+
+	// A later snapshot includes them
+	texts, _ = getPage(url.Values{"limit": {"10"}, "snapshotAt": {snapshot.Add(time.Second).Format(time.RFC3339Nano)}})
+	if len(texts) != 7 {
+		t.Errorf("got %d messages in a new snapshot, want 7", len(texts))
+	}
+
+	for _, query := range []string{
+		"?cursor=not-a-cursor",
+		"?snapshotAt=yesterday",
+		"?snapshotAt=2024-01-01T00:00:00Z&prefix=hel",
+		"?cursor=abc&since=2024-01-01T00:00:00Z",
+		"?limit=201",
+		// A scan cursor, as in X-Result-Cursor, does not resume a page
+		"?cursor=eyJJRCI6IngifQ",
+		"?scanCursor=eyJJRCI6IngifQ",
+		"?scanCursor=eyJJRCI6IngifQ&limit=2",
+		"?scanCursor=eyJJRCI6IngifQ&since=2024-01-01T00:00:00Z",
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+// lookupCountingStore counts the messages looked up by ID
+type lookupCountingStore struct {
+	*store.MessageStore
+	lookups int
+}
+
+func (l *lookupCountingStore) GetByID(ctx context.Context, id string) (*model.Message, error) {
+	l.lookups++
+	return l.MessageStore.GetByID(ctx, id)
+}
+
+func TestGetMessageValidatesID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := &lookupCountingStore{MessageStore: store.NewMessageStore(store.SortAscending)}
+	message := model.NewMessage("hello", "sub-alice", "")
+	if err := messageStore.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: &config.Config{}, messageStore: messageStore}
+	router := gin.New()
+	router.Use(middleware.MaxPathParamLength(middleware.DefaultMaxPathParamLength))
+	router.GET("/messages/:id", validateMessageID, s.getMessage)
+
+	tests := []struct {
+		name        string
+		id          string
+		wantStatus  int
+		wantCode    string
+		wantLookups int
+	}{
+		{"valid", message.ID, http.StatusOK, "", 1},
+		{"valid but unknown", "00000000-0000-0000-0000-000000000000", http.StatusNotFound, "MESSAGE_NOT_FOUND", 1},
+		{"malformed", "not-a-uuid", http.StatusBadRequest, "INVALID_ID", 0},
+		{"braced UUID", "{" + message.ID + "}", http.StatusBadRequest, "INVALID_ID", 0},
+		{"over-long", strings.Repeat("a", middleware.DefaultMaxPathParamLength+1), http.StatusBadRequest, "PARAM_TOO_LONG", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageStore.lookups = 0
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages/"+tt.id, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" && !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("got body %s, want code %s", rec.Body.String(), tt.wantCode)
+			}
+			// Rejected IDs never reach the store
+			if messageStore.lookups != tt.wantLookups {
+				t.Errorf("got %d store lookups, want %d", messageStore.lookups, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestBatchGetMessages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	var ids []string
+	for _, text := range []string{"parent one", "parent two"} {
+		message := model.NewMessage(text, "sub-alice", "")
+		if err := messageStore.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, message.ID)
+	}
+	missing := "00000000-0000-0000-0000-000000000000"
+	s := &Server{config: &config.Config{MaxBatchSize: 3}, messageStore: messageStore}
+	router := gin.New()
+	router.POST("/messages/batch-get", s.batchGetMessages)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantTexts  []string
+	}{
+		{"found and missing at the limit", fmt.Sprintf(`{"ids":[%q,%q,%q]}`, ids[1], missing, ids[0]), http.StatusOK, []string{"parent two", "parent one"}},
+		{"none found", fmt.Sprintf(`{"ids":[%q]}`, missing), http.StatusOK, []string{}},
+		{"over the cap", fmt.Sprintf(`{"ids":[%q,%q,%q,%q]}`, ids[0], ids[1], missing, missing), http.StatusBadRequest, nil},
+		{"invalid ID", `{"ids":["not-a-uuid"]}`, http.StatusBadRequest, nil},
+		{"missing ids", `{}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/messages/batch-get", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantTexts == nil {
+				return
+			}
+
+			var messages []model.Message
+			if err := decodeList(rec.Body.Bytes(), &messages); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			texts := make([]string, len(messages))
+			for i, message := range messages {
+				texts[i] = message.Text
+			}
+			if !slices.Equal(texts, tt.wantTexts) {
+				t.Errorf("got %v, want %v", texts, tt.wantTexts)
+			}
+		})
+	}
+}
+
+func TestCheckBatchSize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{config: &config.Config{MaxBatchSize: 25}}
+	tests := []struct {
+		name   string
+		size   int
+		wantOK bool
+	}{
+		{"below the limit", 24, true},
+		{"at the limit", 25, true},
+		{"above the limit", 26, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+
+			if ok := s.checkBatchSize(c, tt.size); ok != tt.wantOK {
+				t.Fatalf("checkBatchSize(%d) = %t, want %t", tt.size, ok, tt.wantOK)
+			}
+			if tt.wantOK {
+				if rec.Body.Len() != 0 {
+					t.Errorf("wrote a response for an allowed batch: %s", rec.Body.String())
+				}
+				return
+			}
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			var body struct {
+				Code string `json:"code"`
+				Max  int    `json:"max"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if body.Code != "BATCH_TOO_LARGE" || body.Max != 25 {
+				t.Errorf("got code %q and max %d, want BATCH_TOO_LARGE and 25", body.Code, body.Max)
+			}
+		})
+	}
+}
+
+func TestCreateMessageEmptyBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{config: &config.Config{}, messageStore: store.NewMessageStore(store.SortAscending)}
+	router := gin.New()
+	router.POST("/messages", s.createMessage)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/messages", nil)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if want := `{"code":"EMPTY_BODY","error":"request body is required"}`; rec.Body.String() != want {
+		t.Errorf("got body %s, want %s", rec.Body.String(), want)
+	}
+}
+
+func TestGetRecentFeed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	base := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		message := model.NewMessage(fmt.Sprintf("message %d", i), "sub-alice", "")
+		message.Timestamp = httputil.Timestamp(base.Add(time.Duration(i) * time.Minute))
+		if err := messageStore.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{config: &config.Config{}, messageStore: messageStore}
+	router := gin.New()
+	router.GET("/messages/recent", s.getRecentFeed)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantTexts  []string
+	}{
+		{"?n=2", http.StatusOK, []string{"message 4", "message 3"}},
+		{"", http.StatusOK, []string{"message 4", "message 3", "message 2", "message 1", "message 0"}},
+		{"?n=0", http.StatusOK, []string{"message 4"}},
+		{"?n=1000", http.StatusOK, []string{"message 4", "message 3", "message 2", "message 1", "message 0"}},
+		{"?n=many", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages/recent"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantTexts == nil {
+				return
+			}
+
+			var messages []model.Message
+			if err := decodeList(rec.Body.Bytes(), &messages); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			texts := make([]string, len(messages))
+			for i, message := range messages {
+				texts[i] = message.Text
+			}
+			if !slices.Equal(texts, tt.wantTexts) {
+				t.Errorf("got %v, want %v", texts, tt.wantTexts)
+			}
+		})
+	}
+}
+
+func TestRecentFeedLimitClamp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for query, want := range map[string]int{"": 50, "?n=0": 1, "?n=-5": 1, "?n=75": 75, "?n=500": recentFeedLimit.Max} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/messages/recent"+query, nil)
+		if got, ok := recentFeedLimit.clamp(c, "n"); !ok || got != want {
+			t.Errorf("clamp(%q) = %d, %t, want %d", query, got, ok, want)
+		}
+	}
+}
+
+func TestCreateMessageWhitespace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		normalize  bool
+		text       string
+		wantStatus int
+		wantText   string
+	}{
+		{"normal text", false, "hello there", http.StatusCreated, "hello there"},
+		{"leading and trailing spaces", false, "  hello  there \t", http.StatusCreated, "hello  there"},
+		{"whitespace only", false, " \t ", http.StatusBadRequest, ""},
+		{"normalized", true, "  hello \t  there ", http.StatusCreated, "hello there"},
+		{"whitespace only normalized", true, " \n ", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageStore := store.NewMessageStore(store.SortAscending)
+			s := &Server{
+				config:       &config.Config{NormalizeWhitespace: tt.normalize},
+				messageStore: messageStore,
+				moderator:    moderation.NewWordlistModerator(nil),
+			}
+			router := gin.New()
+			router.POST("/messages", s.createMessage)
+
+			body, err := json.Marshal(map[string]string{"text": tt.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if !strings.Contains(rec.Body.String(), `"code":"EMPTY_MESSAGE"`) {
+					t.Errorf("unexpected response body %s", rec.Body.String())
+				}
+				return
+			}
+
+			messages, err := messageStore.GetAll(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 1 || messages[0].Text != tt.wantText {
+				t.Errorf("stored %v, want one message with text %q", messages, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestCreateMessageContentType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		contentType string
+		wantStatus  int
+		wantType    string
+	}{
+		{"default", "", http.StatusCreated, model.ContentTypePlain},
+		{"markdown", "text/markdown", http.StatusCreated, model.ContentTypeMarkdown},
+		{"invalid", "text/html", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageStore := store.NewMessageStore(store.SortAscending)
+			s := &Server{
+				config:       &config.Config{},
+				messageStore: messageStore,
+				moderator:    moderation.NewWordlistModerator(nil),
+			}
+			router := gin.New()
+			router.POST("/messages", s.createMessage)
+
+			request := map[string]string{"text": "hello"}
+			if tt.contentType != "" {
+				request["contentType"] = tt.contentType
+			}
+			body, err := json.Marshal(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if !strings.Contains(rec.Body.String(), `"code":"INVALID_CONTENT_TYPE"`) {
+					t.Errorf("unexpected response body %s", rec.Body.String())
+				}
+				return
+			}
+
+			var response model.Message
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.ContentType != tt.wantType {
+				t.Errorf("response content type %q, want %q", response.ContentType, tt.wantType)
+			}
+			messages, err := messageStore.GetAll(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 1 || messages[0].ContentType != tt.wantType {
+				t.Errorf("stored %v, want one message with content type %q", messages, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestReplies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	parent := model.NewMessage("parent", "sub-alice", "")
+	if err := messageStore.Add(context.Background(), parent); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config:       &config.Config{},
+		messageStore: messageStore,
+		moderator:    moderation.NewWordlistModerator(nil),
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_sub", "sub-bob") })
+	router.GET("/messages", s.getMessages)
+	router.POST("/messages", s.createMessage)
+	router.GET("/messages/:id/replies", s.getReplies)
+
+	post := func(parentID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"text":"reply","parentId":"`+parentID+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := post(parent.ID)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating a reply: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var reply model.Message
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ParentID != parent.ID {
+		t.Errorf("reply has parentId %q, want %q", reply.ParentID, parent.ID)
+	}
+
+	// A reply must name an existing message
+	for _, parentID := range []string{"not-a-uuid", "00000000-0000-0000-0000-000000000000"} {
+		if rec := post(parentID); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"INVALID_PARENT"`) {
+			t.Errorf("reply to %s: got status %d and body %s, want INVALID_PARENT", parentID, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = get("/messages/" + parent.ID + "/replies")
+	var replies []model.Message
+	if err := decodeList(rec.Body.Bytes(), &replies); err != nil {
+		t.Fatalf("listing replies: %v: %s", err, rec.Body.String())
+	}
+	if len(replies) != 1 || replies[0].ID != reply.ID {
+		t.Errorf("got replies %v, want only %s", replies, reply.ID)
+	}
+	if rec := get("/messages/00000000-0000-0000-0000-000000000000/replies"); rec.Code != http.StatusNotFound {
+		t.Errorf("replies to a missing message: got status %d, want 404", rec.Code)
+	}
+
+	tests := []struct {
+		query   string
+		wantIDs []string
+	}{
+		{"", []string{parent.ID, reply.ID}},
+		{"?topLevelOnly=true", []string{parent.ID}},
+	}
+	for _, tt := range tests {
+		var messages []model.Message
+		if err := decodeList(get("/messages"+tt.query).Body.Bytes(), &messages); err != nil {
+			t.Fatalf("GET /messages%s: %v", tt.query, err)
+		}
+		ids := make([]string, len(messages))
+		for i, message := range messages {
+			ids[i] = message.ID
+		}
+		slices.Sort(ids)
+		slices.Sort(tt.wantIDs)
+		if !slices.Equal(ids, tt.wantIDs) {
+			t.Errorf("GET /messages%s returned %v, want %v", tt.query, ids, tt.wantIDs)
+		}
+	}
+}
+
+func TestUpdateMessageHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	message := model.NewMessage("first", "user-1", "")
+	if err := messageStore.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config:       &config.Config{EditHistoryLimit: 2},
+		messageStore: messageStore,
+		moderator:    moderation.NewWordlistModerator(nil),
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_sub", c.GetHeader("X-Test-Sub")) })
+	router.PUT("/messages/:id", s.updateMessage)
+	router.GET("/messages/:id/history", s.getMessageHistory)
+
+	update := func(sub, text string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/messages/"+message.ID, strings.NewReader(`{"text":"`+text+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-Sub", sub)
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := update("user-2", "hijacked"); rec.Code != http.StatusForbidden {
+		t.Fatalf("edit by another user: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	for _, text := range []string{"second", "third", "fourth"} {
+		if rec := update("user-1", text); rec.Code != http.StatusOK {
+			t.Fatalf("edit to %q: got status %d: %s", text, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/messages/"+message.ID+"/history", nil)
+	req.Header.Set("X-Test-Sub", "user-1")
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("history: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var history []model.MessageEdit
+	if err := decodeList(rec.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Text != "second" || history[1].Text != "third" {
+		t.Errorf("history = %+v, want the edits of second and third", history)
+	}
+}
+
+func TestGetMeta(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{config: &config.Config{DefaultSort: "desc"}}
+	router := gin.New()
+	router.GET("/_meta", s.getMeta)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_meta", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		Endpoints []metaEndpoint `json:"endpoints"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	params := make(map[string]metaParam)
+	orders := make(map[string]string)
+	for _, endpoint := range response.Endpoints {
+		orders[endpoint.Path] = endpoint.Order
+		for _, param := range endpoint.Params {
+			params[endpoint.Path+"?"+param.Name] = param
+		}
+	}
+
+	if got := params["/admin/messages/top-owners?limit"]; got.Max != topOwnersLimit.Max || got.Default != "10" {
+		t.Errorf("top owners limit = %+v, want max %d and default 10", got, topOwnersLimit.Max)
+	}
+	if got := params["/admin/messages/recent?limit"]; got.Max != recentMessagesLimit.Max {
+		t.Errorf("recent messages limit max = %d, want %d", got.Max, recentMessagesLimit.Max)
+	}
+	if got := params["/messages?since"]; got.Max != maxMessagesSince {
+		t.Errorf("since page size = %d, want %d", got.Max, maxMessagesSince)
+	}
+	if got := params["/messages?view"]; !slices.Equal(got.Values, messageViews) {
+		t.Errorf("view values = %v, want %v", got.Values, messageViews)
+	}
+	if got := orders["/messages/:id/replies"]; got != "timestamp desc" {
+		t.Errorf("replies order = %q, want the configured sort", got)
+	}
+}
+
+// bufferingStore holds added messages in memory until Close writes them to the underlying store
+type bufferingStore struct {
+	*store.MessageStore
+	pending []*model.Message
+}
+
+func (b *bufferingStore) Add(_ context.Context, message *model.Message) error {
+	b.pending = append(b.pending, message)
+	return nil
+}
+
+func (b *bufferingStore) Close(ctx context.Context) (int, error) {
+	flushed := 0
+	for len(b.pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return flushed, err
+		}
+		if err := b.MessageStore.Add(ctx, b.pending[0]); err != nil {
+			return flushed, err
+		}
+		b.pending = b.pending[1:]
+		flushed++
+	}
+	return flushed, nil
+}
+
+func TestCloseStoreFlushesBufferedWrites(t *testing.T) {
+	buffered := &bufferingStore{MessageStore: store.NewMessageStore(store.SortAscending)}
+	s := &Server{config: &config.Config{}, messageStore: &metricsStore{next: buffered}}
+	for _, text := range []string{"one", "two", "three"} {
+		if err := s.messageStore.Add(context.Background(), model.NewMessage(text, "owner", "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if messages, _ := buffered.MessageStore.GetAll(context.Background()); len(messages) != 0 {
+		t.Fatalf("%d messages written before shutdown, want them buffered", len(messages))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.closeStore(ctx); err != nil {
+		t.Fatalf("closeStore: %v", err)
+	}
+
+	messages, err := buffered.MessageStore.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || len(buffered.pending) != 0 {
+		t.Errorf("after closeStore %d messages written and %d pending, want 3 and 0", len(messages), len(buffered.pending))
+	}
+
+	// A store that does not buffer has nothing to flush
+	s.messageStore = store.NewMessageStore(store.SortAscending)
+	if err := s.closeStore(ctx); err != nil {
+		t.Errorf("closeStore without buffering: %v", err)
+	}
+}
+
+func TestCORSMessagesDeletePreflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	preflight := func(cfg *config.Config, path string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(middleware.CORS([]string{"https://app.example.com"}, corsPolicies(cfg)...))
+		router.DELETE("/messages/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	headers := []string{"Origin", "Content-Type", "Authorization"}
+
+	// DELETE is only enabled for the message endpoints
+	cfg := &config.Config{
+		CorsMethods:         []string{"GET", "POST", "OPTIONS"},
+		CorsHeaders:         headers,
+		CorsMessagesMethods: []string{"GET", "POST", "DELETE", "OPTIONS"},
+		CorsMessagesHeaders: headers,
+	}
+	rec := preflight(cfg, "/messages/123")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want 204", rec.Code)
+	}
+	if methods := rec.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(methods, http.MethodDelete) {
+		t.Errorf("/messages/123 allows methods %q, want DELETE", methods)
+	}
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", origin)
+	}
+	if methods := preflight(cfg, "/admin/messages/recent").Header().Get("Access-Control-Allow-Methods"); strings.Contains(methods, http.MethodDelete) {
+		t.Errorf("/admin/messages/recent allows methods %q, want no DELETE", methods)
+	}
+
+	// Without DELETE in the message policy, browsers are not told it is allowed
+	cfg.CorsMessagesMethods = []string{"GET", "POST", "OPTIONS"}
+	if methods := preflight(cfg, "/messages/123").Header().Get("Access-Control-Allow-Methods"); strings.Contains(methods, http.MethodDelete) {
+		t.Errorf("/messages/123 allows methods %q with DELETE disabled", methods)
+	}
+}
+
+func TestIsSingleGrapheme(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want bool
+	}{
+		{"emoji", "👍", true},
+		{"letter", "a", true},
+		{"variation selector", "❤️", true},
+		{"skin tone", "👍🏽", true},
+		{"keycap", "1️⃣", true},
+		{"flag", "🇺🇸", true},
+		{"subdivision flag", "🏴\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", true},
+		{"ZWJ sequence", "👩\u200D💻", true},
+		{"ZWJ family", "👨\u200D👩\u200D👧\u200D👦", true},
+		{"ZWJ with variation selector", "🏳️\u200D🌈", true},
+		{"empty", "", false},
+		{"two emoji", "👍👍", false},
+		{"word", "ok", false},
+		{"two flags", "🇺🇸🇬🇧", false},
+		{"lone regional indicator", "🇺", false},
+		{"trailing ZWJ", "👩\u200D", false},
+		{"double ZWJ", "👩\u200D\u200D💻", false},
+		{"leading combining mark", "\u0301a", false},
+		{"space", " ", false},
+		{"control", "\n", false},
+		{"invalid UTF-8", "\xff", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSingleGrapheme(tt.s); got != tt.want {
+				t.Errorf("isSingleGrapheme(%q) = %t, want %t", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReactions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	message := model.NewMessage("hello", "user-1", "")
+	if err := messageStore.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: &config.Config{MaxReactionsPerMessage: 1}, messageStore: messageStore}
+	router := gin.New()
+	router.POST("/messages/:id/reactions", s.addReaction)
+	router.DELETE("/messages/:id/reactions/:emoji", s.removeReaction)
+
+	request := func(method, emoji string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		var req *http.Request
+		if method == http.MethodPost {
+			req = httptest.NewRequest(method, "/messages/"+message.ID+"/reactions", strings.NewReader(`{"emoji":"`+emoji+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+		} else {
+			req = httptest.NewRequest(method, "/messages/"+message.ID+"/reactions/"+url.PathEscape(emoji), nil)
+		}
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		emoji         string
+		wantStatus    int
+		wantReactions map[string]int
+	}{
+		{"add", http.MethodPost, "👩\u200D💻", http.StatusOK, map[string]int{"👩\u200D💻": 1}},
+		{"add again", http.MethodPost, "👩\u200D💻", http.StatusOK, map[string]int{"👩\u200D💻": 2}},
+		{"over the limit", http.MethodPost, "🇺🇸", http.StatusConflict, nil},
+		{"not an emoji", http.MethodPost, "ok", http.StatusBadRequest, nil},
+		{"remove", http.MethodDelete, "👩\u200D💻", http.StatusOK, map[string]int{"👩\u200D💻": 1}},
+		{"remove the last", http.MethodDelete, "👩\u200D💻", http.StatusOK, map[string]int{}},
+		{"remove a missing emoji", http.MethodDelete, "👩\u200D💻", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		rec := request(tt.method, tt.emoji)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+		if tt.wantReactions == nil {
+			continue
+		}
+		var body model.Message
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(body.Reactions, tt.wantReactions) {
+			t.Errorf("%s: reactions = %v, want %v", tt.name, body.Reactions, tt.wantReactions)
+		}
+	}
+}
+
+func TestErrorResponseShape(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	message := model.NewMessage("first", "user-1", "")
+	if err := messageStore.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config:       &config.Config{},
+		messageStore: messageStore,
+		moderator:    moderation.NewWordlistModerator(nil),
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if sub := c.GetHeader("X-Test-Sub"); sub != "" {
+			c.Set("user_sub", sub)
+		}
+	})
+	router.GET("/messages", s.getMessages)
+	router.POST("/messages", s.createMessage)
+	router.DELETE("/messages/mine", s.deleteMyMessages)
+	router.GET("/messages/:id", s.getMessage)
+	router.PUT("/messages/:id", s.updateMessage)
+	router.POST("/messages/:id/reactions", s.addReaction)
+	router.POST("/attachments/presign", s.presignAttachment)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"bad query", http.MethodGet, "/messages?view=bogus", "", http.StatusBadRequest, "INVALID_VIEW"},
+		{"malformed body", http.MethodPost, "/messages", `{"text":`, http.StatusBadRequest, "INVALID_BODY"},
+		{"no identity", http.MethodDelete, "/messages/mine", "", http.StatusUnauthorized, "UNAUTHORIZED"},
+		{"not found", http.MethodGet, "/messages/00000000-0000-0000-0000-000000000000", "", http.StatusNotFound, "MESSAGE_NOT_FOUND"},
+		{"not the owner", http.MethodPut, "/messages/" + message.ID, `{"text":"hijacked"}`, http.StatusForbidden, "FORBIDDEN"},
+		{"bad reaction", http.MethodPost, "/messages/" + message.ID + "/reactions", `{"emoji":"not an emoji"}`, http.StatusBadRequest, "INVALID_REACTION"},
+		{"not configured", http.MethodPost, "/attachments/presign", `{}`, http.StatusServiceUnavailable, "UPLOADS_NOT_CONFIGURED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Test-Sub", "user-2")
+			if tt.wantStatus == http.StatusUnauthorized {
+				req.Header.Del("X-Test-Sub")
+			}
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			// Every error has exactly a code and a message
+			var body httputil.ErrorResponse
+			decoder := json.NewDecoder(rec.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&body); err != nil {
+				t.Fatalf("decoding error response: %v", err)
+			}
+			if body.Code != tt.wantCode || body.Error == "" {
+				t.Errorf("got code %q and error %q, want code %s and a message", body.Code, body.Error, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestPinLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	var ids []string
+	for _, text := range []string{"first", "second", "third"} {
+		message := model.NewMessage(text, "user-1", "")
+		if err := messageStore.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, message.ID)
+	}
+	// Pins of other owners do not count towards user-1's limit
+	other := model.NewMessage("other", "user-2", "")
+	other.Pinned = true
+	if err := messageStore.Add(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{config: &config.Config{MaxPinnedPerOwner: 2}, messageStore: messageStore}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_sub", "user-1") })
+	router.POST("/messages/:id/pin", s.pinMessage)
+	router.POST("/messages/:id/unpin", s.unpinMessage)
+
+	request := func(action, id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/messages/"+id+"/"+action, nil))
+		return rec
+	}
+
+	// Up to the limit
+	for _, id := range ids[:2] {
+		if rec := request("pin", id); rec.Code != http.StatusOK {
+			t.Fatalf("pin %s: got status %d: %s", id, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Over the limit
+	rec := request("pin", ids[2])
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("pin over the limit: got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"PIN_LIMIT_REACHED"`) {
+		t.Errorf("unexpected body %s", rec.Body.String())
+	}
+
+	// Pinning an already pinned message is not a new pin
+	if rec := request("pin", ids[0]); rec.Code != http.StatusOK {
+		t.Errorf("re-pin at the limit: got status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Unpinning frees a slot
+	if rec := request("unpin", ids[0]); rec.Code != http.StatusOK {
+		t.Fatalf("unpin: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := request("pin", ids[2]); rec.Code != http.StatusOK {
+		t.Errorf("pin after unpinning: got status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// failingStore fails every read that GET /messages makes with err
+type failingStore struct {
+	*store.MessageStore
+	err error
+}
+
+func (f *failingStore) GetAllWithBudget(_ context.Context, startCursor string, maxDuration time.Duration) ([]*model.Message, bool, string, error) {
+	return nil, false, "", f.err
+}
+
+func (f *failingStore) GetSince(_ context.Context, since time.Time, limit int32) ([]*model.Message, error) {
+	return nil, f.err
+}
+
+func (f *failingStore) GetByPrefix(_ context.Context, prefix string, limit int32) ([]*model.Message, error) {
+	return nil, f.err
+}
+
+func (f *failingStore) GetPage(_ context.Context, snapshotAt time.Time, cursor string, limit int32) ([]*model.Message, string, error) {
+	return nil, "", f.err
+}
+
+func TestGetMessagesStoreError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tt := range []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"store failure", errors.New("connection reset"), http.StatusInternalServerError, "INTERNAL_ERROR"},
+		{"circuit open", store.ErrStoreUnavailable, http.StatusServiceUnavailable, "STORE_UNAVAILABLE"},
+	} {
+		s := &Server{
+			config:       &config.Config{StoreBreakerCooldown: 30 * time.Second},
+			messageStore: &failingStore{MessageStore: store.NewMessageStore(store.SortAscending), err: tt.err},
+		}
+		router := gin.New()
+		router.GET("/messages", s.getMessages)
+
+		// A failed read is reported, never answered with an empty list
+		for _, query := range []string{"", "?since=2024-01-01T00:00:00Z", "?prefix=he", "?limit=10"} {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages"+query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s, GET /messages%s: got status %d, want %d: %s", tt.name, query, rec.Code, tt.wantStatus, rec.Body.String())
+				continue
+			}
+			var body httputil.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != tt.wantCode {
+				t.Errorf("%s, GET /messages%s: got body %s, want code %s", tt.name, query, rec.Body.String(), tt.wantCode)
+			}
+		}
+	}
+}
+
+// slowStore blocks every read that GET /messages makes until the request context is done
+type slowStore struct {
+	*store.MessageStore
+}
+
+func (f *slowStore) GetAllWithBudget(ctx context.Context, startCursor string, maxDuration time.Duration) ([]*model.Message, bool, string, error) {
+	<-ctx.Done()
+	return nil, false, "", ctx.Err()
+}
+
+func (f *slowStore) GetSince(ctx context.Context, since time.Time, limit int32) ([]*model.Message, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGetMessagesRequestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{
+		config:       &config.Config{StoreBreakerCooldown: 30 * time.Second},
+		messageStore: &slowStore{MessageStore: store.NewMessageStore(store.SortAscending)},
+	}
+	router := gin.New()
+	router.Use(middleware.RequestTimeout(20 * time.Millisecond))
+	router.GET("/messages", s.getMessages)
+
+	// The deadline reaches the store, and the error the handler reports for it becomes a 504
+	for _, query := range []string{"", "?since=2024-01-01T00:00:00Z"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages"+query, nil))
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("GET /messages%s: got status %d, want %d: %s", query, rec.Code, http.StatusGatewayTimeout, rec.Body.String())
+			continue
+		}
+		var body httputil.ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "TIMEOUT" {
+			t.Errorf("GET /messages%s: got body %s, want code TIMEOUT", query, rec.Body.String())
+		}
+	}
+}
+
+func TestGetDailyCounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStore := store.NewMessageStore(store.SortAscending)
+	for _, timestamp := range []string{
+		"2024-05-31T23:59:59.999Z", // before the range
+		"2024-06-01T00:00:00Z",
+		"2024-06-01T12:30:00Z",
+		"2024-06-01T23:59:59.5Z",
+		"2024-06-03T08:00:00Z",
+		"2024-06-04T00:00:00Z", // after the range
+	} {
+		at, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message := model.NewMessage("hello", "user-1", "")
+		message.Timestamp = httputil.Timestamp(at)
+		if err := messageStore.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Server{config: &config.Config{}, messageStore: messageStore}
+	router := gin.New()
+	router.GET("/admin/messages/daily", s.getDailyCounts)
+
+	request := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/messages/daily"+query, nil))
+		return rec
+	}
+
+	// Days without messages are present with 0, and the range ends are whole UTC days
+	rec := request("?from=2024-06-01&to=2024-06-03")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if want := `{"2024-06-01":3,"2024-06-02":0,"2024-06-03":1}`; rec.Body.String() != want {
+		t.Errorf("got body %s, want %s", rec.Body.String(), want)
+	}
+
+	// Without from, the 30 days ending on to are counted
+	rec = request("?to=2024-06-03")
+	var counts map[string]int64
+	if err := json.Unmarshal(rec.Body.Bytes(), &counts); err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != defaultDailyCountDays || counts["2024-05-05"] != 0 || counts["2024-05-31"] != 1 || counts["2024-06-01"] != 3 {
+		t.Errorf("default range: got %d days %v", len(counts), counts)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode string
+	}{
+		{"malformed from", "?from=June&to=2024-06-03", "INVALID_DATE"},
+		{"timestamp to", "?to=2024-06-03T00:00:00Z", "INVALID_DATE"},
+		{"reversed range", "?from=2024-06-03&to=2024-06-01", "INVALID_RANGE"},
+		{"range too large", "?from=2023-01-01&to=2024-06-01", "RANGE_TOO_LARGE"},
+	}
+	for _, tt := range tests {
+		rec := request(tt.query)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+			t.Errorf("%s: got status %d body %s, want 400 %s", tt.name, rec.Code, rec.Body.String(), tt.wantCode)
+		}
+	}
+
+	// A full year, leap day included, is within the cap
+	if rec := request("?from=2024-01-01&to=2024-12-31"); rec.Code != http.StatusOK {
+		t.Errorf("366 days: got status %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// decodeList decodes a list response, checking that its count matches its items
+func decodeList[T any](body []byte, items *[]T) error {
+	var list httputil.ListResponse[T]
+	if err := json.Unmarshal(body, &list); err != nil {
+		return err
+	}
+	if list.Count != len(list.Items) {
+		return fmt.Errorf("count is %d, but there are %d items", list.Count, len(list.Items))
+	}
+	*items = list.Items
+	return nil
+}
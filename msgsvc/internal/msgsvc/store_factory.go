@@ -0,0 +1,84 @@
+package msgsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws_e2e_test/msgsvc/internal/config"
+	"github.com/aws_e2e_test/msgsvc/internal/store"
+	"github.com/aws_e2e_test/shared/awsutil"
+)
+
+// NewStore creates the message store selected by STORAGE_BACKEND, wrapped in the decorators
+// enabled by configuration. The circuit breaker is not a decorator: it is built into the
+// DynamoDB client so that it sees the SDK's errors after retries.
+func NewStore(cfg *config.Config) (MessageStore, error) {
+	var messageStore MessageStore
+
+	sortOrder := store.SortOrder(cfg.DefaultSort)
+
+	switch cfg.StorageBackend {
+	case "dynamodb":
+		dynamoDBStore, err := store.NewDynamoDBMessageStore(store.DynamoDBMessageStoreConfig{
+			TableName:       cfg.DynamoDBTableName,
+			TablePrefix:     cfg.DynamoDBTablePrefix,
+			TableSuffix:     cfg.DynamoDBTableSuffix,
+			SortOrder:       sortOrder,
+			AutoCreateTable: cfg.DynamoDBAutoCreateTable,
+			Endpoint:        cfg.DynamoDBEndpoint,
+			HTTPClient:      cfg.DynamoDBHTTPClient,
+
+			BreakerThreshold: cfg.StoreBreakerThreshold,
+			BreakerWindow:    cfg.StoreBreakerWindow,
+			BreakerCooldown:  cfg.StoreBreakerCooldown,
+		})
+		if errors.Is(err, awsutil.ErrNoCredentials) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to create DynamoDB message store: %v", err)
+			log.Printf("ERROR: Stack trace: %+v", err)
+			log.Printf("CRITICAL: Falling back to in-memory message store (WARNING: not suitable for multiple instances)")
+			messageStore = store.NewMessageStore(sortOrder)
+		} else {
+			// Optionally verify read/write access so permission problems fail the deploy
+			if cfg.StartupSelfTest {
+				if err := dynamoDBStore.SelfTest(context.Background()); err != nil {
+					log.Printf("ERROR: Startup self-test failed: %v", err)
+					return nil, err
+				}
+			}
+			messageStore = dynamoDBStore
+		}
+	case "memory":
+		log.Println("STORAGE: Using in-memory message store (suitable for local development only)")
+		log.Println("STORAGE: Set STORAGE_BACKEND=dynamodb for production/multi-instance deployments")
+		messageStore = store.NewMessageStore(sortOrder)
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND '%s': expected 'memory' or 'dynamodb'", cfg.StorageBackend)
+	}
+
+	if cfg.StoreMetrics {
+		messageStore = &metricsStore{next: messageStore}
+	}
+	return messageStore, nil
+}
+
+// storeUnwrapper is implemented by decorators, so that optional interfaces of the underlying
+// store (such as readinessChecker) can still be found
+type storeUnwrapper interface {
+	Unwrap() MessageStore
+}
+
+// baseStore returns the store underneath any decorators
+func baseStore(messageStore MessageStore) MessageStore {
+	for {
+		unwrapper, ok := messageStore.(storeUnwrapper)
+		if !ok {
+			return messageStore
+		}
+		messageStore = unwrapper.Unwrap()
+	}
+}
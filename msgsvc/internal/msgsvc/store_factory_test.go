@@ -0,0 +1,61 @@
+package msgsvc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws_e2e_test/msgsvc/internal/config"
+	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/msgsvc/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNewStore(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		messageStore, err := NewStore(&config.Config{StorageBackend: "memory", DefaultSort: "asc"})
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		if _, ok := messageStore.(*store.MessageStore); !ok {
+			t.Errorf("got %T, want *store.MessageStore", messageStore)
+		}
+	})
+
+	t.Run("memory with metrics", func(t *testing.T) {
+		messageStore, err := NewStore(&config.Config{StorageBackend: "memory", DefaultSort: "asc", StoreMetrics: true})
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		if _, ok := messageStore.(*metricsStore); !ok {
+			t.Fatalf("got %T, want *metricsStore", messageStore)
+		}
+		if _, ok := baseStore(messageStore).(*store.MessageStore); !ok {
+			t.Errorf("base store is %T, want *store.MessageStore", baseStore(messageStore))
+		}
+
+		before := storeCallCount(t, "add")
+		if err := messageStore.Add(context.Background(), model.NewMessage("hello", "owner", "")); err != nil {
+			t.Fatal(err)
+		}
+		if got := storeCallCount(t, "add") - before; got != 1 {
+			t.Errorf("recorded %d add calls, want 1", got)
+		}
+	})
+
+	t.Run("unknown backend", func(t *testing.T) {
+		if _, err := NewStore(&config.Config{StorageBackend: "postgres"}); err == nil {
+			t.Error("NewStore accepted an unknown backend")
+		}
+	})
+}
+
+// storeCallCount returns the number of successful store calls recorded for operation
+func storeCallCount(t *testing.T, operation string) uint64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := storeCallDuration.WithLabelValues(operation, "success").(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,138 @@
+package msgsvc
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/msgsvc/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// storeCallDuration measures message store calls by operation and outcome, when STORE_METRICS
+// is enabled
+var storeCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "msgsvc_store_call_duration_seconds",
+	Help:    "Duration of message store calls, by operation and outcome.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation", "outcome"})
+
+// observeStoreCall records a store call that started at start and returned *err
+func observeStoreCall(operation string, start time.Time, err *error) {
+	outcome := "success"
+	if *err != nil {
+		outcome = "error"
+	}
+	storeCallDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// metricsStore is a MessageStore decorator that records the duration of every call
+type metricsStore struct {
+	next MessageStore
+}
+
+// Unwrap returns the decorated store
+func (m *metricsStore) Unwrap() MessageStore {
+	return m.next
+}
+
+func (m *metricsStore) GetAll(ctx context.Context) (messages []*model.Message, err error) {
+	defer observeStoreCall("get_all", time.Now(), &err)
+	return m.next.GetAll(ctx)
+}
+
+func (m *metricsStore) GetAllSorted(ctx context.Context) (messages []*model.Message, err error) {
+	defer observeStoreCall("get_all_sorted", time.Now(), &err)
+	return m.next.GetAllSorted(ctx)
+}
+
+func (m *metricsStore) GetAllWithBudget(ctx context.Context, startCursor string, maxDuration time.Duration) (messages []*model.Message, truncated bool, cursor string, err error) {
+	defer observeStoreCall("get_all_with_budget", time.Now(), &err)
+	return m.next.GetAllWithBudget(ctx, startCursor, maxDuration)
+}
+
+func (m *metricsStore) GetSince(ctx context.Context, since time.Time, limit int32) (messages []*model.Message, err error) {
+	defer observeStoreCall("get_since", time.Now(), &err)
+	return m.next.GetSince(ctx, since, limit)
+}
+
+func (m *metricsStore) GetRecent(ctx context.Context, limit int32) (messages []*model.Message, err error) {
+	defer observeStoreCall("get_recent", time.Now(), &err)
+	return m.next.GetRecent(ctx, limit)
+}
+
+func (m *metricsStore) GetPage(ctx context.Context, snapshotAt time.Time, cursor string, limit int32) (messages []*model.Message, next string, err error) {
+	defer observeStoreCall("get_page", time.Now(), &err)
+	return m.next.GetPage(ctx, snapshotAt, cursor, limit)
+}
+
+func (m *metricsStore) GetByPrefix(ctx context.Context, prefix string, limit int32) (messages []*model.Message, err error) {
+	defer observeStoreCall("get_by_prefix", time.Now(), &err)
+	return m.next.GetByPrefix(ctx, prefix, limit)
+}
+
+func (m *metricsStore) GetByID(ctx context.Context, id string) (message *model.Message, err error) {
+	defer observeStoreCall("get_by_id", time.Now(), &err)
+	return m.next.GetByID(ctx, id)
+}
+
+func (m *metricsStore) GetByIDs(ctx context.Context, ids []string) (messages []*model.Message, err error) {
+	defer observeStoreCall("get_by_ids", time.Now(), &err)
+	return m.next.GetByIDs(ctx, ids)
+}
+
+func (m *metricsStore) Exists(ctx context.Context, id string) (exists bool, err error) {
+	defer observeStoreCall("exists", time.Now(), &err)
+	return m.next.Exists(ctx, id)
+}
+
+func (m *metricsStore) GetReplies(ctx context.Context, parentID string) (messages []*model.Message, err error) {
+	defer observeStoreCall("get_replies", time.Now(), &err)
+	return m.next.GetReplies(ctx, parentID)
+}
+
+func (m *metricsStore) Add(ctx context.Context, message *model.Message) (err error) {
+	defer observeStoreCall("add", time.Now(), &err)
+	return m.next.Add(ctx, message)
+}
+
+func (m *metricsStore) Update(ctx context.Context, id, text string, maxHistory int) (message *model.Message, err error) {
+	defer observeStoreCall("update", time.Now(), &err)
+	return m.next.Update(ctx, id, text, maxHistory)
+}
+
+func (m *metricsStore) SetPinned(ctx context.Context, id string, pinned bool) (err error) {
+	defer observeStoreCall("set_pinned", time.Now(), &err)
+	return m.next.SetPinned(ctx, id, pinned)
+}
+
+func (m *metricsStore) AddReaction(ctx context.Context, id, emoji string, maxReactions int) (message *model.Message, err error) {
+	defer observeStoreCall("add_reaction", time.Now(), &err)
+	return m.next.AddReaction(ctx, id, emoji, maxReactions)
+}
+
+func (m *metricsStore) RemoveReaction(ctx context.Context, id, emoji string) (message *model.Message, err error) {
+	defer observeStoreCall("remove_reaction", time.Now(), &err)
+	return m.next.RemoveReaction(ctx, id, emoji)
+}
+
+func (m *metricsStore) DeleteByOwner(ctx context.Context, owner string) (deleted int, err error) {
+	defer observeStoreCall("delete_by_owner", time.Now(), &err)
+	return m.next.DeleteByOwner(ctx, owner)
+}
+
+func (m *metricsStore) CountByOwner(ctx context.Context, limit int) (owners []store.OwnerCount, err error) {
+	defer observeStoreCall("count_by_owner", time.Now(), &err)
+	return m.next.CountByOwner(ctx, limit)
+}
+
+func (m *metricsStore) CountPinnedByOwner(ctx context.Context, owner string) (count int, err error) {
+	defer observeStoreCall("count_pinned_by_owner", time.Now(), &err)
+	return m.next.CountPinnedByOwner(ctx, owner)
+}
+
+func (m *metricsStore) CountByDay(ctx context.Context, start, end time.Time) (counts map[string]int64, err error) {
+	defer observeStoreCall("count_by_day", time.Now(), &err)
+	return m.next.CountByDay(ctx, start, end)
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// ErrStoreUnavailable is returned without calling DynamoDB while the circuit breaker is open
+var ErrStoreUnavailable = errors.New("message store is unavailable")
+
+// CircuitState is the state of a circuit breaker
+type CircuitState string
+
+const (
+	// CircuitClosed lets all calls through
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen fails all calls fast until the cooldown has passed
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single probe call through to test whether the store has recovered
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitBreaker stops calls to a failing dependency. After threshold consecutive failures
+// within window it opens and rejects calls for cooldown, then lets one call through: the
+// circuit closes if that call succeeds and opens again if it fails.
+type CircuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mutex        sync.Mutex
+	state        CircuitState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+// NewCircuitBreaker creates a closed circuit breaker. A threshold of 0 or less disables it.
+func NewCircuitBreaker(threshold int, window, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     CircuitClosed,
+	}
+}
+
+// Allow returns ErrStoreUnavailable if a call may not be made now. Every allowed call must be
+// followed by a call to Record with its result.
+func (b *CircuitBreaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrStoreUnavailable
+		}
+		log.Printf("Store circuit breaker half-open, probing the store")
+		b.state = CircuitHalfOpen
+		b.probing = true
+		return nil
+	case CircuitHalfOpen:
+		// Only one probe at a time
+		if b.probing {
+			return ErrStoreUnavailable
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// Record updates the breaker with the result of an allowed call
+func (b *CircuitBreaker) Record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.threshold <= 0 {
+		return
+	}
+
+	if !isUnavailable(err) {
+		if b.state != CircuitClosed {
+			log.Printf("Store circuit breaker closed, the store has recovered")
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	now := b.now()
+	if b.state == CircuitHalfOpen {
+		log.Printf("Store circuit breaker probe failed, reopening: %v", err)
+		b.open(now)
+		return
+	}
+
+	// Only failures within window of the first one count towards the threshold
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		log.Printf("Store circuit breaker open after %d consecutive failures: %v", b.failures, err)
+		b.open(now)
+	}
+}
+
+// Abandon releases an allowed call that the caller gave up on, without counting it either way.
+// A half-open breaker lets the next call probe the store instead.
+func (b *CircuitBreaker) Abandon() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// open opens the circuit from now
+func (b *CircuitBreaker) open(now time.Time) {
+	b.state = CircuitOpen
+	b.openedAt = now
+	b.failures = 0
+	b.probing = false
+}
+
+// State returns the current state of the breaker
+func (b *CircuitBreaker) State() CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// Cooldown returns how long the breaker stays open before probing the store
+func (b *CircuitBreaker) Cooldown() time.Duration {
+	return b.cooldown
+}
+
+// AddToStack adds the breaker to an AWS SDK client's middleware stack, so that it sees every
+// operation once, after the SDK's own retries
+func (b *CircuitBreaker) AddToStack(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CircuitBreaker",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			if err := b.Allow(); err != nil {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, err
+			}
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			// A call cut short by its context, such as a scan out of budget or a request past
+			// its deadline, says nothing about the store
+			if err != nil && ctx.Err() != nil {
+				b.Abandon()
+			} else {
+				b.Record(err)
+			}
+			return out, metadata, err
+		}), middleware.Before)
+}
+
+// isUnavailable reports whether err suggests the store itself is failing, as opposed to a
+// rejected request (e.g. a failed condition) or a caller giving up
+func isUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() == smithy.FaultServer
+	}
+	// Network errors and timeouts
+	return true
+}
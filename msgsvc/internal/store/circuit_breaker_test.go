@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// fakeClock is a settable time source for the circuit breaker
+type fakeClock struct{ now time.Time }
+
+func (f *fakeClock) Now() time.Time { return f.now }
+
+func newTestBreaker(threshold int) (*CircuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	breaker := NewCircuitBreaker(threshold, 10*time.Second, 5*time.Second)
+	breaker.now = clock.Now
+	return breaker, clock
+}
+
+// call runs one call through the breaker with the given result
+func call(b *CircuitBreaker, result error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	b.Record(result)
+	return result
+}
+
+var errServer = &smithy.GenericAPIError{Code: "InternalServerError", Fault: smithy.FaultServer}
+
+func TestCircuitBreakerTripsAndRecovers(t *testing.T) {
+	b, clock := newTestBreaker(3)
+
+	for i := 0; i < 3; i++ {
+		if err := call(b, errServer); errors.Is(err, ErrStoreUnavailable) {
+			t.Fatalf("call %d failed fast before the threshold", i+1)
+		}
+	}
+	if b.State() != CircuitOpen {
+		t.Fatalf("state = %s after 3 failures, want open", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("Allow() = %v while open, want ErrStoreUnavailable", err)
+	}
+
+	// After the cooldown a single probe is let through; a failed probe reopens the circuit
+	clock.now = clock.now.Add(5 * time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() = %v after cooldown, want probe allowed", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrStoreUnavailable) {
+		t.Errorf("second call allowed while probing")
+	}
+	b.Record(errServer)
+	if b.State() != CircuitOpen {
+		t.Fatalf("state = %s after failed probe, want open", b.State())
+	}
+
+	// A successful probe closes it
+	clock.now = clock.now.Add(5 * time.Second)
+	if err := call(b, nil); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if b.State() != CircuitClosed {
+		t.Fatalf("state = %s after successful probe, want closed", b.State())
+	}
+	if err := call(b, nil); err != nil {
+		t.Errorf("call after recovery: %v", err)
+	}
+}
+
+func TestCircuitBreakerCountsOnlyRecentConsecutiveFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []error
+		advance time.Duration
+	}{
+		{"success resets the count", []error{errServer, errServer, nil, errServer, errServer}, 0},
+		{"failures outside the window", []error{errServer, errServer, errServer}, 6 * time.Second},
+		{"client errors", []error{
+			&types.ConditionalCheckFailedException{},
+			&types.ConditionalCheckFailedException{},
+			&types.ConditionalCheckFailedException{},
+		}, 0},
+		{"cancelled calls", []error{context.Canceled, context.Canceled, context.Canceled}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, clock := newTestBreaker(3)
+			for _, result := range tt.results {
+				call(b, result)
+				clock.now = clock.now.Add(tt.advance)
+			}
+			if b.State() != CircuitClosed {
+				t.Errorf("state = %s, want closed", b.State())
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b, _ := newTestBreaker(0)
+	for i := 0; i < 10; i++ {
+		if err := call(b, errServer); errors.Is(err, ErrStoreUnavailable) {
+			t.Fatal("disabled breaker failed fast")
+		}
+	}
+}
+
+// failingTransport answers every request with a DynamoDB server error, or with an empty item
+// once healthy is set
+type failingTransport struct {
+	requests atomic.Int32
+	healthy  atomic.Bool
+}
+
+func (f *failingTransport) Do(req *http.Request) (*http.Response, error) {
+	f.requests.Add(1)
+	status, body := http.StatusInternalServerError, `{"__type":"com.amazonaws.dynamodb.v20120810#InternalServerError","message":"boom"}`
+	if f.healthy.Load() {
+		status, body = http.StatusOK, `{}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestCircuitBreakerAroundDynamoDBClient(t *testing.T) {
+	breaker, clock := newTestBreaker(2)
+	transport := &failingTransport{}
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+		APIOptions:       []func(*middleware.Stack) error{breaker.AddToStack},
+	})
+	s := &DynamoDBMessageStore{client: client, tableName: "messages", breaker: breaker}
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.GetByID(context.Background(), "id"); err == nil || errors.Is(err, ErrStoreUnavailable) {
+			t.Fatalf("call %d: got %v, want a DynamoDB error", i+1, err)
+		}
+	}
+	if _, err := s.GetByID(context.Background(), "id"); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("got %v with the circuit open, want ErrStoreUnavailable", err)
+	}
+	if got := transport.requests.Load(); got != 2 {
+		t.Errorf("DynamoDB received %d requests, want 2", got)
+	}
+	if s.CircuitState() != CircuitOpen {
+		t.Errorf("CircuitState() = %s, want open", s.CircuitState())
+	}
+
+	transport.healthy.Store(true)
+	clock.now = clock.now.Add(5 * time.Second)
+	if _, err := s.GetByID(context.Background(), "id"); err != nil {
+		t.Fatalf("probe after recovery: %v", err)
+	}
+	if s.CircuitState() != CircuitClosed {
+		t.Errorf("CircuitState() = %s after recovery, want closed", s.CircuitState())
+	}
+}
+
+// hangingTransport answers no request, failing each one once its context is done
+type hangingTransport struct{}
+
+func (hangingTransport) Do(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestCircuitBreakerIgnoresScanBudget(t *testing.T) {
+	breaker, _ := newTestBreaker(2)
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       hangingTransport{},
+		RetryMaxAttempts: 1,
+		APIOptions:       []func(*middleware.Stack) error{breaker.AddToStack},
+	})
+
+	// Scans cut short by their budget are truncated results, not store failures
+	for i := 0; i < 3; i++ {
+		_, _, truncated, err := scanWithBudget(context.Background(), client, &dynamodb.ScanInput{TableName: aws.String("messages")}, 10*time.Millisecond)
+		if err != nil || !truncated {
+			t.Fatalf("scan %d: got truncated %t and error %v, want a truncated result", i+1, truncated, err)
+		}
+	}
+	if breaker.State() != CircuitClosed {
+		t.Errorf("state = %s after budget-truncated scans, want closed", breaker.State())
+	}
+}
+
+func TestCircuitBreakerAbandonReleasesProbe(t *testing.T) {
+	b, clock := newTestBreaker(1)
+	call(b, errServer)
+	clock.now = clock.now.Add(5 * time.Second)
+
+	// The probe's caller gives up, so another call may probe the store
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() = %v after the cooldown, want a probe", err)
+	}
+	b.Abandon()
+	if b.State() != CircuitHalfOpen {
+		t.Errorf("state = %s after an abandoned probe, want half-open", b.State())
+	}
+	if err := call(b, nil); err != nil {
+		t.Fatalf("second probe: %v", err)
+	}
+	if b.State() != CircuitClosed {
+		t.Errorf("state = %s after a successful probe, want closed", b.State())
+	}
+}
@@ -2,66 +2,153 @@ package store
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws_e2e_test/msgsvc/internal/logging"
 	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/shared/awsutil"
+	"github.com/aws_e2e_test/shared/httputil"
 )
 
+// parentIDIndexName is the name of the global secondary index used to look up replies
+const parentIDIndexName = "ParentIDIndex"
+
+// timestampIndexName is the name of the global secondary index used to look up messages by
+// time. Every message shares the same Feed partition, with Timestamp as the sort key. One index
+// partition takes about 1,000 writes a second, so this caps the rate of new and edited messages
+// for the whole table; beyond that, Feed would need to be split into shards queried in parallel.
+const timestampIndexName = "TimestampIndex"
+
+// ownerIndexName is the name of the global secondary index used to look up messages by owner
+const ownerIndexName = "OwnerIndex"
+
+// textIndexName is the name of the global secondary index used for prefix search. Every message
+// shares the same Feed partition, with TextLower as the sort key.
+const textIndexName = "TextIndex"
+
+// maxBatchWriteItems is the maximum number of requests DynamoDB accepts in one BatchWriteItem call
+const maxBatchWriteItems = 25
+
+// maxBatchWriteAttempts is the number of times a batch write request is sent before the requests
+// DynamoDB leaves unprocessed are given up on
+const maxBatchWriteAttempts = 5
+
+// batchWriteBackoff is the wait before the first retry of unprocessed batch write requests. It
+// doubles before each retry after that.
+var batchWriteBackoff = 50 * time.Millisecond
+
+// maxBatchGetKeys is the maximum number of keys DynamoDB accepts in one BatchGetItem call
+const maxBatchGetKeys = 100
+
+// messageFeed is the Feed partition value written on every message. Messages stored before
+// Feed existed have none, and so are missing from the timestamp and text indexes until
+// BackfillIndexKeys has run.
+const messageFeed = "messages"
+
+// DynamoDBMessageStoreConfig holds configuration for the DynamoDB message store
+type DynamoDBMessageStoreConfig struct {
+	TableName string
+	SortOrder SortOrder
+
+	// TablePrefix and TableSuffix are applied verbatim around TableName (see awsutil.TableName)
+	TablePrefix string
+	TableSuffix string
+
+	// AutoCreateTable creates the table at startup if it does not exist
+	AutoCreateTable bool
+
+	// Endpoint overrides the DynamoDB endpoint (e.g. a local DynamoDB for testing)
+	Endpoint string
+
+	// HTTPClient tunes the connection pool of the DynamoDB HTTP client
+	HTTPClient awsutil.HTTPClientConfig
+
+	// After BreakerThreshold consecutive failures within BreakerWindow, calls fail fast with
+	// ErrStoreUnavailable for BreakerCooldown (0 threshold = no circuit breaker)
+	BreakerThreshold int
+	BreakerWindow    time.Duration
+	BreakerCooldown  time.Duration
+}
+
 // DynamoDBMessageStore is a DynamoDB-based implementation of message store
 type DynamoDBMessageStore struct {
-	client    *dynamodb.Client
-	tableName string
+	client          *dynamodb.Client
+	tableName       string
+	sortOrder       SortOrder
+	autoCreateTable bool
+	breaker         *CircuitBreaker
+	readiness       *awsutil.TableReadiness
 }
 
 // NewDynamoDBMessageStore creates a new DynamoDB-based message store
-func NewDynamoDBMessageStore(tableName string) (*DynamoDBMessageStore, error) {
-	log.Printf("Initializing DynamoDB message store with table name: %s", tableName)
+func NewDynamoDBMessageStore(storeConfig DynamoDBMessageStoreConfig) (*DynamoDBMessageStore, error) {
+	tableName := awsutil.TableName(storeConfig.TableName, storeConfig.TablePrefix, storeConfig.TableSuffix)
+	log.Printf("Initializing DynamoDB message store with table name: %s (resolved from %q)", tableName, storeConfig.TableName)
 
 	// Validate table name
 	if tableName == "" {
 		return nil, fmt.Errorf("table name cannot be empty")
 	}
 
-	// Load AWS configuration with explicit region
-	// First try to get region from environment variable
-	region := os.Getenv("AWS_REGION")
-	if region == "" {
-		// Default to us-east-1 if not specified
-		region = "us-east-1"
-		log.Printf("AWS_REGION not set, defaulting to %s", region)
-	}
+	// Resolve the region consistently with the other AWS clients
+	region := awsutil.ResolveRegion("")
 
 	// Load AWS configuration
 	log.Printf("Loading AWS configuration for region: %s", region)
 	cfg, err := config.LoadDefaultConfig(context.TODO(),
 		config.WithRegion(region),
+		config.WithHTTPClient(awsutil.NewHTTPClient(storeConfig.HTTPClient)),
 	)
 	if err != nil {
 		log.Printf("Failed to load AWS config: %v", err)
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	// Create DynamoDB client
-	client := dynamodb.NewFromConfig(cfg)
+	// Fail at startup rather than on the first call if there are no credentials. A local
+	// endpoint accepts any credentials.
+	if storeConfig.Endpoint == "" {
+		if err := awsutil.CheckCredentials(context.TODO(), cfg); err != nil {
+			log.Printf("ERROR: %v", err)
+			return nil, err
+		}
+	}
+
+	// Create DynamoDB client, with every call going through the circuit breaker
+	breaker := NewCircuitBreaker(storeConfig.BreakerThreshold, storeConfig.BreakerWindow, storeConfig.BreakerCooldown)
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if storeConfig.Endpoint != "" {
+			o.BaseEndpoint = aws.String(storeConfig.Endpoint)
+		}
+		o.APIOptions = append(o.APIOptions, breaker.AddToStack)
+	})
 
 	log.Printf("Initialized DynamoDB client in region: %s", region)
 
 	// Create the store
 	store := &DynamoDBMessageStore{
-		client:    client,
-		tableName: tableName,
+		client:          client,
+		tableName:       tableName,
+		sortOrder:       storeConfig.SortOrder,
+		autoCreateTable: storeConfig.AutoCreateTable,
+		breaker:         breaker,
+		readiness:       awsutil.NewTableReadiness(client, tableName),
 	}
 
 	// Ensure the table exists
-	err = store.ensureTableExists()
+	err = store.ensureTableExists(context.TODO())
 	if err != nil {
 		return nil, fmt.Errorf("failed to ensure table exists: %w", err)
 	}
@@ -70,7 +157,7 @@ func NewDynamoDBMessageStore(tableName string) (*DynamoDBMessageStore, error) {
 }
 
 // ensureTableExists creates the DynamoDB table if it doesn't exist
-func (s *DynamoDBMessageStore) ensureTableExists() error {
+func (s *DynamoDBMessageStore) ensureTableExists(ctx context.Context) error {
 	log.Printf("Checking if DynamoDB table %s exists...", s.tableName)
 
 	// Check if table exists
@@ -79,7 +166,7 @@ func (s *DynamoDBMessageStore) ensureTableExists() error {
 	}
 	log.Printf("Describing table with input: %+v", describeInput)
 
-	describeOutput, err := s.client.DescribeTable(context.TODO(), describeInput)
+	describeOutput, err := s.client.DescribeTable(ctx, describeInput)
 
 	// If table exists, return
 	if err == nil {
@@ -98,6 +185,15 @@ func (s *DynamoDBMessageStore) ensureTableExists() error {
 		return fmt.Errorf("failed to describe table: %w", err)
 	}
 
+	if !s.autoCreateTable {
+		log.Printf("ERROR: DynamoDB table %s does not exist and auto-create is disabled", s.tableName)
+		return fmt.Errorf("table %q not found and auto-create is disabled; provision it with partition key ID (S), "+
+			"global secondary index %s with partition key ParentID (S), global secondary index %s with "+
+			"partition key Feed (S) and sort key Timestamp (S), global secondary index %s with partition key "+
+			"Owner (S) and global secondary index %s with partition key Feed (S) and sort key TextLower (S)",
+			s.tableName, parentIDIndexName, timestampIndexName, ownerIndexName, textIndexName)
+	}
+
 	log.Printf("DynamoDB table %s does not exist, creating it now...", s.tableName)
 
 	// Create table if it doesn't exist
@@ -108,6 +204,26 @@ func (s *DynamoDBMessageStore) ensureTableExists() error {
 				AttributeName: aws.String("ID"),
 				AttributeType: types.ScalarAttributeTypeS,
 			},
+			{
+				AttributeName: aws.String("ParentID"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("Feed"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("Timestamp"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("Owner"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("TextLower"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
 		},
 		KeySchema: []types.KeySchemaElement{
 			{
@@ -115,12 +231,74 @@ func (s *DynamoDBMessageStore) ensureTableExists() error {
 				KeyType:       types.KeyTypeHash,
 			},
 		},
+		// Index replies by the message they reply to (top-level messages have no ParentID)
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(parentIDIndexName),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("ParentID"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+			// Index all messages by timestamp for incremental polling
+			{
+				IndexName: aws.String(timestampIndexName),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("Feed"),
+						KeyType:       types.KeyTypeHash,
+					},
+					{
+						AttributeName: aws.String("Timestamp"),
+						KeyType:       types.KeyTypeRange,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+			// Index messages by owner for account deletion
+			{
+				IndexName: aws.String(ownerIndexName),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("Owner"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+			// Index all messages by lower-cased text for prefix search
+			{
+				IndexName: aws.String(textIndexName),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("Feed"),
+						KeyType:       types.KeyTypeHash,
+					},
+					{
+						AttributeName: aws.String("TextLower"),
+						KeyType:       types.KeyTypeRange,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
 		BillingMode: types.BillingModePayPerRequest,
 	}
 
 	log.Printf("Creating table with input: %+v", createInput)
 
-	_, err = s.client.CreateTable(context.TODO(), createInput)
+	_, err = s.client.CreateTable(ctx, createInput)
 
 	if err != nil {
 		log.Printf("Failed to create table %s: %v", s.tableName, err)
@@ -131,7 +309,7 @@ func (s *DynamoDBMessageStore) ensureTableExists() error {
 
 	// Wait for table to be active
 	waiter := dynamodb.NewTableExistsWaiter(s.client)
-	err = waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{
+	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{
 		TableName: aws.String(s.tableName),
 	}, 5*60)
 
@@ -144,18 +322,26 @@ func (s *DynamoDBMessageStore) ensureTableExists() error {
 	return nil
 }
 
-// GetAll returns all messages
-func (s *DynamoDBMessageStore) GetAll() ([]*model.Message, error) {
+// Ready reports whether the table is reachable and active, for readiness checks. The result of
+// DescribeTable is reused for a few seconds, so frequent probes do not each call it.
+func (s *DynamoDBMessageStore) Ready(ctx context.Context) error {
+	return s.readiness.Check(ctx)
+}
+
+// CircuitState returns the state of the circuit breaker around DynamoDB calls
+func (s *DynamoDBMessageStore) CircuitState() CircuitState {
+	return s.breaker.State()
+}
+
+// GetAll returns all messages ordered by timestamp
+func (s *DynamoDBMessageStore) GetAll(ctx context.Context) ([]*model.Message, error) {
 	log.Printf("Getting all messages from DynamoDB table %s", s.tableName)
 
 	// Scan the table to get all items
-	scanInput := &dynamodb.ScanInput{
-		TableName:      aws.String(s.tableName),
-		ConsistentRead: aws.Bool(true), // Use strongly consistent reads
-	}
+	scanInput := s.scanAllInput()
 
-	log.Printf("Scanning table with input: %+v", scanInput)
-	result, err := s.client.Scan(context.TODO(), scanInput)
+	logging.Debugf("Scanning table with input: %+v", scanInput)
+	result, err := s.client.Scan(ctx, scanInput)
 
 	if err != nil {
 		log.Printf("Failed to scan table %s: %v", s.tableName, err)
@@ -164,26 +350,210 @@ func (s *DynamoDBMessageStore) GetAll() ([]*model.Message, error) {
 
 	log.Printf("Scan returned %d items from table %s", len(result.Items), s.tableName)
 
-	// Unmarshal items into messages
+	// Unmarshal items into messages. Per-item logs are sampled debug logs to keep log volume
+	// manageable for large scans.
 	messages := make([]*model.Message, 0, len(result.Items))
 	for i, item := range result.Items {
-		log.Printf("Processing item %d: %+v", i, item)
-		var message model.Message
-		err := attributevalue.UnmarshalMap(item, &message)
+		logging.SampledDebugf(i, "Processing item %d: %s", i, awsutil.LogItem(item))
+		message, err := unmarshalMessage(item)
 		if err != nil {
 			log.Printf("Failed to unmarshal item %d: %v", i, err)
 			continue
 		}
-		log.Printf("Successfully unmarshalled item to message: %+v", message)
-		messages = append(messages, &message)
+		messages = append(messages, message)
 	}
 
+	// Scan order is arbitrary, so sort to match the in-memory store
+	SortMessages(messages, s.sortOrder)
+
 	log.Printf("Returning %d messages from table %s", len(messages), s.tableName)
 	return messages, nil
 }
 
-// Add adds a new message to the store
-func (s *DynamoDBMessageStore) Add(message *model.Message) error {
+// GetAllWithBudget returns messages ordered by timestamp, paging through the table scan until
+// maxDuration is nearly used up. If the scan had to stop early, truncated is true and cursor
+// encodes the key the scan would resume from; passing it back as startCursor resumes the scan
+// there. A maxDuration of zero or less scans everything.
+func (s *DynamoDBMessageStore) GetAllWithBudget(ctx context.Context, startCursor string, maxDuration time.Duration) ([]*model.Message, bool, string, error) {
+	log.Printf("Getting messages from DynamoDB table %s with a budget of %s", s.tableName, maxDuration)
+
+	input := s.scanAllInput()
+	if startCursor != "" {
+		startKey, err := decodeScanCursor(startCursor)
+		if err != nil {
+			return nil, false, "", err
+		}
+		input.ExclusiveStartKey = startKey
+	}
+
+	items, lastKey, truncated, err := scanWithBudget(ctx, s.client, input, maxDuration)
+	if err != nil {
+		log.Printf("Failed to scan table %s: %v", s.tableName, err)
+		return []*model.Message{}, false, "", fmt.Errorf("failed to scan table: %w", err)
+	}
+
+	cursor := ""
+	if truncated {
+		cursor, err = encodeCursor(lastKey)
+		if err != nil {
+			return []*model.Message{}, false, "", err
+		}
+		log.Printf("Scan of table %s stopped after %d items to stay within %s", s.tableName, len(items), maxDuration)
+	}
+
+	messages := make([]*model.Message, 0, len(items))
+	for i, item := range items {
+		message, err := unmarshalMessage(item)
+		if err != nil {
+			log.Printf("Failed to unmarshal item %d: %v", i, err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	SortMessages(messages, s.sortOrder)
+
+	log.Printf("Returning %d messages from table %s (truncated: %t)", len(messages), s.tableName, truncated)
+	return messages, truncated, cursor, nil
+}
+
+// scanWithBudget pages through a scan until there are no more pages or the next page would
+// likely not finish within maxDuration, judged by the slowest page so far. A page that is still
+// running when the budget runs out is cancelled. It returns the items read so far, the key to
+// resume from, and whether the scan stopped early.
+func scanWithBudget(ctx context.Context, client dynamodb.ScanAPIClient, input *dynamodb.ScanInput, maxDuration time.Duration) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, bool, error) {
+	start := time.Now()
+	paginator := dynamodb.NewScanPaginator(client, input)
+
+	budgetCtx := ctx
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		budgetCtx, cancel = context.WithDeadline(ctx, start.Add(maxDuration))
+		defer cancel()
+	}
+
+	var items []map[string]types.AttributeValue
+	var lastKey map[string]types.AttributeValue
+	var slowestPage time.Duration
+	for paginator.HasMorePages() {
+		if maxDuration > 0 && time.Since(start)+slowestPage >= maxDuration {
+			return items, lastKey, true, nil
+		}
+
+		pageStart := time.Now()
+		page, err := paginator.NextPage(budgetCtx)
+		if err != nil {
+			// Running out of budget is not a failure, as long as the caller's context is still live
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				return items, lastKey, true, nil
+			}
+			return nil, nil, false, err
+		}
+
+		slowestPage = max(slowestPage, time.Since(pageStart))
+		items = append(items, page.Items...)
+		lastKey = page.LastEvaluatedKey
+	}
+	return items, nil, false, nil
+}
+
+// encodeCursor encodes a scan's LastEvaluatedKey as an opaque, URL-safe cursor
+func encodeCursor(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	var values map[string]interface{}
+	if err := attributevalue.UnmarshalMap(key, &values); err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decodes a cursor produced by encodeCursor back into the key to resume from
+func decodeCursor(cursor string) (map[string]types.AttributeValue, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	key, err := attributevalue.MarshalMap(values)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return key, nil
+}
+
+// decodeScanCursor decodes a cursor that resumes a table scan, whose key is the message ID alone
+func decodeScanCursor(cursor string) (map[string]types.AttributeValue, error) {
+	key, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := key["ID"].(*types.AttributeValueMemberS); !ok || id.Value == "" || len(key) != 1 {
+		return nil, fmt.Errorf("%w: not a scan cursor", ErrInvalidCursor)
+	}
+	return key, nil
+}
+
+// decodePageCursor decodes a cursor that resumes a query of the timestamp index, whose key has
+// the index attributes as well as the message ID. A scan cursor is rejected here, since DynamoDB
+// would fail the query rather than report a bad cursor.
+func decodePageCursor(cursor string) (map[string]types.AttributeValue, error) {
+	key, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	feed, ok := key["Feed"].(*types.AttributeValueMemberS)
+	if !ok || feed.Value != messageFeed || len(key) != 3 {
+		return nil, fmt.Errorf("%w: not a page cursor", ErrInvalidCursor)
+	}
+	if _, err := pageKeyMessage(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// pageKey returns the timestamp index key of a message, in the form DynamoDB returns it as the
+// LastEvaluatedKey of a query on the index
+func pageKey(message *model.Message) map[string]types.AttributeValue {
+	timestamp, err := attributevalue.Marshal(message.Timestamp)
+	if err != nil {
+		timestamp = &types.AttributeValueMemberS{Value: message.Timestamp.Time().Format(time.RFC3339Nano)}
+	}
+	return map[string]types.AttributeValue{
+		"ID":        &types.AttributeValueMemberS{Value: message.ID},
+		"Feed":      &types.AttributeValueMemberS{Value: messageFeed},
+		"Timestamp": timestamp,
+	}
+}
+
+// pageKeyMessage returns a message holding just the ID and timestamp of a timestamp index key
+func pageKeyMessage(key map[string]types.AttributeValue) (*model.Message, error) {
+	var message model.Message
+	if err := attributevalue.UnmarshalMap(key, &message); err != nil || message.ID == "" || message.Timestamp.Time().IsZero() {
+		return nil, fmt.Errorf("%w: missing or malformed key", ErrInvalidCursor)
+	}
+	return &message, nil
+}
+
+// scanAllInput returns the input for scanning all messages. Reads are strongly consistent so a
+// message is listed as soon as Add has returned.
+func (s *DynamoDBMessageStore) scanAllInput() *dynamodb.ScanInput {
+	return &dynamodb.ScanInput{
+		TableName:      aws.String(s.tableName),
+		ConsistentRead: aws.Bool(true), // Use strongly consistent reads
+	}
+}
+
+// Add adds a new message to the store. It returns once PutItem has succeeded, at which point
+// the write is durable and visible to strongly consistent reads.
+func (s *DynamoDBMessageStore) Add(ctx context.Context, message *model.Message) error {
 	log.Printf("Adding message with ID %s to DynamoDB table %s", message.ID, s.tableName)
 
 	// Double-check that the table exists before trying to write to it
@@ -191,27 +561,31 @@ func (s *DynamoDBMessageStore) Add(message *model.Message) error {
 		TableName: aws.String(s.tableName),
 	}
 
-	_, err := s.client.DescribeTable(context.TODO(), describeInput)
+	_, err := s.client.DescribeTable(ctx, describeInput)
 	if err != nil {
 		log.Printf("ERROR: Table %s does not exist or cannot be accessed: %v", s.tableName, err)
 		log.Printf("ERROR: Attempting to create the table before writing...")
 
 		// Try to create the table
-		err = s.ensureTableExists()
+		err = s.ensureTableExists(ctx)
 		if err != nil {
 			log.Printf("ERROR: Failed to create table %s: %v", s.tableName, err)
 			return fmt.Errorf("failed to ensure table exists before writing: %w", err)
 		}
 	}
 
-	// Marshal message to DynamoDB item
+	// Marshal message to DynamoDB item, with the text index key in sync with the text
+	message.TextLower = model.LowerText(message.Text)
 	item, err := attributevalue.MarshalMap(message)
 	if err != nil {
 		log.Printf("Failed to marshal message: %v", err)
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	log.Printf("Marshalled message to DynamoDB item: %+v", item)
+	// Include the message in the timestamp index
+	item["Feed"] = &types.AttributeValueMemberS{Value: messageFeed}
+
+	log.Printf("Marshalled message to DynamoDB item: %s", awsutil.LogItem(item))
 
 	// Put item in table
 	input := &dynamodb.PutItemInput{
@@ -220,9 +594,9 @@ func (s *DynamoDBMessageStore) Add(message *model.Message) error {
 		// Add a condition to ensure the item doesn't already exist (optional)
 		ConditionExpression: aws.String("attribute_not_exists(ID)"),
 	}
-	log.Printf("Putting item in table %s with input: %+v", s.tableName, input)
+	log.Printf("Putting item in table %s: %s", s.tableName, awsutil.LogItem(input.Item))
 
-	_, err = s.client.PutItem(context.TODO(), input)
+	_, err = s.client.PutItem(ctx, input)
 
 	if err != nil {
 		// Check if the error is because the condition failed (item already exists)
@@ -250,7 +624,7 @@ func (s *DynamoDBMessageStore) Add(message *model.Message) error {
 	}
 
 	log.Printf("Verifying item was written by getting it back...")
-	getOutput, err := s.client.GetItem(context.TODO(), getInput)
+	getOutput, err := s.client.GetItem(ctx, getInput)
 
 	if err != nil {
 		log.Printf("WARNING: Failed to verify item was written: %v", err)
@@ -265,3 +639,1073 @@ func (s *DynamoDBMessageStore) Add(message *model.Message) error {
 
 	return nil
 }
+
+// GetAllSorted returns all messages with pinned messages first, then by timestamp
+func (s *DynamoDBMessageStore) GetAllSorted(ctx context.Context) ([]*model.Message, error) {
+	messages, err := s.GetAll(ctx)
+	if err != nil {
+		return messages, err
+	}
+
+	SortPinnedFirst(messages, s.sortOrder)
+	return messages, nil
+}
+
+// GetReplies returns the replies to the message with the given ID, ordered by timestamp
+func (s *DynamoDBMessageStore) GetReplies(ctx context.Context, parentID string) ([]*model.Message, error) {
+	log.Printf("Getting replies to message with ID %s from DynamoDB table %s", parentID, s.tableName)
+
+	// Query the parent ID index, following pagination until all replies are read
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(parentIDIndexName),
+		KeyConditionExpression: aws.String("ParentID = :parentID"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":parentID": &types.AttributeValueMemberS{Value: parentID},
+		},
+	})
+
+	replies := make([]*model.Message, 0)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to query index %s on table %s: %v", parentIDIndexName, s.tableName, err)
+			return nil, fmt.Errorf("failed to query replies: %w", err)
+		}
+
+		for i, item := range page.Items {
+			message, err := unmarshalMessage(item)
+			if err != nil {
+				log.Printf("Failed to unmarshal item %d: %v", i, err)
+				continue
+			}
+			replies = append(replies, message)
+		}
+	}
+
+	SortMessages(replies, s.sortOrder)
+	log.Printf("Returning %d replies to message with ID %s", len(replies), parentID)
+	return replies, nil
+}
+
+// GetByPrefix returns up to limit messages whose text starts with prefix, ignoring case, in text
+// order. It queries the text index with begins_with, so unlike a substring match it reads only
+// the matching messages. Messages written before the index existed have no TextLower and are
+// not found until their text is edited.
+func (s *DynamoDBMessageStore) GetByPrefix(ctx context.Context, prefix string, limit int32) ([]*model.Message, error) {
+	log.Printf("Getting up to %d messages with prefix %q from DynamoDB table %s", limit, prefix, s.tableName)
+
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(textIndexName),
+		KeyConditionExpression: aws.String("Feed = :feed AND begins_with(TextLower, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":feed":   &types.AttributeValueMemberS{Value: messageFeed},
+			":prefix": &types.AttributeValueMemberS{Value: model.LowerText(prefix)},
+		},
+		Limit: aws.Int32(limit),
+	})
+
+	messages := make([]*model.Message, 0)
+	for paginator.HasMorePages() && int32(len(messages)) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to query index %s on table %s: %v", textIndexName, s.tableName, err)
+			return nil, fmt.Errorf("failed to query messages with prefix %q: %w", prefix, err)
+		}
+
+		for i, item := range page.Items {
+			message, err := unmarshalMessage(item)
+			if err != nil {
+				log.Printf("Failed to unmarshal item %d: %v", i, err)
+				continue
+			}
+			messages = append(messages, message)
+		}
+	}
+
+	SortByText(messages)
+	if int32(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+
+	log.Printf("Returning %d messages with prefix %q", len(messages), prefix)
+	return messages, nil
+}
+
+// GetByOwner returns the messages owned by the given user, ordered by timestamp
+func (s *DynamoDBMessageStore) GetByOwner(ctx context.Context, owner string) ([]*model.Message, error) {
+	log.Printf("Getting messages owned by %s from DynamoDB table %s", owner, s.tableName)
+
+	// Query the owner index, following pagination until all messages are read
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(ownerIndexName),
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "Owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+
+	messages := make([]*model.Message, 0)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to query index %s on table %s: %v", ownerIndexName, s.tableName, err)
+			return nil, fmt.Errorf("failed to query messages by owner: %w", err)
+		}
+
+		for i, item := range page.Items {
+			message, err := unmarshalMessage(item)
+			if err != nil {
+				log.Printf("Failed to unmarshal item %d: %v", i, err)
+				continue
+			}
+			messages = append(messages, message)
+		}
+	}
+
+	SortMessages(messages, s.sortOrder)
+	log.Printf("Returning %d messages owned by %s", len(messages), owner)
+	return messages, nil
+}
+
+// CountPinnedByOwner returns the number of pinned messages owned by the given user. It counts
+// the user's messages on the owner index with a filter on Pinned, so no items are returned.
+func (s *DynamoDBMessageStore) CountPinnedByOwner(ctx context.Context, owner string) (int, error) {
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(ownerIndexName),
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		FilterExpression:       aws.String("Pinned = :pinned"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "Owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner":  &types.AttributeValueMemberS{Value: owner},
+			":pinned": &types.AttributeValueMemberBOOL{Value: true},
+		},
+		Select: types.SelectCount,
+	})
+
+	count := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to query index %s on table %s: %v", ownerIndexName, s.tableName, err)
+			return 0, fmt.Errorf("failed to count pinned messages by owner: %w", err)
+		}
+		count += int(page.Count)
+	}
+	return count, nil
+}
+
+// CountByDay returns the number of messages with a timestamp in [start, end), keyed by UTC date
+// ("2006-01-02"). Days without messages are left out. It queries the timestamp index over the
+// range, projecting only Timestamp. As in GetSince, the index is bounded by whole seconds and
+// the range is applied precisely to the results.
+func (s *DynamoDBMessageStore) CountByDay(ctx context.Context, start, end time.Time) (map[string]int64, error) {
+	log.Printf("Counting messages by day from %s to %s in DynamoDB table %s",
+		start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano), s.tableName)
+
+	floor := start.UTC().Truncate(time.Second).Format("2006-01-02T15:04:05")
+	ceiling := end.UTC().Truncate(time.Second).Add(time.Second).Format("2006-01-02T15:04:05")
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(timestampIndexName),
+		KeyConditionExpression: aws.String("Feed = :feed AND #timestamp BETWEEN :floor AND :ceiling"),
+		ProjectionExpression:   aws.String("#timestamp"),
+		ExpressionAttributeNames: map[string]string{
+			"#timestamp": "Timestamp",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":feed":    &types.AttributeValueMemberS{Value: messageFeed},
+			":floor":   &types.AttributeValueMemberS{Value: floor},
+			":ceiling": &types.AttributeValueMemberS{Value: ceiling},
+		},
+	})
+
+	counts := make(map[string]int64)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to query index %s on table %s: %v", timestampIndexName, s.tableName, err)
+			return nil, fmt.Errorf("failed to count messages by day: %w", err)
+		}
+
+		for i, item := range page.Items {
+			message, err := unmarshalMessage(item)
+			if err != nil {
+				log.Printf("Failed to unmarshal item %d: %v", i, err)
+				continue
+			}
+			countDay(counts, message.Timestamp.Time(), start, end)
+		}
+	}
+
+	log.Printf("Counted messages on %d days in table %s", len(counts), s.tableName)
+	return counts, nil
+}
+
+// CountByOwner returns the owners with the most messages, most first, up to limit owners. There
+// is no per-owner counter, so this scans the whole table (projecting only Owner) and aggregates
+// in memory: every call reads every item, and its cost grows with the size of the table.
+func (s *DynamoDBMessageStore) CountByOwner(ctx context.Context, limit int) ([]OwnerCount, error) {
+	log.Printf("Counting messages by owner in DynamoDB table %s", s.tableName)
+
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName:            aws.String(s.tableName),
+		ProjectionExpression: aws.String("#owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "Owner",
+		},
+	})
+
+	counts := make(map[string]int64)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to scan table %s: %v", s.tableName, err)
+			return nil, fmt.Errorf("failed to count messages by owner: %w", err)
+		}
+
+		for _, item := range page.Items {
+			owner, ok := item["Owner"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			counts[owner.Value]++
+		}
+	}
+
+	log.Printf("Counted messages for %d owners in table %s", len(counts), s.tableName)
+	return topOwners(counts, limit), nil
+}
+
+// DeleteByOwner deletes every message owned by the given user with BatchWriteItem, in chunks of
+// 25, and returns how many were deleted. If DynamoDB leaves some deletes unprocessed after every
+// retry, the error names the messages that were not deleted.
+func (s *DynamoDBMessageStore) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	messages, err := s.GetByOwner(ctx, owner)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("Deleting %d messages owned by %s from DynamoDB table %s", len(messages), owner, s.tableName)
+	deleted := 0
+	for start := 0; start < len(messages); start += maxBatchWriteItems {
+		chunk := messages[start:min(start+maxBatchWriteItems, len(messages))]
+		requests := make([]types.WriteRequest, len(chunk))
+		for i, message := range chunk {
+			requests[i] = types.WriteRequest{
+				DeleteRequest: &types.DeleteRequest{
+					Key: map[string]types.AttributeValue{
+						"ID": &types.AttributeValueMemberS{Value: message.ID},
+					},
+				},
+			}
+		}
+		unprocessed, err := s.batchWrite(ctx, requests)
+		deleted += len(chunk) - len(unprocessed)
+		if err != nil {
+			if errors.Is(err, errUnprocessedWrites) {
+				return deleted, fmt.Errorf("messages %s not deleted: %w", strings.Join(deleteRequestIDs(unprocessed), ", "), err)
+			}
+			return deleted, err
+		}
+	}
+
+	log.Printf("Successfully deleted %d messages owned by %s", deleted, owner)
+	return deleted, nil
+}
+
+// BackfillIndexKeys sets Feed and TextLower on the messages missing either, which were stored
+// before the timestamp and text indexes existed and so are not found by GetSince, GetPage,
+// GetRecent or GetByPrefix. It scans the table for them and returns how many it updated. It is
+// safe to run again, e.g. after an error, since updated messages no longer match.
+func (s *DynamoDBMessageStore) BackfillIndexKeys(ctx context.Context) (int, error) {
+	log.Printf("Backfilling index keys in DynamoDB table %s", s.tableName)
+
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName:            aws.String(s.tableName),
+		FilterExpression:     aws.String("attribute_not_exists(Feed) OR attribute_not_exists(TextLower)"),
+		ProjectionExpression: aws.String("ID, #text"),
+		ExpressionAttributeNames: map[string]string{
+			"#text": "Text",
+		},
+	})
+
+	updated := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to scan table %s: %v", s.tableName, err)
+			return updated, fmt.Errorf("failed to scan messages: %w", err)
+		}
+
+		for _, item := range page.Items {
+			var message model.Message
+			if err := attributevalue.UnmarshalMap(item, &message); err != nil || message.ID == "" {
+				log.Printf("Skipping malformed item %s: %v", awsutil.LogItem(item), err)
+				continue
+			}
+			_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName: aws.String(s.tableName),
+				Key: map[string]types.AttributeValue{
+					"ID": &types.AttributeValueMemberS{Value: message.ID},
+				},
+				UpdateExpression: aws.String("SET Feed = :feed, TextLower = :textLower"),
+				// Do not recreate a message deleted since the scan
+				ConditionExpression: aws.String("attribute_exists(ID)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":feed":      &types.AttributeValueMemberS{Value: messageFeed},
+					":textLower": &types.AttributeValueMemberS{Value: model.LowerText(message.Text)},
+				},
+			})
+			var conditionFailedErr *types.ConditionalCheckFailedException
+			if errors.As(err, &conditionFailedErr) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to backfill message %s: %v", message.ID, err)
+				return updated, fmt.Errorf("failed to update message %s: %w", message.ID, err)
+			}
+			updated++
+		}
+	}
+
+	log.Printf("Backfilled index keys on %d messages", updated)
+	return updated, nil
+}
+
+// errUnprocessedWrites is returned by batchWrite when DynamoDB still leaves requests unprocessed
+// after maxBatchWriteAttempts
+var errUnprocessedWrites = errors.New("batch write requests left unprocessed")
+
+// batchWrite writes a batch of requests, retrying any that DynamoDB leaves unprocessed with
+// exponential backoff. On failure it returns the requests that were not written: those left
+// unprocessed after maxBatchWriteAttempts, with errUnprocessedWrites, or those outstanding when a
+// call failed.
+func (s *DynamoDBMessageStore) batchWrite(ctx context.Context, requests []types.WriteRequest) ([]types.WriteRequest, error) {
+	backoff := batchWriteBackoff
+	for attempt := 1; len(requests) > 0; attempt++ {
+		output, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{s.tableName: requests},
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to batch write to table %s: %v", s.tableName, err)
+			return requests, fmt.Errorf("failed to batch write to DynamoDB: %w", err)
+		}
+
+		requests = output.UnprocessedItems[s.tableName]
+		if len(requests) > 0 {
+			if attempt == maxBatchWriteAttempts {
+				log.Printf("ERROR: %d batch write requests to table %s unprocessed after %d attempts", len(requests), s.tableName, attempt)
+				return requests, fmt.Errorf("%d %w after %d attempts", len(requests), errUnprocessedWrites, attempt)
+			}
+			log.Printf("Retrying %d unprocessed batch write requests to table %s in %s", len(requests), s.tableName, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return nil, nil
+}
+
+// deleteRequestIDs returns the message IDs of batch delete requests
+func deleteRequestIDs(requests []types.WriteRequest) []string {
+	ids := make([]string, 0, len(requests))
+	for _, request := range requests {
+		if request.DeleteRequest == nil {
+			continue
+		}
+		if id, ok := request.DeleteRequest.Key["ID"].(*types.AttributeValueMemberS); ok {
+			ids = append(ids, id.Value)
+		}
+	}
+	return ids
+}
+
+// GetSince returns up to limit messages with a timestamp strictly after since, oldest first.
+// Timestamps are stored as RFC 3339 strings with variable-length fractional seconds, which don't
+// compare exactly as strings, so the index is queried from the start of the second and the
+// results are filtered precisely.
+func (s *DynamoDBMessageStore) GetSince(ctx context.Context, since time.Time, limit int32) ([]*model.Message, error) {
+	log.Printf("Getting up to %d messages since %s from DynamoDB table %s", limit, since.Format(time.RFC3339Nano), s.tableName)
+
+	floor := since.UTC().Truncate(time.Second).Format("2006-01-02T15:04:05")
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(timestampIndexName),
+		KeyConditionExpression: aws.String("Feed = :feed AND #timestamp >= :floor"),
+		ExpressionAttributeNames: map[string]string{
+			"#timestamp": "Timestamp",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":feed":  &types.AttributeValueMemberS{Value: messageFeed},
+			":floor": &types.AttributeValueMemberS{Value: floor},
+		},
+		ScanIndexForward: aws.Bool(true),
+		Limit:            aws.Int32(limit),
+	})
+
+	messages := make([]*model.Message, 0)
+	for paginator.HasMorePages() && int32(len(messages)) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to query index %s on table %s: %v", timestampIndexName, s.tableName, err)
+			return nil, fmt.Errorf("failed to query messages since %s: %w", since.Format(time.RFC3339Nano), err)
+		}
+
+		for i, item := range page.Items {
+			message, err := unmarshalMessage(item)
+			if err != nil {
+				log.Printf("Failed to unmarshal item %d: %v", i, err)
+				continue
+			}
+			if message.Timestamp.Time().After(since) {
+				messages = append(messages, message)
+			}
+		}
+	}
+
+	// Within the second at the floor, string order may differ from time order
+	SortMessages(messages, SortAscending)
+	if int32(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+
+	log.Printf("Returning %d messages since %s", len(messages), since.Format(time.RFC3339Nano))
+	return messages, nil
+}
+
+// GetPage returns up to limit messages with a timestamp at or before snapshotAt, ordered by
+// timestamp and starting after the message the cursor points at, along with the cursor of the
+// next page ("" on the last page). It reads one page of the timestamp index, so messages added
+// after snapshotAt are never read. As in GetSince, the index is bounded by whole seconds and the
+// snapshot is applied precisely to the results, so a page may hold fewer than limit messages.
+func (s *DynamoDBMessageStore) GetPage(ctx context.Context, snapshotAt time.Time, cursor string, limit int32) ([]*model.Message, string, error) {
+	log.Printf("Getting up to %d messages at snapshot %s from DynamoDB table %s", limit, snapshotAt.Format(time.RFC3339Nano), s.tableName)
+
+	var startKey map[string]types.AttributeValue
+	if cursor != "" {
+		var err error
+		if startKey, err = decodePageCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	ceiling := snapshotAt.UTC().Truncate(time.Second).Add(time.Second).Format("2006-01-02T15:04:05")
+	result, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(timestampIndexName),
+		KeyConditionExpression: aws.String("Feed = :feed AND #timestamp < :ceiling"),
+		ExpressionAttributeNames: map[string]string{
+			"#timestamp": "Timestamp",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":feed":    &types.AttributeValueMemberS{Value: messageFeed},
+			":ceiling": &types.AttributeValueMemberS{Value: ceiling},
+		},
+		ScanIndexForward:  aws.Bool(s.sortOrder != SortDescending),
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(limit),
+	})
+	if err != nil {
+		log.Printf("Failed to query index %s on table %s: %v", timestampIndexName, s.tableName, err)
+		return nil, "", fmt.Errorf("failed to query messages at snapshot %s: %w", snapshotAt.Format(time.RFC3339Nano), err)
+	}
+
+	messages := make([]*model.Message, 0, len(result.Items))
+	for i, item := range result.Items {
+		message, err := unmarshalMessage(item)
+		if err != nil {
+			log.Printf("Failed to unmarshal item %d: %v", i, err)
+			continue
+		}
+		if !message.Timestamp.Time().After(snapshotAt) {
+			messages = append(messages, message)
+		}
+	}
+	SortMessages(messages, s.sortOrder)
+
+	next, err := encodeCursor(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.Printf("Returning %d messages at snapshot %s", len(messages), snapshotAt.Format(time.RFC3339Nano))
+	return messages, next, nil
+}
+
+// GetRecent returns up to limit of the newest messages, newest first, by querying the timestamp
+// index in descending order
+func (s *DynamoDBMessageStore) GetRecent(ctx context.Context, limit int32) ([]*model.Message, error) {
+	log.Printf("Getting the %d most recent messages from DynamoDB table %s", limit, s.tableName)
+
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(timestampIndexName),
+		KeyConditionExpression: aws.String("Feed = :feed"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":feed": &types.AttributeValueMemberS{Value: messageFeed},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(limit),
+	})
+
+	// Within one second, string order may differ from time order, so keep reading to the end of
+	// the second holding the oldest message wanted before sorting and trimming
+	messages := make([]*model.Message, 0)
+	var cutoff time.Time
+	done := false
+	for paginator.HasMorePages() && !done {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to query index %s on table %s: %v", timestampIndexName, s.tableName, err)
+			return nil, fmt.Errorf("failed to query recent messages: %w", err)
+		}
+
+		for i, item := range page.Items {
+			message, err := unmarshalMessage(item)
+			if err != nil {
+				log.Printf("Failed to unmarshal item %d: %v", i, err)
+				continue
+			}
+			second := message.Timestamp.Time().UTC().Truncate(time.Second)
+			if int32(len(messages)) >= limit && second.Before(cutoff) {
+				done = true
+				break
+			}
+			messages = append(messages, message)
+			if int32(len(messages)) == limit {
+				cutoff = second
+			}
+		}
+	}
+
+	SortMessages(messages, SortDescending)
+	if int32(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+
+	log.Printf("Returning %d recent messages", len(messages))
+	return messages, nil
+}
+
+// Exists reports whether a message with the given ID exists. Only the key is read back.
+func (s *DynamoDBMessageStore) Exists(ctx context.Context, id string) (bool, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+		ProjectionExpression: aws.String("ID"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	if err != nil {
+		log.Printf("Failed to get item from table %s: %v", s.tableName, err)
+		return false, fmt.Errorf("failed to get item from DynamoDB: %w", err)
+	}
+	return len(result.Item) > 0, nil
+}
+
+// GetByID returns the message with the given ID, or nil if it does not exist
+func (s *DynamoDBMessageStore) GetByID(ctx context.Context, id string) (*model.Message, error) {
+	log.Printf("Getting message with ID %s from DynamoDB table %s", id, s.tableName)
+
+	// Get item from DynamoDB
+	getInput := &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	result, err := s.client.GetItem(ctx, getInput)
+	if err != nil {
+		log.Printf("Failed to get item from table %s: %v", s.tableName, err)
+		return nil, fmt.Errorf("failed to get item from DynamoDB: %w", err)
+	}
+
+	// Check if item exists
+	if len(result.Item) == 0 {
+		log.Printf("Message with ID %s not found in table %s", id, s.tableName)
+		return nil, nil
+	}
+
+	return unmarshalMessage(result.Item)
+}
+
+// GetByIDs returns the messages with the given IDs in the order requested, omitting IDs that do
+// not exist and repeated IDs. The IDs are read with BatchGetItem, maxBatchGetKeys at a time.
+func (s *DynamoDBMessageStore) GetByIDs(ctx context.Context, ids []string) ([]*model.Message, error) {
+	log.Printf("Getting %d messages by ID from DynamoDB table %s", len(ids), s.tableName)
+
+	// BatchGetItem rejects repeated keys
+	unique := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	found := make(map[string]*model.Message, len(unique))
+	for start := 0; start < len(unique); start += maxBatchGetKeys {
+		chunk := unique[start:min(start+maxBatchGetKeys, len(unique))]
+		keys := make([]map[string]types.AttributeValue, len(chunk))
+		for i, id := range chunk {
+			keys[i] = map[string]types.AttributeValue{
+				"ID": &types.AttributeValueMemberS{Value: id},
+			}
+		}
+
+		items, err := s.batchGet(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		for i, item := range items {
+			message, err := unmarshalMessage(item)
+			if err != nil {
+				log.Printf("Failed to unmarshal item %d: %v", i, err)
+				continue
+			}
+			found[message.ID] = message
+		}
+	}
+
+	// BatchGetItem returns items in no particular order
+	messages := make([]*model.Message, 0, len(found))
+	for _, id := range unique {
+		if message, ok := found[id]; ok {
+			messages = append(messages, message)
+		}
+	}
+
+	log.Printf("Found %d of %d messages in table %s", len(messages), len(unique), s.tableName)
+	return messages, nil
+}
+
+// batchGet reads the items with the given keys (at most maxBatchGetKeys), retrying unprocessed
+// keys with a growing delay. Reads are strongly consistent.
+func (s *DynamoDBMessageStore) batchGet(ctx context.Context, keys []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	const maxAttempts = 5
+	var items []map[string]types.AttributeValue
+	for attempt := 1; len(keys) > 0; attempt++ {
+		output, err := s.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{
+				s.tableName: {Keys: keys, ConsistentRead: aws.Bool(true)},
+			},
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to batch get from table %s: %v", s.tableName, err)
+			return nil, fmt.Errorf("failed to batch get from DynamoDB: %w", err)
+		}
+
+		items = append(items, output.Responses[s.tableName]...)
+		keys = output.UnprocessedKeys[s.tableName].Keys
+		if len(keys) > 0 {
+			if attempt == maxAttempts {
+				return nil, fmt.Errorf("%d batch get keys unprocessed after %d attempts", len(keys), maxAttempts)
+			}
+			log.Printf("Retrying %d unprocessed batch get keys from table %s", len(keys), s.tableName)
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+	}
+	return items, nil
+}
+
+// SetPinned sets the pinned flag on the message with the given ID
+func (s *DynamoDBMessageStore) SetPinned(ctx context.Context, id string, pinned bool) error {
+	log.Printf("Setting pinned=%t on message with ID %s in DynamoDB table %s", pinned, id, s.tableName)
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("SET Pinned = :pinned"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pinned": &types.AttributeValueMemberBOOL{Value: pinned},
+		},
+		// Add a condition to ensure the item already exists
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+	}
+
+	_, err := s.client.UpdateItem(ctx, input)
+	if err != nil {
+		// Check if the error is because the condition failed (item doesn't exist)
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedErr) {
+			log.Printf("Message with ID %s does not exist in table %s", id, s.tableName)
+			return ErrMessageNotFound
+		}
+
+		log.Printf("ERROR: Failed to update item in table %s: %v", s.tableName, err)
+		return fmt.Errorf("failed to update item in DynamoDB: %w", err)
+	}
+
+	log.Printf("Successfully set pinned=%t on message with ID %s", pinned, id)
+	return nil
+}
+
+// maxUpdateAttempts bounds how often Update retries when the message is edited concurrently
+const maxUpdateAttempts = 3
+
+// Update replaces the text of a message and returns the updated message. The previous text is
+// appended to the message's edit history with list_append, and the oldest entries are then
+// trimmed so that at most maxHistory remain.
+func (s *DynamoDBMessageStore) Update(ctx context.Context, id, text string, maxHistory int) (*model.Message, error) {
+	log.Printf("Updating message with ID %s in DynamoDB table %s", id, s.tableName)
+
+	// The previous text is needed as a value in the update, so it is read first and the update
+	// is conditioned on the text being unchanged since
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		current, err := s.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, ErrMessageNotFound
+		}
+
+		message, err := s.applyEdit(ctx, current, text, maxHistory)
+		if err == nil {
+			return message, nil
+		}
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionFailedErr) {
+			log.Printf("ERROR: Failed to update item in table %s: %v", s.tableName, err)
+			return nil, fmt.Errorf("failed to update item in DynamoDB: %w", err)
+		}
+		log.Printf("Message with ID %s changed during update (attempt %d of %d)", id, attempt, maxUpdateAttempts)
+	}
+	return nil, fmt.Errorf("message %s was modified concurrently, giving up after %d attempts", id, maxUpdateAttempts)
+}
+
+// applyEdit sets the new text on current and appends its previous text to the edit history,
+// then trims the history to maxHistory entries
+func (s *DynamoDBMessageStore) applyEdit(ctx context.Context, current *model.Message, text string, maxHistory int) (*model.Message, error) {
+	key := map[string]types.AttributeValue{
+		"ID": &types.AttributeValueMemberS{Value: current.ID},
+	}
+	names := map[string]string{"#text": "Text"}
+	values := map[string]types.AttributeValue{
+		":text":      &types.AttributeValueMemberS{Value: text},
+		":textLower": &types.AttributeValueMemberS{Value: model.LowerText(text)},
+		":oldText":   &types.AttributeValueMemberS{Value: current.Text},
+	}
+
+	updateExpression := "SET #text = :text, TextLower = :textLower REMOVE EditHistory"
+	if maxHistory > 0 {
+		edit, err := attributevalue.Marshal(model.MessageEdit{
+			Text:     current.Text,
+			EditedAt: httputil.Timestamp(time.Now().UTC()),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal edit: %w", err)
+		}
+		updateExpression = "SET #text = :text, TextLower = :textLower, EditHistory = list_append(if_not_exists(EditHistory, :empty), :edit)"
+		values[":edit"] = &types.AttributeValueMemberL{Value: []types.AttributeValue{edit}}
+		values[":empty"] = &types.AttributeValueMemberL{Value: []types.AttributeValue{}}
+	}
+
+	output, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.tableName),
+		Key:                       key,
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String("attribute_exists(ID) AND #text = :oldText"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, err
+	}
+	message, err := unmarshalMessage(output.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	excess := len(message.EditHistory) - maxHistory
+	if maxHistory <= 0 || excess <= 0 {
+		return message, nil
+	}
+
+	// Drop the oldest entries. The size condition skips the trim if a concurrent edit changed
+	// the history in the meantime; that edit trims it instead.
+	paths := make([]string, excess)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("EditHistory[%d]", i)
+	}
+	trimOutput, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 key,
+		UpdateExpression:    aws.String("REMOVE " + strings.Join(paths, ", ")),
+		ConditionExpression: aws.String("size(EditHistory) = :size"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":size": &types.AttributeValueMemberN{Value: strconv.Itoa(len(message.EditHistory))},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err == nil {
+		return unmarshalMessage(trimOutput.Attributes)
+	}
+
+	var conditionFailedErr *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionFailedErr) {
+		log.Printf("WARNING: Failed to trim edit history of message %s: %v", current.ID, err)
+	}
+	message.EditHistory = message.EditHistory[excess:]
+	return message, nil
+}
+
+// AddReaction atomically increments the count of the given emoji on a message and returns the
+// updated message. A new emoji is only accepted while the message has fewer than maxReactions
+// distinct reactions.
+func (s *DynamoDBMessageStore) AddReaction(ctx context.Context, id, emoji string, maxReactions int) (*model.Message, error) {
+	log.Printf("Adding reaction %s to message with ID %s in DynamoDB table %s", emoji, id, s.tableName)
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression: aws.String("ADD Reactions.#emoji :one"),
+		// The message must exist, and a new emoji must not exceed the distinct reaction limit
+		ConditionExpression: aws.String("attribute_exists(ID) AND (attribute_exists(Reactions.#emoji) OR size(Reactions) < :max)"),
+		ExpressionAttributeNames: map[string]string{
+			"#emoji": emoji,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":max": &types.AttributeValueMemberN{Value: strconv.Itoa(maxReactions)},
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+
+	output, err := s.client.UpdateItem(ctx, input)
+	if err != nil {
+		// Messages created before reactions were introduced have no Reactions map to add into
+		if isInvalidDocumentPath(err) {
+			if err := s.initReactions(ctx, id); err != nil {
+				return nil, err
+			}
+			output, err = s.client.UpdateItem(ctx, input)
+		}
+	}
+	if err != nil {
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedErr) {
+			if len(conditionFailedErr.Item) == 0 {
+				return nil, ErrMessageNotFound
+			}
+			return nil, ErrReactionLimitReached
+		}
+
+		log.Printf("ERROR: Failed to add reaction in table %s: %v", s.tableName, err)
+		return nil, fmt.Errorf("failed to update item in DynamoDB: %w", err)
+	}
+
+	return unmarshalMessage(output.Attributes)
+}
+
+// RemoveReaction atomically decrements the count of the given emoji on a message and returns the
+// updated message. The emoji is removed from the message once its count reaches zero.
+func (s *DynamoDBMessageStore) RemoveReaction(ctx context.Context, id, emoji string) (*model.Message, error) {
+	log.Printf("Removing reaction %s from message with ID %s in DynamoDB table %s", emoji, id, s.tableName)
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("ADD Reactions.#emoji :minusOne"),
+		ConditionExpression: aws.String("attribute_exists(ID) AND Reactions.#emoji > :zero"),
+		ExpressionAttributeNames: map[string]string{
+			"#emoji": emoji,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":minusOne": &types.AttributeValueMemberN{Value: "-1"},
+			":zero":     &types.AttributeValueMemberN{Value: "0"},
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+
+	output, err := s.client.UpdateItem(ctx, input)
+	if err != nil {
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedErr) {
+			if len(conditionFailedErr.Item) == 0 {
+				return nil, ErrMessageNotFound
+			}
+			return nil, ErrReactionNotFound
+		}
+		if isInvalidDocumentPath(err) {
+			return nil, ErrReactionNotFound
+		}
+
+		log.Printf("ERROR: Failed to remove reaction in table %s: %v", s.tableName, err)
+		return nil, fmt.Errorf("failed to update item in DynamoDB: %w", err)
+	}
+
+	message, err := unmarshalMessage(output.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the emoji once nobody is reacting with it any more. The condition guards against
+	// a concurrent add having raised the count again in the meantime.
+	if message.Reactions[emoji] <= 0 {
+		removeOutput, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(s.tableName),
+			Key: map[string]types.AttributeValue{
+				"ID": &types.AttributeValueMemberS{Value: id},
+			},
+			UpdateExpression:    aws.String("REMOVE Reactions.#emoji"),
+			ConditionExpression: aws.String("Reactions.#emoji <= :zero"),
+			ExpressionAttributeNames: map[string]string{
+				"#emoji": emoji,
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":zero": &types.AttributeValueMemberN{Value: "0"},
+			},
+			ReturnValues: types.ReturnValueAllNew,
+		})
+		if err == nil {
+			return unmarshalMessage(removeOutput.Attributes)
+		}
+
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionFailedErr) {
+			log.Printf("WARNING: Failed to remove empty reaction %s from message %s: %v", emoji, id, err)
+		}
+	}
+
+	return message, nil
+}
+
+// initReactions creates an empty Reactions map on a message that does not have one yet
+func (s *DynamoDBMessageStore) initReactions(ctx context.Context, id string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET Reactions = if_not_exists(Reactions, :empty)"),
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+		},
+	})
+	if err != nil {
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedErr) {
+			return ErrMessageNotFound
+		}
+		return fmt.Errorf("failed to initialize reactions in DynamoDB: %w", err)
+	}
+	return nil
+}
+
+// isInvalidDocumentPath reports whether err is DynamoDB rejecting an update expression
+// because a parent attribute in a nested path does not exist
+func isInvalidDocumentPath(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) &&
+		apiErr.ErrorCode() == "ValidationException" &&
+		strings.Contains(apiErr.ErrorMessage(), "document path")
+}
+
+// unmarshalMessage converts a DynamoDB item into a message. Messages stored before content
+// types were introduced are plain text.
+func unmarshalMessage(item map[string]types.AttributeValue) (*model.Message, error) {
+	var message model.Message
+	if err := attributevalue.UnmarshalMap(item, &message); err != nil {
+		log.Printf("Failed to unmarshal item: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal item: %w", err)
+	}
+	if message.ContentType == "" {
+		message.ContentType = model.ContentTypePlain
+	}
+	return &message, nil
+}
+
+// selfTestProbeID is the reserved key the startup self-test addresses. No item is ever stored under
+// it, so list queries never see it.
+const selfTestProbeID = "__startup_selftest__"
+
+// selfTestProbeAttribute is an attribute no item has. The self-test's writes require it, so
+// they always fail their condition and never change the table.
+const selfTestProbeAttribute = "SelfTestProbe"
+
+// SelfTest checks that the table exists and that this task may read and write it, to surface
+// missing IAM permissions at startup rather than on the first user request. It changes nothing:
+// DynamoDB checks permissions before conditions, so a write rejected by a condition no item
+// meets shows the write is allowed.
+func (s *DynamoDBMessageStore) SelfTest(ctx context.Context) error {
+	log.Printf("Running startup self-test against DynamoDB table %s", s.tableName)
+
+	_, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("self-test failed to describe table (check IAM permissions for dynamodb:DescribeTable on table %s): %w", s.tableName, err)
+	}
+
+	key := map[string]types.AttributeValue{
+		"ID": &types.AttributeValueMemberS{Value: selfTestProbeID},
+	}
+
+	_, err = s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("self-test failed to read probe item (check IAM permissions for dynamodb:GetItem on table %s): %w", s.tableName, err)
+	}
+
+	condition := aws.String("attribute_exists(#probe)")
+	names := map[string]string{"#probe": selfTestProbeAttribute}
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(s.tableName),
+		Item:                     key,
+		ConditionExpression:      condition,
+		ExpressionAttributeNames: names,
+	})
+	if err := selfTestWriteError(err); err != nil {
+		return fmt.Errorf("self-test failed to check writes (check IAM permissions for dynamodb:PutItem on table %s): %w", s.tableName, err)
+	}
+
+	_, err = s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(s.tableName),
+		Key:                      key,
+		ConditionExpression:      condition,
+		ExpressionAttributeNames: names,
+	})
+	if err := selfTestWriteError(err); err != nil {
+		return fmt.Errorf("self-test failed to check deletes (check IAM permissions for dynamodb:DeleteItem on table %s): %w", s.tableName, err)
+	}
+
+	log.Printf("Startup self-test against DynamoDB table %s passed", s.tableName)
+	return nil
+}
+
+// selfTestWriteError returns the error of a self-test write, other than the failed condition
+// that shows the write was allowed
+func selfTestWriteError(err error) error {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	return err
+}
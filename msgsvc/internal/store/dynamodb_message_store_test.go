@@ -0,0 +1,833 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/msgsvc/internal/logging"
+	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/shared/awsutil"
+	"github.com/aws_e2e_test/shared/httputil"
+)
+
+func TestScanAllInputIsConsistent(t *testing.T) {
+	s := &DynamoDBMessageStore{tableName: "messages"}
+
+	input := s.scanAllInput()
+	if !aws.ToBool(input.ConsistentRead) {
+		t.Error("scan input does not use strongly consistent reads")
+	}
+	if aws.ToString(input.TableName) != "messages" {
+		t.Errorf("scan input table = %q, want %q", aws.ToString(input.TableName), "messages")
+	}
+}
+
+func TestMessageTimestampStoredAsRFC3339(t *testing.T) {
+	defer httputil.SetTimestampFormat(httputil.TimestampRFC3339)
+	httputil.SetTimestampFormat(httputil.TimestampEpochMillis)
+
+	message := model.NewMessage("hello", "owner", "")
+	message.Timestamp = httputil.Timestamp(time.Date(2024, 5, 6, 7, 8, 9, 123000000, time.UTC))
+
+	// The JSON format must not leak into storage, where the Timestamp index sorts on the string
+	item, err := attributevalue.MarshalMap(message)
+	if err != nil {
+		t.Fatalf("MarshalMap: %v", err)
+	}
+	timestamp, ok := item["Timestamp"].(*types.AttributeValueMemberS)
+	if !ok || timestamp.Value != "2024-05-06T07:08:09.123Z" {
+		t.Errorf("stored Timestamp = %#v, want the RFC 3339 string", item["Timestamp"])
+	}
+
+	decoded, err := unmarshalMessage(item)
+	if err != nil {
+		t.Fatalf("unmarshalMessage: %v", err)
+	}
+	if !decoded.Timestamp.Time().Equal(message.Timestamp.Time()) {
+		t.Errorf("round trip got %v, want %v", decoded.Timestamp.Time(), message.Timestamp.Time())
+	}
+}
+
+// slowScanner is a dynamodb.ScanAPIClient that serves pages of one item each, taking delay per page
+type slowScanner struct {
+	pages int
+	delay time.Duration
+}
+
+func (f *slowScanner) Scan(ctx context.Context, input *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	page := 0
+	if key, ok := input.ExclusiveStartKey["ID"].(*types.AttributeValueMemberS); ok {
+		page, _ = strconv.Atoi(key.Value)
+		page++
+	}
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	id := &types.AttributeValueMemberS{Value: strconv.Itoa(page)}
+	output := &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{"ID": id}}}
+	if page < f.pages-1 {
+		output.LastEvaluatedKey = map[string]types.AttributeValue{"ID": id}
+	}
+	return output, nil
+}
+
+func TestScanWithBudget(t *testing.T) {
+	scanner := &slowScanner{pages: 100, delay: 10 * time.Millisecond}
+
+	items, lastKey, truncated, err := scanWithBudget(context.Background(), scanner, &dynamodb.ScanInput{}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("scanWithBudget: %v", err)
+	}
+	if !truncated {
+		t.Fatal("scan was not truncated")
+	}
+	if len(items) == 0 || len(items) >= scanner.pages {
+		t.Fatalf("got %d items, want a partial result", len(items))
+	}
+
+	// The cursor must point at the last item returned, so a resumed scan continues after it
+	lastID := items[len(items)-1]["ID"].(*types.AttributeValueMemberS).Value
+	if key := lastKey["ID"].(*types.AttributeValueMemberS).Value; key != lastID {
+		t.Errorf("last key = %q, want %q", key, lastID)
+	}
+	cursor, err := encodeCursor(lastKey)
+	if err != nil || cursor == "" {
+		t.Errorf("encodeCursor = %q, %v; want a cursor", cursor, err)
+	}
+}
+
+func TestScanWithBudgetComplete(t *testing.T) {
+	scanner := &slowScanner{pages: 5, delay: time.Millisecond}
+
+	for _, budget := range []time.Duration{0, time.Minute} {
+		items, lastKey, truncated, err := scanWithBudget(context.Background(), scanner, &dynamodb.ScanInput{}, budget)
+		if err != nil {
+			t.Fatalf("scanWithBudget(%s): %v", budget, err)
+		}
+		if truncated || lastKey != nil || len(items) != scanner.pages {
+			t.Errorf("scanWithBudget(%s) = %d items, truncated %t, last key %v; want all %d items",
+				budget, len(items), truncated, lastKey, scanner.pages)
+		}
+	}
+}
+
+func TestScanWithBudgetCancelled(t *testing.T) {
+	scanner := &slowScanner{pages: 5, delay: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// A caller giving up is an error, not a truncated result
+	if _, _, _, err := scanWithBudget(ctx, scanner, &dynamodb.ScanInput{}, time.Minute); err == nil {
+		t.Error("scanWithBudget succeeded after the caller's context expired")
+	}
+}
+
+// recordingTransport records the body of every DynamoDB request and answers with an empty
+// success response
+type recordingTransport struct {
+	bodies []map[string]any
+}
+
+func (r *recordingTransport) Do(req *http.Request) (*http.Response, error) {
+	var body map[string]any
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	r.bodies = append(r.bodies, body)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+		Request:    req,
+	}, nil
+}
+
+func newRecordingStore() (*DynamoDBMessageStore, *recordingTransport) {
+	transport := &recordingTransport{}
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+	})
+	return &DynamoDBMessageStore{client: client, tableName: "messages"}, transport
+}
+
+func TestDynamoDBUpdateKeepsTextLower(t *testing.T) {
+	s, transport := newRecordingStore()
+	current := model.NewMessage("Hello", "owner", "")
+
+	for _, maxHistory := range []int{0, 5} {
+		transport.bodies = nil
+		if _, err := s.applyEdit(context.Background(), current, "Good MORNING", maxHistory); err != nil {
+			t.Fatalf("applyEdit: %v", err)
+		}
+		if len(transport.bodies) != 1 {
+			t.Fatalf("sent %d requests, want 1", len(transport.bodies))
+		}
+		body := transport.bodies[0]
+		if expression, _ := body["UpdateExpression"].(string); !strings.Contains(expression, "TextLower = :textLower") {
+			t.Errorf("maxHistory %d: update expression %q does not set TextLower", maxHistory, expression)
+		}
+		values, _ := body["ExpressionAttributeValues"].(map[string]any)
+		textLower, _ := values[":textLower"].(map[string]any)
+		if textLower["S"] != "good morning" {
+			t.Errorf("maxHistory %d: :textLower = %v, want good morning", maxHistory, values[":textLower"])
+		}
+	}
+}
+
+func TestDynamoDBGetByPrefixQueriesTextIndex(t *testing.T) {
+	s, transport := newRecordingStore()
+
+	messages, err := s.GetByPrefix(context.Background(), "HeL", 20)
+	if err != nil {
+		t.Fatalf("GetByPrefix: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("got %d messages from an empty response, want 0", len(messages))
+	}
+	if len(transport.bodies) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(transport.bodies))
+	}
+	body := transport.bodies[0]
+	if body["IndexName"] != textIndexName {
+		t.Errorf("IndexName = %v, want %s", body["IndexName"], textIndexName)
+	}
+	if condition, _ := body["KeyConditionExpression"].(string); !strings.Contains(condition, "begins_with(TextLower, :prefix)") {
+		t.Errorf("KeyConditionExpression = %q, want a begins_with on TextLower", condition)
+	}
+	values, _ := body["ExpressionAttributeValues"].(map[string]any)
+	prefix, _ := values[":prefix"].(map[string]any)
+	if prefix["S"] != "hel" {
+		t.Errorf(":prefix = %v, want the lower-cased prefix", values[":prefix"])
+	}
+}
+
+func TestDynamoDBGetPageQueriesTimestampIndex(t *testing.T) {
+	s, transport := newRecordingStore()
+	s.sortOrder = SortDescending
+	resume := model.NewMessage("resume here", "owner", "")
+	cursor, err := encodeCursor(pageKey(resume))
+	if err != nil {
+		t.Fatalf("encodeCursor: %v", err)
+	}
+
+	snapshotAt := time.Date(2024, 1, 1, 12, 30, 15, 500, time.UTC)
+	if _, _, err := s.GetPage(context.Background(), snapshotAt, cursor, 25); err != nil {
+		t.Fatalf("GetPage: %v", err)
+	}
+	if len(transport.bodies) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(transport.bodies))
+	}
+	body := transport.bodies[0]
+	if body["IndexName"] != timestampIndexName {
+		t.Errorf("IndexName = %v, want %s", body["IndexName"], timestampIndexName)
+	}
+	if body["ScanIndexForward"] != false {
+		t.Errorf("ScanIndexForward = %v, want false for descending order", body["ScanIndexForward"])
+	}
+	values, _ := body["ExpressionAttributeValues"].(map[string]any)
+	ceiling, _ := values[":ceiling"].(map[string]any)
+	if ceiling["S"] != "2024-01-01T12:30:16" {
+		t.Errorf(":ceiling = %v, want the start of the next second", values[":ceiling"])
+	}
+	startKey, _ := body["ExclusiveStartKey"].(map[string]any)
+	id, _ := startKey["ID"].(map[string]any)
+	if id["S"] != resume.ID {
+		t.Errorf("ExclusiveStartKey = %v, want the key of the cursor's message", body["ExclusiveStartKey"])
+	}
+
+	if _, _, err := s.GetPage(context.Background(), snapshotAt, "not-a-cursor", 25); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("GetPage with a bad cursor returned %v, want ErrInvalidCursor", err)
+	}
+
+	// A scan cursor lacks the index attributes, which DynamoDB would fail the query for
+	scanCursor, err := encodeCursor(map[string]types.AttributeValue{"ID": &types.AttributeValueMemberS{Value: resume.ID}})
+	if err != nil {
+		t.Fatalf("encodeCursor: %v", err)
+	}
+	transport.bodies = nil
+	if _, _, err := s.GetPage(context.Background(), snapshotAt, scanCursor, 25); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("GetPage with a scan cursor returned %v, want ErrInvalidCursor", err)
+	}
+	if len(transport.bodies) != 0 {
+		t.Errorf("sent %d requests with a scan cursor, want 0", len(transport.bodies))
+	}
+}
+
+func TestDynamoDBGetAllWithBudgetResumesScan(t *testing.T) {
+	s, transport := newRecordingStore()
+	cursor, err := encodeCursor(map[string]types.AttributeValue{"ID": &types.AttributeValueMemberS{Value: "resume-here"}})
+	if err != nil {
+		t.Fatalf("encodeCursor: %v", err)
+	}
+
+	if _, _, _, err := s.GetAllWithBudget(context.Background(), cursor, time.Minute); err != nil {
+		t.Fatalf("GetAllWithBudget: %v", err)
+	}
+	if len(transport.bodies) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(transport.bodies))
+	}
+	startKey, _ := transport.bodies[0]["ExclusiveStartKey"].(map[string]any)
+	id, _ := startKey["ID"].(map[string]any)
+	if id["S"] != "resume-here" || len(startKey) != 1 {
+		t.Errorf("ExclusiveStartKey = %v, want the key of the cursor", transport.bodies[0]["ExclusiveStartKey"])
+	}
+
+	// A page cursor is not a scan key
+	pageCursor, err := encodeCursor(pageKey(model.NewMessage("hello", "owner", "")))
+	if err != nil {
+		t.Fatalf("encodeCursor: %v", err)
+	}
+	for _, bad := range []string{"not-a-cursor", pageCursor} {
+		if _, _, _, err := s.GetAllWithBudget(context.Background(), bad, time.Minute); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("GetAllWithBudget(%q) returned %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+}
+
+func TestDynamoDBGetByIDsChunksKeys(t *testing.T) {
+	s, transport := newRecordingStore()
+	ids := make([]string, 0, 160)
+	for i := 0; i < 150; i++ {
+		ids = append(ids, fmt.Sprintf("id-%d", i))
+	}
+	ids = append(ids, ids[:10]...) // repeats are requested once
+
+	messages, err := s.GetByIDs(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("GetByIDs: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("got %d messages from empty responses, want 0", len(messages))
+	}
+	if len(transport.bodies) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(transport.bodies))
+	}
+	for i, want := range []int{100, 50} {
+		requestItems, _ := transport.bodies[i]["RequestItems"].(map[string]any)
+		table, _ := requestItems["messages"].(map[string]any)
+		keys, _ := table["Keys"].([]any)
+		if len(keys) != want {
+			t.Errorf("request %d has %d keys, want %d", i, len(keys), want)
+		}
+		if table["ConsistentRead"] != true {
+			t.Errorf("request %d is not a strongly consistent read", i)
+		}
+	}
+}
+
+func TestDynamoDBGetRecentQueriesTimestampIndex(t *testing.T) {
+	s, transport := newRecordingStore()
+
+	if _, err := s.GetRecent(context.Background(), 50); err != nil {
+		t.Fatalf("GetRecent: %v", err)
+	}
+	if len(transport.bodies) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(transport.bodies))
+	}
+	body := transport.bodies[0]
+	if body["IndexName"] != timestampIndexName {
+		t.Errorf("IndexName = %v, want %s", body["IndexName"], timestampIndexName)
+	}
+	if body["ScanIndexForward"] != false {
+		t.Errorf("ScanIndexForward = %v, want false to read newest first", body["ScanIndexForward"])
+	}
+	if body["Limit"] != float64(50) {
+		t.Errorf("Limit = %v, want 50", body["Limit"])
+	}
+}
+
+func TestDynamoDBGetRepliesQueriesParentIndex(t *testing.T) {
+	s, transport := newRecordingStore()
+
+	replies, err := s.GetReplies(context.Background(), "parent-id")
+	if err != nil {
+		t.Fatalf("GetReplies: %v", err)
+	}
+	if replies == nil || len(replies) != 0 {
+		t.Errorf("got %v from an empty response, want an empty slice", replies)
+	}
+	if len(transport.bodies) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(transport.bodies))
+	}
+	body := transport.bodies[0]
+	if body["IndexName"] != parentIDIndexName {
+		t.Errorf("IndexName = %v, want %s", body["IndexName"], parentIDIndexName)
+	}
+	values, _ := body["ExpressionAttributeValues"].(map[string]any)
+	parentID, _ := values[":parentID"].(map[string]any)
+	if parentID["S"] != "parent-id" {
+		t.Errorf(":parentID = %v, want parent-id", values[":parentID"])
+	}
+}
+
+func TestLowerTextFitsIndexKey(t *testing.T) {
+	text := strings.Repeat("é", 600) // 1200 bytes
+	lower := model.LowerText(text)
+	if len(lower) > 1024 {
+		t.Errorf("TextLower is %d bytes, over the 1024 byte index key limit", len(lower))
+	}
+	if !strings.HasPrefix(text, lower) || len(lower)%2 != 0 {
+		t.Errorf("TextLower %q is not a whole-character prefix of the text", lower)
+	}
+}
+
+func TestNewDynamoDBMessageStoreWithoutCredentials(t *testing.T) {
+	// Leave the default credential chain with nothing to find
+	missing := filepath.Join(t.TempDir(), "missing")
+	for name, value := range map[string]string{
+		"AWS_ACCESS_KEY_ID":                      "",
+		"AWS_SECRET_ACCESS_KEY":                  "",
+		"AWS_SESSION_TOKEN":                      "",
+		"AWS_PROFILE":                            "",
+		"AWS_CONFIG_FILE":                        missing,
+		"AWS_SHARED_CREDENTIALS_FILE":            missing,
+		"AWS_WEB_IDENTITY_TOKEN_FILE":            "",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI":     "",
+		"AWS_EC2_METADATA_DISABLED":              "true",
+	} {
+		t.Setenv(name, value)
+	}
+
+	_, err := NewDynamoDBMessageStore(DynamoDBMessageStoreConfig{TableName: "messages"})
+	if !errors.Is(err, awsutil.ErrNoCredentials) {
+		t.Fatalf("got %v, want ErrNoCredentials", err)
+	}
+	if !strings.Contains(err.Error(), "AWS_ACCESS_KEY_ID") {
+		t.Errorf("error %q does not say how to provide credentials", err)
+	}
+}
+
+func TestDynamoDBAddLogsTruncatedItem(t *testing.T) {
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	s, _ := newRecordingStore()
+	text := strings.Repeat("x", 1000)
+	if err := s.Add(context.Background(), model.NewMessage(text, "owner", "")); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if strings.Contains(logs.String(), text) {
+		t.Error("the full message text was logged")
+	}
+	want := fmt.Sprintf(`Text:"%s... (+%d bytes)"`, text[:awsutil.DefaultLogMaxFieldLen], 1000-awsutil.DefaultLogMaxFieldLen)
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("logs do not contain the truncated text %s:\n%s", want, logs.String())
+	}
+}
+
+// unprocessingTransport answers BatchWriteItem calls, leaving the last request of each batch
+// unprocessed for the first unprocessedCalls calls (every call if negative)
+type unprocessingTransport struct {
+	unprocessedCalls int
+	calls            int
+}
+
+func (u *unprocessingTransport) Do(req *http.Request) (*http.Response, error) {
+	var body struct {
+		RequestItems map[string][]json.RawMessage
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	u.calls++
+
+	response := `{}`
+	if u.unprocessedCalls < 0 || u.calls <= u.unprocessedCalls {
+		requests := body.RequestItems["messages"]
+		response = fmt.Sprintf(`{"UnprocessedItems":{"messages":[%s]}}`, requests[len(requests)-1])
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func newUnprocessingStore(unprocessedCalls int) (*DynamoDBMessageStore, *unprocessingTransport) {
+	transport := &unprocessingTransport{unprocessedCalls: unprocessedCalls}
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+	})
+	return &DynamoDBMessageStore{client: client, tableName: "messages"}, transport
+}
+
+func deleteRequests(ids ...string) []types.WriteRequest {
+	requests := make([]types.WriteRequest, len(ids))
+	for i, id := range ids {
+		requests[i] = types.WriteRequest{
+			DeleteRequest: &types.DeleteRequest{
+				Key: map[string]types.AttributeValue{"ID": &types.AttributeValueMemberS{Value: id}},
+			},
+		}
+	}
+	return requests
+}
+
+func TestBatchWriteRetriesUnprocessed(t *testing.T) {
+	defer func(backoff time.Duration) { batchWriteBackoff = backoff }(batchWriteBackoff)
+	batchWriteBackoff = time.Millisecond
+
+	s, transport := newUnprocessingStore(2)
+	unprocessed, err := s.batchWrite(context.Background(), deleteRequests("id-1", "id-2", "id-3"))
+	if err != nil {
+		t.Fatalf("batchWrite: %v", err)
+	}
+	if len(unprocessed) != 0 {
+		t.Errorf("got %d unprocessed requests, want none", len(unprocessed))
+	}
+	if transport.calls != 3 {
+		t.Errorf("sent %d requests, want 3: the batch and two retries", transport.calls)
+	}
+}
+
+func TestBatchWriteReportsUnprocessed(t *testing.T) {
+	defer func(backoff time.Duration) { batchWriteBackoff = backoff }(batchWriteBackoff)
+	batchWriteBackoff = time.Millisecond
+
+	s, transport := newUnprocessingStore(-1)
+	start := time.Now()
+	unprocessed, err := s.batchWrite(context.Background(), deleteRequests("id-1", "id-2", "id-3"))
+	if !errors.Is(err, errUnprocessedWrites) {
+		t.Fatalf("batchWrite returned %v, want errUnprocessedWrites", err)
+	}
+	if transport.calls != maxBatchWriteAttempts {
+		t.Errorf("sent %d requests, want %d", transport.calls, maxBatchWriteAttempts)
+	}
+	if ids := deleteRequestIDs(unprocessed); len(ids) != 1 || ids[0] != "id-3" {
+		t.Errorf("unprocessed requests are for %v, want [id-3]", ids)
+	}
+	// The waits double: 1+2+4+8 ms before the four retries
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("retries took %s, want at least 15ms of backoff", elapsed)
+	}
+}
+
+// selfTestTransport answers the self-test's calls like a table with no probe item, failing
+// each operation in denied with AccessDeniedException. It records each operation's request.
+type selfTestTransport struct {
+	denied     string
+	operations []string
+	bodies     []map[string]any
+}
+
+func (f *selfTestTransport) Do(req *http.Request) (*http.Response, error) {
+	var body map[string]any
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	operation := strings.TrimPrefix(req.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	f.operations = append(f.operations, operation)
+	f.bodies = append(f.bodies, body)
+
+	status, response := http.StatusOK, `{}`
+	switch {
+	case operation == f.denied:
+		status, response = http.StatusBadRequest, `{"__type":"com.amazon.coral.service#AccessDeniedException","message":"not authorized"}`
+	case operation == "DescribeTable":
+		response = `{"Table":{"TableName":"messages","TableStatus":"ACTIVE"}}`
+	case operation == "PutItem" || operation == "DeleteItem":
+		status, response = http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func TestDynamoDBSelfTest(t *testing.T) {
+	for _, denied := range []string{"", "DescribeTable", "GetItem", "PutItem", "DeleteItem"} {
+		transport := &selfTestTransport{denied: denied}
+		client := dynamodb.New(dynamodb.Options{
+			Region:           "us-east-1",
+			Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+			HTTPClient:       transport,
+			RetryMaxAttempts: 1,
+		})
+		s := &DynamoDBMessageStore{client: client, tableName: "messages"}
+
+		err := s.SelfTest(context.Background())
+		if denied == "" {
+			if err != nil {
+				t.Errorf("SelfTest with full access: %v", err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), "dynamodb:"+denied) {
+			t.Errorf("SelfTest without %s: got %v, want an error naming the permission", denied, err)
+		}
+
+		// Every write is conditioned on an attribute no item has, so the table never changes
+		for i, operation := range transport.operations {
+			if operation != "PutItem" && operation != "DeleteItem" {
+				continue
+			}
+			if transport.bodies[i]["ConditionExpression"] != "attribute_exists(#probe)" {
+				t.Errorf("%s sent without the probe condition: %v", operation, transport.bodies[i])
+			}
+		}
+	}
+}
+
+// backfillTransport answers the backfill scan with items and fails the update of the message
+// in deleted as if it had been deleted since, recording each UpdateItem request
+type backfillTransport struct {
+	items   string
+	deleted string
+	updates []map[string]any
+}
+
+func (f *backfillTransport) Do(req *http.Request) (*http.Response, error) {
+	var body map[string]any
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	status, response := http.StatusOK, `{}`
+	switch req.Header.Get("X-Amz-Target") {
+	case "DynamoDB_20120810.Scan":
+		response = `{"Items":` + f.items + `}`
+	case "DynamoDB_20120810.UpdateItem":
+		f.updates = append(f.updates, body)
+		if strings.Contains(fmt.Sprint(body["Key"]), f.deleted) {
+			status, response = http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func TestDynamoDBBackfillIndexKeys(t *testing.T) {
+	transport := &backfillTransport{
+		items:   `[{"ID":{"S":"old-1"},"Text":{"S":"Hello There"}},{"ID":{"S":"old-2"},"Text":{"S":"gone"}}]`,
+		deleted: "old-2",
+	}
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+	})
+	s := &DynamoDBMessageStore{client: client, tableName: "messages"}
+
+	updated, err := s.BackfillIndexKeys(context.Background())
+	if err != nil {
+		t.Fatalf("BackfillIndexKeys: %v", err)
+	}
+	// The message deleted since the scan is skipped rather than recreated
+	if updated != 1 || len(transport.updates) != 2 {
+		t.Fatalf("got %d updated from %d updates, want 1 from 2", updated, len(transport.updates))
+	}
+
+	update := transport.updates[0]
+	if update["ConditionExpression"] != "attribute_exists(ID)" {
+		t.Errorf("update sent without the existence condition: %v", update)
+	}
+	values, _ := json.Marshal(update["ExpressionAttributeValues"])
+	if want := `{":feed":{"S":"messages"},":textLower":{"S":"hello there"}}`; string(values) != want {
+		t.Errorf("got values %s, want %s", values, want)
+	}
+}
+
+// scanTransport answers every Scan with the given items, in the given order
+type scanTransport struct {
+	items []string
+}
+
+func (f *scanTransport) Do(req *http.Request) (*http.Response, error) {
+	response := `{"Items":[` + strings.Join(f.items, ",") + `],"Count":` + strconv.Itoa(len(f.items)) + `}`
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func newScanStore(transport *scanTransport) *DynamoDBMessageStore {
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+	})
+	return &DynamoDBMessageStore{client: client, tableName: "messages"}
+}
+
+func TestGetAllOrderMatchesAcrossStores(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Inserted, and scanned, out of order, with a timestamp tie between b and c
+	input := []struct {
+		id     string
+		offset time.Duration
+	}{
+		{"c", time.Minute},
+		{"a", 2 * time.Minute},
+		{"d", 0},
+		{"b", time.Minute},
+	}
+
+	for _, order := range []SortOrder{SortAscending, SortDescending} {
+		t.Run(string(order), func(t *testing.T) {
+			memory := NewMessageStore(order)
+			transport := &scanTransport{}
+			for _, in := range input {
+				message := model.NewMessage("text "+in.id, "owner", "")
+				message.ID = in.id
+				message.Timestamp = httputil.Timestamp(base.Add(in.offset))
+				if err := memory.Add(context.Background(), message); err != nil {
+					t.Fatalf("Add: %v", err)
+				}
+				transport.items = append(transport.items, fmt.Sprintf(
+					`{"ID":{"S":%q},"Text":{"S":%q},"Owner":{"S":"owner"},"Timestamp":{"S":%q}}`,
+					in.id, message.Text, base.Add(in.offset).Format(time.RFC3339)))
+			}
+			dynamo := newScanStore(transport)
+			dynamo.sortOrder = order
+
+			want := []string{"d", "b", "c", "a"}
+			if order == SortDescending {
+				// Ties are still broken by ascending ID
+				want = []string{"a", "b", "c", "d"}
+			}
+			for name, s := range map[string]interface {
+				GetAll(context.Context) ([]*model.Message, error)
+			}{"memory": memory, "dynamodb": dynamo} {
+				messages, err := s.GetAll(context.Background())
+				if err != nil {
+					t.Fatalf("%s GetAll: %v", name, err)
+				}
+				got := make([]string, len(messages))
+				for i, message := range messages {
+					got[i] = message.ID
+				}
+				if strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("%s GetAll order = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDynamoDBGetAllSamplesItemLogs(t *testing.T) {
+	defer logging.Configure("info", 1)
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	transport := &scanTransport{}
+	for i := 0; i < 6; i++ {
+		transport.items = append(transport.items, fmt.Sprintf(
+			`{"ID":{"S":"id-%d"},"Text":{"S":"text"},"Owner":{"S":"owner"},"Timestamp":{"S":"2024-01-01T00:00:00Z"}}`, i))
+	}
+	s := newScanStore(transport)
+
+	tests := []struct {
+		name      string
+		level     string
+		rate      int
+		wantItems int
+	}{
+		{"info suppresses item logs", "info", 1, 0},
+		{"debug logs every item", "debug", 1, 6},
+		{"debug logs every Nth item", "debug", 4, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			logging.Configure(tt.level, tt.rate)
+
+			if _, err := s.GetAll(context.Background()); err != nil {
+				t.Fatalf("GetAll: %v", err)
+			}
+			if got := strings.Count(logs.String(), "Processing item"); got != tt.wantItems {
+				t.Errorf("got %d per-item log lines, want %d:\n%s", got, tt.wantItems, logs.String())
+			}
+			if !strings.Contains(logs.String(), "Scan returned 6 items") {
+				t.Errorf("logs do not contain the summary line:\n%s", logs.String())
+			}
+		})
+	}
+}
+
+// missingTableTransport answers DescribeTable as if the table did not exist and records every
+// operation
+type missingTableTransport struct {
+	operations []string
+}
+
+func (f *missingTableTransport) Do(req *http.Request) (*http.Response, error) {
+	f.operations = append(f.operations, strings.TrimPrefix(req.Header.Get("X-Amz-Target"), "DynamoDB_20120810."))
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Requested resource not found"}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestDynamoDBMissingTableWithoutAutoCreate(t *testing.T) {
+	transport := &missingTableTransport{}
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+	})
+	s := &DynamoDBMessageStore{client: client, tableName: "messages"}
+
+	err := s.ensureTableExists(context.Background())
+	if err == nil {
+		t.Fatal("ensureTableExists succeeded for a missing table")
+	}
+	hints := []string{
+		`table "messages" not found`,
+		"auto-create is disabled",
+		"partition key ID (S)",
+		parentIDIndexName + " with partition key ParentID (S)",
+		timestampIndexName + " with partition key Feed (S) and sort key Timestamp (S)",
+		ownerIndexName + " with partition key Owner (S)",
+		textIndexName + " with partition key Feed (S) and sort key TextLower (S)",
+	}
+	for _, hint := range hints {
+		if !strings.Contains(err.Error(), hint) {
+			t.Errorf("error %q does not contain %q", err, hint)
+		}
+	}
+	if strings.Join(transport.operations, ",") != "DescribeTable" {
+		t.Errorf("sent %v, want only DescribeTable", transport.operations)
+	}
+}
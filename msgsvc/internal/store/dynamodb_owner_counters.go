@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/shared/awsutil"
+)
+
+// DynamoDBOwnerCounters keeps a running count of messages per owner in a DynamoDB table keyed
+// by Owner, with the count in MessageCount. The counts are shared by every instance, so the
+// top owners can be read without scanning the messages table.
+type DynamoDBOwnerCounters struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBOwnerCounters creates new owner counters backed by the given table. endpoint
+// overrides the DynamoDB endpoint (e.g. a local DynamoDB for testing) if not empty, and
+// httpClient tunes the connection pool of the DynamoDB HTTP client.
+func NewDynamoDBOwnerCounters(tableName, endpoint string, httpClient awsutil.HTTPClientConfig) (*DynamoDBOwnerCounters, error) {
+	if tableName == "" {
+		return nil, fmt.Errorf("table name cannot be empty")
+	}
+
+	// Resolve the region consistently with the other AWS clients
+	region := awsutil.ResolveRegion("")
+
+	// Load AWS configuration
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(region),
+		config.WithHTTPClient(awsutil.NewHTTPClient(httpClient)),
+	)
+	if err != nil {
+		log.Printf("Failed to load AWS config: %v", err)
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// Fail at startup rather than on the first call if there are no credentials. A local
+	// endpoint accepts any credentials.
+	if endpoint == "" {
+		if err := awsutil.CheckCredentials(context.TODO(), cfg); err != nil {
+			log.Printf("ERROR: %v", err)
+			return nil, err
+		}
+	}
+
+	log.Printf("Initialized owner counters in table %s in region: %s", tableName, region)
+
+	return &DynamoDBOwnerCounters{
+		client: dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}),
+		tableName: tableName,
+	}, nil
+}
+
+// Add atomically adds delta to the owner's counter, creating it if needed
+func (c *DynamoDBOwnerCounters) Add(ctx context.Context, owner string, delta int64) error {
+	_, err := c.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(c.tableName),
+		Key: map[string]types.AttributeValue{
+			"Owner": &types.AttributeValueMemberS{Value: owner},
+		},
+		UpdateExpression: aws.String("ADD MessageCount :delta"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delta": &types.AttributeValueMemberN{Value: strconv.FormatInt(delta, 10)},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to update counter for owner %s in table %s: %v", owner, c.tableName, err)
+		return fmt.Errorf("failed to update owner counter: %w", err)
+	}
+	return nil
+}
+
+// GetAll returns every non-zero counter, keyed by owner
+func (c *DynamoDBOwnerCounters) GetAll(ctx context.Context) (map[string]int64, error) {
+	paginator := dynamodb.NewScanPaginator(c.client, &dynamodb.ScanInput{
+		TableName: aws.String(c.tableName),
+	})
+
+	counts := make(map[string]int64)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to scan table %s: %v", c.tableName, err)
+			return nil, fmt.Errorf("failed to read owner counters: %w", err)
+		}
+		for _, item := range page.Items {
+			owner, ok := item["Owner"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			value, ok := item["MessageCount"].(*types.AttributeValueMemberN)
+			if !ok {
+				continue
+			}
+			count, err := strconv.ParseInt(value.Value, 10, 64)
+			if err != nil {
+				log.Printf("WARNING: Invalid counter %q for owner %s in table %s", value.Value, owner.Value, c.tableName)
+				continue
+			}
+			if count != 0 {
+				counts[owner.Value] = count
+			}
+		}
+	}
+	return counts, nil
+}
+
+// Top returns the owners with the highest counters, highest first
+func (c *DynamoDBOwnerCounters) Top(ctx context.Context, limit int) ([]OwnerCount, error) {
+	counts, err := c.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return topOwners(counts, limit), nil
+}
+
+// Set sets the owner's counter to count if it still holds expected, where a missing counter
+// holds 0. It reports whether the counter was set, so that a correction computed from a stale
+// read does not overwrite a concurrent Add.
+func (c *DynamoDBOwnerCounters) Set(ctx context.Context, owner string, expected, count int64) (bool, error) {
+	condition := "MessageCount = :expected"
+	if expected == 0 {
+		condition = "attribute_not_exists(MessageCount) OR MessageCount = :expected"
+	}
+	_, err := c.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(c.tableName),
+		Key: map[string]types.AttributeValue{
+			"Owner": &types.AttributeValueMemberS{Value: owner},
+		},
+		UpdateExpression:    aws.String("SET MessageCount = :count"),
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":count":    &types.AttributeValueMemberN{Value: strconv.FormatInt(count, 10)},
+			":expected": &types.AttributeValueMemberN{Value: strconv.FormatInt(expected, 10)},
+		},
+	})
+	if err != nil {
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedErr) {
+			return false, nil
+		}
+		log.Printf("Failed to set counter for owner %s in table %s: %v", owner, c.tableName, err)
+		return false, fmt.Errorf("failed to set owner counter: %w", err)
+	}
+	return true, nil
+}
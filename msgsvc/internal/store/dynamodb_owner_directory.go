@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/shared/awsutil"
+)
+
+// usersSubIndexName is the usersvc table index keyed by Cognito sub
+const usersSubIndexName = "SubIndex"
+
+// ownerLookupConcurrency bounds the index queries GetOwners runs at once
+const ownerLookupConcurrency = 10
+
+// DynamoDBOwnerDirectory resolves message owners (Cognito subs) to user profiles by reading
+// the usersvc users table. It only reads the table, which usersvc owns and provisions.
+type DynamoDBOwnerDirectory struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBOwnerDirectory creates a new owner directory backed by the given users table.
+// endpoint overrides the DynamoDB endpoint (e.g. a local DynamoDB for testing) if not empty, and
+// httpClient tunes the connection pool of the DynamoDB HTTP client.
+func NewDynamoDBOwnerDirectory(tableName, endpoint string, httpClient awsutil.HTTPClientConfig) (*DynamoDBOwnerDirectory, error) {
+	if tableName == "" {
+		return nil, fmt.Errorf("table name cannot be empty")
+	}
+
+	// Resolve the region consistently with the other AWS clients
+	region := awsutil.ResolveRegion("")
+
+	// Load AWS configuration
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(region),
+		config.WithHTTPClient(awsutil.NewHTTPClient(httpClient)),
+	)
+	if err != nil {
+		log.Printf("Failed to load AWS config: %v", err)
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// Fail at startup rather than on the first call if there are no credentials. A local
+	// endpoint accepts any credentials.
+	if endpoint == "" {
+		if err := awsutil.CheckCredentials(context.TODO(), cfg); err != nil {
+			log.Printf("ERROR: %v", err)
+			return nil, err
+		}
+	}
+
+	log.Printf("Initialized owner directory for users table %s in region: %s", tableName, region)
+
+	return &DynamoDBOwnerDirectory{
+		client: dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		}),
+		tableName: tableName,
+	}, nil
+}
+
+// OwnerProfile holds the user attributes needed to render a message owner's display name
+type OwnerProfile struct {
+	FirstName string
+	LastName  string
+	Email     string
+}
+
+// GetOwner returns the profile of the user with the given sub, or nil if there is no such user
+func (d *DynamoDBOwnerDirectory) GetOwner(ctx context.Context, sub string) (*OwnerProfile, error) {
+	result, err := d.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(d.tableName),
+		IndexName:              aws.String(usersSubIndexName),
+		KeyConditionExpression: aws.String("#sub = :sub"),
+		ProjectionExpression:   aws.String("FirstName, LastName, Email"),
+		ExpressionAttributeNames: map[string]string{
+			"#sub": "Sub",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sub": &types.AttributeValueMemberS{Value: sub},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		log.Printf("Failed to query index %s on table %s: %v", usersSubIndexName, d.tableName, err)
+		return nil, fmt.Errorf("failed to look up user by sub: %w", err)
+	}
+
+	if len(result.Items) == 0 {
+		return nil, nil
+	}
+
+	item := result.Items[0]
+	stringAttribute := func(name string) string {
+		if value, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return value.Value
+		}
+		return ""
+	}
+	return &OwnerProfile{
+		FirstName: stringAttribute("FirstName"),
+		LastName:  stringAttribute("LastName"),
+		Email:     stringAttribute("Email"),
+	}, nil
+}
+
+// GetOwners returns the profiles of the users with the given subs, keyed by sub, leaving out
+// subs with no user. BatchGetItem cannot read an index, so each sub is still one query of
+// SubIndex, but up to ownerLookupConcurrency of them run at once and each distinct sub is
+// queried once. Subs whose query fails are left out and their errors returned together.
+func (d *DynamoDBOwnerDirectory) GetOwners(ctx context.Context, subs []string) (map[string]*OwnerProfile, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		profiles = make(map[string]*OwnerProfile, len(subs))
+		errs     []error
+		queried  = make(map[string]bool, len(subs))
+		slots    = make(chan struct{}, ownerLookupConcurrency)
+	)
+	for _, sub := range subs {
+		if queried[sub] {
+			continue
+		}
+		queried[sub] = true
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			profile, err := d.GetOwner(ctx, sub)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("owner %s: %w", sub, err))
+			} else if profile != nil {
+				profiles[sub] = profile
+			}
+		}()
+	}
+	wg.Wait()
+	return profiles, errors.Join(errs...)
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// subIndexTransport answers SubIndex queries from a map of sub to item JSON, failing queries
+// for subs in broken. It counts the queries for each sub.
+type subIndexTransport struct {
+	items  map[string]string
+	broken map[string]bool
+
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+func (f *subIndexTransport) Do(req *http.Request) (*http.Response, error) {
+	var body struct {
+		IndexName                 string
+		ExpressionAttributeValues map[string]map[string]string
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	sub := body.ExpressionAttributeValues[":sub"]["S"]
+
+	f.mu.Lock()
+	f.queries[sub]++
+	f.mu.Unlock()
+
+	status, response := http.StatusOK, `{"Items":[]}`
+	switch {
+	case body.IndexName != usersSubIndexName:
+		status, response = http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ValidationException","message":"wrong index"}`
+	case f.broken[sub]:
+		status, response = http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"no such table"}`
+	case f.items[sub] != "":
+		response = `{"Items":[` + f.items[sub] + `]}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func TestDynamoDBGetOwners(t *testing.T) {
+	transport := &subIndexTransport{
+		items: map[string]string{
+			"sub-alice": `{"FirstName":{"S":"Alice"},"LastName":{"S":"Smith"},"Email":{"S":"alice@example.com"}}`,
+			"sub-bob":   `{"Email":{"S":"bob@example.com"}}`,
+		},
+		broken:  map[string]bool{"sub-broken": true},
+		queries: make(map[string]int),
+	}
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+	})
+	directory := &DynamoDBOwnerDirectory{client: client, tableName: "users"}
+
+	subs := []string{"sub-alice", "sub-bob", "sub-alice", "sub-unknown", "sub-broken"}
+	profiles, err := directory.GetOwners(context.Background(), subs)
+
+	// The failed lookup is reported, but does not hide the others
+	if err == nil || !strings.Contains(err.Error(), "sub-broken") {
+		t.Errorf("got error %v, want one naming sub-broken", err)
+	}
+	if len(profiles) != 2 {
+		t.Errorf("got %d profiles, want 2: %v", len(profiles), profiles)
+	}
+	if alice := profiles["sub-alice"]; alice == nil || *alice != (OwnerProfile{FirstName: "Alice", LastName: "Smith", Email: "alice@example.com"}) {
+		t.Errorf("got profile %+v for sub-alice", alice)
+	}
+	if bob := profiles["sub-bob"]; bob == nil || bob.Email != "bob@example.com" {
+		t.Errorf("got profile %+v for sub-bob", bob)
+	}
+	for _, sub := range []string{"sub-alice", "sub-bob", "sub-unknown", "sub-broken"} {
+		if transport.queries[sub] != 1 {
+			t.Errorf("got %d queries for %s, want 1", transport.queries[sub], sub)
+		}
+	}
+}
@@ -1,40 +1,510 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/shared/httputil"
 )
 
+var (
+	// ErrMessageNotFound is returned when an operation targets a message that does not exist
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrReactionLimitReached is returned when adding a new reaction would exceed the
+	// maximum number of distinct reactions on a message
+	ErrReactionLimitReached = errors.New("reaction limit reached")
+
+	// ErrReactionNotFound is returned when removing a reaction the message does not have
+	ErrReactionNotFound = errors.New("reaction not found")
+
+	// ErrInvalidCursor is returned when a page cursor cannot be decoded
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// SortOrder is the order in which messages are returned by timestamp
+type SortOrder string
+
+const (
+	// SortAscending returns the oldest messages first
+	SortAscending SortOrder = "asc"
+	// SortDescending returns the newest messages first
+	SortDescending SortOrder = "desc"
+)
+
+// OwnerCount is the number of messages posted by one owner
+type OwnerCount struct {
+	Owner string `json:"owner"`
+	Count int64  `json:"count"`
+}
+
 // MessageStore is an in-memory store for messages
 type MessageStore struct {
-	messages []*model.Message
-	mutex    sync.RWMutex
+	messages  []*model.Message
+	sortOrder SortOrder
+	mutex     sync.RWMutex
 }
 
 // NewMessageStore creates a new message store
-func NewMessageStore() *MessageStore {
+func NewMessageStore(sortOrder SortOrder) *MessageStore {
 	return &MessageStore{
-		messages: make([]*model.Message, 0),
+		messages:  make([]*model.Message, 0),
+		sortOrder: sortOrder,
 	}
 }
 
-// GetAll returns all messages
-func (s *MessageStore) GetAll() ([]*model.Message, error) {
+// GetAll returns all messages ordered by timestamp
+func (s *MessageStore) GetAll(_ context.Context) ([]*model.Message, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	// Return a copy of the messages to avoid race conditions
 	result := make([]*model.Message, len(s.messages))
 	copy(result, s.messages)
+	SortMessages(result, s.sortOrder)
 	return result, nil
 }
 
+// GetAllWithBudget returns all messages ordered by timestamp. Reading memory never comes close
+// to a time budget, so the result is never truncated and there is no scan to resume: any
+// startCursor is invalid.
+func (s *MessageStore) GetAllWithBudget(ctx context.Context, startCursor string, _ time.Duration) ([]*model.Message, bool, string, error) {
+	if startCursor != "" {
+		return nil, false, "", ErrInvalidCursor
+	}
+	messages, err := s.GetAll(ctx)
+	return messages, false, "", err
+}
+
+// GetAllSorted returns all messages with pinned messages first, then by timestamp
+func (s *MessageStore) GetAllSorted(ctx context.Context) ([]*model.Message, error) {
+	messages, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	SortPinnedFirst(messages, s.sortOrder)
+	return messages, nil
+}
+
+// GetByID returns a copy of the message with the given ID, or nil if it does not exist
+func (s *MessageStore) GetByID(_ context.Context, id string) (*model.Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	message := s.findByID(id)
+	if message == nil {
+		return nil, nil
+	}
+	return message.Clone(), nil
+}
+
+// GetByIDs returns the messages with the given IDs in the order requested, omitting IDs that do
+// not exist and repeated IDs
+func (s *MessageStore) GetByIDs(_ context.Context, ids []string) ([]*model.Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	messages := make([]*model.Message, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if message := s.findByID(id); message != nil {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// Exists reports whether a message with the given ID exists
+func (s *MessageStore) Exists(_ context.Context, id string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.findByID(id) != nil, nil
+}
+
+// GetReplies returns the replies to the message with the given ID, ordered by timestamp
+func (s *MessageStore) GetReplies(_ context.Context, parentID string) ([]*model.Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	replies := make([]*model.Message, 0)
+	for _, message := range s.messages {
+		if message.ParentID == parentID {
+			replies = append(replies, message)
+		}
+	}
+	SortMessages(replies, s.sortOrder)
+	return replies, nil
+}
+
+// GetSince returns up to limit messages with a timestamp strictly after since, oldest first
+func (s *MessageStore) GetSince(_ context.Context, since time.Time, limit int32) ([]*model.Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	messages := make([]*model.Message, 0)
+	for _, message := range s.messages {
+		if message.Timestamp.Time().After(since) {
+			messages = append(messages, message)
+		}
+	}
+	SortMessages(messages, SortAscending)
+	if int32(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// GetRecent returns up to limit of the newest messages, newest first
+func (s *MessageStore) GetRecent(_ context.Context, limit int32) ([]*model.Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	messages := make([]*model.Message, len(s.messages))
+	copy(messages, s.messages)
+	SortMessages(messages, SortDescending)
+	if int32(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// GetPage returns up to limit messages with a timestamp at or before snapshotAt, ordered by
+// timestamp and starting after the message the cursor points at, along with the cursor of the
+// next page ("" on the last page). Messages added after snapshotAt never appear, so paging through
+// a snapshot is stable while new messages arrive.
+func (s *MessageStore) GetPage(_ context.Context, snapshotAt time.Time, cursor string, limit int32) ([]*model.Message, string, error) {
+	var after *model.Message
+	if cursor != "" {
+		key, err := decodePageCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after, err = pageKeyMessage(key)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	messages := make([]*model.Message, 0)
+	for _, message := range s.messages {
+		if message.Timestamp.Time().After(snapshotAt) {
+			continue
+		}
+		if after != nil && !lessByTimestamp(after, message, s.sortOrder) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	SortMessages(messages, s.sortOrder)
+
+	if int32(len(messages)) <= limit {
+		return messages, "", nil
+	}
+	messages = messages[:limit]
+	next, err := encodeCursor(pageKey(messages[len(messages)-1]))
+	if err != nil {
+		return nil, "", err
+	}
+	return messages, next, nil
+}
+
+// GetByPrefix returns up to limit messages whose text starts with prefix, ignoring case, in
+// text order
+func (s *MessageStore) GetByPrefix(_ context.Context, prefix string, limit int32) ([]*model.Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	prefix = strings.ToLower(prefix)
+	messages := make([]*model.Message, 0)
+	for _, message := range s.messages {
+		if strings.HasPrefix(message.TextLower, prefix) {
+			messages = append(messages, message)
+		}
+	}
+	SortByText(messages)
+	if int32(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// GetByOwner returns the messages owned by the given user, ordered by timestamp
+func (s *MessageStore) GetByOwner(_ context.Context, owner string) ([]*model.Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	messages := make([]*model.Message, 0)
+	for _, message := range s.messages {
+		if message.Owner == owner {
+			messages = append(messages, message)
+		}
+	}
+	SortMessages(messages, s.sortOrder)
+	return messages, nil
+}
+
+// CountByOwner returns the owners with the most messages, most first, up to limit owners
+func (s *MessageStore) CountByOwner(_ context.Context, limit int) ([]OwnerCount, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	counts := make(map[string]int64)
+	for _, message := range s.messages {
+		counts[message.Owner]++
+	}
+	return topOwners(counts, limit), nil
+}
+
+// CountPinnedByOwner returns the number of pinned messages owned by the given user
+func (s *MessageStore) CountPinnedByOwner(_ context.Context, owner string) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	count := 0
+	for _, message := range s.messages {
+		if message.Owner == owner && message.Pinned {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountByDay returns the number of messages with a timestamp in [start, end), keyed by UTC date
+// ("2006-01-02"). Days without messages are left out.
+func (s *MessageStore) CountByDay(_ context.Context, start, end time.Time) (map[string]int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	counts := make(map[string]int64)
+	for _, message := range s.messages {
+		countDay(counts, message.Timestamp.Time(), start, end)
+	}
+	return counts, nil
+}
+
+// countDay adds timestamp to the count of its UTC date if it falls in [start, end)
+func countDay(counts map[string]int64, timestamp, start, end time.Time) {
+	if timestamp.Before(start) || !timestamp.Before(end) {
+		return
+	}
+	counts[timestamp.UTC().Format(time.DateOnly)]++
+}
+
+// DeleteByOwner deletes every message owned by the given user and returns how many were deleted
+func (s *MessageStore) DeleteByOwner(_ context.Context, owner string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	remaining := make([]*model.Message, 0, len(s.messages))
+	for _, message := range s.messages {
+		if message.Owner != owner {
+			remaining = append(remaining, message)
+		}
+	}
+	deleted := len(s.messages) - len(remaining)
+	s.messages = remaining
+	return deleted, nil
+}
+
 // Add adds a new message to the store
-func (s *MessageStore) Add(message *model.Message) error {
+func (s *MessageStore) Add(_ context.Context, message *model.Message) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	message.TextLower = model.LowerText(message.Text)
 	s.messages = append(s.messages, message)
 	return nil
 }
+
+// SetPinned sets the pinned flag on the message with the given ID
+func (s *MessageStore) SetPinned(_ context.Context, id string, pinned bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.indexByID(id)
+	if i < 0 {
+		return ErrMessageNotFound
+	}
+
+	// Replace rather than mutate the message so that readers holding the old one are unaffected
+	message := s.messages[i].Clone()
+	message.Pinned = pinned
+	s.messages[i] = message
+	return nil
+}
+
+// Update replaces the text of a message and returns a copy of the updated message. The previous
+// text is appended to the message's edit history, which keeps at most maxHistory entries.
+func (s *MessageStore) Update(_ context.Context, id, text string, maxHistory int) (*model.Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.indexByID(id)
+	if i < 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	// Replace rather than mutate the message so that readers holding the old one are unaffected
+	message := s.messages[i].Clone()
+	edit := model.MessageEdit{Text: message.Text, EditedAt: httputil.Timestamp(time.Now().UTC())}
+	message.EditHistory = appendEdit(message.EditHistory, edit, maxHistory)
+	message.Text = text
+	message.TextLower = model.LowerText(text)
+	s.messages[i] = message
+	return message.Clone(), nil
+}
+
+// AddReaction increments the count of the given emoji on a message and returns a copy of the
+// updated message.
+// A new emoji is only accepted while the message has fewer than maxReactions distinct reactions.
+func (s *MessageStore) AddReaction(_ context.Context, id, emoji string, maxReactions int) (*model.Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.indexByID(id)
+	if i < 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	if _, exists := s.messages[i].Reactions[emoji]; !exists && len(s.messages[i].Reactions) >= maxReactions {
+		return nil, ErrReactionLimitReached
+	}
+
+	// Replace rather than mutate the message so that readers holding the old one are unaffected
+	message := s.messages[i].Clone()
+	if message.Reactions == nil {
+		message.Reactions = make(map[string]int)
+	}
+	message.Reactions[emoji]++
+	s.messages[i] = message
+	return message.Clone(), nil
+}
+
+// RemoveReaction decrements the count of the given emoji on a message and returns a copy of the
+// updated message.
+// The emoji is removed from the message once its count reaches zero.
+func (s *MessageStore) RemoveReaction(_ context.Context, id, emoji string) (*model.Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.indexByID(id)
+	if i < 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	if s.messages[i].Reactions[emoji] <= 0 {
+		return nil, ErrReactionNotFound
+	}
+
+	// Replace rather than mutate the message so that readers holding the old one are unaffected
+	message := s.messages[i].Clone()
+	message.Reactions[emoji]--
+	if message.Reactions[emoji] == 0 {
+		delete(message.Reactions, emoji)
+	}
+	s.messages[i] = message
+	return message.Clone(), nil
+}
+
+// findByID returns the message with the given ID, or nil. The caller must hold the mutex.
+func (s *MessageStore) findByID(id string) *model.Message {
+	if i := s.indexByID(id); i >= 0 {
+		return s.messages[i]
+	}
+	return nil
+}
+
+// indexByID returns the index of the message with the given ID, or -1. The caller must hold the
+// mutex.
+func (s *MessageStore) indexByID(id string) int {
+	for i, message := range s.messages {
+		if message.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// SortMessages sorts messages in place by timestamp in the given order, breaking ties by ID
+// so that every store returns the same order for the same messages
+func SortMessages(messages []*model.Message, order SortOrder) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return lessByTimestamp(messages[i], messages[j], order)
+	})
+}
+
+// SortPinnedFirst sorts messages in place with pinned messages first, then by timestamp
+func SortPinnedFirst(messages []*model.Message, order SortOrder) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].Pinned != messages[j].Pinned {
+			return messages[i].Pinned
+		}
+		return lessByTimestamp(messages[i], messages[j], order)
+	})
+}
+
+// SortByText sorts messages in place by their lower-cased text, breaking ties by ID, which is
+// the order of the DynamoDB text index
+func SortByText(messages []*model.Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].TextLower != messages[j].TextLower {
+			return messages[i].TextLower < messages[j].TextLower
+		}
+		return messages[i].ID < messages[j].ID
+	})
+}
+
+// topOwners returns the owners with the highest counts, sorted by count descending and then by
+// owner so ties come out in a stable order, up to limit owners
+func topOwners(counts map[string]int64, limit int) []OwnerCount {
+	owners := make([]OwnerCount, 0, len(counts))
+	for owner, count := range counts {
+		owners = append(owners, OwnerCount{Owner: owner, Count: count})
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		if owners[i].Count != owners[j].Count {
+			return owners[i].Count > owners[j].Count
+		}
+		return owners[i].Owner < owners[j].Owner
+	})
+	if len(owners) > limit {
+		owners = owners[:limit]
+	}
+	return owners
+}
+
+// appendEdit returns a new history with edit appended, dropping the oldest entries beyond
+// maxHistory
+func appendEdit(history []model.MessageEdit, edit model.MessageEdit, maxHistory int) []model.MessageEdit {
+	if maxHistory <= 0 {
+		return nil
+	}
+	updated := make([]model.MessageEdit, 0, min(len(history)+1, maxHistory))
+	updated = append(updated, history[max(0, len(history)+1-maxHistory):]...)
+	return append(updated, edit)
+}
+
+// lessByTimestamp reports whether a sorts before b by timestamp in the given order, then by ID
+func lessByTimestamp(a, b *model.Message, order SortOrder) bool {
+	if !a.Timestamp.Time().Equal(b.Timestamp.Time()) {
+		if order == SortDescending {
+			return a.Timestamp.Time().After(b.Timestamp.Time())
+		}
+		return a.Timestamp.Time().Before(b.Timestamp.Time())
+	}
+	return a.ID < b.ID
+}
@@ -0,0 +1,602 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/msgsvc/internal/model"
+	"github.com/aws_e2e_test/shared/httputil"
+)
+
+func TestMessageStoreGetSince(t *testing.T) {
+	s := NewMessageStore(SortDescending)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, text := range []string{"first", "second", "third"} {
+		message := model.NewMessage(text, "owner", "")
+		message.Timestamp = httputil.Timestamp(base.Add(time.Duration(i) * time.Minute))
+		if err := s.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		since time.Time
+		limit int32
+		want  []string
+	}{
+		{"exactly equal timestamp excluded", base.Add(time.Minute), 100, []string{"third"}},
+		{"ascending regardless of sort order", base.Add(-time.Second), 100, []string{"first", "second", "third"}},
+		{"limited to oldest", base.Add(-time.Second), 2, []string{"first", "second"}},
+		{"nothing newer", base.Add(2 * time.Minute), 100, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := s.GetSince(context.Background(), tt.since, tt.limit)
+			if err != nil {
+				t.Fatalf("GetSince: %v", err)
+			}
+			if messages == nil {
+				t.Fatal("GetSince returned nil, want empty slice")
+			}
+			got := make([]string, len(messages))
+			for i, message := range messages {
+				got[i] = message.Text
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageStoreGetRecent(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Added out of order, so the result depends on sorting rather than insertion order
+	for _, minute := range []int{2, 0, 3, 1} {
+		message := model.NewMessage(fmt.Sprintf("minute %d", minute), "owner", "")
+		message.Timestamp = httputil.Timestamp(base.Add(time.Duration(minute) * time.Minute))
+		if err := s.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		limit int32
+		want  []string
+	}{
+		{2, []string{"minute 3", "minute 2"}},
+		{4, []string{"minute 3", "minute 2", "minute 1", "minute 0"}},
+		{10, []string{"minute 3", "minute 2", "minute 1", "minute 0"}},
+	}
+	for _, tt := range tests {
+		messages, err := s.GetRecent(context.Background(), tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(messages))
+		for i, message := range messages {
+			got[i] = message.Text
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("GetRecent(%d) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestMessageStoreDeleteByOwner(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	for _, owner := range []string{"alice", "bob", "alice", "alice"} {
+		if err := s.Add(context.Background(), model.NewMessage("hello from "+owner, owner, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		owner       string
+		wantDeleted int
+		wantLeft    int
+	}{
+		{"alice", 3, 1},
+		{"carol", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.owner, func(t *testing.T) {
+			deleted, err := s.DeleteByOwner(context.Background(), tt.owner)
+			if err != nil {
+				t.Fatalf("DeleteByOwner: %v", err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("deleted %d messages, want %d", deleted, tt.wantDeleted)
+			}
+
+			owned, err := s.GetByOwner(context.Background(), tt.owner)
+			if err != nil {
+				t.Fatalf("GetByOwner: %v", err)
+			}
+			if len(owned) != 0 {
+				t.Errorf("%d messages still owned by %s", len(owned), tt.owner)
+			}
+			all, err := s.GetAll(context.Background())
+			if err != nil {
+				t.Fatalf("GetAll: %v", err)
+			}
+			if len(all) != tt.wantLeft {
+				t.Errorf("%d messages left, want %d", len(all), tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestMessageStoreExists(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("hello", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[string]bool{message.ID: true, "no-such-id": false} {
+		exists, err := s.Exists(context.Background(), id)
+		if err != nil {
+			t.Fatalf("Exists(%q): %v", id, err)
+		}
+		if exists != want {
+			t.Errorf("Exists(%q) = %v, want %v", id, exists, want)
+		}
+	}
+}
+
+func TestMessageStoreGetByIDs(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	var ids []string
+	for _, text := range []string{"first", "second", "third"} {
+		message := model.NewMessage(text, "owner", "")
+		if err := s.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, message.ID)
+	}
+
+	messages, err := s.GetByIDs(context.Background(), []string{ids[2], "missing", ids[0], ids[2]})
+	if err != nil {
+		t.Fatalf("GetByIDs: %v", err)
+	}
+	got := make([]string, len(messages))
+	for i, message := range messages {
+		got[i] = message.Text
+	}
+	if want := []string{"third", "first"}; !slices.Equal(got, want) {
+		t.Errorf("GetByIDs = %v, want %v", got, want)
+	}
+}
+
+func TestMessageStoreSetPinned(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("hello", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := s.GetByID(context.Background(), message.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pinned := range []bool{true, true, false} {
+		if err := s.SetPinned(context.Background(), message.ID, pinned); err != nil {
+			t.Fatalf("SetPinned(%t): %v", pinned, err)
+		}
+		got, err := s.GetByID(context.Background(), message.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Pinned != pinned {
+			t.Errorf("after SetPinned(%t) pinned = %t", pinned, got.Pinned)
+		}
+	}
+
+	// Messages already handed out are copies, unaffected by later changes and not changing the store
+	if before.Pinned {
+		t.Error("message read before pinning was changed by SetPinned")
+	}
+	before.Pinned = true
+	if got, _ := s.GetByID(context.Background(), message.ID); got.Pinned {
+		t.Error("changing a returned message changed the store")
+	}
+
+	if err := s.SetPinned(context.Background(), "no-such-id", true); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("SetPinned of missing message returned %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestMessageStoreSetPinnedConcurrentReads(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("hello", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run with -race: readers must never see a message while it is being pinned
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if got, err := s.GetByID(context.Background(), message.ID); err == nil {
+					_ = got.Pinned
+				}
+				if all, err := s.GetAll(context.Background()); err == nil {
+					_ = all[0].Pinned
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		if err := s.SetPinned(context.Background(), message.ID, j%2 == 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestMessageStoreReactions(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("hello", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	const maxReactions = 2
+
+	steps := []struct {
+		name    string
+		add     bool
+		emoji   string
+		wantErr error
+		want    map[string]int
+	}{
+		{"first reaction", true, "👍", nil, map[string]int{"👍": 1}},
+		{"same emoji again", true, "👍", nil, map[string]int{"👍": 2}},
+		{"second emoji", true, "🎉", nil, map[string]int{"👍": 2, "🎉": 1}},
+		{"over the limit", true, "❤️", ErrReactionLimitReached, nil},
+		{"existing emoji at the limit", true, "🎉", nil, map[string]int{"👍": 2, "🎉": 2}},
+		{"remove one", false, "👍", nil, map[string]int{"👍": 1, "🎉": 2}},
+		{"remove the last", false, "👍", nil, map[string]int{"🎉": 2}},
+		{"remove a missing emoji", false, "👍", ErrReactionNotFound, nil},
+	}
+	for _, step := range steps {
+		var got *model.Message
+		var err error
+		if step.add {
+			got, err = s.AddReaction(context.Background(), message.ID, step.emoji, maxReactions)
+		} else {
+			got, err = s.RemoveReaction(context.Background(), message.ID, step.emoji)
+		}
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: got error %v, want %v", step.name, err, step.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if !maps.Equal(got.Reactions, step.want) {
+			t.Errorf("%s: reactions = %v, want %v", step.name, got.Reactions, step.want)
+		}
+
+		// The returned message is a copy
+		got.Reactions["🙃"] = 1
+		if stored, _ := s.GetByID(context.Background(), message.ID); !maps.Equal(stored.Reactions, step.want) {
+			t.Errorf("%s: changing the returned message changed the store to %v", step.name, stored.Reactions)
+		}
+	}
+
+	if _, err := s.AddReaction(context.Background(), "no-such-id", "👍", maxReactions); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("AddReaction of missing message returned %v, want ErrMessageNotFound", err)
+	}
+	if _, err := s.RemoveReaction(context.Background(), "no-such-id", "👍"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("RemoveReaction of missing message returned %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestMessageStoreReactionsConcurrentReads(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("hello", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run with -race: readers must never see a message while its reactions change
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if all, err := s.GetAll(context.Background()); err == nil {
+					_ = len(all[0].Reactions)
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		if _, err := s.AddReaction(context.Background(), message.ID, "👍", 10); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.RemoveReaction(context.Background(), message.ID, "👍"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestMessageStoreCountByOwner(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	for owner, count := range map[string]int{"alice": 2, "bob": 3, "carol": 1, "dave": 2} {
+		for i := 0; i < count; i++ {
+			if err := s.Add(context.Background(), model.NewMessage("hello", owner, "")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		limit int
+		want  []OwnerCount
+	}{
+		{10, []OwnerCount{{"bob", 3}, {"alice", 2}, {"dave", 2}, {"carol", 1}}},
+		{2, []OwnerCount{{"bob", 3}, {"alice", 2}}},
+		{1, []OwnerCount{{"bob", 3}}},
+	}
+	for _, tt := range tests {
+		owners, err := s.CountByOwner(context.Background(), tt.limit)
+		if err != nil {
+			t.Fatalf("CountByOwner(%d): %v", tt.limit, err)
+		}
+		if !slices.Equal(owners, tt.want) {
+			t.Errorf("CountByOwner(%d) = %v, want %v", tt.limit, owners, tt.want)
+		}
+	}
+}
+
+func TestMessageStoreUpdateHistory(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("v0", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	const maxHistory = 3
+	wantHistory := [][]string{
+		{"v0"},
+		{"v0", "v1"},
+		{"v0", "v1", "v2"},
+		{"v1", "v2", "v3"},
+		{"v2", "v3", "v4"},
+	}
+	for i, want := range wantHistory {
+		text := fmt.Sprintf("v%d", i+1)
+		updated, err := s.Update(context.Background(), message.ID, text, maxHistory)
+		if err != nil {
+			t.Fatalf("Update(%q): %v", text, err)
+		}
+		if updated.Text != text {
+			t.Errorf("text = %q, want %q", updated.Text, text)
+		}
+		got := make([]string, len(updated.EditHistory))
+		for j, edit := range updated.EditHistory {
+			got[j] = edit.Text
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("after edit %d history = %v, want %v", i+1, got, want)
+		}
+	}
+
+	if _, err := s.Update(context.Background(), "no-such-id", "text", maxHistory); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("Update of missing message returned %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestMessageStoreUpdateReturnsCopy(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("v0", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.GetByID(context.Background(), message.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := s.Update(context.Background(), message.ID, "v1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated.Text = "changed"
+	updated.EditHistory[0].Text = "changed"
+
+	stored, err := s.GetByID(context.Background(), message.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Text != "v1" || stored.EditHistory[0].Text != "v0" {
+		t.Errorf("changing the updated message changed the store to %q with history %v", stored.Text, stored.EditHistory)
+	}
+	if before.Text != "v0" || len(before.EditHistory) != 0 {
+		t.Errorf("message read before the update was changed to %q with history %v", before.Text, before.EditHistory)
+	}
+}
+
+func TestMessageStoreUpdateConcurrentReads(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("v0", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run with -race: readers must never see a message while its text changes
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if all, err := s.GetAll(context.Background()); err == nil {
+					_ = all[0].Text + all[0].TextLower
+					_ = len(all[0].EditHistory)
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		if _, err := s.Update(context.Background(), message.ID, fmt.Sprintf("v%d", j+1), 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestMessageStoreGetByPrefix(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	for _, text := range []string{"Hello world", "help wanted", "say hello", "HELLO again"} {
+		if err := s.Add(context.Background(), model.NewMessage(text, "owner", "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		limit  int32
+		want   []string
+	}{
+		{"hel", 10, []string{"HELLO again", "Hello world", "help wanted"}},
+		{"HELLO", 10, []string{"HELLO again", "Hello world"}},
+		{"hel", 2, []string{"HELLO again", "Hello world"}},
+		{"world", 10, []string{}},
+		{"goodbye", 10, []string{}},
+	}
+	for _, tt := range tests {
+		messages, err := s.GetByPrefix(context.Background(), tt.prefix, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(messages))
+		for i, message := range messages {
+			got[i] = message.Text
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("GetByPrefix(%q, %d) = %v, want %v", tt.prefix, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestMessageStoreGetPage(t *testing.T) {
+	s := NewMessageStore(SortDescending)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Two messages share a timestamp, so resuming must break the tie by ID
+	for i, text := range []string{"first", "second", "tied a", "tied b", "last"} {
+		message := model.NewMessage(text, "owner", "")
+		message.ID = fmt.Sprintf("id-%d", i)
+		message.Timestamp = httputil.Timestamp(base.Add(time.Duration(min(i, 2)) * time.Minute))
+		if i == 4 {
+			message.Timestamp = httputil.Timestamp(base.Add(10 * time.Minute))
+		}
+		if err := s.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	cursor := ""
+	for {
+		messages, next, err := s.GetPage(context.Background(), base.Add(5*time.Minute), cursor, 1)
+		if err != nil {
+			t.Fatalf("GetPage: %v", err)
+		}
+		for _, message := range messages {
+			got = append(got, message.Text)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := []string{"tied a", "tied b", "second", "first"}; !slices.Equal(got, want) {
+		t.Errorf("paged through %v, want %v", got, want)
+	}
+
+	// A scan cursor has no timestamp to resume after
+	scanCursor, err := encodeCursor(map[string]types.AttributeValue{"ID": &types.AttributeValueMemberS{Value: "id-1"}})
+	if err != nil {
+		t.Fatalf("encodeCursor: %v", err)
+	}
+	for _, bad := range []string{"not-a-cursor", scanCursor} {
+		if _, _, err := s.GetPage(context.Background(), base, bad, 2); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("GetPage(%q) returned %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+}
+
+func TestMessageStoreUpdateKeepsTextLower(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	message := model.NewMessage("Hello", "owner", "")
+	if err := s.Add(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := s.Update(context.Background(), message.ID, "Goodbye", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.TextLower != "goodbye" {
+		t.Errorf("TextLower = %q after update, want %q", updated.TextLower, "goodbye")
+	}
+	if messages, _ := s.GetByPrefix(context.Background(), "hel", 10); len(messages) != 0 {
+		t.Errorf("old prefix still matches %v", messages)
+	}
+	if messages, _ := s.GetByPrefix(context.Background(), "good", 10); len(messages) != 1 {
+		t.Errorf("new prefix matches %d messages, want 1", len(messages))
+	}
+}
+
+func TestMessageStoreGetReplies(t *testing.T) {
+	s := NewMessageStore(SortAscending)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	parent := model.NewMessage("parent", "owner", "")
+	other := model.NewMessage("other", "owner", "")
+	messages := []*model.Message{
+		parent,
+		other,
+		model.NewMessage("second reply", "owner", parent.ID),
+		model.NewMessage("reply to other", "owner", other.ID),
+		model.NewMessage("first reply", "owner", parent.ID),
+	}
+	for i, message := range messages {
+		message.Timestamp = httputil.Timestamp(base.Add(time.Duration(len(messages)-i) * time.Minute))
+		if err := s.Add(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replies, err := s.GetReplies(context.Background(), parent.ID)
+	if err != nil {
+		t.Fatalf("GetReplies: %v", err)
+	}
+	got := make([]string, len(replies))
+	for i, reply := range replies {
+		got[i] = reply.Text
+	}
+	if want := []string{"first reply", "second reply"}; !slices.Equal(got, want) {
+		t.Errorf("got replies %v, want %v", got, want)
+	}
+
+	replies, err = s.GetReplies(context.Background(), "no-such-message")
+	if err != nil || replies == nil || len(replies) != 0 {
+		t.Errorf("GetReplies for a message without replies = %v, %v, want an empty slice", replies, err)
+	}
+}
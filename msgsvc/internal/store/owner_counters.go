@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// OwnerCounters is an in-memory running count of messages per owner
+type OwnerCounters struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// NewOwnerCounters creates a new in-memory set of owner counters
+func NewOwnerCounters() *OwnerCounters {
+	return &OwnerCounters{counts: make(map[string]int64)}
+}
+
+// Add adds delta to the owner's counter, creating it if needed
+func (c *OwnerCounters) Add(ctx context.Context, owner string, delta int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[owner] += delta
+	if c.counts[owner] == 0 {
+		delete(c.counts, owner)
+	}
+	return nil
+}
+
+// GetAll returns every non-zero counter, keyed by owner
+func (c *OwnerCounters) GetAll(ctx context.Context) (map[string]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(c.counts))
+	for owner, count := range c.counts {
+		counts[owner] = count
+	}
+	return counts, nil
+}
+
+// Top returns the owners with the highest counters, highest first
+func (c *OwnerCounters) Top(ctx context.Context, limit int) ([]OwnerCount, error) {
+	counts, err := c.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return topOwners(counts, limit), nil
+}
+
+// Set sets the owner's counter to count if it still holds expected, where a missing counter
+// holds 0. It reports whether the counter was set, so that a correction computed from a stale
+// read does not overwrite a concurrent change.
+func (c *OwnerCounters) Set(ctx context.Context, owner string, expected, count int64) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[owner] != expected {
+		return false, nil
+	}
+	if count == 0 {
+		delete(c.counts, owner)
+	} else {
+		c.counts[owner] = count
+	}
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// countersTransport emulates an owner counters table, applying the UpdateItem expressions
+// DynamoDBOwnerCounters sends and answering Scan with every counter
+type countersTransport struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (f *countersTransport) Do(req *http.Request) (*http.Response, error) {
+	var body struct {
+		Key                       map[string]map[string]string
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues map[string]map[string]string
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	number := func(name string) int64 {
+		value, _ := strconv.ParseInt(body.ExpressionAttributeValues[name]["N"], 10, 64)
+		return value
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status, response := http.StatusOK, `{}`
+	switch req.Header.Get("X-Amz-Target") {
+	case "DynamoDB_20120810.Scan":
+		items := make([]string, 0, len(f.counts))
+		for owner, count := range f.counts {
+			items = append(items, fmt.Sprintf(`{"Owner":{"S":%q},"MessageCount":{"N":"%d"}}`, owner, count))
+		}
+		response = `{"Items":[` + strings.Join(items, ",") + `]}`
+	case "DynamoDB_20120810.UpdateItem":
+		owner := body.Key["Owner"]["S"]
+		current, exists := f.counts[owner]
+		switch {
+		case strings.HasPrefix(body.UpdateExpression, "ADD MessageCount"):
+			f.counts[owner] = current + number(":delta")
+		case exists && current == number(":expected"),
+			!exists && strings.Contains(body.ConditionExpression, "attribute_not_exists(MessageCount)"):
+			f.counts[owner] = number(":count")
+		default:
+			status, response = http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`
+		}
+	default:
+		status, response = http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ValidationException","message":"unexpected operation"}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func TestOwnerCounters(t *testing.T) {
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:       &countersTransport{counts: make(map[string]int64)},
+		RetryMaxAttempts: 1,
+	})
+	stores := map[string]interface {
+		Add(ctx context.Context, owner string, delta int64) error
+		Top(ctx context.Context, limit int) ([]OwnerCount, error)
+		Set(ctx context.Context, owner string, expected, count int64) (bool, error)
+	}{
+		"memory":   NewOwnerCounters(),
+		"dynamodb": &DynamoDBOwnerCounters{client: client, tableName: "counters"},
+	}
+
+	for name, counters := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, owner := range []string{"alice", "alice", "alice", "bob"} {
+				if err := counters.Add(ctx, owner, 1); err != nil {
+					t.Fatalf("Add: %v", err)
+				}
+			}
+			if err := counters.Add(ctx, "alice", -1); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+
+			// A correction applies only while the counter holds the expected value, and a
+			// missing counter holds 0
+			sets := []struct {
+				owner           string
+				expected, count int64
+				want            bool
+			}{
+				{"bob", 5, 3, false},
+				{"bob", 1, 3, true},
+				{"carol", 1, 4, false},
+				{"carol", 0, 4, true},
+				{"alice", 2, 0, true},
+			}
+			for _, tt := range sets {
+				set, err := counters.Set(ctx, tt.owner, tt.expected, tt.count)
+				if err != nil {
+					t.Fatalf("Set(%s, %d, %d): %v", tt.owner, tt.expected, tt.count, err)
+				}
+				if set != tt.want {
+					t.Errorf("Set(%s, %d, %d) = %v, want %v", tt.owner, tt.expected, tt.count, set, tt.want)
+				}
+			}
+
+			// Zero counters are left out
+			top, err := counters.Top(ctx, 10)
+			if err != nil {
+				t.Fatalf("Top: %v", err)
+			}
+			want := []OwnerCount{{Owner: "carol", Count: 4}, {Owner: "bob", Count: 3}}
+			if fmt.Sprint(top) != fmt.Sprint(want) {
+				t.Errorf("Top = %v, want %v", top, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// CognitoClaims holds the claims of a Cognito token in typed form
+type CognitoClaims struct {
+	Sub      string   `json:"sub"`
+	Email    string   `json:"email,omitempty"`
+	Username string   `json:"username,omitempty"`
+	TokenUse string   `json:"token_use"`
+	Groups   []string `json:"cognito:groups,omitempty"`
+	Exp      int64    `json:"exp"`
+	Iat      int64    `json:"iat,omitempty"`
+	Iss      string   `json:"iss,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+}
+
+// ParseClaims converts raw JWT claims into CognitoClaims. The sub, token_use and exp claims
+// are required; the others are optional but must have the right type when present.
+func ParseClaims(claims jwt.MapClaims) (CognitoClaims, error) {
+	var parsed CognitoClaims
+	var err error
+
+	if parsed.Sub, err = requiredStringClaim(claims, "sub"); err != nil {
+		return CognitoClaims{}, err
+	}
+	if parsed.TokenUse, err = requiredStringClaim(claims, "token_use"); err != nil {
+		return CognitoClaims{}, err
+	}
+	if parsed.Email, err = optionalStringClaim(claims, "email"); err != nil {
+		return CognitoClaims{}, err
+	}
+	if parsed.Username, err = optionalStringClaim(claims, "username"); err != nil {
+		return CognitoClaims{}, err
+	}
+	if parsed.Iss, err = optionalStringClaim(claims, "iss"); err != nil {
+		return CognitoClaims{}, err
+	}
+	if parsed.ClientID, err = optionalStringClaim(claims, "client_id"); err != nil {
+		return CognitoClaims{}, err
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return CognitoClaims{}, fmt.Errorf("claim 'exp' is missing or not a number")
+	}
+	parsed.Exp = int64(exp)
+
+	if rawIat, exists := claims["iat"]; exists {
+		iat, ok := rawIat.(float64)
+		if !ok {
+			return CognitoClaims{}, fmt.Errorf("claim 'iat' is not a number")
+		}
+		parsed.Iat = int64(iat)
+	}
+
+	if _, exists := claims["cognito:groups"]; exists {
+		groups, ok := GetUserGroupsFromClaims(claims)
+		if !ok {
+			return CognitoClaims{}, fmt.Errorf("claim 'cognito:groups' is not a list")
+		}
+		parsed.Groups = groups
+	}
+
+	return parsed, nil
+}
+
+// requiredStringClaim returns a string claim, or an error if it is missing or not a non-empty string
+func requiredStringClaim(claims jwt.MapClaims, name string) (string, error) {
+	value, ok := claims[name].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("claim '%s' is missing or not a string", name)
+	}
+	return value, nil
+}
+
+// optionalStringClaim returns a string claim, or "" if it is missing, or an error if it is not a string
+func optionalStringClaim(claims jwt.MapClaims, name string) (string, error) {
+	rawValue, exists := claims[name]
+	if !exists {
+		return "", nil
+	}
+	value, ok := rawValue.(string)
+	if !ok {
+		return "", fmt.Errorf("claim '%s' is not a string", name)
+	}
+	return value, nil
+}
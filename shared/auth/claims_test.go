@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestParseClaims(t *testing.T) {
+	// full returns a complete set of access token claims with the given changes applied
+	full := func(changes map[string]interface{}) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"sub":            "user-123",
+			"email":          "alice@example.com",
+			"username":       "alice",
+			"token_use":      "access",
+			"cognito:groups": []interface{}{"admin", "editors"},
+			"exp":            float64(1700003600),
+			"iat":            float64(1700000000),
+			"iss":            "https://cognito-idp.us-east-1.amazonaws.com/pool",
+			"client_id":      "client-abc",
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		want    CognitoClaims
+		wantErr string
+	}{
+		{"all claims", full(nil), CognitoClaims{
+			Sub:      "user-123",
+			Email:    "alice@example.com",
+			Username: "alice",
+			TokenUse: "access",
+			Groups:   []string{"admin", "editors"},
+			Exp:      1700003600,
+			Iat:      1700000000,
+			Iss:      "https://cognito-idp.us-east-1.amazonaws.com/pool",
+			ClientID: "client-abc",
+		}, ""},
+		{"only required claims", jwt.MapClaims{"sub": "user-123", "token_use": "id", "exp": float64(1700003600)},
+			CognitoClaims{Sub: "user-123", TokenUse: "id", Exp: 1700003600}, ""},
+		{"missing sub", full(map[string]interface{}{"sub": nil}), CognitoClaims{}, "'sub'"},
+		{"empty sub", full(map[string]interface{}{"sub": ""}), CognitoClaims{}, "'sub'"},
+		{"missing token_use", full(map[string]interface{}{"token_use": nil}), CognitoClaims{}, "'token_use'"},
+		{"missing exp", full(map[string]interface{}{"exp": nil}), CognitoClaims{}, "'exp'"},
+		{"exp not a number", full(map[string]interface{}{"exp": "tomorrow"}), CognitoClaims{}, "'exp'"},
+		{"iat not a number", full(map[string]interface{}{"iat": "yesterday"}), CognitoClaims{}, "'iat'"},
+		{"email not a string", full(map[string]interface{}{"email": 42.0}), CognitoClaims{}, "'email'"},
+		{"client_id not a string", full(map[string]interface{}{"client_id": true}), CognitoClaims{}, "'client_id'"},
+		{"groups not a list", full(map[string]interface{}{"cognito:groups": "admin"}), CognitoClaims{}, "'cognito:groups'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseClaims(tt.claims)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want one naming %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseClaims: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenTyped(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newJWKSServer(t, "test-key", &key.PublicKey)
+	validator := NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL, HTTPClient: server.Client()})
+	token := signAccessToken(t, "test-key", key)
+
+	claims, err := validator.ValidateTokenTyped(token)
+	if err != nil {
+		t.Fatalf("ValidateTokenTyped: %v", err)
+	}
+	if claims.Sub != "user-123" || claims.TokenUse != "access" || claims.Exp == 0 {
+		t.Errorf("got claims %+v", claims)
+	}
+	if _, err := validator.ValidateTokenTyped(token + "x"); err == nil {
+		t.Error("ValidateTokenTyped accepted a token with a bad signature")
+	}
+
+	// The middleware stores the typed claims next to the raw ones
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/me", JWTAuthMiddleware(validator), func(c *gin.Context) {
+		typed, ok := GetCognitoClaimsFromContext(c)
+		raw, rawOK := GetJWTClaimsFromContext(c)
+		if !ok || !rawOK || typed.Sub != raw["sub"] {
+			t.Errorf("context has typed claims %+v (%t) and raw claims %v (%t)", typed, ok, raw, rawOK)
+		}
+		c.Status(http.StatusOK)
+	})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,24 @@
+package auth
+
+import "strings"
+
+// DisplayName returns the name to show for a user. It is "First Last" built from whichever of
+// the names are set. If neither is set and fallback is true, it falls back to the local part of
+// the email address and then to the sub, so users created directly in Cognito without name
+// attributes never render as blank.
+func DisplayName(firstName, lastName, email, sub string, fallback bool) string {
+	var names []string
+	for _, name := range []string{firstName, lastName} {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 || !fallback {
+		return strings.Join(names, " ")
+	}
+
+	if localPart, _, _ := strings.Cut(strings.TrimSpace(email), "@"); localPart != "" {
+		return localPart
+	}
+	return sub
+}
@@ -0,0 +1,28 @@
+package auth
+
+import "testing"
+
+func TestDisplayName(t *testing.T) {
+	tests := []struct {
+		name                            string
+		firstName, lastName, email, sub string
+		fallback                        bool
+		want                            string
+	}{
+		{"full name", "Alice", "Smith", "alice@example.com", "sub-1", true, "Alice Smith"},
+		{"first name only", "Alice", "", "alice@example.com", "sub-1", true, "Alice"},
+		{"email only", "", "", "alice.smith@example.com", "sub-1", true, "alice.smith"},
+		{"sub only", "", "", "", "sub-1", true, "sub-1"},
+		{"blank names", " ", "", "", "sub-1", true, "sub-1"},
+		{"fallback disabled", "", "", "alice@example.com", "sub-1", false, ""},
+		{"fallback disabled with names", "Alice", "Smith", "", "sub-1", false, "Alice Smith"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DisplayName(tt.firstName, tt.lastName, tt.email, tt.sub, tt.fallback)
+			if got != tt.want {
+				t.Errorf("DisplayName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,18 @@
 package auth
 
 import (
+	"context"
 	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"math/big"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -30,34 +36,117 @@ type JWKSet struct {
 type JWTValidatorConfig struct {
 	JWKSURL string
 	Issuer  string
+
+	// SkipIssuerCheck disables the issuer comparison for local testing.
+	// It is only honored when Environment is "dev".
+	SkipIssuerCheck bool
+	Environment     string
+
+	// HTTPClient is used to fetch the JWKS. When nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// CacheTTL is how long fetched keys are used before the JWKS is fetched again.
+	// Zero keeps keys until a token names a key ID that is not cached.
+	CacheTTL time.Duration
+
+	// StaleOK serves expired cached keys while the JWKS is refreshed in the background,
+	// so that a JWKS endpoint outage does not fail tokens signed with known keys
+	StaleOK bool
+
+	// FetchTimeout bounds each JWKS fetch, so that a slow JWKS endpoint fails a token with an
+	// unknown key ID promptly rather than holding the request. Zero uses DefaultJWKSFetchTimeout.
+	FetchTimeout time.Duration
 }
 
+// DefaultJWKSFetchTimeout is the JWKS fetch timeout used when none is configured
+const DefaultJWKSFetchTimeout = 5 * time.Second
+
 // JWTValidator handles JWT token validation
 type JWTValidator struct {
-	jwksURL string
-	issuer  string
-	keys    map[string]*rsa.PublicKey
+	jwksURL         string
+	issuer          string
+	skipIssuerCheck bool
+	httpClient      *http.Client
+	cacheTTL        time.Duration
+	staleOK         bool
+	fetchTimeout    time.Duration
+
+	mutex      sync.Mutex
+	keys       map[string]*rsa.PublicKey
+	fetchedAt  time.Time
+	refreshing bool
 }
 
 // NewJWTValidator creates a new JWT validator with the provided configuration
 func NewJWTValidator(config JWTValidatorConfig) *JWTValidator {
+	skipIssuerCheck := false
+	if config.SkipIssuerCheck {
+		if config.Environment == "dev" {
+			log.Printf("WARNING: ************************************************************")
+			log.Printf("WARNING: JWT issuer check is DISABLED (JWT_SKIP_ISSUER_CHECK=true)")
+			log.Printf("WARNING: Tokens from any issuer with a valid signature are accepted")
+			log.Printf("WARNING: ************************************************************")
+			skipIssuerCheck = true
+		} else {
+			log.Printf("WARNING: Ignoring JWT_SKIP_ISSUER_CHECK in environment '%s' (only honored in dev)", config.Environment)
+		}
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	fetchTimeout := config.FetchTimeout
+	if fetchTimeout <= 0 {
+		fetchTimeout = DefaultJWKSFetchTimeout
+	}
+
 	return &JWTValidator{
-		jwksURL: config.JWKSURL,
-		issuer:  config.Issuer,
-		keys:    make(map[string]*rsa.PublicKey),
+		jwksURL:         config.JWKSURL,
+		issuer:          config.Issuer,
+		skipIssuerCheck: skipIssuerCheck,
+		httpClient:      httpClient,
+		cacheTTL:        config.CacheTTL,
+		staleOK:         config.StaleOK,
+		fetchTimeout:    fetchTimeout,
+		keys:            make(map[string]*rsa.PublicKey),
 	}
 }
 
-// NewCognitoJWTValidator creates a new JWT validator configured for AWS Cognito
-func NewCognitoJWTValidator(region, userPoolID string) *JWTValidator {
-	jwksURL := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s/.well-known/jwks.json", region, userPoolID)
-	issuer := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, userPoolID)
+// NewHTTPClientWithCABundle returns an HTTP client that trusts the certificates in the PEM
+// file at caBundlePath in addition to the system trust store. Proxy settings are taken from
+// the environment, as with the default client.
+func NewHTTPClientWithCABundle(caBundlePath string) (*http.Client, error) {
+	pem, err := os.ReadFile(caBundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
 
-	return &JWTValidator{
-		jwksURL: jwksURL,
-		issuer:  issuer,
-		keys:    make(map[string]*rsa.PublicKey),
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil || rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", caBundlePath)
 	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	return &http.Client{Transport: transport}, nil
+}
+
+// CognitoJWTValidatorConfig returns a JWT validator configuration for an AWS Cognito user pool
+func CognitoJWTValidatorConfig(region, userPoolID string) JWTValidatorConfig {
+	return JWTValidatorConfig{
+		JWKSURL: fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s/.well-known/jwks.json", region, userPoolID),
+		Issuer:  fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, userPoolID),
+	}
+}
+
+// NewCognitoJWTValidator creates a new JWT validator configured for AWS Cognito
+func NewCognitoJWTValidator(region, userPoolID string) *JWTValidator {
+	return NewJWTValidator(CognitoJWTValidatorConfig(region, userPoolID))
 }
 
 // ValidateToken validates a JWT token and returns the claims
@@ -110,7 +199,7 @@ func (v *JWTValidator) ValidateToken(tokenString string) (jwt.MapClaims, error)
 	}
 
 	// Validate issuer if provided
-	if v.issuer != "" {
+	if v.issuer != "" && !v.skipIssuerCheck {
 		iss, ok := claims["iss"].(string)
 		if !ok || iss != v.issuer {
 			return nil, fmt.Errorf("invalid issuer: expected '%s', got '%s'", v.issuer, iss)
@@ -123,53 +212,173 @@ func (v *JWTValidator) ValidateToken(tokenString string) (jwt.MapClaims, error)
 		return nil, fmt.Errorf("token missing exp claim")
 	}
 	if time.Now().Unix() > int64(exp) {
-		return nil, fmt.Errorf("token has expired")
+		return nil, jwt.ErrTokenExpired
 	}
 
 	return claims, nil
 }
 
+// ValidateTokenTyped validates a JWT token and returns its claims in typed form
+func (v *JWTValidator) ValidateTokenTyped(tokenString string) (*CognitoClaims, error) {
+	claims, err := v.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := ParseClaims(claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	return &parsed, nil
+}
+
 // getPublicKey retrieves the public key for the given kid
 func (v *JWTValidator) getPublicKey(kid string) (*rsa.PublicKey, error) {
 	// Check if we already have this key cached
-	if key, exists := v.keys[kid]; exists {
+	v.mutex.Lock()
+	key, exists := v.keys[kid]
+	expired := v.cacheTTL > 0 && time.Since(v.fetchedAt) > v.cacheTTL
+	if exists && expired && v.staleOK {
+		// Serve the stale key now and refresh the cache without holding up the request
+		if !v.refreshing {
+			v.refreshing = true
+			go v.refreshInBackground()
+		}
+	}
+	v.mutex.Unlock()
+	if exists && (!expired || v.staleOK) {
 		return key, nil
 	}
 
-	// Fetch the JWKS
-	jwks, err := v.fetchJWKS()
+	// Fetch the JWKS. The fetch is bounded by its own timeout rather than the request's deadline,
+	// so a slow JWKS endpoint cannot use up the time the request has left.
+	kidErrs, err := v.refreshKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	// Only fail when the requested key specifically could not be produced
+	v.mutex.Lock()
+	key, found := v.keys[kid]
+	v.mutex.Unlock()
+	if !found {
+		if kidErr := kidErrs[kid]; kidErr != nil {
+			return nil, fmt.Errorf("failed to convert JWK to RSA public key: %w", kidErr)
+		}
+		return nil, fmt.Errorf("key with kid '%s' not found", kid)
+	}
+
+	return key, nil
+}
+
+// refreshKeys fetches the JWKS, within the fetch timeout and until ctx is done, and replaces the
+// cached keys with every usable key in the set. A malformed key is skipped so that it cannot
+// break validation of tokens signed by the other keys; the conversion errors are returned by kid.
+func (v *JWTValidator) refreshKeys(ctx context.Context) (map[string]error, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.fetchTimeout)
+	defer cancel()
+
+	jwks, err := v.fetchJWKS(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
 	}
 
-	// Find the key with the matching kid
-	var jwk *JWK
-	for _, key := range jwks.Keys {
-		if key.Kid == kid {
-			jwk = &key
-			break
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	kidErrs := make(map[string]error)
+	for i := range jwks.Keys {
+		jwk := &jwks.Keys[i]
+		publicKey, err := v.jwkToRSAPublicKey(jwk)
+		if err != nil {
+			log.Printf("WARNING: Skipping JWK with kid '%s': %v", jwk.Kid, err)
+			kidErrs[jwk.Kid] = err
+			continue
 		}
+		keys[jwk.Kid] = publicKey
 	}
 
-	if jwk == nil {
-		return nil, fmt.Errorf("key with kid '%s' not found", kid)
+	v.mutex.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mutex.Unlock()
+	return kidErrs, nil
+}
+
+// refreshInBackground refreshes the cached keys, keeping the stale keys if the fetch fails
+func (v *JWTValidator) refreshInBackground() {
+	if _, err := v.refreshKeys(context.Background()); err != nil {
+		log.Printf("WARNING: JWKS refresh failed, continuing to serve cached keys: %v", err)
 	}
 
-	// Convert JWK to RSA public key
-	publicKey, err := v.jwkToRSAPublicKey(jwk)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert JWK to RSA public key: %w", err)
+	v.mutex.Lock()
+	v.refreshing = false
+	v.mutex.Unlock()
+}
+
+// Backoff between JWKS fetch attempts in Prime
+var (
+	primeInitialBackoff = 250 * time.Millisecond
+	primeMaxBackoff     = 5 * time.Second
+)
+
+// Prime fetches the JWKS ahead of the first request. With a positive timeout, failed fetches are
+// retried with exponential backoff until one succeeds or the timeout passes, which rides out a
+// JWKS endpoint that returns 404 for a short while after its user pool is created.
+func (v *JWTValidator) Prime(ctx context.Context, timeout time.Duration) error {
+	if timeout <= 0 {
+		_, err := v.refreshKeys(ctx)
+		return err
 	}
 
-	// Cache the key
-	v.keys[kid] = publicKey
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	return publicKey, nil
+	backoff := primeInitialBackoff
+	for attempt := 1; ; attempt++ {
+		_, err := v.refreshKeys(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Printf("WARNING: JWKS not available yet (attempt %d), retrying in %s: %v", attempt, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("JWKS not available after %s: %w", timeout, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, primeMaxBackoff)
+	}
 }
 
-// fetchJWKS fetches the JSON Web Key Set from the JWKS URL
-func (v *JWTValidator) fetchJWKS() (*JWKSet, error) {
-	resp, err := http.Get(v.jwksURL)
+// StartBackgroundRefresh re-fetches the JWKS every interval until ctx is cancelled, so that
+// rotated keys are picked up before a token signed with them arrives. A failed fetch keeps the
+// cached keys. It returns immediately; a non-positive interval starts nothing.
+func (v *JWTValidator) StartBackgroundRefresh(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := v.refreshKeys(ctx); err != nil {
+					log.Printf("WARNING: Scheduled JWKS refresh failed, continuing to serve cached keys: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// fetchJWKS fetches the JSON Web Key Set from the JWKS URL, giving up when ctx is done
+func (v *JWTValidator) fetchJWKS(ctx context.Context) (*JWKSet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	resp, err := v.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
 	}
@@ -204,6 +413,9 @@ func (v *JWTValidator) jwkToRSAPublicKey(jwk *JWK) (*rsa.PublicKey, error) {
 	// Convert bytes to big integers
 	n := new(big.Int).SetBytes(nBytes)
 	e := new(big.Int).SetBytes(eBytes)
+	if n.Sign() == 0 || e.Sign() == 0 || !e.IsInt64() {
+		return nil, fmt.Errorf("invalid modulus or exponent")
+	}
 
 	// Create the RSA public key
 	publicKey := &rsa.PublicKey{
@@ -226,6 +438,22 @@ func GetUsernameFromClaims(claims jwt.MapClaims) (string, bool) {
 	return username, ok
 }
 
+// GetUserGroupsFromClaims extracts the Cognito group memberships from JWT claims
+func GetUserGroupsFromClaims(claims jwt.MapClaims) ([]string, bool) {
+	rawGroups, ok := claims["cognito:groups"].([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	groups := make([]string, 0, len(rawGroups))
+	for _, rawGroup := range rawGroups {
+		if group, ok := rawGroup.(string); ok {
+			groups = append(groups, group)
+		}
+	}
+	return groups, true
+}
+
 // GetUserSubFromClaims extracts the user subject (unique ID) from JWT claims
 func GetUserSubFromClaims(claims jwt.MapClaims) (string, bool) {
 	sub, ok := claims["sub"].(string)
@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newJWKSServer serves a JWKS containing key under kid over TLS with a self-signed certificate
+func newJWKSServer(t *testing.T, kid string, key *rsa.PublicKey) *httptest.Server {
+	t.Helper()
+	return newFailableJWKSServer(t, kid, key, new(atomic.Bool))
+}
+
+// newFailableJWKSServer is like newJWKSServer, but responds with 503 while failing is set
+func newFailableJWKSServer(t *testing.T, kid string, key *rsa.PublicKey, failing *atomic.Bool) *httptest.Server {
+	t.Helper()
+	jwks := JWKSet{Keys: []JWK{jwkFor(kid, key)}}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// jwkFor returns the JWK of key under kid
+func jwkFor(kid string, key *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// signAccessToken returns an access token signed with key under kid
+func signAccessToken(t *testing.T, kid string, key *rsa.PrivateKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":       "user-123",
+		"token_use": "access",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestValidateTokenWithCustomHTTPClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newJWKSServer(t, "test-key", &key.PublicKey)
+	token := signAccessToken(t, "test-key", key)
+
+	// The default client does not trust the test server's self-signed certificate
+	validator := NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL})
+	if _, err := validator.ValidateToken(token); err == nil {
+		t.Fatal("expected validation with the default client to fail")
+	}
+
+	validator = NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL, HTTPClient: server.Client()})
+	claims, err := validator.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if sub, _ := GetUserSubFromClaims(claims); sub != "user-123" {
+		t.Errorf("got sub %q, want %q", sub, "user-123")
+	}
+}
+
+func TestNewHTTPClientWithCABundle(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newJWKSServer(t, "test-key", &key.PublicKey)
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caBundle, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient, err := NewHTTPClientWithCABundle(caBundle)
+	if err != nil {
+		t.Fatalf("NewHTTPClientWithCABundle: %v", err)
+	}
+	validator := NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL, HTTPClient: httpClient})
+	if _, err := validator.ValidateToken(signAccessToken(t, "test-key", key)); err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHTTPClientWithCABundle(empty); err == nil {
+		t.Error("expected an error for a bundle with no certificates")
+	}
+}
+
+func TestValidateTokenWithStaleKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := new(atomic.Bool)
+	server := newFailableJWKSServer(t, "test-key", &key.PublicKey, failing)
+	token := signAccessToken(t, "test-key", key)
+
+	for _, staleOK := range []bool{true, false} {
+		validator := NewJWTValidator(JWTValidatorConfig{
+			JWKSURL:    server.URL,
+			HTTPClient: server.Client(),
+			CacheTTL:   time.Minute,
+			StaleOK:    staleOK,
+		})
+		failing.Store(false)
+		if _, err := validator.ValidateToken(token); err != nil {
+			t.Fatalf("ValidateToken with a reachable JWKS: %v", err)
+		}
+
+		// Expire the cache and make the refetch fail
+		validator.mutex.Lock()
+		validator.fetchedAt = time.Now().Add(-time.Hour)
+		validator.mutex.Unlock()
+		failing.Store(true)
+
+		_, err := validator.ValidateToken(token)
+		if staleOK && err != nil {
+			t.Errorf("StaleOK: ValidateToken with an expired cache: %v", err)
+		}
+		if !staleOK && err == nil {
+			t.Error("expected validation to fail with an expired cache when StaleOK is off")
+		}
+	}
+}
+
+func TestSkipIssuerCheckOnlyInDev(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newJWKSServer(t, "test-key", &key.PublicKey)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":       "user-123",
+		"token_use": "access",
+		"iss":       "https://issuer.localhost",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		environment string
+		skip        bool
+		wantErr     bool
+	}{
+		{"dev", true, false},
+		{"dev", false, true},
+		{"prod", true, true},
+		// An unset environment must not count as dev
+		{"", true, true},
+	}
+	for _, tt := range tests {
+		validator := NewJWTValidator(JWTValidatorConfig{
+			JWKSURL:         server.URL,
+			HTTPClient:      server.Client(),
+			Issuer:          "https://cognito-idp.us-east-1.amazonaws.com/pool",
+			SkipIssuerCheck: tt.skip,
+			Environment:     tt.environment,
+		})
+		_, err := validator.ValidateToken(signed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("environment %q, skip %v: got %v, want error %v", tt.environment, tt.skip, err, tt.wantErr)
+		}
+	}
+}
+
+func TestJWKSRotationAndFetchFailure(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := JWK{Kty: "RSA", Kid: "bad-key", Use: "sig", N: "not base64!", E: "AQAB"}
+
+	var jwks atomic.Pointer[JWKSet]
+	jwks.Store(&JWKSet{Keys: []JWK{malformed, jwkFor("old-key", &oldKey.PublicKey)}})
+	failing := new(atomic.Bool)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jwks.Load())
+	}))
+	t.Cleanup(server.Close)
+
+	validator := NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL, CacheTTL: time.Hour})
+	validate := func(kid string, key *rsa.PrivateKey) error {
+		_, err := validator.ValidateToken(signAccessToken(t, kid, key))
+		return err
+	}
+
+	// A malformed key in the set does not stop the others from being used
+	if err := validate("old-key", oldKey); err != nil {
+		t.Fatalf("ValidateToken beside a malformed key: %v", err)
+	}
+	if err := validate("bad-key", oldKey); err == nil || !strings.Contains(err.Error(), "failed to convert JWK") {
+		t.Errorf("ValidateToken with the malformed key: got %v, want a conversion error", err)
+	}
+
+	// After a rotation, a token signed with the new key fetches the set again, and the retired
+	// key is dropped
+	jwks.Store(&JWKSet{Keys: []JWK{jwkFor("new-key", &newKey.PublicKey)}})
+	if err := validate("new-key", newKey); err != nil {
+		t.Fatalf("ValidateToken after rotation: %v", err)
+	}
+	if err := validate("old-key", oldKey); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("ValidateToken with the retired key: got %v, want key not found", err)
+	}
+
+	// While the JWKS cannot be fetched, unknown keys fail but cached keys keep working
+	failing.Store(true)
+	before := fetches.Load()
+	if err := validate("other-key", oldKey); err == nil || !strings.Contains(err.Error(), "failed to fetch JWKS") {
+		t.Errorf("ValidateToken while the JWKS is down: got %v, want a fetch error", err)
+	}
+	if fetches.Load() == before {
+		t.Error("an unknown key did not trigger a fetch")
+	}
+	if err := validate("new-key", newKey); err != nil {
+		t.Errorf("ValidateToken with a cached key while the JWKS is down: %v", err)
+	}
+}
+
+func TestStartBackgroundRefresh(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jwks atomic.Pointer[JWKSet]
+	jwks.Store(&JWKSet{Keys: []JWK{jwkFor("old-key", &oldKey.PublicKey)}})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jwks.Load())
+	}))
+	t.Cleanup(server.Close)
+
+	validator := NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL})
+	if _, err := validator.ValidateToken(signAccessToken(t, "old-key", oldKey)); err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	validator.StartBackgroundRefresh(ctx, 10*time.Millisecond)
+
+	// Rotate the keys; the ticker should replace the cache without a token asking for them
+	jwks.Store(&JWKSet{Keys: []JWK{jwkFor("new-key", &newKey.PublicKey)}})
+	cached := func(kid string) bool {
+		validator.mutex.Lock()
+		defer validator.mutex.Unlock()
+		_, ok := validator.keys[kid]
+		return ok
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !cached("new-key") || cached("old-key") {
+		if time.Now().After(deadline) {
+			t.Fatal("background refresh did not pick up the rotated keys")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Once cancelled, the refresher stops swapping the cache
+	cancel()
+	time.Sleep(30 * time.Millisecond)
+	jwks.Store(&JWKSet{Keys: []JWK{jwkFor("old-key", &oldKey.PublicKey)}})
+	time.Sleep(50 * time.Millisecond)
+	if cached("old-key") {
+		t.Error("background refresh kept running after the context was cancelled")
+	}
+}
+
+func TestPrimeWaitsForJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := JWKSet{Keys: []JWK{{
+		Kty: "RSA",
+		Kid: "test-key",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+
+	// The endpoint 404s for the first few requests, as it does just after a pool is created
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 3 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(server.Close)
+
+	defer func(initial time.Duration) { primeInitialBackoff = initial }(primeInitialBackoff)
+	primeInitialBackoff = time.Millisecond
+
+	validator := NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL})
+	if err := validator.Prime(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("Prime: %v", err)
+	}
+	if got := requests.Load(); got != 4 {
+		t.Errorf("got %d JWKS requests, want 4", got)
+	}
+
+	// The keys are cached, so validation needs no further fetch
+	if _, err := validator.ValidateToken(signAccessToken(t, "test-key", key)); err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if got := requests.Load(); got != 4 {
+		t.Errorf("got %d JWKS requests after validating, want 4", got)
+	}
+
+	// A JWKS that never appears fails once the timeout passes
+	requests.Store(-1000)
+	validator = NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL})
+	if err := validator.Prime(context.Background(), 50*time.Millisecond); err == nil {
+		t.Error("expected Prime to fail when the JWKS is unavailable for the whole timeout")
+	}
+}
+
+func TestJWKSFetchTimeout(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The endpoint takes far longer to answer than the fetch timeout allows
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	validator := NewJWTValidator(JWTValidatorConfig{JWKSURL: server.URL, FetchTimeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err = validator.ValidateToken(signAccessToken(t, "unknown-key", key))
+	if err == nil {
+		t.Fatal("expected validation to fail while the JWKS cannot be fetched")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("validation took %s, want it to give up after the fetch timeout", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want it to wrap context.DeadlineExceeded", err)
+	}
+}
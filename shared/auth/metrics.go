@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes recorded for JWT validation in JWTAuthMiddleware
+const (
+	OutcomeSuccess       = "success"
+	OutcomeMissingToken  = "missing_token"
+	OutcomeInvalidHeader = "invalid_header"
+	OutcomeInvalidToken  = "invalid_token"
+	OutcomeExpired       = "expired"
+)
+
+// jwtValidationsTotal counts JWT validations performed by JWTAuthMiddleware, by outcome
+var jwtValidationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_jwt_validations_total",
+	Help: "JWT validations performed by the auth middleware, by outcome.",
+}, []string{"outcome"})
+
+// validationOutcome classifies an error returned by ValidateToken
+func validationOutcome(err error) string {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return OutcomeExpired
+	}
+	return OutcomeInvalidToken
+}
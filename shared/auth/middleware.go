@@ -2,25 +2,38 @@ package auth
 
 import (
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/aws_e2e_test/shared/httputil"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 )
 
-// JWTAuthMiddleware creates a middleware that validates JWT tokens
-func JWTAuthMiddleware(jwtValidator *JWTValidator) gin.HandlerFunc {
+// AdminGroup is the Cognito group whose members are treated as administrators
+const AdminGroup = "admin"
+
+// PostValidationHook runs after a token has been validated and the user information stored in
+// the context, e.g. to check that the account is still enabled. A hook that rejects the request
+// writes the response and aborts the context.
+type PostValidationHook func(ctx *gin.Context)
+
+// JWTAuthMiddleware creates a middleware that validates JWT tokens, then runs hooks in order
+func JWTAuthMiddleware(jwtValidator *JWTValidator, hooks ...PostValidationHook) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Get the Authorization header
 		authHeader := ctx.GetHeader("Authorization")
 		if authHeader == "" {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+			jwtValidationsTotal.WithLabelValues(OutcomeMissingToken).Inc()
+			httputil.RespondError(ctx, http.StatusUnauthorized, "MISSING_TOKEN", "Authorization header is required")
 			ctx.Abort()
 			return
 		}
 
 		// Check if the header starts with "Bearer "
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header must start with 'Bearer '"})
+			jwtValidationsTotal.WithLabelValues(OutcomeInvalidHeader).Inc()
+			httputil.RespondError(ctx, http.StatusUnauthorized, "INVALID_AUTH_HEADER", "Authorization header must start with 'Bearer '")
 			ctx.Abort()
 			return
 		}
@@ -28,7 +41,8 @@ func JWTAuthMiddleware(jwtValidator *JWTValidator) gin.HandlerFunc {
 		// Extract the token
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 		if token == "" {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Token is required"})
+			jwtValidationsTotal.WithLabelValues(OutcomeMissingToken).Inc()
+			httputil.RespondError(ctx, http.StatusUnauthorized, "MISSING_TOKEN", "Token is required")
 			ctx.Abort()
 			return
 		}
@@ -36,14 +50,19 @@ func JWTAuthMiddleware(jwtValidator *JWTValidator) gin.HandlerFunc {
 		// Validate the JWT token
 		claims, err := jwtValidator.ValidateToken(token)
 		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			jwtValidationsTotal.WithLabelValues(validationOutcome(err)).Inc()
+			httputil.RespondError(ctx, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid or expired token")
 			ctx.Abort()
 			return
 		}
+		jwtValidationsTotal.WithLabelValues(OutcomeSuccess).Inc()
 
 		// Store user information in the context for use in handlers
 		ctx.Set("jwt_claims", claims)
 		ctx.Set("access_token", token)
+		if cognitoClaims, err := ParseClaims(claims); err == nil {
+			ctx.Set("cognito_claims", &cognitoClaims)
+		}
 
 		// Extract common user info for convenience
 		if email, ok := GetUserEmailFromClaims(claims); ok {
@@ -55,12 +74,37 @@ func JWTAuthMiddleware(jwtValidator *JWTValidator) gin.HandlerFunc {
 		if sub, ok := GetUserSubFromClaims(claims); ok {
 			ctx.Set("user_sub", sub)
 		}
+		if groups, ok := GetUserGroupsFromClaims(claims); ok {
+			ctx.Set("user_groups", groups)
+		}
+
+		// Run the post-validation hooks, stopping at the first that rejects the request
+		for _, hook := range hooks {
+			hook(ctx)
+			if ctx.IsAborted() {
+				return
+			}
+		}
 
 		// Continue to the next handler
 		ctx.Next()
 	}
 }
 
+// RequireAdminMiddleware creates a middleware that only allows members of the admin group.
+// It must run after JWTAuthMiddleware.
+func RequireAdminMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !IsAdminFromContext(ctx) {
+			httputil.RespondError(ctx, http.StatusForbidden, "ADMIN_REQUIRED", "Administrator access is required")
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
 // GetJWTClaimsFromContext extracts JWT claims from the Gin context
 func GetJWTClaimsFromContext(ctx *gin.Context) (map[string]interface{}, bool) {
 	claims, exists := ctx.Get("jwt_claims")
@@ -68,10 +112,22 @@ func GetJWTClaimsFromContext(ctx *gin.Context) (map[string]interface{}, bool) {
 		return nil, false
 	}
 
-	claimsMap, ok := claims.(map[string]interface{})
+	// The claims are stored as jwt.MapClaims, which is a named map type
+	claimsMap, ok := claims.(jwt.MapClaims)
 	return claimsMap, ok
 }
 
+// GetCognitoClaimsFromContext extracts the typed Cognito claims from the Gin context
+func GetCognitoClaimsFromContext(ctx *gin.Context) (*CognitoClaims, bool) {
+	claims, exists := ctx.Get("cognito_claims")
+	if !exists {
+		return nil, false
+	}
+
+	cognitoClaims, ok := claims.(*CognitoClaims)
+	return cognitoClaims, ok
+}
+
 // GetUserEmailFromContext extracts the user email from the Gin context
 func GetUserEmailFromContext(ctx *gin.Context) (string, bool) {
 	email, exists := ctx.Get("user_email")
@@ -94,6 +150,26 @@ func GetUserSubFromContext(ctx *gin.Context) (string, bool) {
 	return subStr, ok
 }
 
+// GetUserGroupsFromContext extracts the user's Cognito groups from the Gin context
+func GetUserGroupsFromContext(ctx *gin.Context) ([]string, bool) {
+	groups, exists := ctx.Get("user_groups")
+	if !exists {
+		return nil, false
+	}
+
+	groupsSlice, ok := groups.([]string)
+	return groupsSlice, ok
+}
+
+// IsAdminFromContext reports whether the authenticated user is a member of the admin group
+func IsAdminFromContext(ctx *gin.Context) bool {
+	groups, ok := GetUserGroupsFromContext(ctx)
+	if !ok {
+		return false
+	}
+	return slices.Contains(groups, AdminGroup)
+}
+
 // GetAccessTokenFromContext extracts the access token from the Gin context
 func GetAccessTokenFromContext(ctx *gin.Context) (string, bool) {
 	token, exists := ctx.Get("access_token")
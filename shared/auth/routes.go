@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteAuth declares whether a route requires authentication
+type RouteAuth int
+
+const (
+	// AuthDefault uses the registry's default authentication requirement
+	AuthDefault RouteAuth = iota
+	// AuthRequired requires a valid JWT
+	AuthRequired
+	// AuthPublic allows unauthenticated access
+	AuthPublic
+)
+
+// ParseRouteAuth parses a default authentication setting ("required" or "public")
+func ParseRouteAuth(value string) (RouteAuth, error) {
+	switch strings.ToLower(value) {
+	case "required":
+		return AuthRequired, nil
+	case "public":
+		return AuthPublic, nil
+	default:
+		return AuthDefault, fmt.Errorf("invalid auth setting '%s': expected 'required' or 'public'", value)
+	}
+}
+
+// Route describes an API route and whether it requires authentication
+type Route struct {
+	Method string
+	Path   string
+	Auth   RouteAuth
+
+	// Middleware runs after authentication and before the handler
+	Middleware []gin.HandlerFunc
+	Handler    gin.HandlerFunc
+}
+
+// RegisterRoutes registers routes on the router, applying JWTAuthMiddleware to every route that
+// requires authentication. Routes that do not declare a requirement use defaultAuth, so with
+// AuthRequired a route is only reachable without a token if it is explicitly marked AuthPublic.
+// Any hooks run after each successful token validation.
+func RegisterRoutes(router gin.IRoutes, routes []Route, jwtValidator *JWTValidator, defaultAuth RouteAuth, hooks ...PostValidationHook) {
+	authMiddleware := JWTAuthMiddleware(jwtValidator, hooks...)
+
+	for _, route := range routes {
+		routeAuth := route.Auth
+		if routeAuth == AuthDefault {
+			routeAuth = defaultAuth
+		}
+
+		handlers := make([]gin.HandlerFunc, 0, len(route.Middleware)+2)
+		if routeAuth != AuthPublic {
+			handlers = append(handlers, authMiddleware)
+		}
+		handlers = append(handlers, route.Middleware...)
+		handlers = append(handlers, route.Handler)
+
+		router.Handle(route.Method, route.Path, handlers...)
+	}
+}
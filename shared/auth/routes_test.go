@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegisterRoutesDefaultAuthRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	defaultAuth, err := ParseRouteAuth("required")
+	if err != nil {
+		t.Fatalf("ParseRouteAuth: %v", err)
+	}
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router := gin.New()
+	RegisterRoutes(router, []Route{
+		{Method: http.MethodGet, Path: "/unmarked", Handler: ok},
+		{Method: http.MethodGet, Path: "/public", Auth: AuthPublic, Handler: ok},
+	}, NewJWTValidator(JWTValidatorConfig{}), defaultAuth)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/unmarked", http.StatusUnauthorized},
+		{"/public", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s: got status %d, want %d", tt.path, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package awsutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ErrNoCredentials is returned at startup when no AWS credentials can be found.
+// config.LoadDefaultConfig succeeds without credentials, so without this check the problem
+// would only surface on the first API call.
+var ErrNoCredentials = errors.New("no AWS credentials found")
+
+// credentialsTimeout bounds the credential check, which may have to give up on the instance
+// metadata service when running outside AWS
+const credentialsTimeout = 10 * time.Second
+
+// CheckCredentials retrieves credentials from cfg, returning an error wrapping
+// ErrNoCredentials that says how to provide them if there are none. Clients pointed at a local
+// endpoint (DYNAMODB_ENDPOINT) skip this check, since local endpoints accept any credentials.
+func CheckCredentials(ctx context.Context, cfg aws.Config) error {
+	const hint = "set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, set AWS_PROFILE, or run with an IAM role"
+	if cfg.Credentials == nil {
+		return fmt.Errorf("%w: %s", ErrNoCredentials, hint)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, credentialsTimeout)
+	defer cancel()
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return fmt.Errorf("%w: %s (%v)", ErrNoCredentials, hint, err)
+	}
+	return nil
+}
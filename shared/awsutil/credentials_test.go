@@ -0,0 +1,37 @@
+package awsutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestCheckCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials aws.CredentialsProvider
+		wantErr     bool
+	}{
+		{"found", aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"}, nil
+		}), false},
+		// This is how the default credential chain fails when nothing is configured
+		{"missing", aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{}, errors.New("failed to refresh cached credentials, no EC2 IMDS role found")
+		}), true},
+		{"no provider", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckCredentials(context.Background(), aws.Config{Credentials: tt.credentials})
+			if tt.wantErr && !errors.Is(err, ErrNoCredentials) {
+				t.Errorf("got %v, want ErrNoCredentials", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got %v, want nil", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package awsutil
+
+import (
+	"net/http"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// HTTPClientConfig tunes the connection pool of the HTTP client used by an AWS SDK client.
+// Zero fields keep the SDK's defaults.
+type HTTPClientConfig struct {
+	// MaxIdleConns bounds the idle connections kept across all hosts
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept per host. A DynamoDB client talks to
+	// a single host, so this is what limits connection reuse under bursty load.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the connections per host, including those in use (0 = unlimited)
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept before being closed
+	IdleConnTimeout time.Duration
+}
+
+// NewHTTPClient returns an HTTP client for the AWS SDK with the given connection pool tuning,
+// to be passed to config.LoadDefaultConfig with config.WithHTTPClient
+func NewHTTPClient(cfg HTTPClientConfig) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().WithTransportOptions(func(transport *http.Transport) {
+		if cfg.MaxIdleConns > 0 {
+			transport.MaxIdleConns = cfg.MaxIdleConns
+		}
+		if cfg.MaxIdleConnsPerHost > 0 {
+			transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+		}
+		if cfg.MaxConnsPerHost > 0 {
+			transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+		}
+		if cfg.IdleConnTimeout > 0 {
+			transport.IdleConnTimeout = cfg.IdleConnTimeout
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package awsutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+func TestNewHTTPClientAppliedToConfig(t *testing.T) {
+	want := HTTPClientConfig{
+		MaxIdleConns:        200,
+		MaxIdleConnsPerHost: 150,
+		MaxConnsPerHost:     300,
+		IdleConnTimeout:     45 * time.Second,
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion("us-east-1"),
+		config.WithHTTPClient(NewHTTPClient(want)),
+	)
+	if err != nil {
+		t.Fatalf("LoadDefaultConfig: %v", err)
+	}
+
+	client, ok := cfg.HTTPClient.(*awshttp.BuildableClient)
+	if !ok {
+		t.Fatalf("HTTPClient is %T, want *http.BuildableClient", cfg.HTTPClient)
+	}
+	transport := client.GetTransport()
+	got := HTTPClientConfig{
+		MaxIdleConns:        transport.MaxIdleConns,
+		MaxIdleConnsPerHost: transport.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     transport.MaxConnsPerHost,
+		IdleConnTimeout:     transport.IdleConnTimeout,
+	}
+	if got != want {
+		t.Errorf("transport pool settings = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewHTTPClientZeroKeepsDefaults(t *testing.T) {
+	transport := NewHTTPClient(HTTPClientConfig{}).GetTransport()
+	if transport.MaxIdleConns != awshttp.DefaultHTTPTransportMaxIdleConns {
+		t.Errorf("MaxIdleConns = %d, want SDK default %d", transport.MaxIdleConns, awshttp.DefaultHTTPTransportMaxIdleConns)
+	}
+	if transport.MaxIdleConnsPerHost != awshttp.DefaultHTTPTransportMaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want SDK default %d", transport.MaxIdleConnsPerHost, awshttp.DefaultHTTPTransportMaxIdleConnsPerHost)
+	}
+	if transport.IdleConnTimeout != awshttp.DefaultHTTPTransportIdleConnTimeout {
+		t.Errorf("IdleConnTimeout = %s, want SDK default %s", transport.IdleConnTimeout, awshttp.DefaultHTTPTransportIdleConnTimeout)
+	}
+}
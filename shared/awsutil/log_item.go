@@ -0,0 +1,129 @@
+package awsutil
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DefaultLogMaxFieldLen is the length string attribute values are truncated to when logged
+const DefaultLogMaxFieldLen = 120
+
+// logMaxElements is the number of attributes, list elements or set members logged for each
+// item, map, list or set; the rest are counted
+const logMaxElements = 20
+
+var logMaxFieldLen atomic.Int64
+
+func init() {
+	logMaxFieldLen.Store(DefaultLogMaxFieldLen)
+}
+
+// SetLogMaxFieldLen sets the length string attribute values are truncated to by LogItem.
+// A length of 0 or less keeps the default.
+func SetLogMaxFieldLen(n int) {
+	if n <= 0 {
+		n = DefaultLogMaxFieldLen
+	}
+	logMaxFieldLen.Store(int64(n))
+}
+
+// LogItem is a DynamoDB item to log with %s in place of %+v, which prints every attribute in
+// full. String values longer than the configured length are cut short with an ellipsis, and only
+// the first few attributes of each item, map, list or set are shown:
+//
+//	{ID:"6f1c...", Text:"hello wor... (+480 bytes)", Reactions:{...}}
+//
+// The item is only formatted if the log line is written.
+type LogItem map[string]types.AttributeValue
+
+// String formats the item
+func (item LogItem) String() string {
+	var b strings.Builder
+	writeMap(&b, item)
+	return b.String()
+}
+
+func writeMap(b *strings.Builder, m map[string]types.AttributeValue) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteByte('{')
+	for i, name := range names {
+		if i == logMaxElements {
+			fmt.Fprintf(b, ", ... (+%d attributes)", len(names)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte(':')
+		writeValue(b, m[name])
+	}
+	b.WriteByte('}')
+}
+
+func writeValue(b *strings.Builder, value types.AttributeValue) {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		b.WriteString(strconv.Quote(truncateField(v.Value)))
+	case *types.AttributeValueMemberN:
+		b.WriteString(truncateField(v.Value))
+	case *types.AttributeValueMemberBOOL:
+		b.WriteString(strconv.FormatBool(v.Value))
+	case *types.AttributeValueMemberNULL:
+		b.WriteString("null")
+	case *types.AttributeValueMemberB:
+		fmt.Fprintf(b, "<%d bytes>", len(v.Value))
+	case *types.AttributeValueMemberM:
+		writeMap(b, v.Value)
+	case *types.AttributeValueMemberL:
+		writeList(b, len(v.Value), func(i int) { writeValue(b, v.Value[i]) })
+	case *types.AttributeValueMemberSS:
+		writeList(b, len(v.Value), func(i int) { b.WriteString(strconv.Quote(truncateField(v.Value[i]))) })
+	case *types.AttributeValueMemberNS:
+		writeList(b, len(v.Value), func(i int) { b.WriteString(truncateField(v.Value[i])) })
+	case *types.AttributeValueMemberBS:
+		fmt.Fprintf(b, "<%d binary values>", len(v.Value))
+	default:
+		fmt.Fprintf(b, "%T", value)
+	}
+}
+
+// writeList writes up to logMaxElements elements, using writeElement to write each one
+func writeList(b *strings.Builder, n int, writeElement func(i int)) {
+	b.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i == logMaxElements {
+			fmt.Fprintf(b, ", ... (+%d elements)", n-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeElement(i)
+	}
+	b.WriteByte(']')
+}
+
+// truncateField shortens s to the configured length, without splitting a multi-byte character
+func truncateField(s string) string {
+	limit := int(logMaxFieldLen.Load())
+	if len(s) <= limit {
+		return s
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... (+%d bytes)", s[:cut], len(s)-cut)
+}
@@ -0,0 +1,64 @@
+package awsutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestLogItemTruncatesLongText(t *testing.T) {
+	defer SetLogMaxFieldLen(DefaultLogMaxFieldLen)
+	SetLogMaxFieldLen(10)
+
+	item := map[string]types.AttributeValue{
+		"ID":     &types.AttributeValueMemberS{Value: "msg-1"},
+		"Text":   &types.AttributeValueMemberS{Value: strings.Repeat("a", 500)},
+		"Pinned": &types.AttributeValueMemberBOOL{Value: true},
+		"Count":  &types.AttributeValueMemberN{Value: "42"},
+	}
+	want := `{Count:42, ID:"msg-1", Pinned:true, Text:"aaaaaaaaaa... (+490 bytes)"}`
+	if got := LogItem(item).String(); got != want {
+		t.Errorf("LogItem.String() = %s, want %s", got, want)
+	}
+}
+
+func TestLogItemDoesNotSplitCharacters(t *testing.T) {
+	defer SetLogMaxFieldLen(DefaultLogMaxFieldLen)
+	SetLogMaxFieldLen(4)
+
+	// "é" is two bytes, so the fifth byte falls inside the third character
+	item := map[string]types.AttributeValue{"Text": &types.AttributeValueMemberS{Value: "ééé"}}
+	if got, want := LogItem(item).String(), `{Text:"éé... (+2 bytes)"}`; got != want {
+		t.Errorf("LogItem.String() = %s, want %s", got, want)
+	}
+
+	SetLogMaxFieldLen(5)
+	if got, want := LogItem(item).String(), `{Text:"éé... (+2 bytes)"}`; got != want {
+		t.Errorf("LogItem.String() = %s, want %s", got, want)
+	}
+}
+
+func TestLogItemCapsElements(t *testing.T) {
+	reactions := make([]types.AttributeValue, 25)
+	for i := range reactions {
+		reactions[i] = &types.AttributeValueMemberS{Value: fmt.Sprintf("r%d", i)}
+	}
+	item := map[string]types.AttributeValue{
+		"Reactions": &types.AttributeValueMemberL{Value: reactions},
+		"Tags":      &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+		"Nested":    &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"Inner": &types.AttributeValueMemberNULL{Value: true}}},
+		"Data":      &types.AttributeValueMemberB{Value: []byte("binary")},
+	}
+
+	got := LogItem(item).String()
+	for _, want := range []string{`"r19", ... (+5 elements)]`, `Tags:["a", "b"]`, `Nested:{Inner:null}`, `Data:<6 bytes>`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("LogItem.String() = %s, want it to contain %s", got, want)
+		}
+	}
+	if strings.Contains(got, `"r20"`) {
+		t.Errorf("LogItem.String() = %s, want elements after the cap left out", got)
+	}
+}
@@ -0,0 +1,43 @@
+package awsutil
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// DefaultRegion is the region used when no other source provides one
+const DefaultRegion = "us-east-1"
+
+var logRegionOnce sync.Once
+
+// ResolveRegion returns the AWS region to use for all AWS clients, so that stores and the
+// Cognito client never end up in different regions. The precedence is:
+//
+//  1. the AWS_REGION environment variable
+//  2. the AWS_DEFAULT_REGION environment variable
+//  3. the explicitly configured region (e.g. COGNITO_REGION), if not empty
+//  4. DefaultRegion
+//
+// The resolved region is logged the first time it is resolved.
+func ResolveRegion(configured string) string {
+	region, source := resolveRegion(configured)
+	logRegionOnce.Do(func() {
+		log.Printf("Resolved AWS region: %s (from %s)", region, source)
+	})
+	return region
+}
+
+// resolveRegion returns the resolved region and a description of where it came from
+func resolveRegion(configured string) (string, string) {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region, "AWS_REGION"
+	}
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region, "AWS_DEFAULT_REGION"
+	}
+	if configured != "" {
+		return configured, "configuration"
+	}
+	return DefaultRegion, "default"
+}
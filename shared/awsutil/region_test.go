@@ -0,0 +1,33 @@
+package awsutil
+
+import "testing"
+
+func TestResolveRegion(t *testing.T) {
+	tests := []struct {
+		name          string
+		awsRegion     string
+		defaultRegion string
+		configured    string
+		want          string
+		wantSource    string
+	}{
+		{"AWS_REGION wins", "eu-west-1", "eu-central-1", "us-west-2", "eu-west-1", "AWS_REGION"},
+		{"AWS_DEFAULT_REGION before configuration", "", "eu-central-1", "us-west-2", "eu-central-1", "AWS_DEFAULT_REGION"},
+		{"configuration", "", "", "us-west-2", "us-west-2", "configuration"},
+		{"default", "", "", "", DefaultRegion, "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", tt.awsRegion)
+			t.Setenv("AWS_DEFAULT_REGION", tt.defaultRegion)
+
+			region, source := resolveRegion(tt.configured)
+			if region != tt.want || source != tt.wantSource {
+				t.Errorf("resolveRegion(%q) = %s from %s, want %s from %s", tt.configured, region, source, tt.want, tt.wantSource)
+			}
+			if got := ResolveRegion(tt.configured); got != tt.want {
+				t.Errorf("ResolveRegion(%q) = %s, want %s", tt.configured, got, tt.want)
+			}
+		})
+	}
+}
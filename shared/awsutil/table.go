@@ -0,0 +1,12 @@
+package awsutil
+
+// TableName returns the effective name of a DynamoDB table: the base name wrapped in the
+// configured prefix and suffix, e.g. TableName("messages", "acme-", "-prod") returns
+// "acme-messages-prod". The prefix and suffix are applied verbatim, so they carry their own
+// separators. An empty base name stays empty, so callers can still detect an unset table.
+func TableName(base, prefix, suffix string) string {
+	if base == "" {
+		return ""
+	}
+	return prefix + base + suffix
+}
@@ -0,0 +1,70 @@
+package awsutil
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TableReadyTTL is how long a TableReadiness reuses the result of a check. Each load balancer
+// node probes every target, so without it every probe would call DescribeTable.
+const TableReadyTTL = 5 * time.Second
+
+// DescribeTableAPI is the part of the DynamoDB client a TableReadiness uses
+type DescribeTableAPI interface {
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+}
+
+// TableReadiness reports whether a DynamoDB table is reachable and active, for readiness
+// checks. A result is reused for TableReadyTTL, and concurrent checks share one call.
+type TableReadiness struct {
+	client    DescribeTableAPI
+	tableName string
+	now       func() time.Time
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// NewTableReadiness returns a readiness check for the named table
+func NewTableReadiness(client DescribeTableAPI, tableName string) *TableReadiness {
+	return &TableReadiness{client: client, tableName: tableName, now: time.Now}
+}
+
+// Check returns nil if the table was active when last described, describing it again once the
+// last result is older than TableReadyTTL. A check cut short by ctx is not reused.
+func (r *TableReadiness) Check(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.checkedAt.IsZero() && r.now().Sub(r.checkedAt) < TableReadyTTL {
+		return r.err
+	}
+
+	err := r.describe(ctx)
+	if ctx.Err() != nil {
+		return err
+	}
+	r.checkedAt, r.err = r.now(), err
+	return err
+}
+
+// describe calls DescribeTable and checks the table's status
+func (r *TableReadiness) describe(ctx context.Context) error {
+	output, err := r.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe table: %w", err)
+	}
+	if output.Table.TableStatus != types.TableStatusActive {
+		return fmt.Errorf("table %s is %s", r.tableName, output.Table.TableStatus)
+	}
+	return nil
+}
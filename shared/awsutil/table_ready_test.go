@@ -0,0 +1,70 @@
+package awsutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDescribeTable answers DescribeTable with status, or err if set, counting the calls
+type fakeDescribeTable struct {
+	status types.TableStatus
+	err    error
+	calls  int
+}
+
+func (f *fakeDescribeTable) DescribeTable(context.Context, *dynamodb.DescribeTableInput, ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{TableStatus: f.status}}, nil
+}
+
+func TestTableReadiness(t *testing.T) {
+	client := &fakeDescribeTable{status: types.TableStatusActive}
+	now := time.Now()
+	readiness := NewTableReadiness(client, "messages")
+	readiness.now = func() time.Time { return now }
+
+	check := func(wantErr bool, wantCalls int) {
+		t.Helper()
+		if err := readiness.Check(context.Background()); (err != nil) != wantErr {
+			t.Errorf("Check: got %v, want error %v", err, wantErr)
+		}
+		if client.calls != wantCalls {
+			t.Errorf("got %d DescribeTable calls, want %d", client.calls, wantCalls)
+		}
+	}
+
+	check(false, 1)
+	// Within the TTL the result is reused, even though the table has since failed
+	client.err = errors.New("connection refused")
+	now = now.Add(TableReadyTTL - time.Millisecond)
+	check(false, 1)
+	// After it the table is described again
+	now = now.Add(time.Millisecond)
+	check(true, 2)
+	// Failures are reused too
+	client.err = nil
+	check(true, 2)
+	now = now.Add(TableReadyTTL)
+	client.status = types.TableStatusUpdating
+	check(true, 3)
+
+	// A check cut short by its context is not reused
+	now = now.Add(TableReadyTTL)
+	client.status = types.TableStatusActive
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.err = context.Canceled
+	if err := readiness.Check(ctx); err == nil {
+		t.Error("Check with a cancelled context: got nil, want an error")
+	}
+	client.err = nil
+	check(false, 5)
+}
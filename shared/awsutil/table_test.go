@@ -0,0 +1,27 @@
+package awsutil
+
+import "testing"
+
+func TestTableName(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		prefix string
+		suffix string
+		want   string
+	}{
+		{"neither", "messages", "", "", "messages"},
+		{"prefix", "messages", "acme-", "", "acme-messages"},
+		{"suffix", "messages", "", "-prod", "messages-prod"},
+		{"both", "messages", "acme-", "-prod", "acme-messages-prod"},
+		{"empty base", "", "acme-", "-prod", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TableName(tt.base, tt.prefix, tt.suffix); got != tt.want {
+				t.Errorf("TableName(%q, %q, %q) = %q, want %q", tt.base, tt.prefix, tt.suffix, got, tt.want)
+			}
+		})
+	}
+}
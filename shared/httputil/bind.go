@@ -0,0 +1,43 @@
+package httputil
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// UnknownFieldError is returned by BindJSON in strict mode for a body with a field the target
+// struct does not have
+type UnknownFieldError struct {
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("Unknown field %q", e.Field)
+}
+
+// BindJSON binds the request body to obj like ctx.ShouldBindJSON. With strict, a field obj does
+// not have is rejected with an *UnknownFieldError rather than ignored, so a misspelt field is
+// reported instead of silently left empty.
+func BindJSON(ctx *gin.Context, obj any, strict bool) error {
+	if !strict || ctx.Request.Body == nil {
+		return ctx.ShouldBindJSON(obj)
+	}
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(obj); err != nil {
+		// encoding/json reports unknown fields only as text: json: unknown field "name"
+		if quoted, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			if field, unquoteErr := strconv.Unquote(quoted); unquoteErr == nil {
+				return &UnknownFieldError{Field: field}
+			}
+		}
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
@@ -0,0 +1,56 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindJSONStrict(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(strict bool) *gin.Engine {
+		router := gin.New()
+		router.POST("/users", func(c *gin.Context) {
+			var request struct {
+				FirstName string `json:"firstName"`
+				Email     string `json:"email" binding:"required"`
+			}
+			if err := BindJSON(c, &request, strict); err != nil {
+				RespondBindError(c, err)
+				return
+			}
+			c.String(http.StatusCreated, request.FirstName)
+		})
+		return router
+	}
+
+	tests := []struct {
+		name       string
+		strict     bool
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"typo ignored", false, `{"frstName":"Ada","email":"ada@example.com"}`, http.StatusCreated, ""},
+		{"typo rejected", true, `{"frstName":"Ada","email":"ada@example.com"}`, http.StatusBadRequest, `{"code":"UNKNOWN_FIELD","error":"Unknown field \"frstName\"","field":"frstName"}`},
+		{"known fields", true, `{"firstName":"Ada","email":"ada@example.com"}`, http.StatusCreated, "Ada"},
+		{"still validated", true, `{"firstName":"Ada"}`, http.StatusBadRequest, ""},
+		{"empty body", true, "", http.StatusBadRequest, `{"code":"EMPTY_BODY","error":"request body is required"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newRouter(tt.strict).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("got body %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorResponse is the body of every error response: a machine-readable code for clients to
+// switch on and a human-readable message.
+type ErrorResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// RespondError writes an error response with the given status, code and message. A server
+// error on a request whose deadline has passed is reported as 504 Gateway Timeout instead, since
+// the backend call most likely failed because it was cancelled.
+func RespondError(ctx *gin.Context, status int, code, message string) {
+	if deadlineExceeded(ctx, status) {
+		respondTimeout(ctx)
+		return
+	}
+	ctx.JSON(status, ErrorResponse{Code: code, Error: message})
+}
+
+// RespondErrorWith writes an error response with extra fields alongside the code and message,
+// such as the limit a request went over. The code and message win over fields of the same name.
+func RespondErrorWith(ctx *gin.Context, status int, code, message string, fields gin.H) {
+	if deadlineExceeded(ctx, status) {
+		respondTimeout(ctx)
+		return
+	}
+	body := make(gin.H, len(fields)+2)
+	for key, value := range fields {
+		body[key] = value
+	}
+	body["code"] = code
+	body["error"] = message
+	ctx.JSON(status, body)
+}
+
+// deadlineExceeded reports whether a response with the given status is a server error on a
+// request whose context deadline has passed
+func deadlineExceeded(ctx *gin.Context, status int) bool {
+	return status >= http.StatusInternalServerError && ctx.Request != nil &&
+		errors.Is(ctx.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+// respondTimeout writes the 504 response for a request that ran out of time
+func respondTimeout(ctx *gin.Context) {
+	ctx.JSON(http.StatusGatewayTimeout, ErrorResponse{Code: "TIMEOUT", Error: "Request timed out"})
+}
+
+// MethodNotAllowed responds with 405 for a known path requested with an unsupported method.
+// Register it with router.NoMethod and enable router.HandleMethodNotAllowed; gin then sets the
+// Allow header to the methods the path supports.
+func MethodNotAllowed(ctx *gin.Context) {
+	RespondError(ctx, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Method not allowed")
+}
+
+// NotFound responds with 404 for a path that matches no route. Register it with router.NoRoute.
+func NotFound(ctx *gin.Context) {
+	RespondError(ctx, http.StatusNotFound, "NOT_FOUND", "resource not found")
+}
+
+// RespondBindError responds with 400 for a request body that failed to bind. An empty or missing
+// body gets a structured EMPTY_BODY error rather than the JSON decoder's bare "EOF", and a field
+// rejected by BindJSON in strict mode an UNKNOWN_FIELD error naming it; any other error is passed
+// through as INVALID_BODY.
+func RespondBindError(ctx *gin.Context, err error) {
+	var unknownField *UnknownFieldError
+	if errors.As(err, &unknownField) {
+		RespondErrorWith(ctx, http.StatusBadRequest, "UNKNOWN_FIELD", err.Error(), gin.H{"field": unknownField.Field})
+		return
+	}
+	if errors.Is(err, io.EOF) || ctx.Request.Body == nil || ctx.Request.Body == http.NoBody {
+		RespondError(ctx, http.StatusBadRequest, "EMPTY_BODY", "request body is required")
+		return
+	}
+	RespondError(ctx, http.StatusBadRequest, "INVALID_BODY", err.Error())
+}
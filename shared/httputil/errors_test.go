@@ -0,0 +1,152 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter returns a router with the error handlers and routes for GET and POST /messages
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.HandleMethodNotAllowed = true
+	router.NoMethod(MethodNotAllowed)
+	router.NoRoute(NotFound)
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/messages", ok)
+	router.POST("/messages", ok)
+	return router
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	router := newTestRouter()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/messages", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := rec.Header().Get("Allow"); !strings.Contains(allow, "GET") || !strings.Contains(allow, "POST") {
+		t.Errorf("got Allow header %q, want GET and POST", allow)
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"METHOD_NOT_ALLOWED"`) {
+		t.Errorf("unexpected body %s", rec.Body.String())
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	router := newTestRouter()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("got Content-Type %q, want application/json", contentType)
+	}
+	if body := rec.Body.String(); body != `{"code":"NOT_FOUND","error":"resource not found"}` {
+		t.Errorf("unexpected body %s", body)
+	}
+}
+
+func TestRespondBindError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/messages", func(c *gin.Context) {
+		var request struct {
+			Text string `json:"text" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			RespondBindError(c, err)
+			return
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantBody string
+	}{
+		{"no body", "", `{"code":"EMPTY_BODY","error":"request body is required"}`},
+		{"whitespace body", "  \n", `{"code":"EMPTY_BODY","error":"request body is required"}`},
+		{"malformed body", `{"text":`, `{"code":"INVALID_BODY","error":"unexpected EOF"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("got body %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRespondErrorWith(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/messages/batch-get", func(c *gin.Context) {
+		RespondErrorWith(c, http.StatusBadRequest, "BATCH_TOO_LARGE", "Too many messages", gin.H{"max": 25, "code": "IGNORED"})
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/messages/batch-get", nil))
+	if want := `{"code":"BATCH_TOO_LARGE","error":"Too many messages","max":25}`; rec.Body.String() != want {
+		t.Errorf("got body %s, want %s", rec.Body.String(), want)
+	}
+}
+
+func TestRespondErrorAfterDeadline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		expired    bool
+		status     int
+		code       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"server error after deadline", true, http.StatusInternalServerError, "INTERNAL_ERROR", http.StatusGatewayTimeout, "TIMEOUT"},
+		{"unavailable after deadline", true, http.StatusServiceUnavailable, "STORE_UNAVAILABLE", http.StatusGatewayTimeout, "TIMEOUT"},
+		{"client error after deadline", true, http.StatusNotFound, "MESSAGE_NOT_FOUND", http.StatusNotFound, "MESSAGE_NOT_FOUND"},
+		{"server error within deadline", false, http.StatusInternalServerError, "INTERNAL_ERROR", http.StatusInternalServerError, "INTERNAL_ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/messages", nil)
+			if tt.expired {
+				ctx, cancel := context.WithDeadline(req.Context(), time.Now().Add(-time.Second))
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = req
+			RespondErrorWith(c, tt.status, tt.code, "failed", gin.H{"max": 1})
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("unexpected body %s", rec.Body.String())
+			}
+		})
+	}
+}
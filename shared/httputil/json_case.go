@@ -0,0 +1,149 @@
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// JSONCase is the casing of object keys in JSON responses
+type JSONCase string
+
+const (
+	// JSONCaseCamel leaves keys as the struct tags spell them, in camelCase (the default)
+	JSONCaseCamel JSONCase = "camel"
+	// JSONCaseSnake rewrites keys in snake_case
+	JSONCaseSnake JSONCase = "snake"
+	// JSONCasePascal rewrites keys in PascalCase
+	JSONCasePascal JSONCase = "pascal"
+)
+
+// jsonCase is the casing RespondJSON writes object keys in
+var jsonCase = JSONCaseCamel
+
+// ParseJSONCase parses a JSON_CASE setting
+func ParseJSONCase(value string) (JSONCase, error) {
+	switch c := JSONCase(value); c {
+	case JSONCaseCamel, JSONCaseSnake, JSONCasePascal:
+		return c, nil
+	default:
+		return "", fmt.Errorf("invalid JSON case %q: expected %q, %q or %q", value, JSONCaseCamel, JSONCaseSnake, JSONCasePascal)
+	}
+}
+
+// SetJSONCase sets the casing of object keys in responses written by RespondJSON. It should be
+// called once at startup, before any responses are written. Struct tags, and so request bodies
+// and DynamoDB items, are unaffected.
+func SetJSONCase(c JSONCase) {
+	jsonCase = c
+}
+
+// convertKey returns key in the configured casing
+func convertKey(key string) string {
+	switch jsonCase {
+	case JSONCaseSnake:
+		return toSnakeCase(key)
+	case JSONCasePascal:
+		return toPascalCase(key)
+	default:
+		return key
+	}
+}
+
+// toSnakeCase converts a camelCase key to snake_case. A run of capitals is kept together as one
+// word, so "ownerID" becomes "owner_id" and "HTTPServer" becomes "http_server".
+func toSnakeCase(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// toPascalCase converts a camelCase key to PascalCase
+func toPascalCase(key string) string {
+	r, size := utf8.DecodeRuneInString(key)
+	if r == utf8.RuneError {
+		return key
+	}
+	return string(unicode.ToUpper(r)) + key[size:]
+}
+
+// recaseJSON rewrites the object keys of an encoded JSON document with convert, leaving values
+// and the order of keys as they are
+func recaseJSON(data []byte, convert func(string) string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	// For each open object or array, whether it is an object and how many tokens it has had
+	type container struct {
+		object bool
+		tokens int
+	}
+	var stack []container
+	var out bytes.Buffer
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return out.Bytes(), nil
+			}
+			return nil, err
+		}
+
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			out.WriteRune(rune(delim))
+			continue
+		}
+
+		// Separate this token from the one before it in the enclosing object or array
+		isKey := false
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			isKey = top.object && top.tokens%2 == 0
+			switch {
+			case isKey && top.tokens > 0, !top.object && top.tokens > 0:
+				out.WriteByte(',')
+			case top.object && !isKey:
+				out.WriteByte(':')
+			}
+			top.tokens++
+		}
+
+		switch value := token.(type) {
+		case json.Delim:
+			stack = append(stack, container{object: value == '{'})
+			out.WriteRune(rune(value))
+		case string:
+			if isKey {
+				value = convert(value)
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(encoded)
+		case json.Number:
+			out.WriteString(value.String())
+		case bool:
+			fmt.Fprintf(&out, "%t", value)
+		case nil:
+			out.WriteString("null")
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConvertKey(t *testing.T) {
+	defer SetJSONCase(JSONCaseCamel)
+
+	tests := []struct {
+		key, snake, pascal string
+	}{
+		{"email", "email", "Email"},
+		{"firstName", "first_name", "FirstName"},
+		{"ownerID", "owner_id", "OwnerID"},
+		{"HTTPServer", "http_server", "HTTPServer"},
+		{"attempt2Count", "attempt2_count", "Attempt2Count"},
+		{"already_snake", "already_snake", "Already_snake"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		SetJSONCase(JSONCaseSnake)
+		if got := convertKey(tt.key); got != tt.snake {
+			t.Errorf("snake case of %q = %q, want %q", tt.key, got, tt.snake)
+		}
+		SetJSONCase(JSONCasePascal)
+		if got := convertKey(tt.key); got != tt.pascal {
+			t.Errorf("Pascal case of %q = %q, want %q", tt.key, got, tt.pascal)
+		}
+		SetJSONCase(JSONCaseCamel)
+		if got := convertKey(tt.key); got != tt.key {
+			t.Errorf("camel case of %q = %q, want it unchanged", tt.key, got)
+		}
+	}
+}
+
+func TestRespondJSONCase(t *testing.T) {
+	defer SetJSONCase(JSONCaseCamel)
+	gin.SetMode(gin.TestMode)
+
+	type reply struct {
+		ParentID string `json:"parentId"`
+	}
+	data := struct {
+		MessageText string           `json:"messageText"`
+		Replies     []reply          `json:"replies"`
+		Pinned      bool             `json:"isPinned"`
+		Attachment  *string          `json:"attachmentUrl"`
+		Counts      map[string]int64 `json:"reactionCounts"`
+	}{
+		MessageText: "Keys change, values like \"someValue\" <b> do not",
+		Replies:     []reply{{ParentID: "a"}, {ParentID: "b"}},
+		Pinned:      true,
+		Counts:      map[string]int64{"thumbsUp": 12345678901},
+	}
+
+	tests := []struct {
+		jsonCase JSONCase
+		want     string
+	}{
+		{JSONCaseCamel, `{"messageText":"Keys change, values like \"someValue\" \u003cb\u003e do not","replies":[{"parentId":"a"},{"parentId":"b"}],"isPinned":true,"attachmentUrl":null,"reactionCounts":{"thumbsUp":12345678901}}`},
+		{JSONCaseSnake, `{"message_text":"Keys change, values like \"someValue\" \u003cb\u003e do not","replies":[{"parent_id":"a"},{"parent_id":"b"}],"is_pinned":true,"attachment_url":null,"reaction_counts":{"thumbs_up":12345678901}}`},
+		{JSONCasePascal, `{"MessageText":"Keys change, values like \"someValue\" \u003cb\u003e do not","Replies":[{"ParentId":"a"},{"ParentId":"b"}],"IsPinned":true,"AttachmentUrl":null,"ReactionCounts":{"ThumbsUp":12345678901}}`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.jsonCase), func(t *testing.T) {
+			SetJSONCase(tt.jsonCase)
+
+			rec := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(rec)
+			RespondJSON(ctx, http.StatusOK, data)
+
+			if rec.Body.String() != tt.want {
+				t.Errorf("got body %s, want %s", rec.Body.String(), tt.want)
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/json; charset=utf-8" {
+				t.Errorf("got Content-Type %q", contentType)
+			}
+		})
+	}
+}
+
+func TestParseJSONCase(t *testing.T) {
+	for _, value := range []string{"camel", "snake", "pascal"} {
+		if _, err := ParseJSONCase(value); err != nil {
+			t.Errorf("ParseJSONCase(%q): %v", value, err)
+		}
+	}
+	if _, err := ParseJSONCase("kebab"); err == nil {
+		t.Error("ParseJSONCase(\"kebab\") succeeded, want an error")
+	}
+}
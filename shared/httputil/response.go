@@ -0,0 +1,58 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RespondJSON writes a successful JSON response with the standard API headers, with object keys
+// in the casing set by SetJSONCase. API responses carry user-specific data, so they are marked
+// as not cacheable.
+func RespondJSON(ctx *gin.Context, status int, data interface{}) {
+	setNoStoreHeaders(ctx)
+	if jsonCase == JSONCaseCamel {
+		ctx.JSON(status, data)
+		return
+	}
+
+	encoded, err := json.Marshal(data)
+	if err == nil {
+		encoded, err = recaseJSON(encoded, convertKey)
+	}
+	if err != nil {
+		// Leave the error to gin's encoder, as for camelCase responses
+		ctx.JSON(status, data)
+		return
+	}
+	ctx.Data(status, "application/json; charset=utf-8", encoded)
+}
+
+// RespondCreated writes a 201 Created JSON response for a newly created resource
+func RespondCreated(ctx *gin.Context, data interface{}) {
+	RespondJSON(ctx, http.StatusCreated, data)
+}
+
+// ListResponse is the body of every list response. Wrapping the items in an object leaves room
+// to add fields such as a cursor without breaking clients that expect the same shape.
+type ListResponse[T any] struct {
+	Items []T `json:"items"`
+	Count int `json:"count"`
+}
+
+// RespondList writes a 200 OK JSON response for a list of items, wrapped in a ListResponse. The
+// items are always encoded as a JSON array, never as null, so clients can rely on the shape.
+func RespondList[T any](ctx *gin.Context, items []T) {
+	if items == nil {
+		items = make([]T, 0)
+	}
+	RespondJSON(ctx, http.StatusOK, ListResponse[T]{Items: items, Count: len(items)})
+}
+
+// setNoStoreHeaders sets headers that prevent browsers and proxies from caching the response
+func setNoStoreHeaders(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+	ctx.Header("Pragma", "no-cache")
+	ctx.Header("Expires", "0")
+}
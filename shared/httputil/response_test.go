@@ -0,0 +1,53 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondHelpers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	type item struct {
+		ID string `json:"id"`
+	}
+	tests := []struct {
+		name       string
+		respond    func(ctx *gin.Context)
+		wantStatus int
+		wantBody   string
+	}{
+		{"object", func(ctx *gin.Context) { RespondJSON(ctx, http.StatusOK, item{ID: "a"}) }, http.StatusOK, `{"id":"a"}`},
+		{"created", func(ctx *gin.Context) { RespondCreated(ctx, item{ID: "a"}) }, http.StatusCreated, `{"id":"a"}`},
+		{"list", func(ctx *gin.Context) { RespondList(ctx, []item{{ID: "a"}, {ID: "b"}}) }, http.StatusOK, `{"items":[{"id":"a"},{"id":"b"}],"count":2}`},
+		{"nil list", func(ctx *gin.Context) { RespondList[item](ctx, nil) }, http.StatusOK, `{"items":[],"count":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(rec)
+			tt.respond(ctx)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("got body %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+			wantHeaders := map[string]string{
+				"Content-Type":  "application/json; charset=utf-8",
+				"Cache-Control": "no-cache, no-store, must-revalidate",
+				"Pragma":        "no-cache",
+				"Expires":       "0",
+			}
+			for header, want := range wantHeaders {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("got %s %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package httputil
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceInfo is the descriptor returned by a service's root endpoint
+type ServiceInfo struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+	Status  string `json:"status"`
+}
+
+// ServiceInfoHandler returns a handler that describes the service, so uptime checks pointed at
+// the root of a service get a successful response instead of a 404
+func ServiceInfoHandler(service, version string) gin.HandlerFunc {
+	info := ServiceInfo{Service: service, Version: version, Status: "ok"}
+	return func(ctx *gin.Context) {
+		RespondJSON(ctx, http.StatusOK, info)
+	}
+}
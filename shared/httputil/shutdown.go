@@ -0,0 +1,94 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ShutdownOptions configures graceful shutdown in ListenAndServeGraceful
+type ShutdownOptions struct {
+	// OnSignal is called as soon as a shutdown signal is received, before the drain delay.
+	// Services use it to start failing readiness checks.
+	OnSignal func()
+
+	// DrainDelay is how long to keep serving after the signal, so the load balancer notices
+	// the failing readiness check and stops routing new requests here
+	DrainDelay time.Duration
+
+	// Timeout bounds how long in-flight requests may take to finish once shutdown starts
+	Timeout time.Duration
+
+	// OnStop is called once the server has stopped, with a context that expires after
+	// StopTimeout. Services use it to flush buffered writes before the process exits.
+	OnStop      func(ctx context.Context) error
+	StopTimeout time.Duration
+}
+
+// ListenAndServeGraceful serves handler on addr until SIGINT or SIGTERM is received, then
+// drains and shuts down: OnSignal is called, requests keep being served for DrainDelay, and
+// the server then stops accepting connections and waits up to Timeout for in-flight requests.
+// Finally OnStop is called, with up to StopTimeout to flush anything buffered.
+func ListenAndServeGraceful(addr string, handler http.Handler, opts ShutdownOptions) error {
+	if addr == "" {
+		addr = ":http"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serveGraceful(ctx, &http.Server{Addr: addr, Handler: handler}, listener, opts)
+}
+
+// serveGraceful serves on listener until ctx is done, then shuts down as described for
+// ListenAndServeGraceful
+func serveGraceful(ctx context.Context, server *http.Server, listener net.Listener, opts ShutdownOptions) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutdown signal received, draining for %s before stopping", opts.DrainDelay)
+	if opts.OnSignal != nil {
+		opts.OnSignal()
+	}
+	time.Sleep(opts.DrainDelay)
+
+	log.Printf("Shutting down server, waiting up to %s for in-flight requests", opts.Timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Printf("Server stopped")
+
+	// No more requests can add to buffers now, so this flush is the last
+	if opts.OnStop != nil {
+		stopCtx, cancel := context.WithTimeout(context.Background(), opts.StopTimeout)
+		defer cancel()
+		if err := opts.OnStop(stopCtx); err != nil {
+			log.Printf("ERROR: Shutdown hook failed: %v", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package httputil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeGracefulCallsOnStopAfterShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+
+	var signalled, stopped bool
+	var stopDeadline time.Time
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveGraceful(ctx, server, listener, ShutdownOptions{
+			OnSignal: func() { signalled = true },
+			Timeout:  time.Second,
+			OnStop: func(ctx context.Context) error {
+				// Serving must be over by the time buffers are flushed
+				if _, err := http.Get("http://" + listener.Addr().String()); err == nil {
+					t.Error("server still accepted a request during OnStop")
+				}
+				stopDeadline, _ = ctx.Deadline()
+				stopped = true
+				return nil
+			},
+			StopTimeout: 5 * time.Second,
+		})
+	}()
+
+	if _, err := http.Get("http://" + listener.Addr().String()); err != nil {
+		t.Fatalf("request before shutdown: %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("serveGraceful: %v", err)
+	}
+	if !signalled || !stopped {
+		t.Errorf("OnSignal called %t, OnStop called %t, want both", signalled, stopped)
+	}
+	if remaining := time.Until(stopDeadline); remaining <= 0 || remaining > 5*time.Second {
+		t.Errorf("OnStop context expires in %s, want within StopTimeout", remaining)
+	}
+}
@@ -0,0 +1,72 @@
+package httputil
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TimestampFormat is the JSON representation used for Timestamp values
+type TimestampFormat string
+
+const (
+	// TimestampRFC3339 encodes timestamps as RFC 3339 strings (the default)
+	TimestampRFC3339 TimestampFormat = "rfc3339"
+	// TimestampEpochMillis encodes timestamps as milliseconds since the Unix epoch
+	TimestampEpochMillis TimestampFormat = "epoch_millis"
+)
+
+// timestampFormat is the format used when marshalling Timestamp values to JSON
+var timestampFormat = TimestampRFC3339
+
+// ParseTimestampFormat parses a TIMESTAMP_FORMAT setting
+func ParseTimestampFormat(value string) (TimestampFormat, error) {
+	switch format := TimestampFormat(value); format {
+	case TimestampRFC3339, TimestampEpochMillis:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid timestamp format %q: expected %q or %q", value, TimestampRFC3339, TimestampEpochMillis)
+	}
+}
+
+// SetTimestampFormat sets the JSON representation of Timestamp values. It should be called
+// once at startup, before any responses are written.
+func SetTimestampFormat(format TimestampFormat) {
+	timestampFormat = format
+}
+
+// Timestamp is a time.Time whose JSON representation follows SetTimestampFormat. Because it
+// converts to and from time.Time, DynamoDB stores it exactly like a time.Time (an RFC 3339
+// string), whatever the JSON format.
+type Timestamp time.Time
+
+// Time returns the timestamp as a time.Time
+func (t Timestamp) Time() time.Time {
+	return time.Time(t)
+}
+
+// MarshalJSON encodes the timestamp in the configured format
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if timestampFormat == TimestampEpochMillis {
+		return strconv.AppendInt(nil, time.Time(t).UnixMilli(), 10), nil
+	}
+	return time.Time(t).MarshalJSON()
+}
+
+// UnmarshalJSON decodes a timestamp in either format, regardless of the configured one
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		return (*time.Time)(t).UnmarshalJSON(data)
+	}
+
+	millis, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s: %w", data, err)
+	}
+	*t = Timestamp(time.UnixMilli(millis).UTC())
+	return nil
+}
@@ -0,0 +1,52 @@
+package httputil
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimestampJSON(t *testing.T) {
+	defer SetTimestampFormat(TimestampRFC3339)
+
+	ts := Timestamp(time.Date(2024, 5, 6, 7, 8, 9, 123000000, time.UTC))
+	tests := []struct {
+		format TimestampFormat
+		want   string
+	}{
+		{TimestampRFC3339, `"2024-05-06T07:08:09.123Z"`},
+		{TimestampEpochMillis, `1714979289123`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			SetTimestampFormat(tt.format)
+
+			data, err := json.Marshal(ts)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %s, want %s", data, tt.want)
+			}
+
+			var decoded Timestamp
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !decoded.Time().Equal(ts.Time()) {
+				t.Errorf("round trip got %v, want %v", decoded.Time(), ts.Time())
+			}
+		})
+	}
+}
+
+func TestParseTimestampFormat(t *testing.T) {
+	for _, value := range []string{"rfc3339", "epoch_millis"} {
+		if _, err := ParseTimestampFormat(value); err != nil {
+			t.Errorf("ParseTimestampFormat(%q): %v", value, err)
+		}
+	}
+	if _, err := ParseTimestampFormat("unix"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAcceptJSON creates a middleware that rejects requests with 406 Not Acceptable when an
+// Accept header is present but allows neither application/json nor a matching wildcard.
+// Requests without an Accept header are let through.
+func RequireAcceptJSON() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		accept := ctx.GetHeader("Accept")
+		if accept != "" && !acceptsJSON(accept) {
+			httputil.RespondError(ctx, http.StatusNotAcceptable, "NOT_ACCEPTABLE", "This API only produces application/json")
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// acceptsJSON reports whether an Accept header value allows an application/json response
+func acceptsJSON(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType != "application/json" && mediaType != "application/*" && mediaType != "*/*" {
+			continue
+		}
+
+		// A quality of zero explicitly marks the media range as not acceptable
+		if !hasZeroQuality(params) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasZeroQuality reports whether media range parameters contain q=0
+func hasZeroQuality(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if found && strings.EqualFold(name, "q") {
+			value = strings.TrimSpace(value)
+			return strings.Trim(value, "0.") == ""
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireAcceptJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		accept     string
+		wantStatus int
+	}{
+		{"missing", "", http.StatusOK},
+		{"json", "application/json", http.StatusOK},
+		{"json with charset", "application/json; charset=utf-8", http.StatusOK},
+		{"any", "*/*", http.StatusOK},
+		{"any application type", "application/*", http.StatusOK},
+		{"browser default", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", http.StatusOK},
+		{"case insensitive", "Application/JSON", http.StatusOK},
+		{"html only", "text/html", http.StatusNotAcceptable},
+		{"xml only", "application/xml", http.StatusNotAcceptable},
+		{"json refused", "text/html, application/json;q=0", http.StatusNotAcceptable},
+		{"wildcard refused", "*/*;q=0.0", http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(RequireAcceptJSON())
+			router.GET("/messages", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/messages", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusNotAcceptable && !strings.Contains(rec.Body.String(), `"code":"NOT_ACCEPTABLE"`) {
+				t.Errorf("got body %s, want code NOT_ACCEPTABLE", rec.Body.String())
+			}
+		})
+	}
+}
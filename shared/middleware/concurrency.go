@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit creates a middleware that limits the number of requests handled at once.
+// Requests beyond the limit are rejected with 503 and a Retry-After header rather than queued.
+// A maxRequests of 0 or less disables the limit. Requests to exemptPaths (e.g. health probes)
+// are never limited.
+func ConcurrencyLimit(maxRequests int, exemptPaths ...string) gin.HandlerFunc {
+	if maxRequests <= 0 {
+		return func(ctx *gin.Context) {
+			ctx.Next()
+		}
+	}
+
+	semaphore := make(chan struct{}, maxRequests)
+
+	return func(ctx *gin.Context) {
+		if slices.Contains(exemptPaths, ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+
+		select {
+		case semaphore <- struct{}{}:
+			defer func() { <-semaphore }()
+			ctx.Next()
+		default:
+			ctx.Header("Retry-After", "1")
+			httputil.RespondError(ctx, http.StatusServiceUnavailable, "OVERLOADED", "Server is overloaded, please retry")
+			ctx.Abort()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const limit = 3
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(ConcurrencyLimit(limit, "/health"))
+	router.GET("/messages", func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Fill every slot with a request that blocks until released
+	var wg sync.WaitGroup
+	held := make([]*httptest.ResponseRecorder, limit)
+	for i := range held {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			held[i] = serve("/messages")
+		}()
+		<-entered
+	}
+
+	// Requests over the limit are shed, not queued
+	for range 2 {
+		rec := serve("/messages")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("over the limit: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Error("over the limit: no Retry-After header")
+		}
+		if !strings.Contains(rec.Body.String(), `"code":"OVERLOADED"`) {
+			t.Errorf("over the limit: got body %s, want code OVERLOADED", rec.Body.String())
+		}
+	}
+
+	// Probes are exempt even while the limit is reached
+	if rec := serve("/health"); rec.Code != http.StatusOK {
+		t.Errorf("health probe at the limit: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, rec := range held {
+		if rec.Code != http.StatusOK {
+			t.Errorf("held request %d: got status %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+
+	// Finished requests free their slots
+	go func() { <-entered }()
+	if rec := serve("/messages"); rec.Code != http.StatusOK {
+		t.Errorf("after release: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestConcurrencyLimitDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(ConcurrencyLimit(0))
+	router.GET("/messages", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// CORSPolicy is the CORS policy of a group of routes, such as the public auth endpoints
+type CORSPolicy struct {
+	// PathPrefix selects the routes the policy applies to: the path itself and everything below
+	// it. "/" applies to every route that no more specific policy covers.
+	PathPrefix   string
+	AllowMethods []string
+	AllowHeaders []string
+
+	// ExposeHeaders are the response headers scripts may read, in addition to Content-Length
+	ExposeHeaders []string
+}
+
+// CORSConfig builds the cors.Config of a policy for the given allowed origins. Credentials are
+// allowed and Content-Length is exposed for every policy.
+func CORSConfig(origins []string, policy CORSPolicy) cors.Config {
+	config := cors.DefaultConfig()
+	config.AllowOrigins = origins
+	config.AllowMethods = policy.AllowMethods
+	config.AllowHeaders = policy.AllowHeaders
+	config.ExposeHeaders = append([]string{"Content-Length"}, policy.ExposeHeaders...)
+	config.AllowCredentials = true
+	return config
+}
+
+// CORS creates a middleware that applies to each request the policy with the longest PathPrefix
+// covering its path. Preflight OPTIONS requests never reach a route, so the policy is chosen by
+// path rather than attached to a route group. Requests no policy covers get no CORS headers.
+func CORS(origins []string, policies ...CORSPolicy) gin.HandlerFunc {
+	policies = append([]CORSPolicy(nil), policies...)
+	sort.SliceStable(policies, func(i, j int) bool {
+		return len(policies[i].PathPrefix) > len(policies[j].PathPrefix)
+	})
+	handlers := make([]gin.HandlerFunc, len(policies))
+	for i, policy := range policies {
+		handlers[i] = cors.New(CORSConfig(origins, policy))
+	}
+
+	return func(ctx *gin.Context) {
+		for i, policy := range policies {
+			if pathUnder(ctx.Request.URL.Path, policy.PathPrefix) {
+				handlers[i](ctx)
+				return
+			}
+		}
+	}
+}
+
+// pathUnder reports whether path is prefix or below it, matching whole path segments only
+func pathUnder(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
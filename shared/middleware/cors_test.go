@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSSelectsPolicyByPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(CORS([]string{"https://app.example.com"},
+		CORSPolicy{PathPrefix: "/", AllowMethods: []string{"GET", "POST"}, AllowHeaders: []string{"Content-Type"}},
+		CORSPolicy{PathPrefix: "/auth", AllowMethods: []string{"POST"}, AllowHeaders: []string{"Content-Type"}},
+		CORSPolicy{PathPrefix: "/users/", AllowMethods: []string{"GET", "PUT", "DELETE"}, AllowHeaders: []string{"Authorization"}},
+	))
+
+	tests := []struct {
+		path        string
+		wantMethods string
+		wantHeaders string
+	}{
+		{"/auth/login", "POST", "Content-Type"},
+		{"/auth", "POST", "Content-Type"},
+		{"/users/me", "GET,PUT,DELETE", "Authorization"},
+		{"/authors", "GET,POST", "Content-Type"},
+		{"/health", "GET,POST", "Content-Type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("got status %d, want 204", rec.Code)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Headers"); got != tt.wantHeaders {
+				t.Errorf("Access-Control-Allow-Headers = %q, want %q", got, tt.wantHeaders)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireHTTPS creates a middleware that rejects requests the client did not make over HTTPS.
+// Behind a load balancer that terminates TLS the scheme is taken from the first value of
+// X-Forwarded-Proto. Non-HTTPS requests get a 400, or a 301 to the HTTPS URL when redirect is
+// set. Requests to exemptPaths (e.g. health probes, which the load balancer makes over HTTP)
+// are always let through.
+func RequireHTTPS(redirect bool, exemptPaths ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if isHTTPS(ctx.Request) || slices.Contains(exemptPaths, ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+
+		if redirect {
+			ctx.Redirect(http.StatusMovedPermanently, "https://"+ctx.Request.Host+ctx.Request.URL.RequestURI())
+			ctx.Abort()
+			return
+		}
+
+		httputil.RespondError(ctx, http.StatusBadRequest, "HTTPS_REQUIRED", "HTTPS is required")
+		ctx.Abort()
+	}
+}
+
+// isHTTPS reports whether the client made the request over HTTPS
+func isHTTPS(req *http.Request) bool {
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme, _, _ := strings.Cut(proto, ",")
+		return strings.EqualFold(strings.TrimSpace(scheme), "https")
+	}
+	return req.TLS != nil
+}
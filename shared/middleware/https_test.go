@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireHTTPS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		redirect     bool
+		path         string
+		proto        string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"forwarded https", false, "/messages", "https", http.StatusOK, ""},
+		{"forwarded http rejected", false, "/messages", "http", http.StatusBadRequest, ""},
+		{"forwarded http redirected", true, "/messages?limit=5", "http", http.StatusMovedPermanently, "https://api.example.com/messages?limit=5"},
+		{"first of several proxies", false, "/messages", "http, https", http.StatusBadRequest, ""},
+		{"plain http without header", false, "/messages", "", http.StatusBadRequest, ""},
+		{"health probe exempt", false, "/health", "http", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(RequireHTTPS(tt.redirect, "/health"))
+			router.GET("/messages", func(c *gin.Context) { c.Status(http.StatusOK) })
+			router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "http://api.example.com"+tt.path, nil)
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if location := rec.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("got Location %q, want %q", location, tt.wantLocation)
+			}
+		})
+	}
+}
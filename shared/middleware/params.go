@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMaxPathParamLength is the default maximum length of a single path parameter.
+// It comfortably fits the longest valid email address (254 characters).
+const DefaultMaxPathParamLength = 256
+
+// MaxPathParamLength creates a middleware that rejects requests whose path parameters
+// exceed maxLength characters, before they reach logging or storage.
+func MaxPathParamLength(maxLength int) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		for _, param := range ctx.Params {
+			if len(param.Value) > maxLength {
+				httputil.RespondError(ctx, http.StatusBadRequest, "PARAM_TOO_LONG", "Path parameter '"+param.Key+"' is too long")
+				ctx.Abort()
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
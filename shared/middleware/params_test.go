@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaxPathParamLength(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		email      string
+		wantStatus int
+	}{
+		{"short", "ada@example.com", http.StatusOK},
+		{"at the limit", strings.Repeat("a", DefaultMaxPathParamLength), http.StatusOK},
+		{"over the limit", strings.Repeat("a", DefaultMaxPathParamLength+1), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			router := gin.New()
+			router.Use(MaxPathParamLength(DefaultMaxPathParamLength))
+			router.GET("/users/:email", func(c *gin.Context) {
+				reached = true
+				c.Status(http.StatusOK)
+			})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/"+tt.email, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if reached != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler reached = %v", reached)
+			}
+			if tt.wantStatus == http.StatusBadRequest && !strings.Contains(rec.Body.String(), `"code":"PARAM_TOO_LONG"`) {
+				t.Errorf("got body %s, want code PARAM_TOO_LONG", rec.Body.String())
+			}
+		})
+	}
+}
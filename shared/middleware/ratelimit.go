@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterSweepInterval is how often idle buckets are dropped from a RateLimiter
+const rateLimiterSweepInterval = time.Minute
+
+// RateLimiter keeps a token bucket per key, such as a user or client IP
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mutex     sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+type rateBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perMinute requests a minute per key, with bursts of
+// up to burst requests. It returns nil, which allows everything, when perMinute is 0 or less.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &RateLimiter{
+		limit:     rate.Every(time.Minute / time.Duration(perMinute)),
+		burst:     burst,
+		buckets:   make(map[string]*rateBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow reports whether a request for key may proceed now. When it may not, it also returns
+// how long until it would.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = bucket
+	}
+	bucket.lastSeen = now
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep drops the buckets that have been idle long enough to refill, since a fresh bucket
+// behaves the same. It runs at most once per rateLimiterSweepInterval.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimiterSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimit creates a middleware that rejects requests over their rate limit with 429 and a
+// Retry-After header. Requests for which subject returns a user (authenticated requests) are
+// counted against that user in users, so that clients sharing an IP behind NAT do not share a
+// limit; other requests are counted against the client IP in ips. A nil limiter allows
+// everything. It must run after authentication for the subject to be known.
+func RateLimit(users, ips *RateLimiter, subject func(*gin.Context) (string, bool)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limiter, key := ips, "ip:"+ctx.ClientIP()
+		if sub, ok := subject(ctx); ok && sub != "" {
+			limiter, key = users, "sub:"+sub
+		}
+
+		if allowed, retryAfter := limiter.Allow(key); !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			ctx.Header("Retry-After", strconv.Itoa(seconds))
+			httputil.RespondError(ctx, http.StatusTooManyRequests, "RATE_LIMITED", "Too many requests, please retry later")
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitBySubject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Stand in for the JWT middleware, which puts the caller's sub in the context
+	authenticate := func(c *gin.Context) {
+		if sub := c.GetHeader("X-Test-Sub"); sub != "" {
+			c.Set("user_sub", sub)
+		}
+	}
+	subject := func(c *gin.Context) (string, bool) { return c.GetString("user_sub"), c.GetString("user_sub") != "" }
+
+	router := gin.New()
+	router.GET("/messages", authenticate, RateLimit(NewRateLimiter(1, 2), NewRateLimiter(1, 3), subject),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/messages", nil)
+		req.RemoteAddr = "203.0.113.7:1234" // every client shares one IP
+		if sub != "" {
+			req.Header.Set("X-Test-Sub", sub)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// alice uses up her burst of 2 and is limited
+	for i := 0; i < 2; i++ {
+		if rec := request("alice"); rec.Code != http.StatusOK {
+			t.Fatalf("alice request %d: got status %d, want 200", i+1, rec.Code)
+		}
+	}
+	rec := request("alice")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("alice over the limit: got status %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	// bob shares alice's IP but has a limit of his own
+	for i := 0; i < 2; i++ {
+		if rec := request("bob"); rec.Code != http.StatusOK {
+			t.Fatalf("bob request %d: got status %d, want 200", i+1, rec.Code)
+		}
+	}
+	if rec := request("bob"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("bob over the limit: got status %d, want 429", rec.Code)
+	}
+
+	// Unauthenticated requests from the same IP fall back to the IP limit, untouched so far
+	for i := 0; i < 3; i++ {
+		if rec := request(""); rec.Code != http.StatusOK {
+			t.Fatalf("anonymous request %d: got status %d, want 200", i+1, rec.Code)
+		}
+	}
+	if rec := request(""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("anonymous over the limit: got status %d, want 429", rec.Code)
+	}
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	// Only the ALB, inside the VPC, may report the client IP
+	if err := router.SetTrustedProxies([]string{"10.0.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	anonymous := func(*gin.Context) (string, bool) { return "", false }
+	router.GET("/messages", RateLimit(nil, NewRateLimiter(1, 2), anonymous),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/messages", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A client connecting directly uses up its burst, then cannot reset its limit by claiming
+	// to be someone else
+	for i := 0; i < 2; i++ {
+		if code := request("203.0.113.7:1234", ""); code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want 200", i+1, code)
+		}
+	}
+	for i, spoofed := range []string{"198.51.100.1", "198.51.100.2", "10.0.1.5, 198.51.100.3"} {
+		if code := request("203.0.113.7:1234", spoofed); code != http.StatusTooManyRequests {
+			t.Errorf("spoofed request %d: got status %d, want 429", i+1, code)
+		}
+	}
+
+	// Behind the ALB, clients are told apart by the address it reports
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		if code := request("10.0.1.5:4321", client); code != http.StatusOK {
+			t.Errorf("%s via the ALB: got status %d, want 200", client, code)
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/messages", RateLimit(NewRateLimiter(0, 0), nil, func(*gin.Context) (string, bool) { return "alice", true }),
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 50; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want 200", i+1, rec.Code)
+		}
+	}
+}
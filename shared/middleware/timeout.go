@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestTimeout creates a middleware that attaches a deadline to every request's context.
+// Handlers pass c.Request.Context() to the stores and Cognito, so their calls are cancelled once
+// the deadline passes. A server error the handler reports after that is sent as 504 Gateway
+// Timeout by httputil.RespondError, and a handler that writes nothing gets one here.
+// A timeout of 0 or less disables the deadline.
+func RequestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if timeout <= 0 {
+			ctx.Next()
+			return
+		}
+
+		timeoutCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(timeoutCtx)
+
+		ctx.Next()
+
+		if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && !ctx.Writer.Written() {
+			httputil.RespondError(ctx, http.StatusGatewayTimeout, "TIMEOUT", "Request timed out")
+			ctx.Abort()
+		}
+	}
+}
@@ -11,6 +11,10 @@ func main() {
 	// Load configuration from environment variables
 	cfg := config.NewConfig()
 
+	// Record the configuration in effect
+	cfg.LogEffective()
+	cfg.Features.LogEnabledFeatures()
+
 	// Create and initialize the server
 	server, err := usersvc.NewServer(cfg)
 	if err != nil {
@@ -2,13 +2,19 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws_e2e_test/shared/awsutil"
+	"github.com/aws_e2e_test/shared/httputil"
 	"github.com/aws_e2e_test/usersvc/internal/model"
 )
 
@@ -44,6 +50,12 @@ func NewCognitoClient(region, userPoolID, userPoolClientID string) (*CognitoClie
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
+	// Fail at startup rather than on the first call if there are no credentials
+	if err := awsutil.CheckCredentials(context.TODO(), cfg); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+
 	// Create Cognito client
 	client := cognitoidentityprovider.NewFromConfig(cfg)
 
@@ -54,8 +66,8 @@ func NewCognitoClient(region, userPoolID, userPoolClientID string) (*CognitoClie
 	}, nil
 }
 
-// SignUp registers a new user with Cognito
-func (c *CognitoClient) SignUp(email, password, firstName, lastName string) error {
+// SignUp registers a new user with Cognito and returns the new user's sub
+func (c *CognitoClient) SignUp(ctx context.Context, email, password, firstName, lastName string) (string, error) {
 	log.Printf("Signing up user with email: %s", email)
 
 	// Create the sign-up request
@@ -80,18 +92,18 @@ func (c *CognitoClient) SignUp(email, password, firstName, lastName string) erro
 	}
 
 	// Call Cognito to sign up the user
-	_, err := c.client.SignUp(context.TODO(), input)
+	result, err := c.client.SignUp(ctx, input)
 	if err != nil {
 		log.Printf("Failed to sign up user: %v", err)
-		return fmt.Errorf("failed to sign up user: %w", err)
+		return "", fmt.Errorf("failed to sign up user: %w", err)
 	}
 
 	log.Printf("Successfully signed up user with email: %s", email)
-	return nil
+	return aws.ToString(result.UserSub), nil
 }
 
 // ConfirmSignUp confirms a user's registration with the confirmation code
-func (c *CognitoClient) ConfirmSignUp(email, confirmationCode string) error {
+func (c *CognitoClient) ConfirmSignUp(ctx context.Context, email, confirmationCode string) error {
 	log.Printf("Confirming sign up for user with email: %s", email)
 
 	// Create the confirm sign-up request
@@ -102,7 +114,7 @@ func (c *CognitoClient) ConfirmSignUp(email, confirmationCode string) error {
 	}
 
 	// Call Cognito to confirm the user
-	_, err := c.client.ConfirmSignUp(context.TODO(), input)
+	_, err := c.client.ConfirmSignUp(ctx, input)
 	if err != nil {
 		log.Printf("Failed to confirm sign up: %v", err)
 		return fmt.Errorf("failed to confirm sign up: %w", err)
@@ -113,7 +125,7 @@ func (c *CognitoClient) ConfirmSignUp(email, confirmationCode string) error {
 }
 
 // ResendConfirmationCode resends the confirmation code to the user
-func (c *CognitoClient) ResendConfirmationCode(email string) error {
+func (c *CognitoClient) ResendConfirmationCode(ctx context.Context, email string) error {
 	log.Printf("Resending confirmation code for user with email: %s", email)
 
 	// Create the resend confirmation code request
@@ -123,7 +135,7 @@ func (c *CognitoClient) ResendConfirmationCode(email string) error {
 	}
 
 	// Call Cognito to resend the confirmation code
-	_, err := c.client.ResendConfirmationCode(context.TODO(), input)
+	_, err := c.client.ResendConfirmationCode(ctx, input)
 	if err != nil {
 		log.Printf("Failed to resend confirmation code: %v", err)
 		return fmt.Errorf("failed to resend confirmation code: %w", err)
@@ -134,7 +146,7 @@ func (c *CognitoClient) ResendConfirmationCode(email string) error {
 }
 
 // Login authenticates a user and returns the authentication tokens
-func (c *CognitoClient) Login(email, password string) (*model.AuthResponse, error) {
+func (c *CognitoClient) Login(ctx context.Context, email, password string) (*model.AuthResponse, error) {
 	log.Printf("Logging in user with email: %s", email)
 
 	// Create the authentication request
@@ -148,7 +160,7 @@ func (c *CognitoClient) Login(email, password string) (*model.AuthResponse, erro
 	}
 
 	// Call Cognito to authenticate the user
-	result, err := c.client.InitiateAuth(context.TODO(), input)
+	result, err := c.client.InitiateAuth(ctx, input)
 	if err != nil {
 		log.Printf("Failed to authenticate user: %v", err)
 		return nil, fmt.Errorf("failed to authenticate user: %w", err)
@@ -175,7 +187,7 @@ func (c *CognitoClient) Login(email, password string) (*model.AuthResponse, erro
 }
 
 // RefreshToken refreshes the authentication tokens
-func (c *CognitoClient) RefreshToken(refreshToken string) (*model.AuthResponse, error) {
+func (c *CognitoClient) RefreshToken(ctx context.Context, refreshToken string) (*model.AuthResponse, error) {
 	log.Printf("Refreshing authentication tokens")
 
 	// Create the refresh token request
@@ -188,7 +200,7 @@ func (c *CognitoClient) RefreshToken(refreshToken string) (*model.AuthResponse,
 	}
 
 	// Call Cognito to refresh the tokens
-	result, err := c.client.InitiateAuth(context.TODO(), input)
+	result, err := c.client.InitiateAuth(ctx, input)
 	if err != nil {
 		log.Printf("Failed to refresh tokens: %v", err)
 		return nil, fmt.Errorf("failed to refresh tokens: %w", err)
@@ -221,7 +233,7 @@ func (c *CognitoClient) RefreshToken(refreshToken string) (*model.AuthResponse,
 }
 
 // ForgotPassword initiates the forgot password flow
-func (c *CognitoClient) ForgotPassword(email string) error {
+func (c *CognitoClient) ForgotPassword(ctx context.Context, email string) error {
 	log.Printf("Initiating forgot password flow for user with email: %s", email)
 
 	// Create the forgot password request
@@ -231,7 +243,7 @@ func (c *CognitoClient) ForgotPassword(email string) error {
 	}
 
 	// Call Cognito to initiate the forgot password flow
-	_, err := c.client.ForgotPassword(context.TODO(), input)
+	_, err := c.client.ForgotPassword(ctx, input)
 	if err != nil {
 		log.Printf("Failed to initiate forgot password flow: %v", err)
 		return fmt.Errorf("failed to initiate forgot password flow: %w", err)
@@ -242,7 +254,7 @@ func (c *CognitoClient) ForgotPassword(email string) error {
 }
 
 // ConfirmForgotPassword completes the forgot password flow
-func (c *CognitoClient) ConfirmForgotPassword(email, confirmationCode, newPassword string) error {
+func (c *CognitoClient) ConfirmForgotPassword(ctx context.Context, email, confirmationCode, newPassword string) error {
 	log.Printf("Confirming forgot password for user with email: %s", email)
 
 	// Create the confirm forgot password request
@@ -254,7 +266,7 @@ func (c *CognitoClient) ConfirmForgotPassword(email, confirmationCode, newPasswo
 	}
 
 	// Call Cognito to confirm the forgot password
-	_, err := c.client.ConfirmForgotPassword(context.TODO(), input)
+	_, err := c.client.ConfirmForgotPassword(ctx, input)
 	if err != nil {
 		log.Printf("Failed to confirm forgot password: %v", err)
 		return fmt.Errorf("failed to confirm forgot password: %w", err)
@@ -265,7 +277,7 @@ func (c *CognitoClient) ConfirmForgotPassword(email, confirmationCode, newPasswo
 }
 
 // ChangePassword changes the password for an authenticated user
-func (c *CognitoClient) ChangePassword(accessToken, oldPassword, newPassword string) error {
+func (c *CognitoClient) ChangePassword(ctx context.Context, accessToken, oldPassword, newPassword string) error {
 	log.Printf("Changing password for authenticated user")
 
 	// Create the change password request
@@ -276,7 +288,7 @@ func (c *CognitoClient) ChangePassword(accessToken, oldPassword, newPassword str
 	}
 
 	// Call Cognito to change the password
-	_, err := c.client.ChangePassword(context.TODO(), input)
+	_, err := c.client.ChangePassword(ctx, input)
 	if err != nil {
 		log.Printf("Failed to change password: %v", err)
 		return fmt.Errorf("failed to change password: %w", err)
@@ -287,7 +299,7 @@ func (c *CognitoClient) ChangePassword(accessToken, oldPassword, newPassword str
 }
 
 // GetUser gets the user attributes for an authenticated user
-func (c *CognitoClient) GetUser(accessToken string) (map[string]string, error) {
+func (c *CognitoClient) GetUser(ctx context.Context, accessToken string) (map[string]string, error) {
 	log.Printf("Getting user attributes for authenticated user")
 
 	// Create the get user request
@@ -296,7 +308,7 @@ func (c *CognitoClient) GetUser(accessToken string) (map[string]string, error) {
 	}
 
 	// Call Cognito to get the user
-	result, err := c.client.GetUser(context.TODO(), input)
+	result, err := c.client.GetUser(ctx, input)
 	if err != nil {
 		log.Printf("Failed to get user: %v", err)
 		return nil, fmt.Errorf("failed to get user: %w", err)
@@ -313,7 +325,7 @@ func (c *CognitoClient) GetUser(accessToken string) (map[string]string, error) {
 }
 
 // UpdateUserAttributes updates the user attributes for an authenticated user
-func (c *CognitoClient) UpdateUserAttributes(accessToken string, attributes map[string]string) error {
+func (c *CognitoClient) UpdateUserAttributes(ctx context.Context, accessToken string, attributes map[string]string) error {
 	log.Printf("Updating user attributes for authenticated user")
 
 	// Convert the attributes to the Cognito format
@@ -332,7 +344,7 @@ func (c *CognitoClient) UpdateUserAttributes(accessToken string, attributes map[
 	}
 
 	// Call Cognito to update the user attributes
-	_, err := c.client.UpdateUserAttributes(context.TODO(), input)
+	_, err := c.client.UpdateUserAttributes(ctx, input)
 	if err != nil {
 		log.Printf("Failed to update user attributes: %v", err)
 		return fmt.Errorf("failed to update user attributes: %w", err)
@@ -342,8 +354,102 @@ func (c *CognitoClient) UpdateUserAttributes(accessToken string, attributes map[
 	return nil
 }
 
+// AdminUpdateUserAttributes updates the user attributes of any user as an administrator
+func (c *CognitoClient) AdminUpdateUserAttributes(ctx context.Context, email string, attributes map[string]string) error {
+	log.Printf("Updating user attributes for user with email: %s as administrator", email)
+
+	// Convert the attributes to the Cognito format
+	userAttributes := make([]types.AttributeType, 0, len(attributes))
+	for name, value := range attributes {
+		userAttributes = append(userAttributes, types.AttributeType{
+			Name:  aws.String(name),
+			Value: aws.String(value),
+		})
+	}
+
+	// Create the admin update user attributes request
+	input := &cognitoidentityprovider.AdminUpdateUserAttributesInput{
+		UserPoolId:     aws.String(c.userPoolID),
+		Username:       aws.String(email),
+		UserAttributes: userAttributes,
+	}
+
+	// Call Cognito to update the user attributes
+	_, err := c.client.AdminUpdateUserAttributes(ctx, input)
+	if err != nil {
+		log.Printf("Failed to update user attributes: %v", err)
+		return fmt.Errorf("failed to update user attributes: %w", err)
+	}
+
+	log.Printf("Successfully updated user attributes for user with email: %s", email)
+	return nil
+}
+
+// ChangeEmail starts changing the authenticated user's email. Cognito sends a verification
+// code to the new address, and keeps the old one until VerifyEmail is called with that code
+// (when the user pool keeps original attribute values while updates are pending).
+func (c *CognitoClient) ChangeEmail(ctx context.Context, accessToken, newEmail string) (*model.CodeDelivery, error) {
+	log.Printf("Changing email for authenticated user")
+
+	// Create the update user attributes request
+	input := &cognitoidentityprovider.UpdateUserAttributesInput{
+		AccessToken: aws.String(accessToken),
+		UserAttributes: []types.AttributeType{
+			{Name: aws.String("email"), Value: aws.String(newEmail)},
+		},
+	}
+
+	// Call Cognito to update the email
+	result, err := c.client.UpdateUserAttributes(ctx, input)
+	if err != nil {
+		log.Printf("Failed to change email: %v", err)
+		return nil, fmt.Errorf("failed to change email: %w", err)
+	}
+
+	// Report where the verification code was sent
+	delivery := &model.CodeDelivery{AttributeName: "email"}
+	for _, details := range result.CodeDeliveryDetailsList {
+		if aws.ToString(details.AttributeName) == "email" {
+			delivery.Destination = aws.ToString(details.Destination)
+			delivery.DeliveryMedium = string(details.DeliveryMedium)
+		}
+	}
+
+	log.Printf("Successfully requested email change for authenticated user")
+	return delivery, nil
+}
+
+// VerifyEmail confirms the authenticated user's email change with the code sent to the new
+// address, and returns the email Cognito now has on record
+func (c *CognitoClient) VerifyEmail(ctx context.Context, accessToken, code string) (string, error) {
+	log.Printf("Verifying email for authenticated user")
+
+	// Create the verify user attribute request
+	input := &cognitoidentityprovider.VerifyUserAttributeInput{
+		AccessToken:   aws.String(accessToken),
+		AttributeName: aws.String("email"),
+		Code:          aws.String(code),
+	}
+
+	// Call Cognito to verify the email
+	_, err := c.client.VerifyUserAttribute(ctx, input)
+	if err != nil {
+		log.Printf("Failed to verify email: %v", err)
+		return "", fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	// Read the verified email back, since the request does not carry it
+	attributes, err := c.GetUser(ctx, accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Successfully verified email for authenticated user")
+	return attributes["email"], nil
+}
+
 // DeleteUser deletes the authenticated user
-func (c *CognitoClient) DeleteUser(accessToken string) error {
+func (c *CognitoClient) DeleteUser(ctx context.Context, accessToken string) error {
 	log.Printf("Deleting authenticated user")
 
 	// Create the delete user request
@@ -352,7 +458,7 @@ func (c *CognitoClient) DeleteUser(accessToken string) error {
 	}
 
 	// Call Cognito to delete the user
-	_, err := c.client.DeleteUser(context.TODO(), input)
+	_, err := c.client.DeleteUser(ctx, input)
 	if err != nil {
 		log.Printf("Failed to delete user: %v", err)
 		return fmt.Errorf("failed to delete user: %w", err)
@@ -363,7 +469,7 @@ func (c *CognitoClient) DeleteUser(accessToken string) error {
 }
 
 // AdminDeleteUser deletes a user as an administrator
-func (c *CognitoClient) AdminDeleteUser(email string) error {
+func (c *CognitoClient) AdminDeleteUser(ctx context.Context, email string) error {
 	log.Printf("Deleting user with email: %s as administrator", email)
 
 	// Create the admin delete user request
@@ -373,7 +479,7 @@ func (c *CognitoClient) AdminDeleteUser(email string) error {
 	}
 
 	// Call Cognito to delete the user
-	_, err := c.client.AdminDeleteUser(context.TODO(), input)
+	_, err := c.client.AdminDeleteUser(ctx, input)
 	if err != nil {
 		log.Printf("Failed to delete user: %v", err)
 		return fmt.Errorf("failed to delete user: %w", err)
@@ -382,3 +488,232 @@ func (c *CognitoClient) AdminDeleteUser(email string) error {
 	log.Printf("Successfully deleted user with email: %s", email)
 	return nil
 }
+
+// ResendInvitation re-sends the invitation email for a user created with AdminCreateUser,
+// without resetting the user's temporary password
+func (c *CognitoClient) ResendInvitation(ctx context.Context, email string) error {
+	log.Printf("Resending invitation for user with email: %s", email)
+
+	// Create the admin create user request with the RESEND action
+	input := &cognitoidentityprovider.AdminCreateUserInput{
+		UserPoolId:    aws.String(c.userPoolID),
+		Username:      aws.String(email),
+		MessageAction: types.MessageActionTypeResend,
+	}
+
+	// Call Cognito to resend the invitation
+	_, err := c.client.AdminCreateUser(ctx, input)
+	if err != nil {
+		log.Printf("Failed to resend invitation: %v", err)
+		return fmt.Errorf("failed to resend invitation: %w", err)
+	}
+
+	log.Printf("Successfully resent invitation for user with email: %s", email)
+	return nil
+}
+
+// AdminGetUser gets a user's attributes and account state as an administrator. Besides the user
+// attributes, the result contains "enabled", "userStatus" and "lastModified" (RFC 3339).
+// It returns nil if the user does not exist in the user pool.
+func (c *CognitoClient) AdminGetUser(ctx context.Context, email string) (map[string]string, error) {
+	log.Printf("Getting user with email: %s as administrator", email)
+
+	// Create the admin get user request
+	input := &cognitoidentityprovider.AdminGetUserInput{
+		UserPoolId: aws.String(c.userPoolID),
+		Username:   aws.String(email),
+	}
+
+	// Call Cognito to get the user
+	result, err := c.client.AdminGetUser(ctx, input)
+	if err != nil {
+		var notFoundErr *types.UserNotFoundException
+		if errors.As(err, &notFoundErr) {
+			log.Printf("User with email %s not found in user pool", email)
+			return nil, nil
+		}
+
+		log.Printf("Failed to get user: %v", err)
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// Extract the user attributes and account state
+	attributes := make(map[string]string)
+	for _, attr := range result.UserAttributes {
+		attributes[aws.ToString(attr.Name)] = aws.ToString(attr.Value)
+	}
+	attributes["enabled"] = strconv.FormatBool(result.Enabled)
+	attributes["userStatus"] = string(result.UserStatus)
+	if result.UserLastModifiedDate != nil {
+		attributes["lastModified"] = result.UserLastModifiedDate.Format(time.RFC3339)
+	}
+
+	log.Printf("Successfully got user with email: %s", email)
+	return attributes, nil
+}
+
+// AdminListUsers lists up to limit user pool accounts as an administrator, starting at
+// paginationToken (empty for the first page). It returns the token for the next page, which is
+// empty after the last page.
+func (c *CognitoClient) AdminListUsers(ctx context.Context, limit int32, paginationToken string) ([]model.CognitoUser, string, error) {
+	log.Printf("Listing up to %d users as administrator", limit)
+
+	// Create the list users request
+	input := &cognitoidentityprovider.ListUsersInput{
+		UserPoolId: aws.String(c.userPoolID),
+		Limit:      aws.Int32(limit),
+	}
+	if paginationToken != "" {
+		input.PaginationToken = aws.String(paginationToken)
+	}
+
+	// Call Cognito to list the users
+	result, err := c.client.ListUsers(ctx, input)
+	if err != nil {
+		log.Printf("Failed to list users: %v", err)
+		return nil, "", fmt.Errorf("failed to list users: %w", err)
+	}
+
+	users, nextToken := cognitoUsersFromOutput(result)
+	log.Printf("Successfully listed %d users", len(users))
+	return users, nextToken, nil
+}
+
+// cognitoUsersFromOutput converts a ListUsers result into typed users and the next page's token
+func cognitoUsersFromOutput(output *cognitoidentityprovider.ListUsersOutput) ([]model.CognitoUser, string) {
+	users := make([]model.CognitoUser, 0, len(output.Users))
+	for _, userType := range output.Users {
+		user := model.CognitoUser{
+			Email:   aws.ToString(userType.Username),
+			Status:  string(userType.UserStatus),
+			Enabled: userType.Enabled,
+		}
+		for _, attr := range userType.Attributes {
+			switch aws.ToString(attr.Name) {
+			case "email":
+				user.Email = aws.ToString(attr.Value)
+			case "sub":
+				user.Sub = aws.ToString(attr.Value)
+			}
+		}
+		if userType.UserCreateDate != nil {
+			user.Created = httputil.Timestamp(*userType.UserCreateDate)
+		}
+		users = append(users, user)
+	}
+	return users, aws.ToString(output.PaginationToken)
+}
+
+// GetUserMFAStatus reports whether the authenticated user has MFA enabled and, if so, the
+// preferred method ("totp" or "sms")
+func (c *CognitoClient) GetUserMFAStatus(ctx context.Context, accessToken string) (bool, string, error) {
+	log.Printf("Getting MFA status for authenticated user")
+
+	// Create the get user request
+	input := &cognitoidentityprovider.GetUserInput{
+		AccessToken: aws.String(accessToken),
+	}
+
+	// Call Cognito to get the user, which includes their MFA settings
+	result, err := c.client.GetUser(ctx, input)
+	if err != nil {
+		log.Printf("Failed to get user: %v", err)
+		return false, "", fmt.Errorf("failed to get user: %w", err)
+	}
+
+	enabled, method := mfaStatusFromOutput(result)
+	log.Printf("Successfully got MFA status for authenticated user")
+	return enabled, method, nil
+}
+
+// mfaStatusFromOutput extracts whether MFA is enabled, and the preferred method, from a GetUser
+// result. Users who enabled SMS MFA through the legacy MFA options have no MFA setting list.
+func mfaStatusFromOutput(output *cognitoidentityprovider.GetUserOutput) (bool, string) {
+	settings := output.UserMFASettingList
+	if len(settings) == 0 {
+		for _, option := range output.MFAOptions {
+			if option.DeliveryMedium == types.DeliveryMediumTypeSms {
+				settings = append(settings, "SMS_MFA")
+			}
+		}
+	}
+	if len(settings) == 0 {
+		return false, ""
+	}
+
+	preferred := aws.ToString(output.PreferredMfaSetting)
+	if preferred == "" {
+		preferred = settings[0]
+	}
+	switch preferred {
+	case "SOFTWARE_TOKEN_MFA":
+		return true, "totp"
+	case "SMS_MFA":
+		return true, "sms"
+	}
+	return true, strings.ToLower(preferred)
+}
+
+// AssociateSoftwareToken starts TOTP MFA setup for the authenticated user and returns the
+// shared secret to load into an authenticator app
+func (c *CognitoClient) AssociateSoftwareToken(ctx context.Context, accessToken string) (string, error) {
+	log.Printf("Associating software token for authenticated user")
+
+	// Create the associate software token request
+	input := &cognitoidentityprovider.AssociateSoftwareTokenInput{
+		AccessToken: aws.String(accessToken),
+	}
+
+	// Call Cognito to generate the secret
+	result, err := c.client.AssociateSoftwareToken(ctx, input)
+	if err != nil {
+		log.Printf("Failed to associate software token: %v", err)
+		return "", fmt.Errorf("failed to associate software token: %w", err)
+	}
+
+	log.Printf("Successfully associated software token for authenticated user")
+	return aws.ToString(result.SecretCode), nil
+}
+
+// VerifySoftwareToken completes TOTP MFA setup by checking a code from the authenticator app,
+// then makes TOTP the authenticated user's preferred MFA method
+func (c *CognitoClient) VerifySoftwareToken(ctx context.Context, accessToken, code, deviceName string) error {
+	log.Printf("Verifying software token for authenticated user")
+
+	// Create the verify software token request
+	input := &cognitoidentityprovider.VerifySoftwareTokenInput{
+		AccessToken: aws.String(accessToken),
+		UserCode:    aws.String(code),
+	}
+	if deviceName != "" {
+		input.FriendlyDeviceName = aws.String(deviceName)
+	}
+
+	// Call Cognito to verify the code
+	result, err := c.client.VerifySoftwareToken(ctx, input)
+	if err != nil {
+		log.Printf("Failed to verify software token: %v", err)
+		return fmt.Errorf("failed to verify software token: %w", err)
+	}
+	if result.Status != types.VerifySoftwareTokenResponseTypeSuccess {
+		log.Printf("Software token verification returned status %s", result.Status)
+		return fmt.Errorf("failed to verify software token: %w",
+			&types.EnableSoftwareTokenMFAException{Message: aws.String("Code mismatch")})
+	}
+
+	// A verified token does not turn MFA on by itself
+	_, err = c.client.SetUserMFAPreference(ctx, &cognitoidentityprovider.SetUserMFAPreferenceInput{
+		AccessToken: aws.String(accessToken),
+		SoftwareTokenMfaSettings: &types.SoftwareTokenMfaSettingsType{
+			Enabled:      true,
+			PreferredMfa: true,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to set MFA preference: %v", err)
+		return fmt.Errorf("failed to set MFA preference: %w", err)
+	}
+
+	log.Printf("Successfully enabled TOTP MFA for authenticated user")
+	return nil
+}
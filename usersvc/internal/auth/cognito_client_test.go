@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/aws_e2e_test/usersvc/internal/model"
+)
+
+func TestCognitoUsersFromOutput(t *testing.T) {
+	created := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	output := &cognitoidentityprovider.ListUsersOutput{
+		Users: []types.UserType{
+			{
+				Username:       aws.String("alice@example.com"),
+				UserStatus:     types.UserStatusTypeConfirmed,
+				Enabled:        true,
+				UserCreateDate: aws.Time(created),
+				Attributes: []types.AttributeType{
+					{Name: aws.String("sub"), Value: aws.String("sub-alice")},
+					{Name: aws.String("email"), Value: aws.String("alice@example.com")},
+					{Name: aws.String("given_name"), Value: aws.String("Alice")},
+				},
+			},
+			{
+				Username:   aws.String("bob@example.com"),
+				UserStatus: types.UserStatusTypeForceChangePassword,
+				Enabled:    false,
+				Attributes: []types.AttributeType{
+					{Name: aws.String("sub"), Value: aws.String("sub-bob")},
+				},
+			},
+		},
+		PaginationToken: aws.String("next-page"),
+	}
+
+	users, nextToken := cognitoUsersFromOutput(output)
+
+	want := []model.CognitoUser{
+		{Email: "alice@example.com", Sub: "sub-alice", Status: "CONFIRMED", Enabled: true, Created: httputil.Timestamp(created)},
+		{Email: "bob@example.com", Sub: "sub-bob", Status: "FORCE_CHANGE_PASSWORD", Enabled: false},
+	}
+	if len(users) != len(want) {
+		t.Fatalf("got %d users, want %d", len(users), len(want))
+	}
+	for i := range want {
+		if users[i] != want[i] {
+			t.Errorf("user %d = %+v, want %+v", i, users[i], want[i])
+		}
+	}
+	if nextToken != "next-page" {
+		t.Errorf("next token = %q, want %q", nextToken, "next-page")
+	}
+}
+
+func TestMFAStatusFromOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      *cognitoidentityprovider.GetUserOutput
+		wantEnabled bool
+		wantMethod  string
+	}{
+		{"no MFA", &cognitoidentityprovider.GetUserOutput{}, false, ""},
+		{"TOTP", &cognitoidentityprovider.GetUserOutput{
+			UserMFASettingList:  []string{"SOFTWARE_TOKEN_MFA"},
+			PreferredMfaSetting: aws.String("SOFTWARE_TOKEN_MFA"),
+		}, true, "totp"},
+		{"SMS preferred over TOTP", &cognitoidentityprovider.GetUserOutput{
+			UserMFASettingList:  []string{"SOFTWARE_TOKEN_MFA", "SMS_MFA"},
+			PreferredMfaSetting: aws.String("SMS_MFA"),
+		}, true, "sms"},
+		{"no preference", &cognitoidentityprovider.GetUserOutput{
+			UserMFASettingList: []string{"SOFTWARE_TOKEN_MFA"},
+		}, true, "totp"},
+		{"legacy SMS option", &cognitoidentityprovider.GetUserOutput{
+			MFAOptions: []types.MFAOptionType{{DeliveryMedium: types.DeliveryMediumTypeSms, AttributeName: aws.String("phone_number")}},
+		}, true, "sms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enabled, method := mfaStatusFromOutput(tt.output)
+			if enabled != tt.wantEnabled || method != tt.wantMethod {
+				t.Errorf("got %t %q, want %t %q", enabled, method, tt.wantEnabled, tt.wantMethod)
+			}
+		})
+	}
+}
@@ -3,7 +3,12 @@ package config
 import (
 	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws_e2e_test/shared/awsutil"
 )
 
 // Config represents the application configuration
@@ -11,24 +16,165 @@ type Config struct {
 	// Server configuration
 	ServerAddress string
 
-	// CORS configuration
-	CorsOrigins string
+	// CORS configuration: the allowed origins, and the methods and headers for the public auth
+	// endpoints under /auth, the user endpoints under /users and all other routes
+	CorsOrigins      string
+	CorsAuthMethods  []string
+	CorsAuthHeaders  []string
+	CorsUsersMethods []string
+	CorsUsersHeaders []string
+	CorsMethods      []string
+	CorsHeaders      []string
 
 	// Environment
 	Environment string
 
 	// DynamoDB configuration
-	UseDynamoDB       bool
-	DynamoDBTableName string
+	StorageBackend          string // "memory" or "dynamodb"
+	StoreMetrics            bool
+	DynamoDBTableName       string
+	DynamoDBTablePrefix     string
+	DynamoDBTableSuffix     string
+	DynamoDBAutoCreateTable bool
+	DynamoDBEndpoint        string // local endpoint such as DynamoDB Local; skips the credential check
+	StartupSelfTest         bool
+
+	// Connection pool of the DynamoDB HTTP client. The SDK keeps only 10 idle connections per
+	// host by default, which causes connection churn under bursty load.
+	DynamoDBHTTPClient awsutil.HTTPClientConfig
 
 	// Cognito configuration
 	UserPoolID       string
 	UserPoolClientID string
 	CognitoRegion    string
+
+	// JWT configuration
+	JWTSkipIssuerCheck bool
+	JWKSCABundle       string // PEM file of extra CAs trusted when fetching the JWKS
+	JWKSCacheTTL       time.Duration
+	JWKSStaleOK        bool // Serve expired keys while the JWKS is refreshed in the background
+
+	// How often the JWKS is re-fetched in the background to pick up rotated keys (0 disables)
+	JWKSRefreshInterval time.Duration
+
+	// How long startup waits for the JWKS to become available (0 = don't wait)
+	JWKSWaitTimeout time.Duration
+
+	// Bound on each JWKS fetch, separate from the request's deadline
+	JWKSFetchTimeout time.Duration
+
+	// Authentication required by routes that don't declare it ("required" or "public")
+	DefaultAuth string
+
+	// Reject valid tokens of users whose status is not ACTIVE
+	EnforceUserStatus bool
+
+	// JSON representation of timestamps ("rfc3339" or "epoch_millis")
+	TimestampFormat string
+
+	// Casing of object keys in JSON responses ("camel", "snake" or "pascal")
+	JSONCase string
+
+	// Reject signup, create and update bodies with fields the endpoint does not know
+	StrictJSON bool
+
+	// Length string attribute values are truncated to in logged DynamoDB items
+	LogMaxFieldLen int
+
+	// Soft-deleted users are purged once deleted for longer than UserPurgeAfter, by
+	// POST /admin/users/purge and, if UserPurgeInterval is set, on a timer
+	UserPurgeAfter    time.Duration
+	UserPurgeInterval time.Duration
+
+	// Request limiting configuration (0 = unlimited)
+	MaxConcurrentRequests int
+	RequestTimeout        time.Duration
+
+	// Per-minute request rate limits (0 = off): per user for authenticated requests, per
+	// client IP otherwise
+	RateLimitUserPerMinute int
+	RateLimitUserBurst     int
+	RateLimitIPPerMinute   int
+	RateLimitIPBurst       int
+
+	// Proxies, as IPs or CIDRs, trusted to give the client IP in X-Forwarded-For. Requests from
+	// anywhere else are limited by their own address, so the header cannot be spoofed.
+	TrustedProxies []string
+
+	// Content negotiation configuration
+	EnforceAcceptJSON bool
+
+	// TLS policy: reject (or redirect, with HTTPSRedirect) requests the client made over HTTP
+	RequireHTTPS  bool
+	HTTPSRedirect bool
+
+	// Refresh token cookie: read from RefreshCookieName when the body has no refresh token, and
+	// set on login and refresh responses when UseRefreshCookie is enabled
+	RefreshCookieName string
+	UseRefreshCookie  bool
+
+	// Minimum password length, matching the Cognito user pool password policy
+	PasswordMinLength int
+
+	// Number of digits in Cognito confirmation and password reset codes
+	ConfirmationCodeLength int
+
+	// Email domains allowed to sign up (empty = any domain)
+	SignupAllowedDomains []string
+
+	// Email local-parts (on any domain) and full addresses that may not be used to sign up
+	ReservedLocalParts []string
+	ReservedEmails     []string
+
+	// Name users without a first or last name by their email local-part, or failing that their sub
+	DisplayNameFallback bool
+
+	// Maximum number of registered users (0 = unlimited)
+	MaxUsers int
+
+	// Forgot-password lockout configuration
+	AttemptTracker            string // "memory" or "dynamodb"
+	AttemptTrackerTableName   string
+	ForgotPasswordMaxAttempts int
+	ForgotPasswordWindow      time.Duration
+
+	// Minimum time between confirmation code resends for an email (0 = no limit)
+	ResendMinInterval time.Duration
+
+	// Service descriptor configuration
+	Version         string
+	DisableRootInfo bool
+
+	// Account deletion configuration
+	CascadeDeleteMessages bool   // Delete a user's messages when they delete their account
+	MessagesServiceURL    string // Base URL of msgsvc, required when cascading
+
+	// Graceful shutdown configuration
+	ShutdownDrainDelay time.Duration
+	ShutdownTimeout    time.Duration
+
+	// Feature flags: every boolean setting, which the bool fields above are read from
+	Features Features
+}
+
+// defaultReservedLocalParts are the role addresses that can never be used to sign up.
+// RESERVED_LOCAL_PARTS adds to this list.
+var defaultReservedLocalParts = []string{
+	"abuse", "admin", "administrator", "hostmaster", "no-reply", "noreply",
+	"postmaster", "root", "security", "webmaster",
 }
 
+// defaultCORSHeaders are the request headers every CORS policy allows by default
+var defaultCORSHeaders = []string{"Origin", "Content-Type", "Authorization"}
+
+// defaultTrustedProxies is the CIDR of the VPC in iac/networking.yaml, which the ALB runs in
+var defaultTrustedProxies = []string{"10.0.0.0/16"}
+
 // NewConfig creates a new configuration from environment variables
 func NewConfig() *Config {
+	// Boolean settings are all read at once, see features.go
+	features := loadFeatures()
+
 	// Get server address from environment or use default
 	serverAddress := os.Getenv("SERVER_ADDRESS")
 	if serverAddress == "" {
@@ -41,20 +187,28 @@ func NewConfig() *Config {
 		corsOrigins = "*" // Default to allow all origins
 	}
 
-	// Get environment name
+	// Get environment name. Dev-only behavior such as JWT_SKIP_ISSUER_CHECK needs an explicit
+	// ENVIRONMENT=dev, so a deployment that forgets to set it is treated as production.
 	environment := os.Getenv("ENVIRONMENT")
 	if environment == "" {
-		environment = "dev" // Default to dev environment
+		environment = "prod"
 	}
 
-	// DynamoDB configuration
-	useDynamoDB := false
-	useDynamoDBStr := os.Getenv("USE_DYNAMODB")
-	if useDynamoDBStr != "" {
-		var err error
-		useDynamoDB, err = strconv.ParseBool(useDynamoDBStr)
-		if err != nil {
-			log.Printf("WARNING: Invalid USE_DYNAMODB value: %s, defaulting to false", useDynamoDBStr)
+	// Storage configuration. USE_DYNAMODB is deprecated and only consulted when STORAGE_BACKEND
+	// is not set.
+	storageBackend := strings.ToLower(os.Getenv("STORAGE_BACKEND"))
+	if storageBackend == "" {
+		storageBackend = "memory"
+		useDynamoDBStr := os.Getenv("USE_DYNAMODB")
+		if useDynamoDBStr != "" {
+			log.Printf("WARNING: USE_DYNAMODB is deprecated, set STORAGE_BACKEND=dynamodb or STORAGE_BACKEND=memory instead")
+			useDynamoDB, err := strconv.ParseBool(useDynamoDBStr)
+			if err != nil {
+				log.Printf("WARNING: Invalid USE_DYNAMODB value: %s, defaulting to false", useDynamoDBStr)
+			}
+			if useDynamoDB {
+				storageBackend = "dynamodb"
+			}
 		}
 	}
 
@@ -63,6 +217,56 @@ func NewConfig() *Config {
 		dynamoDBTableName = "users" // Default table name
 	}
 
+	// Optional prefix and suffix applied around every table name, e.g. for per-tenant tables
+	dynamoDBTablePrefix := os.Getenv("DYNAMODB_TABLE_PREFIX")
+	dynamoDBTableSuffix := os.Getenv("DYNAMODB_TABLE_SUFFIX")
+	dynamoDBEndpoint := os.Getenv("DYNAMODB_ENDPOINT")
+
+	// Connection pool of the DynamoDB HTTP client
+	dynamoDBMaxIdleConns := 100
+	dynamoDBMaxIdleConnsStr := os.Getenv("DYNAMODB_MAX_IDLE_CONNS")
+	if dynamoDBMaxIdleConnsStr != "" {
+		var err error
+		dynamoDBMaxIdleConns, err = strconv.Atoi(dynamoDBMaxIdleConnsStr)
+		if err != nil || dynamoDBMaxIdleConns < 0 {
+			log.Printf("WARNING: Invalid DYNAMODB_MAX_IDLE_CONNS value: %s, defaulting to 100", dynamoDBMaxIdleConnsStr)
+			dynamoDBMaxIdleConns = 100
+		}
+	}
+
+	dynamoDBMaxIdleConnsPerHost := 100
+	dynamoDBMaxIdleConnsPerHostStr := os.Getenv("DYNAMODB_MAX_IDLE_CONNS_PER_HOST")
+	if dynamoDBMaxIdleConnsPerHostStr != "" {
+		var err error
+		dynamoDBMaxIdleConnsPerHost, err = strconv.Atoi(dynamoDBMaxIdleConnsPerHostStr)
+		if err != nil || dynamoDBMaxIdleConnsPerHost < 0 {
+			log.Printf("WARNING: Invalid DYNAMODB_MAX_IDLE_CONNS_PER_HOST value: %s, defaulting to 100", dynamoDBMaxIdleConnsPerHostStr)
+			dynamoDBMaxIdleConnsPerHost = 100
+		}
+	}
+
+	dynamoDBMaxConnsPerHost := 0
+	dynamoDBMaxConnsPerHostStr := os.Getenv("DYNAMODB_MAX_CONNS_PER_HOST")
+	if dynamoDBMaxConnsPerHostStr != "" {
+		var err error
+		dynamoDBMaxConnsPerHost, err = strconv.Atoi(dynamoDBMaxConnsPerHostStr)
+		if err != nil || dynamoDBMaxConnsPerHost < 0 {
+			log.Printf("WARNING: Invalid DYNAMODB_MAX_CONNS_PER_HOST value: %s, defaulting to 0 (unlimited)", dynamoDBMaxConnsPerHostStr)
+			dynamoDBMaxConnsPerHost = 0
+		}
+	}
+
+	dynamoDBIdleConnTimeout := 90 * time.Second
+	dynamoDBIdleConnTimeoutStr := os.Getenv("DYNAMODB_IDLE_CONN_TIMEOUT")
+	if dynamoDBIdleConnTimeoutStr != "" {
+		var err error
+		dynamoDBIdleConnTimeout, err = time.ParseDuration(dynamoDBIdleConnTimeoutStr)
+		if err != nil || dynamoDBIdleConnTimeout < 0 {
+			log.Printf("WARNING: Invalid DYNAMODB_IDLE_CONN_TIMEOUT value: %s, defaulting to 90s", dynamoDBIdleConnTimeoutStr)
+			dynamoDBIdleConnTimeout = 90 * time.Second
+		}
+	}
+
 	// Cognito configuration
 	userPoolID := os.Getenv("COGNITO_USER_POOL_ID")
 	if userPoolID == "" {
@@ -74,23 +278,521 @@ func NewConfig() *Config {
 		log.Println("WARNING: COGNITO_USER_POOL_CLIENT_ID not set")
 	}
 
-	cognitoRegion := os.Getenv("COGNITO_REGION")
-	if cognitoRegion == "" {
-		cognitoRegion = os.Getenv("AWS_REGION")
-		if cognitoRegion == "" {
-			cognitoRegion = "us-east-1" // Default to us-east-1
-			log.Printf("WARNING: COGNITO_REGION and AWS_REGION not set, defaulting to %s", cognitoRegion)
+	// Resolve the region consistently with the DynamoDB store (COGNITO_REGION is only
+	// used when neither AWS_REGION nor AWS_DEFAULT_REGION is set)
+	cognitoRegion := awsutil.ResolveRegion(os.Getenv("COGNITO_REGION"))
+
+	// JWT configuration
+	jwksCABundle := os.Getenv("JWKS_CA_BUNDLE")
+
+	jwksCacheTTL := time.Hour
+	jwksCacheTTLStr := os.Getenv("JWKS_CACHE_TTL")
+	if jwksCacheTTLStr != "" {
+		var err error
+		jwksCacheTTL, err = time.ParseDuration(jwksCacheTTLStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid JWKS_CACHE_TTL value: %s, defaulting to 1h", jwksCacheTTLStr)
+			jwksCacheTTL = time.Hour
+		}
+	}
+
+	var jwksRefreshInterval time.Duration
+	jwksRefreshIntervalStr := os.Getenv("JWKS_BACKGROUND_REFRESH_INTERVAL")
+	if jwksRefreshIntervalStr != "" {
+		var err error
+		jwksRefreshInterval, err = time.ParseDuration(jwksRefreshIntervalStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid JWKS_BACKGROUND_REFRESH_INTERVAL value: %s, defaulting to 0 (disabled)", jwksRefreshIntervalStr)
+			jwksRefreshInterval = 0
+		}
+	}
+
+	var jwksWaitTimeout time.Duration
+	jwksWaitTimeoutStr := os.Getenv("JWKS_WAIT_TIMEOUT")
+	if jwksWaitTimeoutStr != "" {
+		var err error
+		jwksWaitTimeout, err = time.ParseDuration(jwksWaitTimeoutStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid JWKS_WAIT_TIMEOUT value: %s, defaulting to 0 (don't wait)", jwksWaitTimeoutStr)
+			jwksWaitTimeout = 0
+		}
+	}
+
+	jwksFetchTimeout := 5 * time.Second
+	jwksFetchTimeoutStr := os.Getenv("JWKS_FETCH_TIMEOUT")
+	if jwksFetchTimeoutStr != "" {
+		var err error
+		jwksFetchTimeout, err = time.ParseDuration(jwksFetchTimeoutStr)
+		if err != nil || jwksFetchTimeout <= 0 {
+			log.Printf("WARNING: Invalid JWKS_FETCH_TIMEOUT value: %s, defaulting to 5s", jwksFetchTimeoutStr)
+			jwksFetchTimeout = 5 * time.Second
+		}
+	}
+
+	defaultAuth := os.Getenv("DEFAULT_AUTH")
+	if defaultAuth == "" {
+		defaultAuth = "required" // Routes are authenticated unless explicitly marked public
+	}
+
+	timestampFormat := os.Getenv("TIMESTAMP_FORMAT")
+	if timestampFormat == "" {
+		timestampFormat = "rfc3339" // Default to RFC 3339 strings
+	}
+
+	jsonCase := os.Getenv("JSON_CASE")
+	if jsonCase == "" {
+		jsonCase = "camel" // Default to the keys of the struct tags
+	}
+
+	logMaxFieldLen := 120
+	logMaxFieldLenStr := os.Getenv("LOG_MAX_FIELD_LEN")
+	if logMaxFieldLenStr != "" {
+		var err error
+		logMaxFieldLen, err = strconv.Atoi(logMaxFieldLenStr)
+		if err != nil || logMaxFieldLen <= 0 {
+			log.Printf("WARNING: Invalid LOG_MAX_FIELD_LEN value: %s, defaulting to 120", logMaxFieldLenStr)
+			logMaxFieldLen = 120
+		}
+	}
+
+	userPurgeAfter := 30 * 24 * time.Hour
+	userPurgeAfterStr := os.Getenv("USER_PURGE_AFTER")
+	if userPurgeAfterStr != "" {
+		var err error
+		userPurgeAfter, err = time.ParseDuration(userPurgeAfterStr)
+		if err != nil || userPurgeAfter < 0 {
+			log.Printf("WARNING: Invalid USER_PURGE_AFTER value: %s, defaulting to 720h", userPurgeAfterStr)
+			userPurgeAfter = 30 * 24 * time.Hour
+		}
+	}
+
+	var userPurgeInterval time.Duration
+	userPurgeIntervalStr := os.Getenv("USER_PURGE_INTERVAL")
+	if userPurgeIntervalStr != "" {
+		var err error
+		userPurgeInterval, err = time.ParseDuration(userPurgeIntervalStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid USER_PURGE_INTERVAL value: %s, defaulting to 0 (disabled)", userPurgeIntervalStr)
+			userPurgeInterval = 0
+		}
+	}
+
+	// Request limiting configuration
+	maxConcurrentRequests := 0
+	maxConcurrentRequestsStr := os.Getenv("MAX_CONCURRENT_REQUESTS")
+	if maxConcurrentRequestsStr != "" {
+		var err error
+		maxConcurrentRequests, err = strconv.Atoi(maxConcurrentRequestsStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid MAX_CONCURRENT_REQUESTS value: %s, defaulting to 0 (unlimited)", maxConcurrentRequestsStr)
+			maxConcurrentRequests = 0
+		}
+	}
+
+	requestTimeout := 15 * time.Second
+	requestTimeoutStr := os.Getenv("REQUEST_TIMEOUT")
+	if requestTimeoutStr != "" {
+		var err error
+		requestTimeout, err = time.ParseDuration(requestTimeoutStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid REQUEST_TIMEOUT value: %s, defaulting to 15s", requestTimeoutStr)
+			requestTimeout = 15 * time.Second
+		}
+	}
+
+	rateLimitUserPerMinute := 0
+	rateLimitUserPerMinuteStr := os.Getenv("RATE_LIMIT_USER_PER_MINUTE")
+	if rateLimitUserPerMinuteStr != "" {
+		var err error
+		rateLimitUserPerMinute, err = strconv.Atoi(rateLimitUserPerMinuteStr)
+		if err != nil || rateLimitUserPerMinute < 0 {
+			log.Printf("WARNING: Invalid RATE_LIMIT_USER_PER_MINUTE value: %s, defaulting to 0 (off)", rateLimitUserPerMinuteStr)
+			rateLimitUserPerMinute = 0
+		}
+	}
+
+	rateLimitUserBurst := 20
+	rateLimitUserBurstStr := os.Getenv("RATE_LIMIT_USER_BURST")
+	if rateLimitUserBurstStr != "" {
+		var err error
+		rateLimitUserBurst, err = strconv.Atoi(rateLimitUserBurstStr)
+		if err != nil || rateLimitUserBurst < 0 {
+			log.Printf("WARNING: Invalid RATE_LIMIT_USER_BURST value: %s, defaulting to 20", rateLimitUserBurstStr)
+			rateLimitUserBurst = 20
+		}
+	}
+
+	rateLimitIPPerMinute := 0
+	rateLimitIPPerMinuteStr := os.Getenv("RATE_LIMIT_IP_PER_MINUTE")
+	if rateLimitIPPerMinuteStr != "" {
+		var err error
+		rateLimitIPPerMinute, err = strconv.Atoi(rateLimitIPPerMinuteStr)
+		if err != nil || rateLimitIPPerMinute < 0 {
+			log.Printf("WARNING: Invalid RATE_LIMIT_IP_PER_MINUTE value: %s, defaulting to 0 (off)", rateLimitIPPerMinuteStr)
+			rateLimitIPPerMinute = 0
+		}
+	}
+
+	rateLimitIPBurst := 20
+	rateLimitIPBurstStr := os.Getenv("RATE_LIMIT_IP_BURST")
+	if rateLimitIPBurstStr != "" {
+		var err error
+		rateLimitIPBurst, err = strconv.Atoi(rateLimitIPBurstStr)
+		if err != nil || rateLimitIPBurst < 0 {
+			log.Printf("WARNING: Invalid RATE_LIMIT_IP_BURST value: %s, defaulting to 20", rateLimitIPBurstStr)
+			rateLimitIPBurst = 20
+		}
+	}
+
+	// Refresh token cookie configuration
+	refreshCookieName := os.Getenv("REFRESH_COOKIE_NAME")
+	if refreshCookieName == "" {
+		refreshCookieName = "refresh_token"
+	}
+
+	// Password policy configuration (keep in sync with the user pool's MinimumLength)
+	passwordMinLength := 8
+	passwordMinLengthStr := os.Getenv("PASSWORD_MIN_LENGTH")
+	if passwordMinLengthStr != "" {
+		var err error
+		passwordMinLength, err = strconv.Atoi(passwordMinLengthStr)
+		if err != nil || passwordMinLength < 1 {
+			log.Printf("WARNING: Invalid PASSWORD_MIN_LENGTH value: %s, defaulting to 8", passwordMinLengthStr)
+			passwordMinLength = 8
+		}
+	}
+
+	confirmationCodeLength := 6
+	confirmationCodeLengthStr := os.Getenv("CONFIRMATION_CODE_LENGTH")
+	if confirmationCodeLengthStr != "" {
+		var err error
+		confirmationCodeLength, err = strconv.Atoi(confirmationCodeLengthStr)
+		if err != nil || confirmationCodeLength < 1 {
+			log.Printf("WARNING: Invalid CONFIRMATION_CODE_LENGTH value: %s, defaulting to 6", confirmationCodeLengthStr)
+			confirmationCodeLength = 6
+		}
+	}
+
+	// Self-service signup configuration (lowercased so that matching is case-insensitive)
+	var signupAllowedDomains []string
+	for _, domain := range strings.Split(os.Getenv("SIGNUP_ALLOWED_DOMAINS"), ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			signupAllowedDomains = append(signupAllowedDomains, domain)
+		}
+	}
+
+	reservedLocalParts := slices.Clone(defaultReservedLocalParts)
+	for _, localPart := range strings.Split(os.Getenv("RESERVED_LOCAL_PARTS"), ",") {
+		localPart = strings.ToLower(strings.TrimSpace(localPart))
+		if localPart != "" && !slices.Contains(reservedLocalParts, localPart) {
+			reservedLocalParts = append(reservedLocalParts, localPart)
+		}
+	}
+	var reservedEmails []string
+	for _, email := range strings.Split(os.Getenv("RESERVED_EMAILS"), ",") {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" {
+			reservedEmails = append(reservedEmails, email)
+		}
+	}
+
+	maxUsers := 0
+	maxUsersStr := os.Getenv("MAX_USERS")
+	if maxUsersStr != "" {
+		var err error
+		maxUsers, err = strconv.Atoi(maxUsersStr)
+		if err != nil || maxUsers < 0 {
+			log.Printf("WARNING: Invalid MAX_USERS value: %s, defaulting to 0 (unlimited)", maxUsersStr)
+			maxUsers = 0
+		}
+	}
+
+	// Forgot-password lockout configuration
+	attemptTracker := os.Getenv("ATTEMPT_TRACKER")
+	if attemptTracker == "" {
+		attemptTracker = "memory" // Per-instance counts; use dynamodb behind a load balancer
+	}
+
+	attemptTrackerTableName := os.Getenv("ATTEMPT_TRACKER_TABLE_NAME")
+	if attemptTrackerTableName == "" {
+		attemptTrackerTableName = "attempts" // Default table name
+	}
+
+	forgotPasswordMaxAttempts := 5
+	forgotPasswordMaxAttemptsStr := os.Getenv("FORGOT_PASSWORD_MAX_ATTEMPTS")
+	if forgotPasswordMaxAttemptsStr != "" {
+		var err error
+		forgotPasswordMaxAttempts, err = strconv.Atoi(forgotPasswordMaxAttemptsStr)
+		if err != nil || forgotPasswordMaxAttempts < 1 {
+			log.Printf("WARNING: Invalid FORGOT_PASSWORD_MAX_ATTEMPTS value: %s, defaulting to 5", forgotPasswordMaxAttemptsStr)
+			forgotPasswordMaxAttempts = 5
+		}
+	}
+
+	forgotPasswordWindow := 15 * time.Minute
+	forgotPasswordWindowStr := os.Getenv("FORGOT_PASSWORD_WINDOW")
+	if forgotPasswordWindowStr != "" {
+		var err error
+		forgotPasswordWindow, err = time.ParseDuration(forgotPasswordWindowStr)
+		if err != nil || forgotPasswordWindow < time.Second {
+			log.Printf("WARNING: Invalid FORGOT_PASSWORD_WINDOW value: %s, defaulting to 15m", forgotPasswordWindowStr)
+			forgotPasswordWindow = 15 * time.Minute
+		}
+	}
+
+	resendMinInterval := 30 * time.Second
+	resendMinIntervalStr := os.Getenv("RESEND_MIN_INTERVAL")
+	if resendMinIntervalStr != "" {
+		var err error
+		resendMinInterval, err = time.ParseDuration(resendMinIntervalStr)
+		if err != nil || resendMinInterval < 0 {
+			log.Printf("WARNING: Invalid RESEND_MIN_INTERVAL value: %s, defaulting to 30s", resendMinIntervalStr)
+			resendMinInterval = 30 * time.Second
+		}
+	}
+
+	// Service descriptor configuration
+	version := os.Getenv("SERVICE_VERSION")
+	if version == "" {
+		version = "dev" // Default for local builds
+	}
+
+	// Account deletion configuration
+	messagesServiceURL := os.Getenv("MESSAGES_SERVICE_URL")
+
+	// Graceful shutdown configuration. The drain delay is long enough for the ALB to see /ready
+	// fail: two failed checks 5s apart, plus up to one interval before the first.
+	shutdownDrainDelay := 15 * time.Second
+	shutdownDrainDelayStr := os.Getenv("SHUTDOWN_DRAIN_DELAY")
+	if shutdownDrainDelayStr != "" {
+		var err error
+		shutdownDrainDelay, err = time.ParseDuration(shutdownDrainDelayStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid SHUTDOWN_DRAIN_DELAY value: %s, defaulting to 15s", shutdownDrainDelayStr)
+			shutdownDrainDelay = 15 * time.Second
+		}
+	}
+
+	shutdownTimeout := 15 * time.Second
+	shutdownTimeoutStr := os.Getenv("SHUTDOWN_TIMEOUT")
+	if shutdownTimeoutStr != "" {
+		var err error
+		shutdownTimeout, err = time.ParseDuration(shutdownTimeoutStr)
+		if err != nil {
+			log.Printf("WARNING: Invalid SHUTDOWN_TIMEOUT value: %s, defaulting to 15s", shutdownTimeoutStr)
+			shutdownTimeout = 15 * time.Second
 		}
 	}
 
 	return &Config{
-		ServerAddress:     serverAddress,
-		CorsOrigins:       corsOrigins,
-		Environment:       environment,
-		UseDynamoDB:       useDynamoDB,
-		DynamoDBTableName: dynamoDBTableName,
-		UserPoolID:        userPoolID,
-		UserPoolClientID:  userPoolClientID,
-		CognitoRegion:     cognitoRegion,
+		ServerAddress:           serverAddress,
+		CorsOrigins:             corsOrigins,
+		CorsAuthMethods:         envList("CORS_AUTH_METHODS", []string{"GET", "POST", "OPTIONS"}),
+		CorsAuthHeaders:         envList("CORS_AUTH_HEADERS", defaultCORSHeaders),
+		CorsUsersMethods:        envList("CORS_USERS_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		CorsUsersHeaders:        envList("CORS_USERS_HEADERS", defaultCORSHeaders),
+		CorsMethods:             envList("CORS_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		CorsHeaders:             envList("CORS_HEADERS", defaultCORSHeaders),
+		Environment:             environment,
+		StorageBackend:          storageBackend,
+		StoreMetrics:            features.IsEnabled("STORE_METRICS"),
+		DynamoDBTableName:       dynamoDBTableName,
+		DynamoDBTablePrefix:     dynamoDBTablePrefix,
+		DynamoDBTableSuffix:     dynamoDBTableSuffix,
+		DynamoDBAutoCreateTable: features.IsEnabled("DYNAMODB_AUTO_CREATE_TABLE"),
+		DynamoDBEndpoint:        dynamoDBEndpoint,
+		StartupSelfTest:         features.IsEnabled("STARTUP_SELFTEST"),
+		UserPoolID:              userPoolID,
+		UserPoolClientID:        userPoolClientID,
+		CognitoRegion:           cognitoRegion,
+
+		DynamoDBHTTPClient: awsutil.HTTPClientConfig{
+			MaxIdleConns:        dynamoDBMaxIdleConns,
+			MaxIdleConnsPerHost: dynamoDBMaxIdleConnsPerHost,
+			MaxConnsPerHost:     dynamoDBMaxConnsPerHost,
+			IdleConnTimeout:     dynamoDBIdleConnTimeout,
+		},
+
+		JWTSkipIssuerCheck: features.IsEnabled("JWT_SKIP_ISSUER_CHECK"),
+		JWKSCABundle:       jwksCABundle,
+		JWKSCacheTTL:       jwksCacheTTL,
+		JWKSStaleOK:        features.IsEnabled("JWKS_STALE_OK"),
+
+		JWKSRefreshInterval: jwksRefreshInterval,
+
+		JWKSWaitTimeout: jwksWaitTimeout,
+
+		JWKSFetchTimeout: jwksFetchTimeout,
+
+		DefaultAuth:       defaultAuth,
+		EnforceUserStatus: features.IsEnabled("ENFORCE_USER_STATUS"),
+		TimestampFormat:   timestampFormat,
+		JSONCase:          jsonCase,
+		StrictJSON:        features.IsEnabled("STRICT_JSON"),
+
+		LogMaxFieldLen: logMaxFieldLen,
+
+		UserPurgeAfter:    userPurgeAfter,
+		UserPurgeInterval: userPurgeInterval,
+
+		MaxConcurrentRequests: maxConcurrentRequests,
+		RequestTimeout:        requestTimeout,
+
+		RateLimitUserPerMinute: rateLimitUserPerMinute,
+		RateLimitUserBurst:     rateLimitUserBurst,
+		RateLimitIPPerMinute:   rateLimitIPPerMinute,
+		RateLimitIPBurst:       rateLimitIPBurst,
+		TrustedProxies:         envList("TRUSTED_PROXIES", defaultTrustedProxies),
+
+		EnforceAcceptJSON: features.IsEnabled("ENFORCE_ACCEPT_JSON"),
+
+		RequireHTTPS:  features.IsEnabled("REQUIRE_HTTPS"),
+		HTTPSRedirect: features.IsEnabled("HTTPS_REDIRECT"),
+
+		RefreshCookieName: refreshCookieName,
+		UseRefreshCookie:  features.IsEnabled("USE_REFRESH_COOKIE"),
+
+		PasswordMinLength:      passwordMinLength,
+		ConfirmationCodeLength: confirmationCodeLength,
+
+		SignupAllowedDomains: signupAllowedDomains,
+		ReservedLocalParts:   reservedLocalParts,
+		ReservedEmails:       reservedEmails,
+		MaxUsers:             maxUsers,
+
+		DisplayNameFallback: features.IsEnabled("DISPLAY_NAME_FALLBACK"),
+
+		AttemptTracker:            attemptTracker,
+		AttemptTrackerTableName:   attemptTrackerTableName,
+		ForgotPasswordMaxAttempts: forgotPasswordMaxAttempts,
+		ForgotPasswordWindow:      forgotPasswordWindow,
+
+		ResendMinInterval: resendMinInterval,
+
+		Version:         version,
+		DisableRootInfo: features.IsEnabled("DISABLE_ROOT_INFO"),
+
+		CascadeDeleteMessages: features.IsEnabled("CASCADE_DELETE_MESSAGES"),
+		MessagesServiceURL:    messagesServiceURL,
+
+		ShutdownDrainDelay: shutdownDrainDelay,
+		ShutdownTimeout:    shutdownTimeout,
+
+		Features: features,
+	}
+}
+
+// LogEffective logs every resolved setting at startup, so there is a record of the
+// configuration actually in effect. Values that identify credentials are redacted.
+func (c *Config) LogEffective() {
+	c.logEffective(log.Default())
+}
+
+// logEffective writes the resolved settings to logger
+func (c *Config) logEffective(logger *log.Logger) {
+	settings := []struct {
+		name  string
+		value interface{}
+	}{
+		{"ServerAddress", c.ServerAddress},
+		{"CorsOrigins", c.CorsOrigins},
+		{"CorsAuthMethods", c.CorsAuthMethods},
+		{"CorsAuthHeaders", c.CorsAuthHeaders},
+		{"CorsUsersMethods", c.CorsUsersMethods},
+		{"CorsUsersHeaders", c.CorsUsersHeaders},
+		{"CorsMethods", c.CorsMethods},
+		{"CorsHeaders", c.CorsHeaders},
+		{"Environment", c.Environment},
+		{"StorageBackend", c.StorageBackend},
+		{"StoreMetrics", c.StoreMetrics},
+		{"DynamoDBTableName", c.DynamoDBTableName},
+		{"DynamoDBTablePrefix", c.DynamoDBTablePrefix},
+		{"DynamoDBTableSuffix", c.DynamoDBTableSuffix},
+		{"DynamoDBAutoCreateTable", c.DynamoDBAutoCreateTable},
+		{"DynamoDBEndpoint", c.DynamoDBEndpoint},
+		{"DynamoDBMaxIdleConns", c.DynamoDBHTTPClient.MaxIdleConns},
+		{"DynamoDBMaxIdleConnsPerHost", c.DynamoDBHTTPClient.MaxIdleConnsPerHost},
+		{"DynamoDBMaxConnsPerHost", c.DynamoDBHTTPClient.MaxConnsPerHost},
+		{"DynamoDBIdleConnTimeout", c.DynamoDBHTTPClient.IdleConnTimeout},
+		{"StartupSelfTest", c.StartupSelfTest},
+		{"UserPoolID", c.UserPoolID},
+		{"UserPoolClientID", redactSecret(c.UserPoolClientID)},
+		{"CognitoRegion", c.CognitoRegion},
+		{"JWTSkipIssuerCheck", c.JWTSkipIssuerCheck},
+		{"JWKSCABundle", c.JWKSCABundle},
+		{"JWKSCacheTTL", c.JWKSCacheTTL},
+		{"JWKSStaleOK", c.JWKSStaleOK},
+		{"JWKSRefreshInterval", c.JWKSRefreshInterval},
+		{"JWKSWaitTimeout", c.JWKSWaitTimeout},
+		{"JWKSFetchTimeout", c.JWKSFetchTimeout},
+		{"DefaultAuth", c.DefaultAuth},
+		{"EnforceUserStatus", c.EnforceUserStatus},
+		{"TimestampFormat", c.TimestampFormat},
+		{"JSONCase", c.JSONCase},
+		{"StrictJSON", c.StrictJSON},
+		{"LogMaxFieldLen", c.LogMaxFieldLen},
+		{"UserPurgeAfter", c.UserPurgeAfter},
+		{"UserPurgeInterval", c.UserPurgeInterval},
+		{"MaxConcurrentRequests", c.MaxConcurrentRequests},
+		{"RequestTimeout", c.RequestTimeout},
+		{"RateLimitUserPerMinute", c.RateLimitUserPerMinute},
+		{"RateLimitUserBurst", c.RateLimitUserBurst},
+		{"RateLimitIPPerMinute", c.RateLimitIPPerMinute},
+		{"RateLimitIPBurst", c.RateLimitIPBurst},
+		{"TrustedProxies", c.TrustedProxies},
+		{"EnforceAcceptJSON", c.EnforceAcceptJSON},
+		{"RequireHTTPS", c.RequireHTTPS},
+		{"HTTPSRedirect", c.HTTPSRedirect},
+		{"RefreshCookieName", c.RefreshCookieName},
+		{"UseRefreshCookie", c.UseRefreshCookie},
+		{"PasswordMinLength", c.PasswordMinLength},
+		{"ConfirmationCodeLength", c.ConfirmationCodeLength},
+		{"SignupAllowedDomains", c.SignupAllowedDomains},
+		{"ReservedLocalParts", c.ReservedLocalParts},
+		{"ReservedEmails", c.ReservedEmails},
+		{"DisplayNameFallback", c.DisplayNameFallback},
+		{"MaxUsers", c.MaxUsers},
+		{"AttemptTracker", c.AttemptTracker},
+		{"AttemptTrackerTableName", c.AttemptTrackerTableName},
+		{"ForgotPasswordMaxAttempts", c.ForgotPasswordMaxAttempts},
+		{"ForgotPasswordWindow", c.ForgotPasswordWindow},
+		{"ResendMinInterval", c.ResendMinInterval},
+		{"Version", c.Version},
+		{"DisableRootInfo", c.DisableRootInfo},
+		{"CascadeDeleteMessages", c.CascadeDeleteMessages},
+		{"MessagesServiceURL", c.MessagesServiceURL},
+		{"ShutdownDrainDelay", c.ShutdownDrainDelay},
+		{"ShutdownTimeout", c.ShutdownTimeout},
+	}
+	for _, setting := range settings {
+		logger.Printf("CONFIG: %s=%v", setting.name, setting.value)
+	}
+}
+
+// envList returns the comma-separated values, such as CORS methods or headers, in the given
+// environment variable, or defaultValue if it is not set
+func envList(key string, defaultValue []string) []string {
+	if os.Getenv(key) == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// redactSecret hides all but the last four characters of a sensitive value, which is enough
+// to tell which one is configured
+func redactSecret(value string) string {
+	if value == "" {
+		return "(not set)"
+	}
+	if len(value) <= 8 {
+		return "(redacted)"
 	}
+	return "(redacted)..." + value[len(value)-4:]
 }
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLogEffectiveRedactsSecrets(t *testing.T) {
+	cfg := &Config{
+		UserPoolID:       "us-east-1_AbCdEfGhI",
+		UserPoolClientID: "4s7dklfj2s9dkd0fjw8dkwq1",
+	}
+
+	var buf bytes.Buffer
+	cfg.logEffective(log.New(&buf, "", 0))
+	output := buf.String()
+
+	if strings.Contains(output, cfg.UserPoolClientID) {
+		t.Errorf("client ID not redacted:\n%s", output)
+	}
+	if !strings.Contains(output, "CONFIG: UserPoolClientID=(redacted)...kwq1") {
+		t.Errorf("client ID not logged in redacted form:\n%s", output)
+	}
+	if !strings.Contains(output, "CONFIG: UserPoolID=us-east-1_AbCdEfGhI") {
+		t.Errorf("settings not logged:\n%s", output)
+	}
+}
+
+func TestReservedLocalPartsExtendDefaults(t *testing.T) {
+	t.Setenv("RESERVED_LOCAL_PARTS", " Support, admin ,")
+	t.Setenv("RESERVED_EMAILS", "CEO@Example.com")
+
+	cfg := NewConfig()
+	if !slices.Contains(cfg.ReservedLocalParts, "admin") || !slices.Contains(cfg.ReservedLocalParts, "support") {
+		t.Errorf("ReservedLocalParts = %v, want the defaults plus support", cfg.ReservedLocalParts)
+	}
+	if got := len(cfg.ReservedLocalParts); got != len(defaultReservedLocalParts)+1 {
+		t.Errorf("got %d reserved local-parts, want %d", got, len(defaultReservedLocalParts)+1)
+	}
+	if !slices.Equal(cfg.ReservedEmails, []string{"ceo@example.com"}) {
+		t.Errorf("ReservedEmails = %v, want [ceo@example.com]", cfg.ReservedEmails)
+	}
+}
+
+func TestEnvironmentDefaultsToProd(t *testing.T) {
+	// Unset, dev-only behavior must stay off
+	t.Setenv("ENVIRONMENT", "")
+	if got := NewConfig().Environment; got != "prod" {
+		t.Errorf("Environment = %q, want prod", got)
+	}
+	t.Setenv("ENVIRONMENT", "dev")
+	if got := NewConfig().Environment; got != "dev" {
+		t.Errorf("Environment = %q, want dev", got)
+	}
+}
+
+func TestStorageBackend(t *testing.T) {
+	tests := []struct {
+		name           string
+		storageBackend string
+		useDynamoDB    string
+		want           string
+	}{
+		{"default", "", "", "memory"},
+		{"explicit", "DynamoDB", "", "dynamodb"},
+		{"deprecated flag", "", "true", "dynamodb"},
+		{"explicit wins over deprecated flag", "memory", "true", "memory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STORAGE_BACKEND", tt.storageBackend)
+			t.Setenv("USE_DYNAMODB", tt.useDynamoDB)
+			if got := NewConfig().StorageBackend; got != tt.want {
+				t.Errorf("StorageBackend = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	t.Setenv("STRICT_JSON", "true")
+	t.Setenv("CASCADE_DELETE_MESSAGES", "yes")
+	t.Setenv("JWKS_STALE_OK", "false")
+	t.Setenv("ENFORCE_USER_STATUS", "sometimes")
+	t.Setenv("DISPLAY_NAME_FALLBACK", "banana")
+	t.Setenv("STORE_METRICS", "")
+
+	features := loadFeatures()
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"STRICT_JSON", true},                // enabled
+		{"CASCADE_DELETE_MESSAGES", true},    // enabled with "yes"
+		{"JWKS_STALE_OK", false},             // disabled, overriding a default of true
+		{"ENFORCE_USER_STATUS", false},       // invalid, keeps the default of false
+		{"DISPLAY_NAME_FALLBACK", true},      // invalid, keeps the default of true
+		{"STORE_METRICS", false},             // unset, default false
+		{"DYNAMODB_AUTO_CREATE_TABLE", true}, // unset, default true
+		{"NOT_A_FLAG", false},                // unknown
+	}
+	for _, tt := range tests {
+		if got := features.IsEnabled(tt.name); got != tt.want {
+			t.Errorf("IsEnabled(%q) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	features.logEnabled(log.New(&buf, "", 0))
+	if got, want := buf.String(), "FEATURES: DYNAMODB_AUTO_CREATE_TABLE, STRICT_JSON, DISPLAY_NAME_FALLBACK, CASCADE_DELETE_MESSAGES\n"; got != want {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	Features{}.logEnabled(log.New(&buf, "", 0))
+	if got, want := buf.String(), "FEATURES: none enabled\n"; got != want {
+		t.Errorf("with no flags on, logged %q, want %q", got, want)
+	}
+}
+
+func TestNewConfigReadsFlagsFromFeatures(t *testing.T) {
+	t.Setenv("USE_REFRESH_COOKIE", "1")
+	t.Setenv("DISPLAY_NAME_FALLBACK", "0")
+
+	cfg := NewConfig()
+	if !cfg.UseRefreshCookie || cfg.DisplayNameFallback {
+		t.Errorf("got UseRefreshCookie=%t DisplayNameFallback=%t, want true and false", cfg.UseRefreshCookie, cfg.DisplayNameFallback)
+	}
+	if cfg.UseRefreshCookie != cfg.Features.IsEnabled("USE_REFRESH_COOKIE") {
+		t.Error("UseRefreshCookie disagrees with Features")
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// featureFlags lists every boolean setting read from the environment, with its default. New
+// flags belong here rather than in NewConfig, so that they are all parsed the same way and
+// listed in one place. The JWT validator only honours JWT_SKIP_ISSUER_CHECK in dev.
+var featureFlags = []struct {
+	name         string
+	defaultValue bool
+}{
+	{"STORE_METRICS", false},
+	{"DYNAMODB_AUTO_CREATE_TABLE", true},
+	{"STARTUP_SELFTEST", false},
+	{"JWT_SKIP_ISSUER_CHECK", false},
+	{"JWKS_STALE_OK", true},
+	{"ENFORCE_USER_STATUS", false},
+	{"STRICT_JSON", false},
+	{"ENFORCE_ACCEPT_JSON", false},
+	{"REQUIRE_HTTPS", false},
+	{"HTTPS_REDIRECT", false},
+	{"USE_REFRESH_COOKIE", false},
+	{"DISABLE_ROOT_INFO", false},
+	{"DISPLAY_NAME_FALLBACK", true},
+	{"CASCADE_DELETE_MESSAGES", false},
+}
+
+// Features holds the boolean settings in featureFlags, by environment variable name
+type Features struct {
+	enabled map[string]bool
+}
+
+// loadFeatures reads every flag in featureFlags from the environment
+func loadFeatures() Features {
+	features := Features{enabled: make(map[string]bool, len(featureFlags))}
+	for _, flag := range featureFlags {
+		features.enabled[flag.name] = parseFeature(flag.name, flag.defaultValue)
+	}
+	return features
+}
+
+// parseFeature reads a flag from the environment. "yes" and "no" are accepted along with the
+// values strconv.ParseBool understands; anything else is logged and the default kept.
+func parseFeature(name string, defaultValue bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	switch strings.ToLower(value) {
+	case "yes":
+		return true
+	case "no":
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("WARNING: Invalid %s value: %s, defaulting to %t", name, value, defaultValue)
+		return defaultValue
+	}
+	return enabled
+}
+
+// IsEnabled reports whether the flag with the given environment variable name is on. Names not
+// in featureFlags are always off.
+func (f Features) IsEnabled(name string) bool {
+	return f.enabled[name]
+}
+
+// LogEnabledFeatures logs the flags that are on at startup
+func (f Features) LogEnabledFeatures() {
+	f.logEnabled(log.Default())
+}
+
+// logEnabled writes the flags that are on to logger, in the order of featureFlags
+func (f Features) logEnabled(logger *log.Logger) {
+	var names []string
+	for _, flag := range featureFlags {
+		if f.enabled[flag.name] {
+			names = append(names, flag.name)
+		}
+	}
+	if len(names) == 0 {
+		logger.Printf("FEATURES: none enabled")
+		return
+	}
+	logger.Printf("FEATURES: %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,94 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws_e2e_test/shared/httputil"
+)
+
+// Client calls the message service on behalf of an authenticated user
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient creates a new message service client for the service at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// DeleteMine deletes every message owned by the user the access token was issued to and returns
+// how many were deleted
+func (c *Client) DeleteMine(ctx context.Context, accessToken string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.baseURL+"/messages/mine", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to call message service: %v", err)
+		return 0, fmt.Errorf("failed to call message service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("message service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode message service response: %w", err)
+	}
+	return result.Deleted, nil
+}
+
+// Message is a message as returned by the message service
+type Message struct {
+	ID        string             `json:"id"`
+	Text      string             `json:"text"`
+	Owner     string             `json:"owner"`
+	Timestamp httputil.Timestamp `json:"timestamp"`
+}
+
+// Recent returns up to limit of the newest messages, newest first. The access token must belong
+// to an administrator.
+func (c *Client) Recent(ctx context.Context, accessToken string, limit int) ([]Message, error) {
+	url := fmt.Sprintf("%s/admin/messages/recent?limit=%d", c.baseURL, limit)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to call message service: %v", err)
+		return nil, fmt.Errorf("failed to call message service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("message service returned status %d", resp.StatusCode)
+	}
+
+	var list httputil.ListResponse[Message]
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode message service response: %w", err)
+	}
+	return list.Items, nil
+}
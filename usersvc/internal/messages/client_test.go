@@ -0,0 +1,30 @@
+package messages
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/messages/recent" || r.URL.Query().Get("limit") != "2" {
+			t.Errorf("got request for %s", r.URL)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("got Authorization %q", auth)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items":[{"id":"m2","text":"second","owner":"sub-bob","timestamp":"2024-01-01T12:02:00Z"},{"id":"m1","text":"first","owner":"sub-alice","timestamp":"2024-01-01T12:01:00Z"}],"count":2}`))
+	}))
+	defer server.Close()
+
+	messages, err := NewClient(server.URL).Recent(context.Background(), "token", 2)
+	if err != nil {
+		t.Fatalf("Recent: %v", err)
+	}
+	if len(messages) != 2 || messages[0].ID != "m2" || messages[1].Owner != "sub-alice" {
+		t.Errorf("got messages %+v", messages)
+	}
+}
@@ -0,0 +1,16 @@
+package model
+
+import "github.com/aws_e2e_test/shared/httputil"
+
+// Activity event types
+const (
+	ActivityUserCreated    = "user_created"
+	ActivityMessageCreated = "message_created"
+)
+
+// ActivityEvent is one entry in the admin activity feed
+type ActivityEvent struct {
+	Type    string             `json:"type"`
+	At      httputil.Timestamp `json:"at"`
+	Summary string             `json:"summary"`
+}
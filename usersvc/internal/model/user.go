@@ -1,17 +1,38 @@
 package model
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
+	"unicode/utf8"
+
+	"github.com/aws_e2e_test/shared/httputil"
 )
 
 // User represents a user in the system
 type User struct {
-	Email     string    `json:"email" dynamodbav:"Email"`
-	FirstName string    `json:"firstName" dynamodbav:"FirstName"`
-	LastName  string    `json:"lastName" dynamodbav:"LastName"`
-	Status    string    `json:"status" dynamodbav:"Status"`
-	CreatedAt time.Time `json:"createdAt" dynamodbav:"CreatedAt"`
-	UpdatedAt time.Time `json:"updatedAt" dynamodbav:"UpdatedAt"`
+	Email     string             `json:"email" dynamodbav:"Email"`
+	Sub       string             `json:"sub,omitempty" dynamodbav:"Sub,omitempty"`
+	FirstName string             `json:"firstName" dynamodbav:"FirstName"`
+	LastName  string             `json:"lastName" dynamodbav:"LastName"`
+	Status    string             `json:"status" dynamodbav:"Status"`
+	CreatedAt httputil.Timestamp `json:"createdAt" dynamodbav:"CreatedAt"`
+	UpdatedAt httputil.Timestamp `json:"updatedAt" dynamodbav:"UpdatedAt"`
+
+	// DeletedAt is when a user was marked DELETED, pending purge
+	DeletedAt *httputil.Timestamp `json:"deletedAt,omitempty" dynamodbav:"DeletedAt,omitempty"`
+	// CreatedBy is CreatedBySelf for users who signed up, or the sub of the administrator who
+	// created the user. It is empty on records written before it was tracked.
+	CreatedBy string `json:"createdBy,omitempty" dynamodbav:"CreatedBy,omitempty"`
+}
+
+// CreatedBySelf is the CreatedBy of users who signed up themselves
+const CreatedBySelf = "self"
+
+// SelfCreated reports whether the user signed up themselves. Records that predate CreatedBy
+// count as signups.
+func (u *User) SelfCreated() bool {
+	return u.CreatedBy == "" || u.CreatedBy == CreatedBySelf
 }
 
 // UserStatus defines the possible status values for a user
@@ -24,11 +45,13 @@ const (
 	UserStatusInactive UserStatus = "INACTIVE"
 	// UserStatusPending indicates a pending user
 	UserStatusPending UserStatus = "PENDING"
+	// UserStatusDeleted indicates a soft-deleted user, hard-deleted by the purge sweep
+	UserStatusDeleted UserStatus = "DELETED"
 )
 
 // NewUser creates a new user with the given details
 func NewUser(email, firstName, lastName string) *User {
-	now := time.Now()
+	now := httputil.Timestamp(time.Now())
 	return &User{
 		Email:     email,
 		FirstName: firstName,
@@ -42,11 +65,20 @@ func NewUser(email, firstName, lastName string) *User {
 // UserSignupRequest represents the request to sign up a new user
 type UserSignupRequest struct {
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=8"`
+	Password  string `json:"password" binding:"required"`
 	FirstName string `json:"firstName" binding:"required"`
 	LastName  string `json:"lastName" binding:"required"`
 }
 
+// ValidatePassword checks a new password against the minimum length required by the user pool.
+// Length is counted in characters, as Cognito does.
+func ValidatePassword(password string, minLength int) error {
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Errorf("password must be at least %d characters", minLength)
+	}
+	return nil
+}
+
 // UserLoginRequest represents the request to log in a user
 type UserLoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
@@ -60,20 +92,85 @@ type UserUpdateRequest struct {
 	Status    string `json:"status"`
 }
 
+// UserPatchRequest represents a JSON Merge Patch (RFC 7396) to a user. A nil field was absent
+// from the patch and is left unchanged; a non-nil field is set, with null clearing the field.
+type UserPatchRequest struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	Status    *string `json:"status"`
+}
+
+// UnmarshalJSON decodes a merge patch, treating an explicit null as clearing the field
+func (r *UserPatchRequest) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	targets := map[string]**string{
+		"firstName": &r.FirstName,
+		"lastName":  &r.LastName,
+		"status":    &r.Status,
+	}
+	for name, raw := range fields {
+		target, ok := targets[name]
+		if !ok {
+			continue
+		}
+
+		value := ""
+		if string(raw) != "null" {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("field %s must be a string or null", name)
+			}
+		}
+		*target = &value
+	}
+	return nil
+}
+
+// Apply applies the patch to a user
+func (r *UserPatchRequest) Apply(user *User) {
+	if r.FirstName != nil {
+		user.FirstName = *r.FirstName
+	}
+	if r.LastName != nil {
+		user.LastName = *r.LastName
+	}
+	if r.Status != nil {
+		user.Status = *r.Status
+	}
+}
+
+// CognitoAttributes returns the Cognito user attributes for the name fields present in the patch
+func (r *UserPatchRequest) CognitoAttributes() map[string]string {
+	attributes := make(map[string]string)
+	if r.FirstName != nil {
+		attributes["given_name"] = *r.FirstName
+	}
+	if r.LastName != nil {
+		attributes["family_name"] = *r.LastName
+	}
+	return attributes
+}
+
 // UserResponse represents the response for user operations
 type UserResponse struct {
-	Email     string    `json:"email"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	Email     string             `json:"email"`
+	Sub       string             `json:"sub,omitempty"`
+	FirstName string             `json:"firstName"`
+	LastName  string             `json:"lastName"`
+	Status    string             `json:"status"`
+	CreatedAt httputil.Timestamp `json:"createdAt"`
+	UpdatedAt httputil.Timestamp `json:"updatedAt"`
+	CreatedBy string             `json:"createdBy,omitempty"` // only shown to administrators
 }
 
-// ToResponse converts a User to a UserResponse
+// ToResponse converts a User to a UserResponse, leaving out CreatedBy
 func (u *User) ToResponse() *UserResponse {
 	return &UserResponse{
 		Email:     u.Email,
+		Sub:       u.Sub,
 		FirstName: u.FirstName,
 		LastName:  u.LastName,
 		Status:    u.Status,
@@ -82,6 +179,48 @@ func (u *User) ToResponse() *UserResponse {
 	}
 }
 
+// ToAdminResponse converts a User to a UserResponse for an administrator, including CreatedBy
+func (u *User) ToAdminResponse() *UserResponse {
+	response := u.ToResponse()
+	response.CreatedBy = u.CreatedBy
+	if u.SelfCreated() {
+		response.CreatedBy = CreatedBySelf
+	}
+	return response
+}
+
+// AdminUserResponse represents a user as seen by an administrator, combining the database
+// record with the live Cognito account state
+type AdminUserResponse struct {
+	Email        string              `json:"email"`
+	FirstName    string              `json:"firstName"`
+	LastName     string              `json:"lastName"`
+	Status       string              `json:"status,omitempty"`
+	CreatedAt    *httputil.Timestamp `json:"createdAt,omitempty"`
+	UpdatedAt    *httputil.Timestamp `json:"updatedAt,omitempty"`
+	InDatabase   bool                `json:"inDatabase"`
+	InCognito    bool                `json:"inCognito"`
+	Enabled      bool                `json:"enabled"`
+	UserStatus   string              `json:"userStatus,omitempty"`
+	LastModified string              `json:"lastModified,omitempty"`
+}
+
+// CognitoUser is a user pool account as listed by an administrator
+type CognitoUser struct {
+	Email   string             `json:"email"`
+	Sub     string             `json:"sub"`
+	Status  string             `json:"status"`
+	Enabled bool               `json:"enabled"`
+	Created httputil.Timestamp `json:"created"`
+}
+
+// CodeDelivery describes where Cognito sent a verification code
+type CodeDelivery struct {
+	Destination    string `json:"destination"`
+	DeliveryMedium string `json:"deliveryMedium"`
+	AttributeName  string `json:"attributeName"`
+}
+
 // AuthResponse represents the response for authentication operations
 type AuthResponse struct {
 	AccessToken  string `json:"accessToken"`
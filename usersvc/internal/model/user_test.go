@@ -0,0 +1,68 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"one below minimum", "Abcdefgh1!x", true},
+		{"exactly minimum", "Abcdefgh1!xy", false},
+		{"above minimum", "Abcdefgh1!xyz", false},
+		{"multibyte characters counted once", "Ábcdéfgh1!xy", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password, 12)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePassword(%q, 12) error = %v, wantErr %v", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserResponseJSONCase(t *testing.T) {
+	defer httputil.SetJSONCase(httputil.JSONCaseCamel)
+	gin.SetMode(gin.TestMode)
+
+	created := httputil.Timestamp(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC))
+	response := (&User{
+		Email:     "ada@example.com",
+		FirstName: "Ada",
+		LastName:  "Lovelace",
+		Status:    string(UserStatusActive),
+		CreatedAt: created,
+		UpdatedAt: created,
+	}).ToResponse()
+
+	tests := []struct {
+		jsonCase httputil.JSONCase
+		want     string
+	}{
+		{httputil.JSONCaseCamel, `{"email":"ada@example.com","firstName":"Ada","lastName":"Lovelace","status":"ACTIVE","createdAt":"2024-05-06T07:08:09Z","updatedAt":"2024-05-06T07:08:09Z"}`},
+		{httputil.JSONCaseSnake, `{"email":"ada@example.com","first_name":"Ada","last_name":"Lovelace","status":"ACTIVE","created_at":"2024-05-06T07:08:09Z","updated_at":"2024-05-06T07:08:09Z"}`},
+		{httputil.JSONCasePascal, `{"Email":"ada@example.com","FirstName":"Ada","LastName":"Lovelace","Status":"ACTIVE","CreatedAt":"2024-05-06T07:08:09Z","UpdatedAt":"2024-05-06T07:08:09Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.jsonCase), func(t *testing.T) {
+			httputil.SetJSONCase(tt.jsonCase)
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			httputil.RespondJSON(c, http.StatusOK, response)
+			if rec.Body.String() != tt.want {
+				t.Errorf("got %s, want %s", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
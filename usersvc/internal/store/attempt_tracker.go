@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AttemptTracker counts attempts per key within a fixed window, for rate limiting sensitive
+// operations such as password resets
+type AttemptTracker interface {
+	// RecordAttempt records an attempt for key and reports whether it is within the allowed
+	// number of attempts for the current window
+	RecordAttempt(ctx context.Context, key string) (bool, error)
+
+	// Reset clears the attempts recorded for key
+	Reset(ctx context.Context, key string) error
+}
+
+// NewAttemptTracker creates a new in-memory attempt tracker allowing maxAttempts per window
+func NewAttemptTracker(maxAttempts int, window time.Duration) AttemptTracker {
+	return &InMemoryAttemptTracker{
+		maxAttempts: maxAttempts,
+		window:      window,
+		attempts:    make(map[string]*attemptWindow),
+	}
+}
+
+// attemptWindow holds the attempt count for a key and when its window ends
+type attemptWindow struct {
+	count     int
+	expiresAt time.Time
+}
+
+// InMemoryAttemptTracker is an in-memory implementation of AttemptTracker. Counts are per
+// instance, so it is only suitable for local development or single-instance deployments.
+type InMemoryAttemptTracker struct {
+	maxAttempts int
+	window      time.Duration
+	attempts    map[string]*attemptWindow
+	mutex       sync.Mutex
+}
+
+// RecordAttempt records an attempt for key and reports whether it is within the limit
+func (t *InMemoryAttemptTracker) RecordAttempt(_ context.Context, key string) (bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	attempts, exists := t.attempts[key]
+	if !exists || !now.Before(attempts.expiresAt) {
+		t.attempts[key] = &attemptWindow{count: 1, expiresAt: now.Add(t.window)}
+		return true, nil
+	}
+
+	if attempts.count >= t.maxAttempts {
+		return false, nil
+	}
+	attempts.count++
+	return true, nil
+}
+
+// Reset clears the attempts recorded for key
+func (t *InMemoryAttemptTracker) Reset(_ context.Context, key string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.attempts, key)
+	return nil
+}
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/shared/awsutil"
+)
+
+// maxRecordAttemptRetries bounds how often RecordAttempt retries after losing a race with
+// another instance starting a new window for the same key
+const maxRecordAttemptRetries = 3
+
+// DynamoDBAttemptTrackerConfig holds configuration for the DynamoDB attempt tracker
+type DynamoDBAttemptTrackerConfig struct {
+	TableName   string
+	MaxAttempts int
+	Window      time.Duration
+
+	// TablePrefix and TableSuffix are applied verbatim around TableName (see awsutil.TableName)
+	TablePrefix string
+	TableSuffix string
+
+	// AutoCreateTable creates the table at startup if it does not exist
+	AutoCreateTable bool
+
+	// Endpoint overrides the DynamoDB endpoint (e.g. a local DynamoDB for testing)
+	Endpoint string
+
+	// HTTPClient tunes the connection pool of the DynamoDB HTTP client
+	HTTPClient awsutil.HTTPClientConfig
+}
+
+// DynamoDBAttemptTracker is a DynamoDB-based implementation of AttemptTracker. Counts are shared
+// by all instances, so limits hold behind a load balancer. Each item stores the attempt count
+// for a key and the end of its window as epoch seconds in ExpiresAt, which is also the table's
+// TTL attribute so DynamoDB eventually removes stale windows.
+type DynamoDBAttemptTracker struct {
+	client          *dynamodb.Client
+	tableName       string
+	maxAttempts     int
+	window          time.Duration
+	autoCreateTable bool
+}
+
+// NewDynamoDBAttemptTracker creates a new DynamoDB-based attempt tracker
+func NewDynamoDBAttemptTracker(trackerConfig DynamoDBAttemptTrackerConfig) (*DynamoDBAttemptTracker, error) {
+	tableName := awsutil.TableName(trackerConfig.TableName, trackerConfig.TablePrefix, trackerConfig.TableSuffix)
+	log.Printf("Initializing DynamoDB attempt tracker with table name: %s (resolved from %q)", tableName, trackerConfig.TableName)
+
+	// Validate configuration
+	if tableName == "" {
+		return nil, fmt.Errorf("table name cannot be empty")
+	}
+	if trackerConfig.MaxAttempts < 1 {
+		return nil, fmt.Errorf("max attempts must be at least 1")
+	}
+	if trackerConfig.Window < time.Second {
+		return nil, fmt.Errorf("window must be at least 1s")
+	}
+
+	// Resolve the region consistently with the other AWS clients
+	region := awsutil.ResolveRegion("")
+
+	// Load AWS configuration
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(region),
+		config.WithHTTPClient(awsutil.NewHTTPClient(trackerConfig.HTTPClient)),
+	)
+	if err != nil {
+		log.Printf("Failed to load AWS config: %v", err)
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	// Fail at startup rather than on the first call if there are no credentials. A local
+	// endpoint accepts any credentials.
+	if trackerConfig.Endpoint == "" {
+		if err := awsutil.CheckCredentials(context.TODO(), cfg); err != nil {
+			log.Printf("ERROR: %v", err)
+			return nil, err
+		}
+	}
+
+	// Create DynamoDB client
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if trackerConfig.Endpoint != "" {
+			o.BaseEndpoint = aws.String(trackerConfig.Endpoint)
+		}
+	})
+
+	tracker := &DynamoDBAttemptTracker{
+		client:          client,
+		tableName:       tableName,
+		maxAttempts:     trackerConfig.MaxAttempts,
+		window:          trackerConfig.Window,
+		autoCreateTable: trackerConfig.AutoCreateTable,
+	}
+
+	// Ensure the table exists
+	err = tracker.ensureTableExists(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure table exists: %w", err)
+	}
+
+	return tracker, nil
+}
+
+// ensureTableExists creates the DynamoDB table with TTL enabled if it doesn't exist
+func (t *DynamoDBAttemptTracker) ensureTableExists(ctx context.Context) error {
+	_, err := t.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(t.tableName),
+	})
+	if err == nil {
+		return nil
+	}
+
+	var notFoundErr *types.ResourceNotFoundException
+	if !errors.As(err, &notFoundErr) {
+		log.Printf("ERROR: Failed to describe table %s: %v", t.tableName, err)
+		return fmt.Errorf("failed to describe table: %w", err)
+	}
+
+	if !t.autoCreateTable {
+		log.Printf("ERROR: DynamoDB table %s does not exist and auto-create is disabled", t.tableName)
+		return fmt.Errorf("table %q not found and auto-create is disabled; provision it with partition key AttemptKey (S) and TTL on ExpiresAt", t.tableName)
+	}
+
+	log.Printf("DynamoDB table %s does not exist, creating it now...", t.tableName)
+
+	_, err = t.client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(t.tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("AttemptKey"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("AttemptKey"),
+				KeyType:       types.KeyTypeHash,
+			},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		log.Printf("Failed to create table %s: %v", t.tableName, err)
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	// Wait for table to be active
+	waiter := dynamodb.NewTableExistsWaiter(t.client)
+	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(t.tableName),
+	}, 5*time.Minute)
+	if err != nil {
+		log.Printf("Failed to wait for table %s to be created: %v", t.tableName, err)
+		return fmt.Errorf("failed to wait for table to be created: %w", err)
+	}
+
+	// Let DynamoDB remove expired windows
+	_, err = t.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(t.tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String("ExpiresAt"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to enable TTL on table %s: %v", t.tableName, err)
+		return fmt.Errorf("failed to enable TTL: %w", err)
+	}
+
+	log.Printf("Successfully created DynamoDB table: %s", t.tableName)
+	return nil
+}
+
+// RecordAttempt records an attempt for key and reports whether it is within the limit. The count
+// is incremented atomically with UpdateItem ADD, conditional on the window still being open and
+// the count being below the threshold. TTL deletion is not immediate, so an expired window is
+// detected from ExpiresAt and replaced with a new one rather than waiting for DynamoDB to remove it.
+func (t *DynamoDBAttemptTracker) RecordAttempt(ctx context.Context, key string) (bool, error) {
+	for range maxRecordAttemptRetries {
+		now := time.Now().Unix()
+
+		// Count the attempt against the current window, unless the threshold is reached
+		_, err := t.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(t.tableName),
+			Key: map[string]types.AttributeValue{
+				"AttemptKey": &types.AttributeValueMemberS{Value: key},
+			},
+			UpdateExpression:    aws.String("ADD Attempts :one"),
+			ConditionExpression: aws.String("ExpiresAt > :now AND Attempts < :max"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":one": &types.AttributeValueMemberN{Value: "1"},
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+				":max": &types.AttributeValueMemberN{Value: strconv.Itoa(t.maxAttempts)},
+			},
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		})
+		if err == nil {
+			return true, nil
+		}
+
+		var conditionFailedErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionFailedErr) {
+			log.Printf("ERROR: Failed to record attempt in table %s: %v", t.tableName, err)
+			return false, fmt.Errorf("failed to record attempt: %w", err)
+		}
+
+		// The window is still open, so the condition failed because the threshold is reached
+		if conditionFailedErr.Item != nil && windowExpiresAt(conditionFailedErr.Item) > now {
+			return false, nil
+		}
+
+		// There is no window for this key or it has expired, so start a new one
+		_, err = t.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(t.tableName),
+			Item: map[string]types.AttributeValue{
+				"AttemptKey": &types.AttributeValueMemberS{Value: key},
+				"Attempts":   &types.AttributeValueMemberN{Value: "1"},
+				"ExpiresAt":  &types.AttributeValueMemberN{Value: strconv.FormatInt(now+int64(t.window/time.Second), 10)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(AttemptKey) OR ExpiresAt <= :now"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+			},
+		})
+		if err == nil {
+			return true, nil
+		}
+		if !errors.As(err, &conditionFailedErr) {
+			log.Printf("ERROR: Failed to start attempt window in table %s: %v", t.tableName, err)
+			return false, fmt.Errorf("failed to start attempt window: %w", err)
+		}
+
+		// Another instance started a new window first; count the attempt against it
+	}
+
+	return false, fmt.Errorf("failed to record attempt for %s after %d tries", key, maxRecordAttemptRetries)
+}
+
+// Reset clears the attempts recorded for key
+func (t *DynamoDBAttemptTracker) Reset(ctx context.Context, key string) error {
+	_, err := t.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(t.tableName),
+		Key: map[string]types.AttributeValue{
+			"AttemptKey": &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to reset attempts in table %s: %v", t.tableName, err)
+		return fmt.Errorf("failed to reset attempts: %w", err)
+	}
+	return nil
+}
+
+// windowExpiresAt returns the ExpiresAt epoch seconds of an attempt item, or 0 if it is missing
+func windowExpiresAt(item map[string]types.AttributeValue) int64 {
+	expiresAt, ok := item["ExpiresAt"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	value, err := strconv.ParseInt(expiresAt.Value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
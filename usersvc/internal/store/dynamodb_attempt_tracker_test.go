@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestDynamoDBAttemptTracker runs against a local DynamoDB, e.g.
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	DYNAMODB_ENDPOINT=http://localhost:8000 AWS_ACCESS_KEY_ID=local AWS_SECRET_ACCESS_KEY=local go test ./internal/store/
+func TestDynamoDBAttemptTracker(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT not set; skipping local DynamoDB test")
+	}
+
+	tracker, err := NewDynamoDBAttemptTracker(DynamoDBAttemptTrackerConfig{
+		TableName:       "attempts-test",
+		MaxAttempts:     3,
+		Window:          time.Second,
+		AutoCreateTable: true,
+		Endpoint:        endpoint,
+	})
+	if err != nil {
+		t.Fatalf("NewDynamoDBAttemptTracker: %v", err)
+	}
+
+	key := fmt.Sprintf("test-%d@example.com", time.Now().UnixNano())
+	defer tracker.Reset(context.Background(), key)
+
+	record := func() bool {
+		t.Helper()
+		allowed, err := tracker.RecordAttempt(context.Background(), key)
+		if err != nil {
+			t.Fatalf("RecordAttempt: %v", err)
+		}
+		return allowed
+	}
+
+	// Increment up to the threshold
+	for i := 1; i <= 3; i++ {
+		if !record() {
+			t.Fatalf("attempt %d: got blocked, want allowed", i)
+		}
+	}
+
+	// Threshold reached
+	if record() {
+		t.Fatal("attempt 4: got allowed, want blocked")
+	}
+
+	// Expiry starts a new window
+	time.Sleep(2 * time.Second)
+	if !record() {
+		t.Fatal("attempt after window expiry: got blocked, want allowed")
+	}
+}
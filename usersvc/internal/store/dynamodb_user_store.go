@@ -5,63 +5,97 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/shared/awsutil"
 	"github.com/aws_e2e_test/usersvc/internal/model"
 )
 
+// subIndexName is the name of the global secondary index used to look up users by Cognito sub
+const subIndexName = "SubIndex"
+
+// DynamoDBUserStoreConfig holds configuration for the DynamoDB user store
+type DynamoDBUserStoreConfig struct {
+	TableName string
+
+	// TablePrefix and TableSuffix are applied verbatim around TableName (see awsutil.TableName)
+	TablePrefix string
+	TableSuffix string
+
+	// AutoCreateTable creates the table at startup if it does not exist
+	AutoCreateTable bool
+
+	// Endpoint overrides the DynamoDB endpoint (e.g. a local DynamoDB for testing)
+	Endpoint string
+
+	// HTTPClient tunes the connection pool of the DynamoDB HTTP client
+	HTTPClient awsutil.HTTPClientConfig
+}
+
 // DynamoDBUserStore is a DynamoDB-based implementation of user store
 type DynamoDBUserStore struct {
-	client    *dynamodb.Client
-	tableName string
+	client          *dynamodb.Client
+	tableName       string
+	autoCreateTable bool
+	readiness       *awsutil.TableReadiness
 }
 
 // NewDynamoDBUserStore creates a new DynamoDB-based user store
-func NewDynamoDBUserStore(tableName string) (*DynamoDBUserStore, error) {
-	log.Printf("Initializing DynamoDB user store with table name: %s", tableName)
+func NewDynamoDBUserStore(storeConfig DynamoDBUserStoreConfig) (*DynamoDBUserStore, error) {
+	tableName := awsutil.TableName(storeConfig.TableName, storeConfig.TablePrefix, storeConfig.TableSuffix)
+	log.Printf("Initializing DynamoDB user store with table name: %s (resolved from %q)", tableName, storeConfig.TableName)
 
 	// Validate table name
 	if tableName == "" {
 		return nil, fmt.Errorf("table name cannot be empty")
 	}
 
-	// Load AWS configuration with explicit region
-	// First try to get region from environment variable
-	region := os.Getenv("AWS_REGION")
-	if region == "" {
-		// Default to us-east-1 if not specified
-		region = "us-east-1"
-		log.Printf("AWS_REGION not set, defaulting to %s", region)
-	}
+	// Resolve the region consistently with the other AWS clients
+	region := awsutil.ResolveRegion("")
 
 	// Load AWS configuration
 	log.Printf("Loading AWS configuration for region: %s", region)
 	cfg, err := config.LoadDefaultConfig(context.TODO(),
 		config.WithRegion(region),
+		config.WithHTTPClient(awsutil.NewHTTPClient(storeConfig.HTTPClient)),
 	)
 	if err != nil {
 		log.Printf("Failed to load AWS config: %v", err)
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
+	// Fail at startup rather than on the first call if there are no credentials. A local
+	// endpoint accepts any credentials.
+	if storeConfig.Endpoint == "" {
+		if err := awsutil.CheckCredentials(context.TODO(), cfg); err != nil {
+			log.Printf("ERROR: %v", err)
+			return nil, err
+		}
+	}
+
 	// Create DynamoDB client
-	client := dynamodb.NewFromConfig(cfg)
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if storeConfig.Endpoint != "" {
+			o.BaseEndpoint = aws.String(storeConfig.Endpoint)
+		}
+	})
 
 	log.Printf("Initialized DynamoDB client in region: %s", region)
 
 	// Create the store
 	store := &DynamoDBUserStore{
-		client:    client,
-		tableName: tableName,
+		client:          client,
+		tableName:       tableName,
+		autoCreateTable: storeConfig.AutoCreateTable,
+		readiness:       awsutil.NewTableReadiness(client, tableName),
 	}
 
 	// Ensure the table exists
-	err = store.ensureTableExists()
+	err = store.ensureTableExists(context.TODO())
 	if err != nil {
 		return nil, fmt.Errorf("failed to ensure table exists: %w", err)
 	}
@@ -70,7 +104,7 @@ func NewDynamoDBUserStore(tableName string) (*DynamoDBUserStore, error) {
 }
 
 // ensureTableExists creates the DynamoDB table if it doesn't exist
-func (s *DynamoDBUserStore) ensureTableExists() error {
+func (s *DynamoDBUserStore) ensureTableExists(ctx context.Context) error {
 	log.Printf("Checking if DynamoDB table %s exists...", s.tableName)
 
 	// Check if table exists
@@ -79,7 +113,7 @@ func (s *DynamoDBUserStore) ensureTableExists() error {
 	}
 	log.Printf("Describing table with input: %+v", describeInput)
 
-	describeOutput, err := s.client.DescribeTable(context.TODO(), describeInput)
+	describeOutput, err := s.client.DescribeTable(ctx, describeInput)
 
 	// If table exists, return
 	if err == nil {
@@ -98,6 +132,12 @@ func (s *DynamoDBUserStore) ensureTableExists() error {
 		return fmt.Errorf("failed to describe table: %w", err)
 	}
 
+	if !s.autoCreateTable {
+		log.Printf("ERROR: DynamoDB table %s does not exist and auto-create is disabled", s.tableName)
+		return fmt.Errorf("table %q not found and auto-create is disabled; provision it with partition key Email (S) "+
+			"and global secondary index %s with partition key Sub (S)", s.tableName, subIndexName)
+	}
+
 	log.Printf("DynamoDB table %s does not exist, creating it now...", s.tableName)
 
 	// Create table if it doesn't exist
@@ -108,6 +148,10 @@ func (s *DynamoDBUserStore) ensureTableExists() error {
 				AttributeName: aws.String("Email"),
 				AttributeType: types.ScalarAttributeTypeS,
 			},
+			{
+				AttributeName: aws.String("Sub"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
 		},
 		KeySchema: []types.KeySchemaElement{
 			{
@@ -115,12 +159,27 @@ func (s *DynamoDBUserStore) ensureTableExists() error {
 				KeyType:       types.KeyTypeHash,
 			},
 		},
+		// Index users by Cognito sub (users created without Cognito have no Sub)
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(subIndexName),
+				KeySchema: []types.KeySchemaElement{
+					{
+						AttributeName: aws.String("Sub"),
+						KeyType:       types.KeyTypeHash,
+					},
+				},
+				Projection: &types.Projection{
+					ProjectionType: types.ProjectionTypeAll,
+				},
+			},
+		},
 		BillingMode: types.BillingModePayPerRequest,
 	}
 
 	log.Printf("Creating table with input: %+v", createInput)
 
-	_, err = s.client.CreateTable(context.TODO(), createInput)
+	_, err = s.client.CreateTable(ctx, createInput)
 
 	if err != nil {
 		log.Printf("Failed to create table %s: %v", s.tableName, err)
@@ -131,7 +190,7 @@ func (s *DynamoDBUserStore) ensureTableExists() error {
 
 	// Wait for table to be active
 	waiter := dynamodb.NewTableExistsWaiter(s.client)
-	err = waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{
+	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{
 		TableName: aws.String(s.tableName),
 	}, 5*60)
 
@@ -144,8 +203,31 @@ func (s *DynamoDBUserStore) ensureTableExists() error {
 	return nil
 }
 
+// Ready reports whether the table is reachable and active, for readiness checks. The result of
+// DescribeTable is reused for a few seconds, so frequent probes do not each call it.
+func (s *DynamoDBUserStore) Ready(ctx context.Context) error {
+	return s.readiness.Check(ctx)
+}
+
+// Exists reports whether a user with the given email exists. Only the key is read back.
+func (s *DynamoDBUserStore) Exists(ctx context.Context, email string) (bool, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"Email": &types.AttributeValueMemberS{Value: email},
+		},
+		ProjectionExpression: aws.String("Email"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	if err != nil {
+		log.Printf("Failed to get item from table %s: %v", s.tableName, err)
+		return false, fmt.Errorf("failed to get item from DynamoDB: %w", err)
+	}
+	return len(result.Item) > 0, nil
+}
+
 // GetByEmail retrieves a user by email
-func (s *DynamoDBUserStore) GetByEmail(email string) (*model.User, error) {
+func (s *DynamoDBUserStore) GetByEmail(ctx context.Context, email string) (*model.User, error) {
 	log.Printf("Getting user with email %s from DynamoDB table %s", email, s.tableName)
 
 	// Get item from DynamoDB
@@ -157,8 +239,8 @@ func (s *DynamoDBUserStore) GetByEmail(email string) (*model.User, error) {
 		ConsistentRead: aws.Bool(true),
 	}
 
-	log.Printf("Getting item with input: %+v", getInput)
-	result, err := s.client.GetItem(context.TODO(), getInput)
+	log.Printf("Getting item with key: %s", awsutil.LogItem(getInput.Key))
+	result, err := s.client.GetItem(ctx, getInput)
 
 	if err != nil {
 		log.Printf("Failed to get item from table %s: %v", s.tableName, err)
@@ -183,8 +265,45 @@ func (s *DynamoDBUserStore) GetByEmail(email string) (*model.User, error) {
 	return &user, nil
 }
 
+// GetBySub retrieves a user by Cognito sub, or returns nil if no user has it. The lookup uses a
+// global secondary index, so a just-created user may briefly not be found.
+func (s *DynamoDBUserStore) GetBySub(ctx context.Context, sub string) (*model.User, error) {
+	log.Printf("Getting user with sub %s from DynamoDB table %s", sub, s.tableName)
+
+	result, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(subIndexName),
+		KeyConditionExpression: aws.String("#sub = :sub"),
+		ExpressionAttributeNames: map[string]string{
+			"#sub": "Sub",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sub": &types.AttributeValueMemberS{Value: sub},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		log.Printf("Failed to query index %s on table %s: %v", subIndexName, s.tableName, err)
+		return nil, fmt.Errorf("failed to query user by sub: %w", err)
+	}
+
+	if len(result.Items) == 0 {
+		log.Printf("User with sub %s not found in table %s", sub, s.tableName)
+		return nil, nil
+	}
+
+	var user model.User
+	err = attributevalue.UnmarshalMap(result.Items[0], &user)
+	if err != nil {
+		log.Printf("Failed to unmarshal item: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal item: %w", err)
+	}
+
+	return &user, nil
+}
+
 // GetAll retrieves all users
-func (s *DynamoDBUserStore) GetAll() ([]*model.User, error) {
+func (s *DynamoDBUserStore) GetAll(ctx context.Context) ([]*model.User, error) {
 	log.Printf("Getting all users from DynamoDB table %s", s.tableName)
 
 	// Scan the table to get all items
@@ -194,7 +313,7 @@ func (s *DynamoDBUserStore) GetAll() ([]*model.User, error) {
 	}
 
 	log.Printf("Scanning table with input: %+v", scanInput)
-	result, err := s.client.Scan(context.TODO(), scanInput)
+	result, err := s.client.Scan(ctx, scanInput)
 
 	if err != nil {
 		log.Printf("Failed to scan table %s: %v", s.tableName, err)
@@ -206,7 +325,7 @@ func (s *DynamoDBUserStore) GetAll() ([]*model.User, error) {
 	// Unmarshal items into users
 	users := make([]*model.User, 0, len(result.Items))
 	for i, item := range result.Items {
-		log.Printf("Processing item %d: %+v", i, item)
+		log.Printf("Processing item %d: %s", i, awsutil.LogItem(item))
 		var user model.User
 		err := attributevalue.UnmarshalMap(item, &user)
 		if err != nil {
@@ -221,8 +340,84 @@ func (s *DynamoDBUserStore) GetAll() ([]*model.User, error) {
 	return users, nil
 }
 
+// GetPage retrieves up to limit users with one page of a table scan, starting after the user the
+// cursor points at. The scan order is fixed by the table's partitioning, so cursors are stable.
+func (s *DynamoDBUserStore) GetPage(ctx context.Context, cursor string, limit int32) ([]*model.User, string, error) {
+	log.Printf("Getting a page of up to %d users from DynamoDB table %s", limit, s.tableName)
+
+	var startKey map[string]types.AttributeValue
+	if cursor != "" {
+		email, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		startKey = map[string]types.AttributeValue{"Email": &types.AttributeValueMemberS{Value: email}}
+	}
+
+	result, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+		TableName:         aws.String(s.tableName),
+		ConsistentRead:    aws.Bool(true),
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(limit),
+	})
+	if err != nil {
+		log.Printf("Failed to scan table %s: %v", s.tableName, err)
+		return nil, "", fmt.Errorf("failed to scan users: %w", err)
+	}
+
+	users := make([]*model.User, 0, len(result.Items))
+	for i, item := range result.Items {
+		var user model.User
+		if err := attributevalue.UnmarshalMap(item, &user); err != nil {
+			log.Printf("Failed to unmarshal item %d: %v", i, err)
+			continue
+		}
+		users = append(users, &user)
+	}
+
+	next := ""
+	if key, ok := result.LastEvaluatedKey["Email"].(*types.AttributeValueMemberS); ok {
+		next = encodeCursor(key.Value)
+	}
+
+	log.Printf("Returning %d users from table %s", len(users), s.tableName)
+	return users, next, nil
+}
+
+// GetRecent retrieves up to limit of the most recently created users, newest first. The table
+// has no index on CreatedAt, so this scans every user and sorts in memory.
+func (s *DynamoDBUserStore) GetRecent(ctx context.Context, limit int) ([]*model.User, error) {
+	users, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newestUsers(users, limit), nil
+}
+
+// CountUsers returns the number of users. DynamoDB has no cheap exact count, so this scans the
+// table with Select COUNT, which reads every item without returning any.
+func (s *DynamoDBUserStore) CountUsers(ctx context.Context) (int64, error) {
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName: aws.String(s.tableName),
+		Select:    types.SelectCount,
+	})
+
+	var count int64
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			log.Printf("Failed to count users in table %s: %v", s.tableName, err)
+			return 0, fmt.Errorf("failed to count users: %w", err)
+		}
+		count += int64(page.Count)
+	}
+
+	log.Printf("Counted %d users in table %s", count, s.tableName)
+	return count, nil
+}
+
 // Create creates a new user
-func (s *DynamoDBUserStore) Create(user *model.User) error {
+func (s *DynamoDBUserStore) Create(ctx context.Context, user *model.User) error {
 	log.Printf("Creating user with email %s in DynamoDB table %s", user.Email, s.tableName)
 
 	// Marshal user to DynamoDB item
@@ -232,7 +427,7 @@ func (s *DynamoDBUserStore) Create(user *model.User) error {
 		return fmt.Errorf("failed to marshal user: %w", err)
 	}
 
-	log.Printf("Marshalled user to DynamoDB item: %+v", item)
+	log.Printf("Marshalled user to DynamoDB item: %s", awsutil.LogItem(item))
 
 	// Put item in table
 	input := &dynamodb.PutItemInput{
@@ -241,16 +436,16 @@ func (s *DynamoDBUserStore) Create(user *model.User) error {
 		// Add a condition to ensure the item doesn't already exist
 		ConditionExpression: aws.String("attribute_not_exists(Email)"),
 	}
-	log.Printf("Putting item in table %s with input: %+v", s.tableName, input)
+	log.Printf("Putting item in table %s: %s", s.tableName, awsutil.LogItem(input.Item))
 
-	_, err = s.client.PutItem(context.TODO(), input)
+	_, err = s.client.PutItem(ctx, input)
 
 	if err != nil {
 		// Check if the error is because the condition failed (item already exists)
 		var conditionFailedErr *types.ConditionalCheckFailedException
 		if errors.As(err, &conditionFailedErr) {
 			log.Printf("User with email %s already exists in table %s", user.Email, s.tableName)
-			return fmt.Errorf("user with email %s already exists", user.Email)
+			return fmt.Errorf("user with email %s: %w", user.Email, ErrAlreadyExists)
 		}
 
 		log.Printf("ERROR: Failed to put item in table %s: %v", s.tableName, err)
@@ -261,8 +456,117 @@ func (s *DynamoDBUserStore) Create(user *model.User) error {
 	return nil
 }
 
+// GetOrCreate creates the user with a conditional put unless one with the same email exists,
+// returning the stored user and whether it was created. When the condition fails, the existing
+// item is returned with the error, so no separate read is needed.
+func (s *DynamoDBUserStore) GetOrCreate(ctx context.Context, user *model.User) (*model.User, bool, error) {
+	log.Printf("Getting or creating user with email %s in DynamoDB table %s", user.Email, s.tableName)
+
+	// Marshal user to DynamoDB item
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		log.Printf("Failed to marshal user: %v", err)
+		return nil, false, fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                           aws.String(s.tableName),
+		Item:                                item,
+		ConditionExpression:                 aws.String("attribute_not_exists(Email)"),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	if err == nil {
+		log.Printf("Successfully created user with email %s in DynamoDB table %s", user.Email, s.tableName)
+		return user, true, nil
+	}
+
+	var conditionFailedErr *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionFailedErr) {
+		log.Printf("ERROR: Failed to put item in table %s: %v", s.tableName, err)
+		return nil, false, fmt.Errorf("failed to put item in DynamoDB: %w", err)
+	}
+
+	log.Printf("User with email %s already exists in table %s", user.Email, s.tableName)
+	if conditionFailedErr.Item == nil {
+		// Older DynamoDB-compatible endpoints may not return the existing item
+		existing, err := s.GetByEmail(ctx, user.Email)
+		if err != nil {
+			return nil, false, err
+		}
+		if existing == nil {
+			return nil, false, fmt.Errorf("user with email %s vanished after a failed create", user.Email)
+		}
+		return existing, false, nil
+	}
+
+	var existing model.User
+	if err := attributevalue.UnmarshalMap(conditionFailedErr.Item, &existing); err != nil {
+		log.Printf("Failed to unmarshal existing user: %v", err)
+		return nil, false, fmt.Errorf("failed to unmarshal existing user: %w", err)
+	}
+	return &existing, false, nil
+}
+
+// CreateWithInit creates a new user together with related initialization items in a single
+// TransactWriteItems call, so either every item is written or none are. Initialization items are
+// written to the user table and must include its Email partition key. DynamoDB limits a
+// transaction to 25 items (including the user record) and 4 MB of data.
+func (s *DynamoDBUserStore) CreateWithInit(ctx context.Context, user *model.User, initItems ...map[string]types.AttributeValue) error {
+	log.Printf("Creating user with email %s and %d initialization items in DynamoDB table %s",
+		user.Email, len(initItems), s.tableName)
+
+	if len(initItems)+1 > MaxTransactionItems {
+		return fmt.Errorf("transaction has %d items, maximum is %d", len(initItems)+1, MaxTransactionItems)
+	}
+
+	// Marshal user to DynamoDB item
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		log.Printf("Failed to marshal user: %v", err)
+		return fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	transactItems := make([]types.TransactWriteItem, 0, len(initItems)+1)
+	transactItems = append(transactItems, types.TransactWriteItem{
+		Put: &types.Put{
+			TableName: aws.String(s.tableName),
+			Item:      item,
+			// Add a condition to ensure the user doesn't already exist
+			ConditionExpression: aws.String("attribute_not_exists(Email)"),
+		},
+	})
+	for _, initItem := range initItems {
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName: aws.String(s.tableName),
+				Item:      initItem,
+			},
+		})
+	}
+
+	_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+
+	if err != nil {
+		// Check if the transaction was cancelled because the user already exists
+		var cancelledErr *types.TransactionCanceledException
+		if errors.As(err, &cancelledErr) && len(cancelledErr.CancellationReasons) > 0 &&
+			aws.ToString(cancelledErr.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			log.Printf("User with email %s already exists in table %s", user.Email, s.tableName)
+			return fmt.Errorf("user with email %s: %w", user.Email, ErrAlreadyExists)
+		}
+
+		log.Printf("ERROR: Failed to write transaction to table %s: %v", s.tableName, err)
+		return fmt.Errorf("failed to write transaction to DynamoDB: %w", err)
+	}
+
+	log.Printf("Successfully created user with email %s in DynamoDB table %s", user.Email, s.tableName)
+	return nil
+}
+
 // Update updates an existing user
-func (s *DynamoDBUserStore) Update(user *model.User) error {
+func (s *DynamoDBUserStore) Update(ctx context.Context, user *model.User) error {
 	log.Printf("Updating user with email %s in DynamoDB table %s", user.Email, s.tableName)
 
 	// Marshal user to DynamoDB item
@@ -272,7 +576,7 @@ func (s *DynamoDBUserStore) Update(user *model.User) error {
 		return fmt.Errorf("failed to marshal user: %w", err)
 	}
 
-	log.Printf("Marshalled user to DynamoDB item: %+v", item)
+	log.Printf("Marshalled user to DynamoDB item: %s", awsutil.LogItem(item))
 
 	// Put item in table
 	input := &dynamodb.PutItemInput{
@@ -281,9 +585,9 @@ func (s *DynamoDBUserStore) Update(user *model.User) error {
 		// Add a condition to ensure the item already exists
 		ConditionExpression: aws.String("attribute_exists(Email)"),
 	}
-	log.Printf("Putting item in table %s with input: %+v", s.tableName, input)
+	log.Printf("Putting item in table %s: %s", s.tableName, awsutil.LogItem(input.Item))
 
-	_, err = s.client.PutItem(context.TODO(), input)
+	_, err = s.client.PutItem(ctx, input)
 
 	if err != nil {
 		// Check if the error is because the condition failed (item doesn't exist)
@@ -301,8 +605,68 @@ func (s *DynamoDBUserStore) Update(user *model.User) error {
 	return nil
 }
 
+// ChangeEmail moves the user stored under oldEmail to user.Email. Email is the table's partition
+// key, so the old item is deleted and the new one put in a single TransactWriteItems call.
+func (s *DynamoDBUserStore) ChangeEmail(ctx context.Context, oldEmail string, user *model.User) error {
+	if user.Email == oldEmail {
+		return s.Update(ctx, user)
+	}
+	log.Printf("Changing email of user %s to %s in DynamoDB table %s", oldEmail, user.Email, s.tableName)
+
+	// Marshal user to DynamoDB item
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		log.Printf("Failed to marshal user: %v", err)
+		return fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName: aws.String(s.tableName),
+					Key: map[string]types.AttributeValue{
+						"Email": &types.AttributeValueMemberS{Value: oldEmail},
+					},
+					// Add a condition to ensure the old user still exists
+					ConditionExpression: aws.String("attribute_exists(Email)"),
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName: aws.String(s.tableName),
+					Item:      item,
+					// Add a condition to ensure the new email isn't taken
+					ConditionExpression: aws.String("attribute_not_exists(Email)"),
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		// Check which condition cancelled the transaction
+		var cancelledErr *types.TransactionCanceledException
+		if errors.As(err, &cancelledErr) && len(cancelledErr.CancellationReasons) == 2 {
+			if aws.ToString(cancelledErr.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				log.Printf("User with email %s does not exist in table %s", oldEmail, s.tableName)
+				return fmt.Errorf("user with email %s does not exist", oldEmail)
+			}
+			if aws.ToString(cancelledErr.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				log.Printf("User with email %s already exists in table %s", user.Email, s.tableName)
+				return fmt.Errorf("user with email %s: %w", user.Email, ErrAlreadyExists)
+			}
+		}
+
+		log.Printf("ERROR: Failed to write transaction to table %s: %v", s.tableName, err)
+		return fmt.Errorf("failed to write transaction to DynamoDB: %w", err)
+	}
+
+	log.Printf("Successfully changed email of user %s to %s in DynamoDB table %s", oldEmail, user.Email, s.tableName)
+	return nil
+}
+
 // Delete deletes a user by email
-func (s *DynamoDBUserStore) Delete(email string) error {
+func (s *DynamoDBUserStore) Delete(ctx context.Context, email string) error {
 	log.Printf("Deleting user with email %s from DynamoDB table %s", email, s.tableName)
 
 	// Delete item from table
@@ -312,9 +676,9 @@ func (s *DynamoDBUserStore) Delete(email string) error {
 			"Email": &types.AttributeValueMemberS{Value: email},
 		},
 	}
-	log.Printf("Deleting item from table %s with input: %+v", s.tableName, input)
+	log.Printf("Deleting item from table %s with key: %s", s.tableName, awsutil.LogItem(input.Key))
 
-	_, err := s.client.DeleteItem(context.TODO(), input)
+	_, err := s.client.DeleteItem(ctx, input)
 
 	if err != nil {
 		log.Printf("ERROR: Failed to delete item from table %s: %v", s.tableName, err)
@@ -324,3 +688,75 @@ func (s *DynamoDBUserStore) Delete(email string) error {
 	log.Printf("Successfully deleted user with email %s from DynamoDB table %s", email, s.tableName)
 	return nil
 }
+
+// selfTestProbeEmail is the reserved key the startup self-test addresses. No item is ever stored under
+// it, so user listings never see it.
+const selfTestProbeEmail = "__startup_selftest__"
+
+// selfTestProbeAttribute is an attribute no item has. The self-test's writes require it, so
+// they always fail their condition and never change the table.
+const selfTestProbeAttribute = "SelfTestProbe"
+
+// SelfTest checks that the table exists and that this task may read and write it, to surface
+// missing IAM permissions at startup rather than on the first user request. It changes nothing:
+// DynamoDB checks permissions before conditions, so a write rejected by a condition no item
+// meets shows the write is allowed.
+func (s *DynamoDBUserStore) SelfTest(ctx context.Context) error {
+	log.Printf("Running startup self-test against DynamoDB table %s", s.tableName)
+
+	_, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("self-test failed to describe table (check IAM permissions for dynamodb:DescribeTable on table %s): %w", s.tableName, err)
+	}
+
+	key := map[string]types.AttributeValue{
+		"Email": &types.AttributeValueMemberS{Value: selfTestProbeEmail},
+	}
+
+	_, err = s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("self-test failed to read probe item (check IAM permissions for dynamodb:GetItem on table %s): %w", s.tableName, err)
+	}
+
+	condition := aws.String("attribute_exists(#probe)")
+	names := map[string]string{"#probe": selfTestProbeAttribute}
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(s.tableName),
+		Item:                     key,
+		ConditionExpression:      condition,
+		ExpressionAttributeNames: names,
+	})
+	if err := selfTestWriteError(err); err != nil {
+		return fmt.Errorf("self-test failed to check writes (check IAM permissions for dynamodb:PutItem on table %s): %w", s.tableName, err)
+	}
+
+	_, err = s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(s.tableName),
+		Key:                      key,
+		ConditionExpression:      condition,
+		ExpressionAttributeNames: names,
+	})
+	if err := selfTestWriteError(err); err != nil {
+		return fmt.Errorf("self-test failed to check deletes (check IAM permissions for dynamodb:DeleteItem on table %s): %w", s.tableName, err)
+	}
+
+	log.Printf("Startup self-test against DynamoDB table %s passed", s.tableName)
+	return nil
+}
+
+// selfTestWriteError returns the error of a self-test write, other than the failed condition
+// that shows the write was allowed
+func selfTestWriteError(err error) error {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/usersvc/internal/model"
+)
+
+// selfTestTransport answers the self-test's calls like a table with no probe item, failing
+// each operation in denied with AccessDeniedException. It records each operation's request.
+type selfTestTransport struct {
+	denied     string
+	operations []string
+	bodies     []map[string]any
+}
+
+func (f *selfTestTransport) Do(req *http.Request) (*http.Response, error) {
+	var body map[string]any
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	operation := strings.TrimPrefix(req.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	f.operations = append(f.operations, operation)
+	f.bodies = append(f.bodies, body)
+
+	status, response := http.StatusOK, `{}`
+	switch {
+	case operation == f.denied:
+		status, response = http.StatusBadRequest, `{"__type":"com.amazon.coral.service#AccessDeniedException","message":"not authorized"}`
+	case operation == "DescribeTable":
+		response = `{"Table":{"TableName":"users","TableStatus":"ACTIVE"}}`
+	case operation == "PutItem" || operation == "DeleteItem":
+		status, response = http.StatusBadRequest, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func TestDynamoDBUserStoreSelfTest(t *testing.T) {
+	for _, denied := range []string{"", "DescribeTable", "GetItem", "PutItem", "DeleteItem"} {
+		transport := &selfTestTransport{denied: denied}
+		client := dynamodb.New(dynamodb.Options{
+			Region:           "us-east-1",
+			Credentials:      aws.AnonymousCredentials{},
+			HTTPClient:       transport,
+			RetryMaxAttempts: 1,
+		})
+		s := &DynamoDBUserStore{client: client, tableName: "users"}
+
+		err := s.SelfTest(context.Background())
+		if denied == "" {
+			if err != nil {
+				t.Errorf("SelfTest with full access: %v", err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), "dynamodb:"+denied) {
+			t.Errorf("SelfTest without %s: got %v, want an error naming the permission", denied, err)
+		}
+
+		// Every write is conditioned on an attribute no item has, so the table never changes
+		for i, operation := range transport.operations {
+			if operation != "PutItem" && operation != "DeleteItem" {
+				continue
+			}
+			if transport.bodies[i]["ConditionExpression"] != "attribute_exists(#probe)" {
+				t.Errorf("%s sent without the probe condition: %v", operation, transport.bodies[i])
+			}
+		}
+	}
+}
+
+// missingTableTransport answers DescribeTable as if the table did not exist and records every
+// operation
+type missingTableTransport struct {
+	operations []string
+}
+
+func (f *missingTableTransport) Do(req *http.Request) (*http.Response, error) {
+	f.operations = append(f.operations, strings.TrimPrefix(req.Header.Get("X-Amz-Target"), "DynamoDB_20120810."))
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Requested resource not found"}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestDynamoDBUserStoreMissingTableWithoutAutoCreate(t *testing.T) {
+	transport := &missingTableTransport{}
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+	})
+	s := &DynamoDBUserStore{client: client, tableName: "users"}
+
+	err := s.ensureTableExists(context.Background())
+	if err == nil {
+		t.Fatal("ensureTableExists succeeded for a missing table")
+	}
+	for _, hint := range []string{`table "users" not found`, "auto-create is disabled", "partition key Email (S)", subIndexName + " with partition key Sub (S)"} {
+		if !strings.Contains(err.Error(), hint) {
+			t.Errorf("error %q does not contain %q", err, hint)
+		}
+	}
+	if strings.Join(transport.operations, ",") != "DescribeTable" {
+		t.Errorf("sent %v, want only DescribeTable", transport.operations)
+	}
+}
+
+// transactionTransport cancels every transaction with the given cancellation reason codes, as
+// DynamoDB does when any item fails, and records every request
+type transactionTransport struct {
+	reasons    []string
+	operations []string
+	bodies     []map[string]any
+}
+
+func (f *transactionTransport) Do(req *http.Request) (*http.Response, error) {
+	var body map[string]any
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	f.operations = append(f.operations, strings.TrimPrefix(req.Header.Get("X-Amz-Target"), "DynamoDB_20120810."))
+	f.bodies = append(f.bodies, body)
+
+	reasons := make([]string, len(f.reasons))
+	for i, code := range f.reasons {
+		reasons[i] = `{"Code":"` + code + `"}`
+	}
+	response := `{"__type":"com.amazonaws.dynamodb.v20120810#TransactionCanceledException","message":"Transaction cancelled","CancellationReasons":[` + strings.Join(reasons, ",") + `]}`
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+func TestDynamoDBUserStoreCreateWithInit(t *testing.T) {
+	tests := []struct {
+		name       string
+		reasons    []string
+		wantExists bool
+	}{
+		{"user exists", []string{"ConditionalCheckFailed", "None"}, true},
+		{"item fails", []string{"None", "ValidationError"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &transactionTransport{reasons: tt.reasons}
+			client := dynamodb.New(dynamodb.Options{
+				Region:           "us-east-1",
+				Credentials:      aws.AnonymousCredentials{},
+				HTTPClient:       transport,
+				RetryMaxAttempts: 1,
+			})
+			s := &DynamoDBUserStore{client: client, tableName: "users"}
+
+			err := s.CreateWithInit(context.Background(), model.NewUser("a@example.com", "Create", "Init"), map[string]types.AttributeValue{
+				"Email": &types.AttributeValueMemberS{Value: "counter#a@example.com"},
+			})
+			if err == nil {
+				t.Fatal("CreateWithInit succeeded in a cancelled transaction")
+			}
+			if errors.Is(err, ErrAlreadyExists) != tt.wantExists {
+				t.Errorf("got %v, want ErrAlreadyExists %t", err, tt.wantExists)
+			}
+
+			// The user and the item go out in one transaction, so DynamoDB rolls back the user
+			// put when the item fails; nothing is written outside it
+			if strings.Join(transport.operations, ",") != "TransactWriteItems" {
+				t.Fatalf("sent %v, want only TransactWriteItems", transport.operations)
+			}
+			items, _ := transport.bodies[0]["TransactItems"].([]any)
+			if len(items) != 2 {
+				t.Errorf("transaction has %d items, want the user and the initialization item", len(items))
+			}
+		})
+	}
+
+	// Transactions over the item limit are rejected without a request
+	transport := &transactionTransport{}
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		HTTPClient:       transport,
+		RetryMaxAttempts: 1,
+	})
+	s := &DynamoDBUserStore{client: client, tableName: "users"}
+	items := make([]map[string]types.AttributeValue, MaxTransactionItems)
+	if err := s.CreateWithInit(context.Background(), model.NewUser("a@example.com", "Create", "Init"), items...); err == nil {
+		t.Error("CreateWithInit succeeded over the item limit")
+	}
+	if len(transport.operations) != 0 {
+		t.Errorf("sent %v over the item limit, want nothing", transport.operations)
+	}
+}
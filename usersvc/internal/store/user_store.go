@@ -1,41 +1,96 @@
 package store
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws_e2e_test/usersvc/internal/model"
 )
 
+// MaxTransactionItems is the maximum number of items DynamoDB allows in a single transaction.
+// A transaction is also limited to 4 MB of data in total.
+const MaxTransactionItems = 25
+
+// ErrAlreadyExists is returned when creating a user whose email is already taken
+var ErrAlreadyExists = errors.New("user already exists")
+
+// ErrInvalidCursor is returned when a page cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 // UserStore is an interface for user storage
 type UserStore interface {
 	// GetByEmail retrieves a user by email
-	GetByEmail(email string) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+
+	// Exists reports whether a user with the given email exists, without reading the full record
+	Exists(ctx context.Context, email string) (bool, error)
+
+	// GetBySub retrieves a user by Cognito sub
+	GetBySub(ctx context.Context, sub string) (*model.User, error)
 
 	// GetAll retrieves all users
-	GetAll() ([]*model.User, error)
+	GetAll(ctx context.Context) ([]*model.User, error)
+
+	// GetPage retrieves up to limit users starting after the one the cursor points at, along
+	// with the cursor of the next page ("" on the last page)
+	GetPage(ctx context.Context, cursor string, limit int32) ([]*model.User, string, error)
+
+	// GetRecent retrieves up to limit of the most recently created users, newest first
+	GetRecent(ctx context.Context, limit int) ([]*model.User, error)
+
+	// CountUsers returns the number of users
+	CountUsers(ctx context.Context) (int64, error)
+
+	// Create creates a new user, returning ErrAlreadyExists if the email is already taken
+	Create(ctx context.Context, user *model.User) error
 
-	// Create creates a new user
-	Create(user *model.User) error
+	// GetOrCreate atomically creates the user unless one with the same email exists, returning
+	// the stored user and whether it was created
+	GetOrCreate(ctx context.Context, user *model.User) (*model.User, bool, error)
+
+	// CreateWithInit creates a new user together with related initialization items
+	// (keyed by the user table's Email partition key) as a single all-or-nothing write
+	CreateWithInit(ctx context.Context, user *model.User, initItems ...map[string]types.AttributeValue) error
 
 	// Update updates an existing user
-	Update(user *model.User) error
+	Update(ctx context.Context, user *model.User) error
+
+	// ChangeEmail moves the user stored under oldEmail to user.Email, replacing the record with
+	// user as a single all-or-nothing write. It returns ErrAlreadyExists if the new email is taken.
+	ChangeEmail(ctx context.Context, oldEmail string, user *model.User) error
 
 	// Delete deletes a user by email
-	Delete(email string) error
+	Delete(ctx context.Context, email string) error
 }
 
 // NewUserStore creates a new in-memory user store
 func NewUserStore() UserStore {
 	return &InMemoryUserStore{
 		users: make(map[string]*model.User),
+		items: make(map[string]map[string]types.AttributeValue),
 	}
 }
 
-// InMemoryUserStore is an in-memory implementation of UserStore
+// InMemoryUserStore is an in-memory implementation of UserStore. Users are listed in the order
+// they were created, so paging through them is stable as it is on DynamoDB.
 type InMemoryUserStore struct {
 	users map[string]*model.User
+	order []string // emails of users, in insertion order
+	items map[string]map[string]types.AttributeValue
+	mutex sync.RWMutex
 }
 
 // GetByEmail retrieves a user by email
-func (s *InMemoryUserStore) GetByEmail(email string) (*model.User, error) {
+func (s *InMemoryUserStore) GetByEmail(_ context.Context, email string) (*model.User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	user, exists := s.users[email]
 	if !exists {
 		return nil, nil
@@ -43,29 +98,229 @@ func (s *InMemoryUserStore) GetByEmail(email string) (*model.User, error) {
 	return user, nil
 }
 
+// Exists reports whether a user with the given email exists
+func (s *InMemoryUserStore) Exists(_ context.Context, email string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, exists := s.users[email]
+	return exists, nil
+}
+
+// GetBySub retrieves a user by Cognito sub
+func (s *InMemoryUserStore) GetBySub(_ context.Context, sub string) (*model.User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, email := range s.order {
+		if user := s.users[email]; user.Sub != "" && user.Sub == sub {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
 // GetAll retrieves all users
-func (s *InMemoryUserStore) GetAll() ([]*model.User, error) {
-	users := make([]*model.User, 0, len(s.users))
-	for _, user := range s.users {
-		users = append(users, user)
+func (s *InMemoryUserStore) GetAll(_ context.Context) ([]*model.User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	users := make([]*model.User, 0, len(s.order))
+	for _, email := range s.order {
+		users = append(users, s.users[email])
 	}
 	return users, nil
 }
 
+// GetPage retrieves up to limit users in insertion order, starting after the user the cursor
+// points at. A cursor for a user deleted since is rejected with ErrInvalidCursor.
+func (s *InMemoryUserStore) GetPage(_ context.Context, cursor string, limit int32) ([]*model.User, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("page limit must be positive, got %d", limit)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	start := 0
+	if cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		position := slices.Index(s.order, after)
+		if position < 0 {
+			return nil, "", fmt.Errorf("%w: user %s no longer exists", ErrInvalidCursor, after)
+		}
+		start = position + 1
+	}
+
+	end := min(start+int(limit), len(s.order))
+	users := make([]*model.User, 0, end-start)
+	for _, email := range s.order[start:end] {
+		users = append(users, s.users[email])
+	}
+	if end == len(s.order) {
+		return users, "", nil
+	}
+	return users, encodeCursor(s.order[end-1]), nil
+}
+
+// encodeCursor encodes the email of the last user on a page as an opaque, URL-safe cursor
+func encodeCursor(email string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(email))
+}
+
+// decodeCursor decodes a cursor produced by encodeCursor back into the email to resume after
+func decodeCursor(cursor string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) == 0 {
+		return "", fmt.Errorf("%w: malformed cursor", ErrInvalidCursor)
+	}
+	return string(data), nil
+}
+
+// GetRecent retrieves up to limit of the most recently created users, newest first
+func (s *InMemoryUserStore) GetRecent(ctx context.Context, limit int) ([]*model.User, error) {
+	users, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newestUsers(users, limit), nil
+}
+
+// CountUsers returns the number of users
+func (s *InMemoryUserStore) CountUsers(_ context.Context) (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return int64(len(s.users)), nil
+}
+
+// newestUsers sorts users by creation time, newest first (ties broken by email), and returns
+// up to limit of them
+func newestUsers(users []*model.User, limit int) []*model.User {
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i].CreatedAt.Time(), users[j].CreatedAt.Time()
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return users[i].Email < users[j].Email
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users
+}
+
 // Create creates a new user
-func (s *InMemoryUserStore) Create(user *model.User) error {
-	s.users[user.Email] = user
+func (s *InMemoryUserStore) Create(_ context.Context, user *model.User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[user.Email]; exists {
+		return fmt.Errorf("user with email %s: %w", user.Email, ErrAlreadyExists)
+	}
+
+	s.put(user)
+	return nil
+}
+
+// GetOrCreate creates the user unless one with the same email exists, returning the stored
+// user and whether it was created. The check and the write happen under a single lock.
+func (s *InMemoryUserStore) GetOrCreate(_ context.Context, user *model.User) (*model.User, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existing, exists := s.users[user.Email]; exists {
+		return existing, false, nil
+	}
+
+	s.put(user)
+	return user, true, nil
+}
+
+// CreateWithInit creates a new user together with related initialization items.
+// All writes happen under a single lock, and nothing is written if any item is invalid.
+func (s *InMemoryUserStore) CreateWithInit(_ context.Context, user *model.User, initItems ...map[string]types.AttributeValue) error {
+	if len(initItems)+1 > MaxTransactionItems {
+		return fmt.Errorf("transaction has %d items, maximum is %d", len(initItems)+1, MaxTransactionItems)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Validate every write before applying any of them
+	if _, exists := s.users[user.Email]; exists {
+		return fmt.Errorf("user with email %s: %w", user.Email, ErrAlreadyExists)
+	}
+	keys := make([]string, len(initItems))
+	for i, item := range initItems {
+		key, ok := item["Email"].(*types.AttributeValueMemberS)
+		if !ok {
+			return fmt.Errorf("initialization item %d is missing the Email key", i)
+		}
+		if _, exists := s.users[key.Value]; exists || key.Value == user.Email {
+			return fmt.Errorf("initialization item %d conflicts with a user record", i)
+		}
+		keys[i] = key.Value
+	}
+
+	s.put(user)
+	for i, item := range initItems {
+		s.items[keys[i]] = item
+	}
 	return nil
 }
 
 // Update updates an existing user
-func (s *InMemoryUserStore) Update(user *model.User) error {
+func (s *InMemoryUserStore) Update(_ context.Context, user *model.User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.put(user)
+	return nil
+}
+
+// ChangeEmail moves the user stored under oldEmail to user.Email. The user keeps its place in
+// the insertion order.
+func (s *InMemoryUserStore) ChangeEmail(_ context.Context, oldEmail string, user *model.User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[oldEmail]; !exists {
+		return fmt.Errorf("user with email %s does not exist", oldEmail)
+	}
+	if user.Email == oldEmail {
+		s.users[oldEmail] = user
+		return nil
+	}
+	if _, exists := s.users[user.Email]; exists {
+		return fmt.Errorf("user with email %s: %w", user.Email, ErrAlreadyExists)
+	}
+
+	delete(s.users, oldEmail)
 	s.users[user.Email] = user
+	s.order[slices.Index(s.order, oldEmail)] = user.Email
 	return nil
 }
 
 // Delete deletes a user by email
-func (s *InMemoryUserStore) Delete(email string) error {
-	delete(s.users, email)
+func (s *InMemoryUserStore) Delete(_ context.Context, email string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[email]; exists {
+		delete(s.users, email)
+		s.order = slices.DeleteFunc(s.order, func(e string) bool { return e == email })
+	}
 	return nil
 }
+
+// put stores user, appending new users to the insertion order. The caller holds the write lock.
+func (s *InMemoryUserStore) put(user *model.User) {
+	if _, exists := s.users[user.Email]; !exists {
+		s.order = append(s.order, user.Email)
+	}
+	s.users[user.Email] = user
+}
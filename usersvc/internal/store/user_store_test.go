@@ -0,0 +1,267 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/usersvc/internal/model"
+)
+
+func TestInMemoryUserStoreConcurrentCreate(t *testing.T) {
+	s := NewUserStore()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Create(context.Background(), model.NewUser("race@example.com", "Race", "Condition"))
+		}()
+	}
+	wg.Wait()
+
+	var created, exists int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case errors.Is(err, ErrAlreadyExists):
+			exists++
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if created != 1 || exists != 1 {
+		t.Errorf("got %d created and %d already exists, want 1 and 1", created, exists)
+	}
+}
+
+func TestInMemoryUserStoreGetBySub(t *testing.T) {
+	s := NewUserStore()
+	user := model.NewUser("sub@example.com", "Sub", "Lookup")
+	user.Sub = "11111111-2222-3333-4444-555555555555"
+	if err := s.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(context.Background(), model.NewUser("nosub@example.com", "No", "Sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := s.GetBySub(context.Background(), user.Sub)
+	if err != nil {
+		t.Fatalf("GetBySub: %v", err)
+	}
+	if found == nil || found.Email != user.Email {
+		t.Errorf("GetBySub(%q) = %+v, want %s", user.Sub, found, user.Email)
+	}
+
+	for _, sub := range []string{"no-such-sub", ""} {
+		found, err := s.GetBySub(context.Background(), sub)
+		if err != nil {
+			t.Fatalf("GetBySub(%q): %v", sub, err)
+		}
+		if found != nil {
+			t.Errorf("GetBySub(%q) = %+v, want nil", sub, found)
+		}
+	}
+}
+
+func TestInMemoryUserStoreGetOrCreate(t *testing.T) {
+	s := NewUserStore()
+
+	first := model.NewUser("getorcreate@example.com", "First", "User")
+	got, created, err := s.GetOrCreate(context.Background(), first)
+	if err != nil {
+		t.Fatalf("GetOrCreate: %v", err)
+	}
+	if !created || got != first {
+		t.Errorf("first GetOrCreate = (%+v, %v), want the new user and created", got, created)
+	}
+
+	second := model.NewUser("getorcreate@example.com", "Second", "User")
+	got, created, err = s.GetOrCreate(context.Background(), second)
+	if err != nil {
+		t.Fatalf("GetOrCreate: %v", err)
+	}
+	if created || got == nil || got.FirstName != "First" {
+		t.Errorf("second GetOrCreate = (%+v, %v), want the existing user and not created", got, created)
+	}
+}
+
+func TestInMemoryUserStoreExists(t *testing.T) {
+	s := NewUserStore()
+	if err := s.Create(context.Background(), model.NewUser("present@example.com", "Present", "User")); err != nil {
+		t.Fatal(err)
+	}
+
+	for email, want := range map[string]bool{"present@example.com": true, "absent@example.com": false} {
+		exists, err := s.Exists(context.Background(), email)
+		if err != nil {
+			t.Fatalf("Exists(%q): %v", email, err)
+		}
+		if exists != want {
+			t.Errorf("Exists(%q) = %v, want %v", email, exists, want)
+		}
+	}
+}
+
+func TestInMemoryUserStoreGetPage(t *testing.T) {
+	s := NewUserStore()
+	var emails []string
+	for i := 0; i < 25; i++ {
+		email := fmt.Sprintf("user%02d@example.com", i)
+		if err := s.Create(context.Background(), model.NewUser(email, "Page", "User")); err != nil {
+			t.Fatal(err)
+		}
+		emails = append(emails, email)
+	}
+
+	pageThrough := func() []string {
+		var seen []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(emails) {
+				t.Fatal("paging did not end")
+			}
+			users, next, err := s.GetPage(context.Background(), cursor, 7)
+			if err != nil {
+				t.Fatalf("GetPage(%q): %v", cursor, err)
+			}
+			for _, user := range users {
+				seen = append(seen, user.Email)
+			}
+			if next == "" {
+				return seen
+			}
+			cursor = next
+		}
+	}
+
+	// Every user appears exactly once, in insertion order, and paging again gives the same result
+	first := pageThrough()
+	if !slices.Equal(first, emails) {
+		t.Fatalf("paged users = %v, want %v", first, emails)
+	}
+	if again := pageThrough(); !slices.Equal(again, first) {
+		t.Errorf("second pass = %v, want %v", again, first)
+	}
+
+	all, err := s.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, user := range all {
+		if user.Email != emails[i] {
+			t.Fatalf("GetAll()[%d] = %s, want %s", i, user.Email, emails[i])
+		}
+	}
+
+	// A cursor for a user deleted since is rejected
+	_, next, err := s.GetPage(context.Background(), "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(context.Background(), emails[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.GetPage(context.Background(), next, 1); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("GetPage with a deleted user's cursor: got %v, want ErrInvalidCursor", err)
+	}
+	if _, _, err := s.GetPage(context.Background(), "not base64!", 1); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("GetPage with a malformed cursor: got %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestInMemoryUserStoreChangeEmail(t *testing.T) {
+	s := NewUserStore()
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if err := s.Create(context.Background(), model.NewUser(email, "Change", "Email")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved := model.NewUser("d@example.com", "Change", "Email")
+	if err := s.ChangeEmail(context.Background(), "b@example.com", moved); err != nil {
+		t.Fatalf("ChangeEmail: %v", err)
+	}
+	if user, _ := s.GetByEmail(context.Background(), "b@example.com"); user != nil {
+		t.Error("old email still has a record")
+	}
+	if user, _ := s.GetByEmail(context.Background(), "d@example.com"); user != moved {
+		t.Errorf("GetByEmail(new) = %v, want the moved user", user)
+	}
+
+	// The user keeps its place in the listing
+	all, err := s.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emails []string
+	for _, user := range all {
+		emails = append(emails, user.Email)
+	}
+	if want := []string{"a@example.com", "d@example.com", "c@example.com"}; !slices.Equal(emails, want) {
+		t.Errorf("GetAll() = %v, want %v", emails, want)
+	}
+
+	if err := s.ChangeEmail(context.Background(), "a@example.com", model.NewUser("c@example.com", "Change", "Email")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("ChangeEmail to a taken email: got %v, want ErrAlreadyExists", err)
+	}
+	if err := s.ChangeEmail(context.Background(), "missing@example.com", model.NewUser("e@example.com", "Change", "Email")); err == nil {
+		t.Error("ChangeEmail of a missing user succeeded")
+	}
+	if exists, _ := s.Exists(context.Background(), "e@example.com"); exists {
+		t.Error("failed ChangeEmail created a record")
+	}
+}
+
+func TestInMemoryUserStoreCreateWithInit(t *testing.T) {
+	s := NewUserStore().(*InMemoryUserStore)
+	if err := s.Create(context.Background(), model.NewUser("taken@example.com", "Create", "Init")); err != nil {
+		t.Fatal(err)
+	}
+	counter := func(email string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"Email":        &types.AttributeValueMemberS{Value: email},
+			"MessageCount": &types.AttributeValueMemberN{Value: "0"},
+		}
+	}
+
+	// A failed item rolls back every write, including the user record
+	failures := map[string][]map[string]types.AttributeValue{
+		"item conflicting with a user": {counter("counter#a@example.com"), counter("taken@example.com")},
+		"item missing the key":         {counter("counter#a@example.com"), {"MessageCount": &types.AttributeValueMemberN{Value: "0"}}},
+		"too many items":               make([]map[string]types.AttributeValue, MaxTransactionItems),
+	}
+	for name, items := range failures {
+		if err := s.CreateWithInit(context.Background(), model.NewUser("a@example.com", "Create", "Init"), items...); err == nil {
+			t.Errorf("%s: CreateWithInit succeeded", name)
+		}
+		if exists, _ := s.Exists(context.Background(), "a@example.com"); exists {
+			t.Errorf("%s: user was created", name)
+		}
+		if len(s.items) != 0 {
+			t.Errorf("%s: initialization items were written: %v", name, s.items)
+		}
+	}
+
+	if err := s.CreateWithInit(context.Background(), model.NewUser("taken@example.com", "Create", "Init"), counter("counter#taken@example.com")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("CreateWithInit of an existing user: got %v, want ErrAlreadyExists", err)
+	}
+
+	if err := s.CreateWithInit(context.Background(), model.NewUser("a@example.com", "Create", "Init"), counter("counter#a@example.com")); err != nil {
+		t.Fatalf("CreateWithInit: %v", err)
+	}
+	if exists, _ := s.Exists(context.Background(), "a@example.com"); !exists {
+		t.Error("user was not created")
+	}
+	if _, ok := s.items["counter#a@example.com"]; !ok || len(s.items) != 1 {
+		t.Errorf("initialization items = %v, want the counter", s.items)
+	}
+}
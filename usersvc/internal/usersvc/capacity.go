@@ -0,0 +1,58 @@
+package usersvc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// userCountTTL is how long a user count is reused before the store is counted again
+const userCountTTL = 30 * time.Second
+
+// userCounter caches the number of registered users, so that enforcing MAX_USERS does not count
+// every user (a full table scan on DynamoDB) on each registration. Registrations and deletions
+// handled by this instance adjust the cached count; others are picked up when it expires.
+type userCounter struct {
+	mutex     sync.Mutex
+	count     int64
+	fetchedAt time.Time
+}
+
+// get returns the cached count, counting the users in userStore if it has expired
+func (u *userCounter) get(ctx context.Context, userStore UserStore) (int64, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if !u.fetchedAt.IsZero() && time.Since(u.fetchedAt) < userCountTTL {
+		return u.count, nil
+	}
+	count, err := userStore.CountUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	u.count = count
+	u.fetchedAt = time.Now()
+	return count, nil
+}
+
+// add adjusts the cached count by delta, if there is one
+func (u *userCounter) add(delta int64) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if !u.fetchedAt.IsZero() {
+		u.count += delta
+	}
+}
+
+// capacityReached reports whether MAX_USERS is set and the number of users has reached it
+func (s *Server) capacityReached(ctx context.Context) (bool, error) {
+	if s.config.MaxUsers <= 0 {
+		return false, nil
+	}
+	count, err := s.userCount.get(ctx, s.userStore)
+	if err != nil {
+		return false, err
+	}
+	return count >= int64(s.config.MaxUsers), nil
+}
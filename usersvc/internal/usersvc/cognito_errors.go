@@ -0,0 +1,89 @@
+package usersvc
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/gin-gonic/gin"
+)
+
+// cognitoLimitRetryAfter is the Retry-After sent when Cognito throttles a request. Cognito does
+// not say when its limits reset.
+const cognitoLimitRetryAfter = time.Minute
+
+// mapCognitoError maps an error returned by a Cognito call to the status, error code and message
+// to send to the client. Errors that are not a recognized Cognito exception map to 500 with an
+// empty code and message, for the caller to describe.
+func mapCognitoError(err error) (status int, code string, message string) {
+	var (
+		usernameExistsErr       *types.UsernameExistsException
+		aliasExistsErr          *types.AliasExistsException
+		userNotFoundErr         *types.UserNotFoundException
+		userNotConfirmedErr     *types.UserNotConfirmedException
+		notAuthorizedErr        *types.NotAuthorizedException
+		unsupportedUserStateErr *types.UnsupportedUserStateException
+		tooManyRequestsErr      *types.TooManyRequestsException
+		limitExceededErr        *types.LimitExceededException
+		tooManyFailedErr        *types.TooManyFailedAttemptsException
+		codeMismatchErr         *types.CodeMismatchException
+		expiredCodeErr          *types.ExpiredCodeException
+		invalidPasswordErr      *types.InvalidPasswordException
+		invalidParameterErr     *types.InvalidParameterException
+		codeDeliveryErr         *types.CodeDeliveryFailureException
+		enableSoftwareTokenErr  *types.EnableSoftwareTokenMFAException
+		softwareTokenMissingErr *types.SoftwareTokenMFANotFoundException
+	)
+	switch {
+	case errors.As(err, &usernameExistsErr), errors.As(err, &aliasExistsErr):
+		return http.StatusConflict, "USER_EXISTS", "User already exists"
+	case errors.As(err, &userNotFoundErr):
+		return http.StatusNotFound, "USER_NOT_FOUND", "User not found"
+	case errors.As(err, &userNotConfirmedErr):
+		return http.StatusForbidden, "USER_NOT_CONFIRMED", "User has not confirmed their account"
+	case errors.As(err, &notAuthorizedErr):
+		return http.StatusUnauthorized, "NOT_AUTHORIZED", "Not authorized"
+	case errors.As(err, &unsupportedUserStateErr):
+		return http.StatusConflict, "UNSUPPORTED_USER_STATE", "The account is not in a state that allows this operation"
+	case errors.As(err, &tooManyRequestsErr):
+		return http.StatusTooManyRequests, "TOO_MANY_REQUESTS", "Too many requests, please try again later"
+	case errors.As(err, &limitExceededErr):
+		return http.StatusTooManyRequests, "LIMIT_EXCEEDED", "Too many requests, please try again later"
+	case errors.As(err, &tooManyFailedErr):
+		return http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS", "Too many failed attempts, please try again later"
+	case errors.As(err, &codeMismatchErr):
+		return http.StatusBadRequest, "CODE_MISMATCH", "Invalid confirmation code"
+	case errors.As(err, &expiredCodeErr):
+		return http.StatusBadRequest, "CODE_EXPIRED", "Confirmation code has expired, please request a new one"
+	case errors.As(err, &invalidPasswordErr):
+		return http.StatusBadRequest, "WEAK_PASSWORD", "Password does not meet the password policy"
+	case errors.As(err, &invalidParameterErr):
+		return http.StatusBadRequest, "INVALID_PARAMETER", "Invalid request parameters"
+	case errors.As(err, &codeDeliveryErr):
+		return http.StatusBadGateway, "CODE_DELIVERY_FAILED", "Failed to deliver the confirmation code"
+	case errors.As(err, &enableSoftwareTokenErr):
+		return http.StatusBadRequest, "CODE_MISMATCH", "Invalid MFA code"
+	case errors.As(err, &softwareTokenMissingErr):
+		return http.StatusConflict, "MFA_NOT_SET_UP", "MFA setup has not been started"
+	}
+	return http.StatusInternalServerError, "", ""
+}
+
+// respondCognitoError writes the response for an error returned by a Cognito call, using
+// fallbackMessage for errors that mapCognitoError does not recognize
+func respondCognitoError(c *gin.Context, err error, fallbackMessage string) {
+	status, code, message := mapCognitoError(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Cognito error: %v", err)
+		httputil.RespondError(c, status, "INTERNAL_ERROR", fallbackMessage)
+		return
+	}
+	if status == http.StatusTooManyRequests {
+		c.Header("Retry-After", strconv.Itoa(int(cognitoLimitRetryAfter.Seconds())))
+	}
+	httputil.RespondError(c, status, code, message)
+}
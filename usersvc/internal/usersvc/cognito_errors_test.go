@@ -0,0 +1,50 @@
+package usersvc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+func TestMapCognitoError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"username exists", &types.UsernameExistsException{}, http.StatusConflict, "USER_EXISTS"},
+		{"alias exists", &types.AliasExistsException{}, http.StatusConflict, "USER_EXISTS"},
+		{"user not found", &types.UserNotFoundException{}, http.StatusNotFound, "USER_NOT_FOUND"},
+		{"user not confirmed", &types.UserNotConfirmedException{}, http.StatusForbidden, "USER_NOT_CONFIRMED"},
+		{"not authorized", &types.NotAuthorizedException{}, http.StatusUnauthorized, "NOT_AUTHORIZED"},
+		{"unsupported user state", &types.UnsupportedUserStateException{}, http.StatusConflict, "UNSUPPORTED_USER_STATE"},
+		{"too many requests", &types.TooManyRequestsException{}, http.StatusTooManyRequests, "TOO_MANY_REQUESTS"},
+		{"limit exceeded", &types.LimitExceededException{}, http.StatusTooManyRequests, "LIMIT_EXCEEDED"},
+		{"too many failed attempts", &types.TooManyFailedAttemptsException{}, http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS"},
+		{"code mismatch", &types.CodeMismatchException{}, http.StatusBadRequest, "CODE_MISMATCH"},
+		{"expired code", &types.ExpiredCodeException{}, http.StatusBadRequest, "CODE_EXPIRED"},
+		{"invalid password", &types.InvalidPasswordException{}, http.StatusBadRequest, "WEAK_PASSWORD"},
+		{"invalid parameter", &types.InvalidParameterException{}, http.StatusBadRequest, "INVALID_PARAMETER"},
+		{"code delivery failure", &types.CodeDeliveryFailureException{}, http.StatusBadGateway, "CODE_DELIVERY_FAILED"},
+		{"MFA code mismatch", &types.EnableSoftwareTokenMFAException{}, http.StatusBadRequest, "CODE_MISMATCH"},
+		{"MFA not set up", &types.SoftwareTokenMFANotFoundException{}, http.StatusConflict, "MFA_NOT_SET_UP"},
+		{"wrapped", fmt.Errorf("failed to sign up: %w", &types.UsernameExistsException{}), http.StatusConflict, "USER_EXISTS"},
+		{"unrecognized exception", &types.InternalErrorException{}, http.StatusInternalServerError, ""},
+		{"other error", errors.New("connection reset"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code, message := mapCognitoError(tt.err)
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("got %d %q, want %d %q", status, code, tt.wantStatus, tt.wantCode)
+			}
+			if (message == "") != (tt.wantCode == "") {
+				t.Errorf("message %q inconsistent with code %q", message, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package usersvc
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Authentication operations recorded in authRequestsTotal
+const (
+	operationSignUp       = "signup"
+	operationLogin        = "login"
+	operationRefreshToken = "refresh_token"
+)
+
+// Outcomes recorded in authRequestsTotal
+const (
+	outcomeSuccess            = "success"
+	outcomeBadRequest         = "bad_request"
+	outcomeInvalidPassword    = "invalid_password"
+	outcomeDomainNotAllowed   = "domain_not_allowed"
+	outcomeReservedEmail      = "reserved_email"
+	outcomeCapacityReached    = "capacity_reached"
+	outcomeUserExists         = "user_exists"
+	outcomeInvalidCredentials = "invalid_credentials"
+	outcomeNotConfirmed       = "not_confirmed"
+	outcomeError              = "error"
+)
+
+// authRequestsTotal counts sign-up, login and token refresh requests by outcome, so that
+// spikes in failures (e.g. credential stuffing) are visible
+var authRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "usersvc_auth_requests_total",
+	Help: "Authentication requests handled by usersvc, by operation and outcome.",
+}, []string{"operation", "outcome"})
+
+// recordAuthOutcome increments the counter for an authentication request
+func recordAuthOutcome(operation, outcome string) {
+	authRequestsTotal.WithLabelValues(operation, outcome).Inc()
+}
+
+// cognitoFailureOutcome classifies an error returned by Cognito for a login or token refresh
+func cognitoFailureOutcome(err error) string {
+	var notAuthorizedErr *types.NotAuthorizedException
+	var userNotFoundErr *types.UserNotFoundException
+	var notConfirmedErr *types.UserNotConfirmedException
+	switch {
+	case errors.As(err, &notAuthorizedErr), errors.As(err, &userNotFoundErr):
+		return outcomeInvalidCredentials
+	case errors.As(err, &notConfirmedErr):
+		return outcomeNotConfirmed
+	default:
+		return outcomeError
+	}
+}
@@ -0,0 +1,97 @@
+package usersvc
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/aws_e2e_test/usersvc/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// purgeEligible reports whether user is soft-deleted and has been for longer than retention.
+// Users marked DELETED without a DeletedAt (e.g. through PATCH) count from their last update.
+func purgeEligible(user *model.User, retention time.Duration, now time.Time) bool {
+	if user.Status != string(model.UserStatusDeleted) {
+		return false
+	}
+	deletedAt := time.Time(user.UpdatedAt)
+	if user.DeletedAt != nil {
+		deletedAt = time.Time(*user.DeletedAt)
+	}
+	return now.Sub(deletedAt) > retention
+}
+
+// purgeDeletedUsers hard-deletes, from the database and Cognito, the users that have been
+// soft-deleted for longer than USER_PURGE_AFTER. With dryRun it only counts them. It returns the
+// number of users purged, or that would be, up to the first failure.
+func (s *Server) purgeDeletedUsers(ctx context.Context, dryRun bool) (int, error) {
+	users, err := s.userStore.GetAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, user := range users {
+		if !purgeEligible(user, s.config.UserPurgeAfter, now) {
+			continue
+		}
+		if !dryRun {
+			// Cognito accounts that are already gone are logged and skipped by removeUser
+			if err := s.removeUser(ctx, user.Email); err != nil {
+				return purged, err
+			}
+			log.Printf("Purged deleted user %s", user.Email)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeUsers hard-deletes the users soft-deleted for longer than USER_PURGE_AFTER. With
+// ?dryRun=true it only reports how many would be purged.
+func (s *Server) purgeUsers(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid dryRun, expected true or false")
+		return
+	}
+
+	purged, err := s.purgeDeletedUsers(c.Request.Context(), dryRun)
+	if err != nil {
+		log.Printf("Error purging deleted users after %d: %v", purged, err)
+		httputil.RespondErrorWith(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to purge deleted users", gin.H{"purged": purged})
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"purged": purged, "dryRun": dryRun})
+}
+
+// startPurgeSweep purges deleted users every interval until ctx is cancelled. A non-positive
+// interval starts nothing.
+func (s *Server) startPurgeSweep(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := s.purgeDeletedUsers(ctx, false)
+				if err != nil {
+					log.Printf("ERROR: Scheduled purge of deleted users failed after %d: %v", purged, err)
+					continue
+				}
+				log.Printf("Scheduled purge removed %d deleted users", purged)
+			}
+		}
+	}()
+}
@@ -1,54 +1,136 @@
 package usersvc
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws_e2e_test/shared/auth"
+	"github.com/aws_e2e_test/shared/awsutil"
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/aws_e2e_test/shared/middleware"
 	localauth "github.com/aws_e2e_test/usersvc/internal/auth"
 	"github.com/aws_e2e_test/usersvc/internal/config"
+	"github.com/aws_e2e_test/usersvc/internal/messages"
 	"github.com/aws_e2e_test/usersvc/internal/model"
 	"github.com/aws_e2e_test/usersvc/internal/store"
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // UserStore is an interface for user storage
 type UserStore interface {
-	GetByEmail(email string) (*model.User, error)
-	GetAll() ([]*model.User, error)
-	Create(user *model.User) error
-	Update(user *model.User) error
-	Delete(email string) error
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	Exists(ctx context.Context, email string) (bool, error)
+	GetBySub(ctx context.Context, sub string) (*model.User, error)
+	GetAll(ctx context.Context) ([]*model.User, error)
+	GetRecent(ctx context.Context, limit int) ([]*model.User, error)
+	CountUsers(ctx context.Context) (int64, error)
+	Create(ctx context.Context, user *model.User) error
+	GetOrCreate(ctx context.Context, user *model.User) (*model.User, bool, error)
+	CreateWithInit(ctx context.Context, user *model.User, initItems ...map[string]dynamodbtypes.AttributeValue) error
+	Update(ctx context.Context, user *model.User) error
+	ChangeEmail(ctx context.Context, oldEmail string, user *model.User) error
+	Delete(ctx context.Context, email string) error
+}
+
+// AttemptTracker is an interface for counting attempts at rate-limited operations
+type AttemptTracker interface {
+	// RecordAttempt records an attempt for key and reports whether it is within the limit
+	RecordAttempt(ctx context.Context, key string) (bool, error)
+
+	// Reset clears the attempts recorded for key
+	Reset(ctx context.Context, key string) error
+}
+
+// CognitoClient is an interface for the Cognito operations used by the server
+type CognitoClient interface {
+	SignUp(ctx context.Context, email, password, firstName, lastName string) (string, error)
+	ConfirmSignUp(ctx context.Context, email, confirmationCode string) error
+	ResendConfirmationCode(ctx context.Context, email string) error
+	Login(ctx context.Context, email, password string) (*model.AuthResponse, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*model.AuthResponse, error)
+	ForgotPassword(ctx context.Context, email string) error
+	ConfirmForgotPassword(ctx context.Context, email, confirmationCode, newPassword string) error
+	UpdateUserAttributes(ctx context.Context, accessToken string, attributes map[string]string) error
+	AdminUpdateUserAttributes(ctx context.Context, email string, attributes map[string]string) error
+	ChangeEmail(ctx context.Context, accessToken, newEmail string) (*model.CodeDelivery, error)
+	VerifyEmail(ctx context.Context, accessToken, code string) (string, error)
+	AdminDeleteUser(ctx context.Context, email string) error
+	ResendInvitation(ctx context.Context, email string) error
+	AdminGetUser(ctx context.Context, email string) (map[string]string, error)
+	GetUser(ctx context.Context, accessToken string) (map[string]string, error)
+	GetUserMFAStatus(ctx context.Context, accessToken string) (bool, string, error)
+	AssociateSoftwareToken(ctx context.Context, accessToken string) (string, error)
+	VerifySoftwareToken(ctx context.Context, accessToken, code, deviceName string) error
+}
+
+// MessageDeleter is an interface for deleting a user's messages when they delete their account
+type MessageDeleter interface {
+	// DeleteMine deletes every message owned by the access token's user and returns how many were deleted
+	DeleteMine(ctx context.Context, accessToken string) (int, error)
+}
+
+// MessageFeed is an interface for reading recent messages from the message service
+type MessageFeed interface {
+	// Recent returns up to limit of the newest messages, newest first
+	Recent(ctx context.Context, accessToken string, limit int) ([]messages.Message, error)
 }
 
 // Server represents the API server
 type Server struct {
-	router        *gin.Engine
-	config        *config.Config
-	userStore     UserStore
-	cognitoClient *localauth.CognitoClient
-	jwtValidator  *auth.JWTValidator
+	router                *gin.Engine
+	config                *config.Config
+	userStore             UserStore
+	forgotPasswordTracker AttemptTracker
+	resendTracker         AttemptTracker // nil when RESEND_MIN_INTERVAL is 0
+	cognitoClient         CognitoClient
+	messageDeleter        MessageDeleter // nil unless CASCADE_DELETE_MESSAGES is enabled
+	messageFeed           MessageFeed    // nil unless MESSAGES_SERVICE_URL is set
+	jwtValidator          *auth.JWTValidator
+	defaultAuth           auth.RouteAuth
+
+	// userCount caches the number of users for the MAX_USERS check
+	userCount userCounter
+
+	// userStatuses caches account statuses for the ENFORCE_USER_STATUS check
+	userStatuses userStatusCache
+
+	// draining is set once shutdown begins, so readiness checks fail while traffic drains
+	draining atomic.Bool
 }
 
 // NewServer creates a new API server
 func NewServer(cfg *config.Config) (*Server, error) {
-	var userStore UserStore
-	var err error
+	userStore, err := NewStore(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	// Initialize the appropriate user store based on configuration
-	if cfg.UseDynamoDB {
-		userStore, err = store.NewDynamoDBUserStore(cfg.DynamoDBTableName)
+	// Initialize the forgot-password attempt tracker
+	forgotPasswordTracker, err := newAttemptTracker(cfg, cfg.ForgotPasswordMaxAttempts, cfg.ForgotPasswordWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resends are limited to one per interval, tracked alongside forgot-password attempts
+	var resendTracker AttemptTracker
+	if cfg.ResendMinInterval > 0 {
+		resendTracker, err = newAttemptTracker(cfg, 1, cfg.ResendMinInterval)
 		if err != nil {
-			log.Printf("ERROR: Failed to create DynamoDB user store: %v", err)
-			log.Printf("ERROR: Stack trace: %+v", err)
-			log.Printf("CRITICAL: Falling back to in-memory user store (WARNING: not suitable for multiple instances)")
-			userStore = store.NewUserStore()
+			return nil, err
 		}
-	} else {
-		log.Println("STORAGE: Using in-memory user store (suitable for local development only)")
-		log.Println("STORAGE: Set USE_DYNAMODB=true for production/multi-instance deployments")
-		userStore = store.NewUserStore()
 	}
 
 	// Initialize Cognito client
@@ -63,24 +145,114 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	}
 
 	// Initialize JWT validator
-	jwtValidator := auth.NewCognitoJWTValidator(cfg.CognitoRegion, cfg.UserPoolID)
+	jwtConfig := auth.CognitoJWTValidatorConfig(cfg.CognitoRegion, cfg.UserPoolID)
+	jwtConfig.SkipIssuerCheck = cfg.JWTSkipIssuerCheck
+	jwtConfig.Environment = cfg.Environment
+	jwtConfig.CacheTTL = cfg.JWKSCacheTTL
+	jwtConfig.StaleOK = cfg.JWKSStaleOK
+	jwtConfig.FetchTimeout = cfg.JWKSFetchTimeout
+	if cfg.JWKSCABundle != "" {
+		// Fetch the JWKS through a client that trusts the configured CA bundle
+		jwtConfig.HTTPClient, err = auth.NewHTTPClientWithCABundle(cfg.JWKSCABundle)
+		if err != nil {
+			log.Printf("ERROR: Failed to load JWKS CA bundle: %v", err)
+			return nil, err
+		}
+	}
+	jwtValidator := auth.NewJWTValidator(jwtConfig)
+
+	// Optionally wait for the JWKS, which can briefly 404 after a user pool is created
+	if cfg.JWKSWaitTimeout > 0 {
+		if err := jwtValidator.Prime(context.Background(), cfg.JWKSWaitTimeout); err != nil {
+			log.Printf("ERROR: JWKS unavailable at startup: %v", err)
+			return nil, err
+		}
+	}
+
+	// Routes that don't declare whether they require authentication use this default
+	defaultAuth, err := auth.ParseRouteAuth(cfg.DefaultAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps in responses are RFC 3339 strings or epoch milliseconds
+	timestampFormat, err := httputil.ParseTimestampFormat(cfg.TimestampFormat)
+	if err != nil {
+		return nil, err
+	}
+	httputil.SetTimestampFormat(timestampFormat)
+
+	// Keys in responses are camelCase, snake_case or PascalCase
+	jsonCase, err := httputil.ParseJSONCase(cfg.JSONCase)
+	if err != nil {
+		return nil, err
+	}
+	httputil.SetJSONCase(jsonCase)
+
+	// Keep logged DynamoDB items to a readable size
+	awsutil.SetLogMaxFieldLen(cfg.LogMaxFieldLen)
+
+	// Read recent messages from msgsvc for the activity feed, and optionally delete a user's
+	// messages when they delete their account
+	var messageFeed MessageFeed
+	var messageDeleter MessageDeleter
+	if cfg.MessagesServiceURL != "" {
+		messagesClient := messages.NewClient(cfg.MessagesServiceURL)
+		messageFeed = messagesClient
+		if cfg.CascadeDeleteMessages {
+			messageDeleter = messagesClient
+		}
+	} else if cfg.CascadeDeleteMessages {
+		return nil, fmt.Errorf("MESSAGES_SERVICE_URL is required when CASCADE_DELETE_MESSAGES is enabled")
+	}
 
 	server := &Server{
-		router:        gin.Default(),
-		config:        cfg,
-		userStore:     userStore,
-		cognitoClient: cognitoClient,
-		jwtValidator:  jwtValidator,
-	}
-
-	// Configure CORS
-	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowOrigins = []string{cfg.CorsOrigins}
-	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
-	corsConfig.ExposeHeaders = []string{"Content-Length"}
-	corsConfig.AllowCredentials = true
-	server.router.Use(cors.New(corsConfig))
+		router:                gin.Default(),
+		config:                cfg,
+		userStore:             userStore,
+		forgotPasswordTracker: forgotPasswordTracker,
+		resendTracker:         resendTracker,
+		cognitoClient:         cognitoClient,
+		messageDeleter:        messageDeleter,
+		messageFeed:           messageFeed,
+		jwtValidator:          jwtValidator,
+		defaultAuth:           defaultAuth,
+	}
+
+	// Take the client IP from X-Forwarded-For only on requests from the ALB, so that clients
+	// cannot pick their own IP rate limit
+	if err := server.router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
+	// Respond 405 with an Allow header, rather than 404, for known paths with unsupported methods
+	server.router.HandleMethodNotAllowed = true
+	server.router.NoMethod(httputil.MethodNotAllowed)
+
+	// Respond with JSON rather than gin's plain text for unknown paths
+	server.router.NoRoute(httputil.NotFound)
+
+	// Configure CORS, with separate policies for the public auth endpoints and the user endpoints
+	server.router.Use(middleware.CORS([]string{cfg.CorsOrigins}, corsPolicies(cfg)...))
+
+	// Optionally enforce TLS for clients (probes reach the service directly over HTTP)
+	if cfg.RequireHTTPS {
+		server.router.Use(middleware.RequireHTTPS(cfg.HTTPSRedirect, "/health", "/ready", "/metrics"))
+	}
+
+	// Shed load beyond the configured number of in-flight requests (health checks are exempt)
+	server.router.Use(middleware.ConcurrencyLimit(cfg.MaxConcurrentRequests, "/health", "/ready", "/metrics"))
+
+	// Bound the time any handler may spend on a request
+	server.router.Use(middleware.RequestTimeout(cfg.RequestTimeout))
+
+	// Optionally reject clients that cannot accept JSON responses
+	if cfg.EnforceAcceptJSON {
+		server.router.Use(middleware.RequireAcceptJSON())
+	}
+
+	// Reject oversized path parameters before they are logged or used as keys
+	server.router.Use(middleware.MaxPathParamLength(middleware.DefaultMaxPathParamLength))
 
 	// Register routes
 	server.registerRoutes()
@@ -88,72 +260,233 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	return server, nil
 }
 
-// Run starts the server
+// newAttemptTracker creates the attempt tracker selected by ATTEMPT_TRACKER, allowing
+// maxAttempts per window. If the DynamoDB tracker cannot be created, it falls back to memory.
+func newAttemptTracker(cfg *config.Config, maxAttempts int, window time.Duration) (AttemptTracker, error) {
+	switch cfg.AttemptTracker {
+	case "dynamodb":
+		dynamoDBTracker, err := store.NewDynamoDBAttemptTracker(store.DynamoDBAttemptTrackerConfig{
+			TableName:       cfg.AttemptTrackerTableName,
+			TablePrefix:     cfg.DynamoDBTablePrefix,
+			TableSuffix:     cfg.DynamoDBTableSuffix,
+			MaxAttempts:     maxAttempts,
+			Window:          window,
+			AutoCreateTable: cfg.DynamoDBAutoCreateTable,
+			Endpoint:        cfg.DynamoDBEndpoint,
+			HTTPClient:      cfg.DynamoDBHTTPClient,
+		})
+		if errors.Is(err, awsutil.ErrNoCredentials) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to create DynamoDB attempt tracker: %v", err)
+			log.Printf("CRITICAL: Falling back to in-memory attempt tracker (WARNING: lockout is per instance)")
+			return store.NewAttemptTracker(maxAttempts, window), nil
+		}
+		return dynamoDBTracker, nil
+	case "memory":
+		return store.NewAttemptTracker(maxAttempts, window), nil
+	default:
+		return nil, fmt.Errorf("invalid ATTEMPT_TRACKER '%s': expected 'memory' or 'dynamodb'", cfg.AttemptTracker)
+	}
+}
+
+// corsPolicies returns the CORS policy of each group of routes
+func corsPolicies(cfg *config.Config) []middleware.CORSPolicy {
+	return []middleware.CORSPolicy{
+		{PathPrefix: "/", AllowMethods: cfg.CorsMethods, AllowHeaders: cfg.CorsHeaders},
+		{PathPrefix: "/auth", AllowMethods: cfg.CorsAuthMethods, AllowHeaders: cfg.CorsAuthHeaders},
+		{PathPrefix: "/users", AllowMethods: cfg.CorsUsersMethods, AllowHeaders: cfg.CorsUsersHeaders},
+	}
+}
+
+// readinessChecker is implemented by stores that can report whether their backend is available
+type readinessChecker interface {
+	Ready(ctx context.Context) error
+}
+
+// Run starts the server and blocks until it has shut down gracefully after SIGINT or SIGTERM
 func (s *Server) Run(addr string) error {
-	return s.router.Run(addr)
+	// Keep the JWKS fresh and purge deleted users for as long as the server runs
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	s.jwtValidator.StartBackgroundRefresh(backgroundCtx, s.config.JWKSRefreshInterval)
+	s.startPurgeSweep(backgroundCtx, s.config.UserPurgeInterval)
+
+	return httputil.ListenAndServeGraceful(addr, s.router, httputil.ShutdownOptions{
+		OnSignal:   func() { s.draining.Store(true) },
+		DrainDelay: s.config.ShutdownDrainDelay,
+		Timeout:    s.config.ShutdownTimeout,
+	})
+}
+
+// ready reports whether the server should receive traffic. It fails as soon as shutdown begins,
+// so the load balancer stops routing requests here before the server stops accepting them.
+func (s *Server) ready(c *gin.Context) {
+	if s.draining.Load() {
+		httputil.RespondJSON(c, http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	if checker, ok := baseStore(s.userStore).(readinessChecker); ok {
+		if err := checker.Ready(c.Request.Context()); err != nil {
+			log.Printf("Readiness check failed: %v", err)
+			httputil.RespondJSON(c, http.StatusServiceUnavailable, gin.H{"status": "unavailable"})
+			return
+		}
+	}
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"status": "ready"})
 }
 
 // registerRoutes registers all API routes
 func (s *Server) registerRoutes() {
 	// Health check endpoint
 	s.router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		httputil.RespondJSON(c, http.StatusOK, gin.H{"status": "ok"})
 	})
 
-	// API endpoints
-	api := s.router.Group("/")
-	{
+	// Readiness check endpoint (fails while draining during shutdown)
+	s.router.GET("/ready", s.ready)
+
+	// Prometheus metrics
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Service descriptor for uptime checks pointed at the root
+	if !s.config.DisableRootInfo {
+		s.router.GET("/", httputil.ServiceInfoHandler("usersvc", s.config.Version))
+	}
+
+	// Middleware for administrative endpoints (require membership in the admin group)
+	adminOnly := []gin.HandlerFunc{auth.RequireAdminMiddleware()}
+
+	// API endpoints. Routes without an explicit Auth use the DEFAULT_AUTH setting.
+	routes := []auth.Route{
 		// Authentication endpoints
-		api.POST("/auth/signup", s.signUp)
-		api.POST("/auth/confirm", s.confirmSignUp)
-		api.POST("/auth/resend-code", s.resendConfirmationCode)
-		api.POST("/auth/login", s.login)
-		api.POST("/auth/refresh", s.refreshToken)
-		api.POST("/auth/forgot-password", s.forgotPassword)
-		api.POST("/auth/confirm-forgot-password", s.confirmForgotPassword)
-
-		// Protected user endpoints (require authentication)
-		protected := api.Group("/users")
-		protected.Use(auth.JWTAuthMiddleware(s.jwtValidator))
-		{
-			protected.GET("", s.getUsers)
-			protected.GET("/:email", s.getUserByEmail)
-			protected.POST("", s.createUser)
-			protected.PUT("/:email", s.updateUser)
-			protected.DELETE("/:email", s.deleteUser)
-		}
+		{Method: http.MethodPost, Path: "/auth/signup", Auth: auth.AuthPublic, Handler: s.signUp},
+		{Method: http.MethodPost, Path: "/auth/confirm", Auth: auth.AuthPublic, Handler: s.confirmSignUp},
+		{Method: http.MethodPost, Path: "/auth/resend-code", Auth: auth.AuthPublic, Handler: s.resendConfirmationCode},
+		{Method: http.MethodPost, Path: "/auth/login", Auth: auth.AuthPublic, Handler: s.login},
+		{Method: http.MethodPost, Path: "/auth/refresh", Auth: auth.AuthPublic, Handler: s.refreshToken},
+		{Method: http.MethodPost, Path: "/auth/forgot-password", Auth: auth.AuthPublic, Handler: s.forgotPassword},
+		{Method: http.MethodPost, Path: "/auth/confirm-forgot-password", Auth: auth.AuthPublic, Handler: s.confirmForgotPassword},
+		{Method: http.MethodGet, Path: "/auth/permissions", Auth: auth.AuthRequired, Handler: s.getPermissions},
+		{Method: http.MethodGet, Path: "/auth/mfa/status", Auth: auth.AuthRequired, Handler: s.getMFAStatus},
+		{Method: http.MethodPost, Path: "/auth/mfa/setup", Auth: auth.AuthRequired, Handler: s.setupMFA},
+		{Method: http.MethodPost, Path: "/auth/mfa/verify", Auth: auth.AuthRequired, Handler: s.verifyMFA},
+
+		// User endpoints (require authentication)
+		{Method: http.MethodGet, Path: "/users", Auth: auth.AuthRequired, Handler: s.getUsers},
+		{Method: http.MethodGet, Path: "/users/:email", Auth: auth.AuthRequired, Handler: s.getUserByEmail},
+		{Method: http.MethodPost, Path: "/users", Auth: auth.AuthRequired, Handler: s.createUser},
+		{Method: http.MethodPut, Path: "/users/me", Auth: auth.AuthRequired, Handler: s.updateCurrentUser},
+		{Method: http.MethodDelete, Path: "/users/me", Auth: auth.AuthRequired, Handler: s.deleteCurrentUser},
+		{Method: http.MethodPost, Path: "/users/me/email", Auth: auth.AuthRequired, Handler: s.changeEmail},
+		{Method: http.MethodPost, Path: "/users/me/email/verify", Auth: auth.AuthRequired, Handler: s.verifyEmail},
+		{Method: http.MethodPut, Path: "/users/:email", Auth: auth.AuthRequired, Handler: s.updateUser},
+		{Method: http.MethodPatch, Path: "/users/:email", Auth: auth.AuthRequired, Handler: s.patchUser},
+		{Method: http.MethodDelete, Path: "/users/:email", Auth: auth.AuthRequired, Handler: s.deleteUser},
+		{Method: http.MethodGet, Path: "/users/by-sub/:sub", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.getUserBySub},
+
+		// Administrative endpoints
+		{Method: http.MethodGet, Path: "/admin/users", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.adminListUsers},
+		{Method: http.MethodGet, Path: "/admin/users/:email", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.adminGetUser},
+		{Method: http.MethodGet, Path: "/admin/activity", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.getActivity},
+		{Method: http.MethodPost, Path: "/admin/users/:email/resend-invite", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.resendInvitation},
+		{Method: http.MethodPost, Path: "/admin/users/purge", Auth: auth.AuthRequired, Middleware: adminOnly, Handler: s.purgeUsers},
 	}
+	// Rate limit after authentication, so that authenticated requests are counted per user
+	// rather than per IP
+	rateLimit := middleware.RateLimit(
+		middleware.NewRateLimiter(s.config.RateLimitUserPerMinute, s.config.RateLimitUserBurst),
+		middleware.NewRateLimiter(s.config.RateLimitIPPerMinute, s.config.RateLimitIPBurst),
+		auth.GetUserSubFromContext,
+	)
+	for i := range routes {
+		routes[i].Middleware = append([]gin.HandlerFunc{rateLimit}, routes[i].Middleware...)
+	}
+
+	// Optionally reject valid tokens of users whose account has been disabled
+	var hooks []auth.PostValidationHook
+	if s.config.EnforceUserStatus {
+		hooks = append(hooks, s.enforceUserStatus)
+	}
+	auth.RegisterRoutes(s.router, routes, s.jwtValidator, s.defaultAuth, hooks...)
 }
 
 // signUp handles user registration
 func (s *Server) signUp(c *gin.Context) {
 	var request model.UserSignupRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := httputil.BindJSON(c, &request, s.config.StrictJSON); err != nil {
+		recordAuthOutcome(operationSignUp, outcomeBadRequest)
+		httputil.RespondBindError(c, err)
+		return
+	}
+	if !emailDomainAllowed(request.Email, s.config.SignupAllowedDomains) {
+		recordAuthOutcome(operationSignUp, outcomeDomainNotAllowed)
+		httputil.RespondError(c, http.StatusForbidden, "DOMAIN_NOT_ALLOWED", "Sign up is not available for this email domain")
+		return
+	}
+	if emailReserved(request.Email, s.config.ReservedLocalParts, s.config.ReservedEmails) {
+		recordAuthOutcome(operationSignUp, outcomeReservedEmail)
+		httputil.RespondError(c, http.StatusForbidden, "RESERVED_EMAIL", "This email address is reserved")
+		return
+	}
+	if err := model.ValidatePassword(request.Password, s.config.PasswordMinLength); err != nil {
+		recordAuthOutcome(operationSignUp, outcomeInvalidPassword)
+		httputil.RespondError(c, http.StatusBadRequest, "WEAK_PASSWORD", err.Error())
+		return
+	}
+	full, err := s.capacityReached(c.Request.Context())
+	if err != nil {
+		log.Printf("Error counting users: %v", err)
+		recordAuthOutcome(operationSignUp, outcomeError)
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to sign up user")
+		return
+	}
+	if full {
+		recordAuthOutcome(operationSignUp, outcomeCapacityReached)
+		respondCapacityReached(c)
 		return
 	}
 
 	// Sign up the user with Cognito
-	err := s.cognitoClient.SignUp(
+	sub, err := s.cognitoClient.SignUp(c.Request.Context(),
 		request.Email,
 		request.Password,
 		request.FirstName,
 		request.LastName,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign up user"})
+		switch _, code, _ := mapCognitoError(err); code {
+		case "USER_EXISTS":
+			recordAuthOutcome(operationSignUp, outcomeUserExists)
+		case "WEAK_PASSWORD":
+			recordAuthOutcome(operationSignUp, outcomeInvalidPassword)
+		default:
+			recordAuthOutcome(operationSignUp, outcomeError)
+		}
+		respondCognitoError(c, err, "Failed to sign up user")
 		return
 	}
 
-	// Create the user in the database
+	// Create the user in the database unless a record with this email already exists
 	user := model.NewUser(request.Email, request.FirstName, request.LastName)
-	err = s.userStore.Create(user)
+	user.Sub = sub
+	user.CreatedBy = model.CreatedBySelf
+	stored, created, err := s.userStore.GetOrCreate(c.Request.Context(), user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		recordAuthOutcome(operationSignUp, outcomeError)
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to create user")
+		return
+	}
+	if !created {
+		recordAuthOutcome(operationSignUp, outcomeUserExists)
+		respondUserExists(c)
 		return
 	}
 
-	c.JSON(http.StatusCreated, user.ToResponse())
+	s.userCount.add(1)
+	recordAuthOutcome(operationSignUp, outcomeSuccess)
+	respondUserCreated(c, stored)
 }
 
 // confirmSignUp handles user registration confirmation
@@ -163,18 +496,24 @@ func (s *Server) confirmSignUp(c *gin.Context) {
 		ConfirmationCode string `json:"confirmationCode" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	// Reject malformed codes before spending a Cognito round trip; Cognito still checks the value
+	if !validConfirmationCode(request.ConfirmationCode, s.config.ConfirmationCodeLength) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_CODE_FORMAT", fmt.Sprintf("Confirmation code must be %d digits", s.config.ConfirmationCodeLength))
 		return
 	}
 
 	// Confirm the user's registration with Cognito
-	err := s.cognitoClient.ConfirmSignUp(request.Email, request.ConfirmationCode)
+	err := s.cognitoClient.ConfirmSignUp(c.Request.Context(), request.Email, request.ConfirmationCode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm sign up"})
+		respondCognitoError(c, err, "Failed to confirm sign up")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User confirmed successfully"})
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"message": "User confirmed successfully"})
 }
 
 // resendConfirmationCode resends the confirmation code to the user
@@ -183,56 +522,159 @@ func (s *Server) resendConfirmationCode(c *gin.Context) {
 		Email string `json:"email" binding:"required,email"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		httputil.RespondBindError(c, err)
 		return
 	}
 
+	// Allow one resend per interval for each email. Keys are prefixed because the tracker may
+	// share its table with the forgot-password attempts.
+	if s.resendTracker != nil {
+		allowed, err := s.resendTracker.RecordAttempt(c.Request.Context(), "resend:"+strings.ToLower(request.Email))
+		if err != nil {
+			log.Printf("Error recording resend attempt: %v", err)
+			httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to resend confirmation code")
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(s.config.ResendMinInterval.Seconds())))
+			httputil.RespondError(c, http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS", "A code was sent recently, please wait before requesting another")
+			return
+		}
+	}
+
 	// Resend the confirmation code with Cognito
-	err := s.cognitoClient.ResendConfirmationCode(request.Email)
+	err := s.cognitoClient.ResendConfirmationCode(c.Request.Context(), request.Email)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resend confirmation code"})
+		respondCognitoError(c, err, "Failed to resend confirmation code")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Confirmation code resent successfully"})
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"message": "Confirmation code resent successfully"})
 }
 
 // login handles user authentication
 func (s *Server) login(c *gin.Context) {
 	var request model.UserLoginRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		recordAuthOutcome(operationLogin, outcomeBadRequest)
+		httputil.RespondBindError(c, err)
 		return
 	}
 
 	// Authenticate the user with Cognito
-	authResponse, err := s.cognitoClient.Login(request.Email, request.Password)
+	authResponse, err := s.cognitoClient.Login(c.Request.Context(), request.Email, request.Password)
+	if err != nil {
+		recordAuthOutcome(operationLogin, cognitoFailureOutcome(err))
+		// Unknown users and wrong passwords get the same response
+		if status, _, _ := mapCognitoError(err); status == http.StatusUnauthorized || status == http.StatusNotFound {
+			httputil.RespondError(c, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid credentials")
+			return
+		}
+		respondCognitoError(c, err, "Failed to log in")
+		return
+	}
+
+	s.recordSubOnLogin(c.Request.Context(), request.Email, authResponse.AccessToken)
+
+	s.setRefreshCookie(c, authResponse.RefreshToken)
+	recordAuthOutcome(operationLogin, outcomeSuccess)
+	httputil.RespondJSON(c, http.StatusOK, authResponse)
+}
+
+// recordSubOnLogin records the Cognito sub of a user stored without one, such as a user created
+// with POST /users or stored before subs were recorded. Access tokens identify the user only by
+// sub, so until it is recorded the user cannot be found from their token. Failures are logged
+// rather than failing the login.
+func (s *Server) recordSubOnLogin(ctx context.Context, email, accessToken string) {
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil {
+		log.Printf("WARNING: Failed to look up %s to record their sub: %v", email, err)
+		return
+	}
+	if user == nil || user.Sub != "" {
+		return
+	}
+
+	attributes, err := s.cognitoClient.GetUser(ctx, accessToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		log.Printf("WARNING: Failed to get the sub of %s from Cognito: %v", email, err)
 		return
 	}
+	s.recordSub(ctx, user, attributes["sub"])
+}
 
-	c.JSON(http.StatusOK, authResponse)
+// recordSub stores sub as the sub of a user stored without one, logging rather than returning
+// a failure
+func (s *Server) recordSub(ctx context.Context, user *model.User, sub string) {
+	if sub == "" {
+		return
+	}
+	user.Sub = sub
+	if err := s.userStore.Update(ctx, user); err != nil {
+		log.Printf("WARNING: Failed to record the sub of %s: %v", user.Email, err)
+		return
+	}
+	log.Printf("Recorded the sub of %s", user.Email)
 }
 
 // refreshToken refreshes the authentication tokens
 func (s *Server) refreshToken(c *gin.Context) {
+	// The body may be empty when the refresh token comes from the cookie
 	var request struct {
-		RefreshToken string `json:"refreshToken" binding:"required"`
+		RefreshToken string `json:"refreshToken"`
 	}
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		recordAuthOutcome(operationRefreshToken, outcomeBadRequest)
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	// Prefer the body, falling back to the HttpOnly cookie set by the web app's login
+	refreshToken := request.RefreshToken
+	if refreshToken == "" {
+		refreshToken, _ = c.Cookie(s.config.RefreshCookieName)
+	}
+	if refreshToken == "" {
+		recordAuthOutcome(operationRefreshToken, outcomeBadRequest)
+		httputil.RespondError(c, http.StatusBadRequest, "MISSING_REFRESH_TOKEN", "A refresh token is required in the body or the "+s.config.RefreshCookieName+" cookie")
 		return
 	}
 
 	// Refresh the tokens with Cognito
-	authResponse, err := s.cognitoClient.RefreshToken(request.RefreshToken)
+	authResponse, err := s.cognitoClient.RefreshToken(c.Request.Context(), refreshToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		recordAuthOutcome(operationRefreshToken, cognitoFailureOutcome(err))
+		if status, _, _ := mapCognitoError(err); status == http.StatusUnauthorized || status == http.StatusNotFound {
+			httputil.RespondError(c, http.StatusUnauthorized, "INVALID_REFRESH_TOKEN", "Invalid refresh token")
+			return
+		}
+		respondCognitoError(c, err, "Failed to refresh token")
 		return
 	}
 
-	c.JSON(http.StatusOK, authResponse)
+	s.setRefreshCookie(c, authResponse.RefreshToken)
+	recordAuthOutcome(operationRefreshToken, outcomeSuccess)
+	httputil.RespondJSON(c, http.StatusOK, authResponse)
+}
+
+// refreshCookieMaxAge matches Cognito's default refresh token validity of 30 days
+const refreshCookieMaxAge = 30 * 24 * time.Hour
+
+// setRefreshCookie sets the refresh token as an HttpOnly, Secure, SameSite=Strict cookie when
+// USE_REFRESH_COOKIE is enabled, so browser scripts never need to handle it
+func (s *Server) setRefreshCookie(c *gin.Context, refreshToken string) {
+	if !s.config.UseRefreshCookie || refreshToken == "" {
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     s.config.RefreshCookieName,
+		Value:    refreshToken,
+		Path:     "/",
+		MaxAge:   int(refreshCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
 }
 
 // forgotPassword initiates the forgot password flow
@@ -241,18 +683,32 @@ func (s *Server) forgotPassword(c *gin.Context) {
 		Email string `json:"email" binding:"required,email"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	// Limit reset requests per email, across all instances when the tracker is shared
+	key := strings.ToLower(request.Email)
+	allowed, err := s.forgotPasswordTracker.RecordAttempt(c.Request.Context(), key)
+	if err != nil {
+		log.Printf("Error recording forgot password attempt: %v", err)
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to initiate forgot password flow")
+		return
+	}
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(s.config.ForgotPasswordWindow.Seconds())))
+		httputil.RespondError(c, http.StatusTooManyRequests, "TOO_MANY_ATTEMPTS", "Too many password reset attempts, please try again later")
 		return
 	}
 
 	// Initiate the forgot password flow with Cognito
-	err := s.cognitoClient.ForgotPassword(request.Email)
+	err = s.cognitoClient.ForgotPassword(c.Request.Context(), request.Email)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initiate forgot password flow"})
+		respondCognitoError(c, err, "Failed to initiate forgot password flow")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Password reset code sent successfully"})
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"message": "Password reset code sent successfully"})
 }
 
 // confirmForgotPassword completes the forgot password flow
@@ -260,59 +716,190 @@ func (s *Server) confirmForgotPassword(c *gin.Context) {
 	var request struct {
 		Email            string `json:"email" binding:"required,email"`
 		ConfirmationCode string `json:"confirmationCode" binding:"required"`
-		NewPassword      string `json:"newPassword" binding:"required,min=8"`
+		NewPassword      string `json:"newPassword" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	// Reject malformed codes before spending a Cognito round trip; Cognito still checks the value
+	if !validConfirmationCode(request.ConfirmationCode, s.config.ConfirmationCodeLength) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_CODE_FORMAT", fmt.Sprintf("Confirmation code must be %d digits", s.config.ConfirmationCodeLength))
+		return
+	}
+	// Check the password locally first so that users get immediate feedback
+	if err := model.ValidatePassword(request.NewPassword, s.config.PasswordMinLength); err != nil {
+		httputil.RespondError(c, http.StatusBadRequest, "WEAK_PASSWORD", err.Error())
 		return
 	}
 
 	// Confirm the forgot password with Cognito
-	err := s.cognitoClient.ConfirmForgotPassword(
+	err := s.cognitoClient.ConfirmForgotPassword(c.Request.Context(),
 		request.Email,
 		request.ConfirmationCode,
 		request.NewPassword,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		respondCognitoError(c, err, "Failed to reset password")
+		return
+	}
+
+	// The reset succeeded, so earlier attempts no longer count against the user
+	if err := s.forgotPasswordTracker.Reset(c.Request.Context(), strings.ToLower(request.Email)); err != nil {
+		log.Printf("Error resetting forgot password attempts: %v", err)
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// getPermissions describes what the authenticated user may do, so clients can decide whether to
+// show admin features without decoding the token themselves. The groups come from the token's
+// cognito:groups claim, extracted by the JWT middleware with auth.GetUserGroupsFromClaims.
+func (s *Server) getPermissions(c *gin.Context) {
+	sub, ok := auth.GetUserSubFromContext(c)
+	if !ok || sub == "" {
+		httputil.RespondError(c, http.StatusUnauthorized, "UNAUTHORIZED", "Token does not identify a user")
+		return
+	}
+
+	groups, ok := auth.GetUserGroupsFromContext(c)
+	if !ok {
+		groups = []string{}
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{
+		"sub":     sub,
+		"groups":  groups,
+		"isAdmin": auth.IsAdminFromContext(c),
+	})
+}
+
+// totpIssuer names this service in authenticator apps
+const totpIssuer = "aws_e2e_test"
+
+// totpCodeLength is the length of the codes generated by authenticator apps
+const totpCodeLength = 6
+
+// getMFAStatus reports whether the authenticated user has MFA enabled, and the preferred method
+// ("totp" or "sms", empty when MFA is off)
+func (s *Server) getMFAStatus(c *gin.Context) {
+	accessToken, _ := auth.GetAccessTokenFromContext(c)
+	enabled, method, err := s.cognitoClient.GetUserMFAStatus(c.Request.Context(), accessToken)
+	if err != nil {
+		respondCognitoError(c, err, "Failed to get MFA status")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"enabled": enabled, "method": method})
+}
+
+// setupMFA starts TOTP setup for the authenticated user. It returns the secret along with an
+// otpauth:// URI, which the client shows as a QR code for the authenticator app to scan.
+func (s *Server) setupMFA(c *gin.Context) {
+	accessToken, _ := auth.GetAccessTokenFromContext(c)
+	secret, err := s.cognitoClient.AssociateSoftwareToken(c.Request.Context(), accessToken)
+	if err != nil {
+		respondCognitoError(c, err, "Failed to set up MFA")
+		return
+	}
+
+	// Label the entry in the authenticator app with the user's email, or username if there is none
+	account, ok := auth.GetUserEmailFromContext(c)
+	if !ok || account == "" {
+		account = c.GetString("username")
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{
+		"secretCode": secret,
+		"otpauthUri": totpURI(totpIssuer, account, secret),
+	})
+}
+
+// totpURI builds the otpauth:// URI of a TOTP secret, in the Key URI Format understood by
+// authenticator apps
+func totpURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + query.Encode()
+}
+
+// verifyMFA completes TOTP setup with a code from the authenticator app, enabling TOTP MFA for
+// the authenticated user
+func (s *Server) verifyMFA(c *gin.Context) {
+	var request struct {
+		Code       string `json:"code" binding:"required"`
+		DeviceName string `json:"deviceName"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		httputil.RespondBindError(c, err)
+		return
+	}
+	if !validConfirmationCode(request.Code, totpCodeLength) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_CODE_FORMAT", fmt.Sprintf("MFA code must be %d digits", totpCodeLength))
+		return
+	}
+
+	accessToken, _ := auth.GetAccessTokenFromContext(c)
+	if err := s.cognitoClient.VerifySoftwareToken(c.Request.Context(), accessToken, request.Code, request.DeviceName); err != nil {
+		respondCognitoError(c, err, "Failed to verify MFA code")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"enabled": true, "method": "totp"})
 }
 
 // getUsers returns all users
 func (s *Server) getUsers(c *gin.Context) {
-	users, err := s.userStore.GetAll()
+	users, err := s.userStore.GetAll(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve users")
 		return
 	}
 
 	// Convert users to response format
 	responses := make([]*model.UserResponse, len(users))
 	for i, user := range users {
-		responses[i] = user.ToResponse()
+		responses[i] = userResponse(c, user)
 	}
 
-	c.JSON(http.StatusOK, responses)
+	httputil.RespondList(c, responses)
 }
 
 // getUserByEmail returns a user by email
 func (s *Server) getUserByEmail(c *gin.Context) {
 	email := c.Param("email")
-	user, err := s.userStore.GetByEmail(email)
+	user, err := s.userStore.GetByEmail(c.Request.Context(), email)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
 		return
 	}
 
 	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		httputil.RespondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
 		return
 	}
 
-	c.JSON(http.StatusOK, user.ToResponse())
+	httputil.RespondJSON(c, http.StatusOK, userResponse(c, user))
+}
+
+// getUserBySub returns the user with the given Cognito sub, for resolving identities when only
+// an access token (which has no email claim) is available
+func (s *Server) getUserBySub(c *gin.Context) {
+	sub := c.Param("sub")
+
+	user, err := s.userStore.GetBySub(c.Request.Context(), sub)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
+		return
+	}
+	if user == nil {
+		httputil.RespondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, userResponse(c, user))
 }
 
 // createUser creates a new user
@@ -322,50 +909,72 @@ func (s *Server) createUser(c *gin.Context) {
 		FirstName string `json:"firstName" binding:"required"`
 		LastName  string `json:"lastName" binding:"required"`
 	}
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := httputil.BindJSON(c, &request, s.config.StrictJSON); err != nil {
+		httputil.RespondBindError(c, err)
 		return
 	}
-
-	// Check if user already exists
-	existingUser, err := s.userStore.GetByEmail(request.Email)
+	full, err := s.capacityReached(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check if user exists"})
+		log.Printf("Error counting users: %v", err)
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to create user")
 		return
 	}
-	if existingUser != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+	if full {
+		respondCapacityReached(c)
 		return
 	}
 
-	// Create the user
+	// Create the user, recording the administrator who created it. Any authenticated user may
+	// call this endpoint, so other callers' users are recorded like signups. The store's
+	// conditional write rejects duplicates atomically, so there is no separate existence check
+	// that a concurrent request could race past.
 	user := model.NewUser(request.Email, request.FirstName, request.LastName)
-	err = s.userStore.Create(user)
+	if auth.IsAdminFromContext(c) {
+		user.CreatedBy, _ = auth.GetUserSubFromContext(c)
+	} else {
+		user.CreatedBy = model.CreatedBySelf
+	}
+	stored, created, err := s.userStore.GetOrCreate(c.Request.Context(), user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to create user")
+		return
+	}
+	if !created {
+		respondUserExists(c)
 		return
 	}
 
-	c.JSON(http.StatusCreated, user.ToResponse())
+	s.userCount.add(1)
+	respondUserCreated(c, stored)
 }
 
 // updateUser updates an existing user
 func (s *Server) updateUser(c *gin.Context) {
 	email := c.Param("email")
+	if !s.checkCanModifyUser(c, email) {
+		return
+	}
+
 	var request model.UserUpdateRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := httputil.BindJSON(c, &request, s.config.StrictJSON); err != nil {
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	// Only administrators may change a user's status
+	if request.Status != "" && !auth.IsAdminFromContext(c) {
+		httputil.RespondError(c, http.StatusForbidden, "FORBIDDEN", "Not allowed to change status")
 		return
 	}
 
 	// Get the existing user
-	user, err := s.userStore.GetByEmail(email)
+	user, err := s.userStore.GetByEmail(c.Request.Context(), email)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
 		return
 	}
 	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		httputil.RespondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
 		return
 	}
 
@@ -382,43 +991,637 @@ func (s *Server) updateUser(c *gin.Context) {
 	user.UpdatedAt = model.NewUser("", "", "").UpdatedAt // Update the timestamp
 
 	// Save the updated user
-	err = s.userStore.Update(user)
+	err = s.userStore.Update(c.Request.Context(), user)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update user")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, userResponse(c, user))
+}
+
+// patchUser partially updates an existing user with a JSON Merge Patch
+func (s *Server) patchUser(c *gin.Context) {
+	email := c.Param("email")
+	if !s.checkCanModifyUser(c, email) {
+		return
+	}
+
+	contentType := c.ContentType()
+	if contentType != "application/merge-patch+json" && contentType != "application/json" {
+		httputil.RespondError(c, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "Content-Type must be application/merge-patch+json")
+		return
+	}
+
+	var request model.UserPatchRequest
+	if err := httputil.BindJSON(c, &request, s.config.StrictJSON); err != nil {
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	// Only administrators may change a user's status
+	if request.Status != nil && !auth.IsAdminFromContext(c) {
+		httputil.RespondError(c, http.StatusForbidden, "FORBIDDEN", "Not allowed to change status")
+		return
+	}
+
+	// Get the existing user
+	user, err := s.userStore.GetByEmail(c.Request.Context(), email)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
+		return
+	}
+	if user == nil {
+		httputil.RespondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	// Update the name attributes in Cognito first so that a failure leaves both stores unchanged.
+	// The access token can only update its own user, so an administrator patching someone else
+	// goes through the admin API.
+	if attributes := request.CognitoAttributes(); len(attributes) > 0 {
+		sub, _ := auth.GetUserSubFromContext(c)
+		if sub != "" && sub == user.Sub {
+			accessToken, _ := auth.GetAccessTokenFromContext(c)
+			err = s.cognitoClient.UpdateUserAttributes(c.Request.Context(), accessToken, attributes)
+		} else {
+			err = s.cognitoClient.AdminUpdateUserAttributes(c.Request.Context(), user.Email, attributes)
+		}
+		if err != nil {
+			respondCognitoError(c, err, "Failed to update user attributes")
+			return
+		}
+	}
+
+	// Apply the fields present in the patch
+	request.Apply(user)
+	user.UpdatedAt = httputil.Timestamp(time.Now())
+
+	// Save the updated user
+	err = s.userStore.Update(c.Request.Context(), user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update user")
 		return
 	}
 
-	c.JSON(http.StatusOK, user.ToResponse())
+	httputil.RespondJSON(c, http.StatusOK, userResponse(c, user))
+}
+
+// currentUser returns the record of the authenticated user, writing the error response if there
+// is none
+func (s *Server) currentUser(c *gin.Context) (*model.User, bool) {
+	sub, _ := auth.GetUserSubFromContext(c)
+	email, _ := auth.GetUserEmailFromContext(c)
+	if sub == "" && email == "" {
+		httputil.RespondError(c, http.StatusUnauthorized, "UNAUTHORIZED", "Token does not identify a user")
+		return nil, false
+	}
+
+	user, err := s.callerUser(c)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
+		return nil, false
+	}
+	if user == nil {
+		httputil.RespondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return nil, false
+	}
+	return user, true
+}
+
+// updateCurrentUser updates the profile of the authenticated user
+func (s *Server) updateCurrentUser(c *gin.Context) {
+	user, ok := s.currentUser(c)
+	if !ok {
+		return
+	}
+
+	var request model.UserUpdateRequest
+	if err := httputil.BindJSON(c, &request, s.config.StrictJSON); err != nil {
+		httputil.RespondBindError(c, err)
+		return
+	}
+
+	// Only administrators may change a user's status
+	if request.Status != "" && !auth.IsAdminFromContext(c) {
+		httputil.RespondError(c, http.StatusForbidden, "FORBIDDEN", "Not allowed to change status")
+		return
+	}
+
+	// Update the name attributes in Cognito first so that a failure leaves both stores unchanged
+	attributes := make(map[string]string)
+	if request.FirstName != "" {
+		attributes["given_name"] = request.FirstName
+	}
+	if request.LastName != "" {
+		attributes["family_name"] = request.LastName
+	}
+	if len(attributes) > 0 {
+		accessToken, _ := auth.GetAccessTokenFromContext(c)
+		err := s.cognitoClient.UpdateUserAttributes(c.Request.Context(), accessToken, attributes)
+		if err != nil {
+			respondCognitoError(c, err, "Failed to update user attributes")
+			return
+		}
+	}
+
+	// Update the user fields
+	if request.FirstName != "" {
+		user.FirstName = request.FirstName
+	}
+	if request.LastName != "" {
+		user.LastName = request.LastName
+	}
+	if request.Status != "" {
+		user.Status = request.Status
+	}
+	user.UpdatedAt = httputil.Timestamp(time.Now())
+
+	// Save the updated user
+	err := s.userStore.Update(c.Request.Context(), user)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update user")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, userResponse(c, user))
 }
 
 // deleteUser deletes a user
 func (s *Server) deleteUser(c *gin.Context) {
 	email := c.Param("email")
+	if !s.checkCanModifyUser(c, email) {
+		return
+	}
 
 	// Check if user exists
-	user, err := s.userStore.GetByEmail(email)
+	exists, err := s.userStore.Exists(c.Request.Context(), email)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
+		return
+	}
+	if !exists {
+		httputil.RespondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	if err := s.removeUser(c.Request.Context(), email); err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to delete user")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+// changeEmail starts changing the authenticated user's email. Cognito sends a code to the new
+// address, and the user record keeps the old email until the code is passed to verifyEmail.
+func (s *Server) changeEmail(c *gin.Context) {
+	user, ok := s.currentUser(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		httputil.RespondBindError(c, err)
+		return
+	}
+	if strings.EqualFold(request.Email, user.Email) {
+		httputil.RespondError(c, http.StatusBadRequest, "EMAIL_UNCHANGED", "New email is the same as the current one")
+		return
+	}
+	// The new address must be one the user could have signed up with
+	if !emailDomainAllowed(request.Email, s.config.SignupAllowedDomains) {
+		httputil.RespondError(c, http.StatusForbidden, "DOMAIN_NOT_ALLOWED", "Email changes are not available for this email domain")
+		return
+	}
+	if emailReserved(request.Email, s.config.ReservedLocalParts, s.config.ReservedEmails) {
+		httputil.RespondError(c, http.StatusForbidden, "RESERVED_EMAIL", "This email address is reserved")
+		return
+	}
+
+	exists, err := s.userStore.Exists(c.Request.Context(), request.Email)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to check email")
+		return
+	}
+	if exists {
+		httputil.RespondError(c, http.StatusConflict, "USER_EXISTS", "User already exists")
+		return
+	}
+
+	accessToken, _ := auth.GetAccessTokenFromContext(c)
+	delivery, err := s.cognitoClient.ChangeEmail(c.Request.Context(), accessToken, request.Email)
+	if err != nil {
+		respondCognitoError(c, err, "Failed to change email")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusAccepted, gin.H{
+		"message":      "Verification code sent to the new email",
+		"codeDelivery": delivery,
+	})
+}
+
+// verifyEmail completes an email change with the code sent to the new address, then moves the
+// user record to the new email. The record is found by sub, since the token may still carry the
+// old email.
+func (s *Server) verifyEmail(c *gin.Context) {
+	sub, ok := auth.GetUserSubFromContext(c)
+	if !ok || sub == "" {
+		httputil.RespondError(c, http.StatusUnauthorized, "UNAUTHORIZED", "Token does not identify a user")
+		return
+	}
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		httputil.RespondBindError(c, err)
+		return
+	}
+	if !validConfirmationCode(request.Code, s.config.ConfirmationCodeLength) {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_CODE_FORMAT", fmt.Sprintf("Confirmation code must be %d digits", s.config.ConfirmationCodeLength))
+		return
+	}
+
+	accessToken, _ := auth.GetAccessTokenFromContext(c)
+	newEmail, err := s.cognitoClient.VerifyEmail(c.Request.Context(), accessToken, request.Code)
+	if err != nil {
+		respondCognitoError(c, err, "Failed to verify email")
+		return
+	}
+
+	user, err := s.userStore.GetBySub(c.Request.Context(), sub)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
 		return
 	}
 	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		httputil.RespondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	// A retried verification finds the record already moved
+	if newEmail != "" && user.Email != newEmail {
+		oldEmail := user.Email
+		updated := *user
+		updated.Email = newEmail
+		updated.UpdatedAt = httputil.Timestamp(time.Now())
+		if err := s.userStore.ChangeEmail(c.Request.Context(), oldEmail, &updated); err != nil {
+			if errors.Is(err, store.ErrAlreadyExists) {
+				httputil.RespondError(c, http.StatusConflict, "USER_EXISTS", "User already exists")
+				return
+			}
+			log.Printf("Error changing email of user %s to %s: %v", oldEmail, newEmail, err)
+			httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update user")
+			return
+		}
+		user = &updated
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, userResponse(c, user))
+}
+
+// deleteCurrentUser deletes the account of the authenticated user. When CASCADE_DELETE_MESSAGES
+// is enabled, the user's messages are deleted first so that they are not orphaned.
+func (s *Server) deleteCurrentUser(c *gin.Context) {
+	user, ok := s.currentUser(c)
+	if !ok {
 		return
 	}
 
+	response := gin.H{"message": "User deleted successfully"}
+
+	// Delete the user's messages while their token is still valid, so a failure can be retried
+	if s.messageDeleter != nil {
+		accessToken, _ := auth.GetAccessTokenFromContext(c)
+		messagesDeleted, err := s.messageDeleter.DeleteMine(c.Request.Context(), accessToken)
+		if err != nil {
+			log.Printf("Error deleting messages for user %s: %v", user.Email, err)
+			httputil.RespondError(c, http.StatusBadGateway, "UPSTREAM_ERROR", "Failed to delete the user's messages")
+			return
+		}
+		response["messagesDeleted"] = messagesDeleted
+	}
+
+	if err := s.removeUser(c.Request.Context(), user.Email); err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to delete user")
+		return
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, response)
+}
+
+// removeUser deletes a user from Cognito and the database
+func (s *Server) removeUser(ctx context.Context, email string) error {
 	// Delete the user from Cognito
-	err = s.cognitoClient.AdminDeleteUser(email)
+	err := s.cognitoClient.AdminDeleteUser(ctx, email)
 	if err != nil {
 		log.Printf("WARNING: Failed to delete user from Cognito: %v", err)
 		// Continue with deleting from the database
 	}
 
 	// Delete the user from the database
-	err = s.userStore.Delete(email)
+	if err := s.userStore.Delete(ctx, email); err != nil {
+		return err
+	}
+	s.userCount.add(-1)
+	return nil
+}
+
+// respondUserCreated writes the 201 response for a signup or create. The body is the user exactly
+// as written to the store, and Location points at it, so clients can use the body rather than
+// fetching the user again, which may miss the write on an eventually consistent store.
+func respondUserCreated(c *gin.Context, user *model.User) {
+	c.Header("Location", "/users/"+url.PathEscape(user.Email))
+	httputil.RespondCreated(c, userResponse(c, user))
+}
+
+// userResponse converts a user to the response for the caller. Only administrators see who
+// created the user.
+func userResponse(c *gin.Context, user *model.User) *model.UserResponse {
+	if auth.IsAdminFromContext(c) {
+		return user.ToAdminResponse()
+	}
+	return user.ToResponse()
+}
+
+// respondUserExists writes the conflict response for a signup or create with a taken email
+func respondUserExists(c *gin.Context) {
+	httputil.RespondError(c, http.StatusConflict, "USER_EXISTS", "User already exists")
+}
+
+// respondCapacityReached writes the response for a signup or create beyond MAX_USERS
+func respondCapacityReached(c *gin.Context) {
+	httputil.RespondError(c, http.StatusForbidden, "CAPACITY_REACHED", "The maximum number of users has been reached")
+}
+
+// emailDomainAllowed reports whether the email's domain is in allowedDomains, which must be
+// lowercase. An empty list allows every domain.
+func emailDomainAllowed(email string, allowedDomains []string) bool {
+	if len(allowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	return slices.Contains(allowedDomains, strings.ToLower(email[at+1:]))
+}
+
+// emailReserved reports whether email is one of reservedEmails, or its local-part (ignoring any
+// +tag) is one of reservedLocalParts on any domain. Both lists must be lowercase.
+func emailReserved(email string, reservedLocalParts, reservedEmails []string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if slices.Contains(reservedEmails, email) {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	localPart, _, _ := strings.Cut(email[:at], "+")
+	return slices.Contains(reservedLocalParts, localPart)
+}
+
+// validConfirmationCode reports whether code consists of exactly length ASCII digits, the
+// format of the codes Cognito sends
+func validConfirmationCode(code string, length int) bool {
+	if len(code) != length {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// canModifyUser reports whether the authenticated user may modify the user with the given email.
+// Administrators may modify anyone; other users may only modify their own record.
+func (s *Server) canModifyUser(c *gin.Context, email string) (bool, error) {
+	if auth.IsAdminFromContext(c) {
+		return true, nil
+	}
+
+	caller, err := s.callerUser(c)
+	if err != nil {
+		return false, err
+	}
+	return caller != nil && strings.EqualFold(caller.Email, email), nil
+}
+
+// callerUser returns the authenticated user's record, or nil if there is none. The record is
+// found by the token's sub or, failing that, by its email claim, which finds users stored
+// without a sub. Their sub is then recorded, so that access tokens, which have no email claim,
+// find them too. A record found by email that holds a different sub is not the caller's.
+func (s *Server) callerUser(c *gin.Context) (*model.User, error) {
+	ctx := c.Request.Context()
+	sub, _ := auth.GetUserSubFromContext(c)
+	if sub != "" {
+		user, err := s.userStore.GetBySub(ctx, sub)
+		if err != nil || user != nil {
+			return user, err
+		}
+	}
+
+	email, _ := auth.GetUserEmailFromContext(c)
+	if email == "" {
+		return nil, nil
+	}
+	user, err := s.userStore.GetByEmail(ctx, email)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if user.Sub != "" {
+		if user.Sub != sub {
+			return nil, nil
+		}
+		return user, nil
+	}
+	s.recordSub(ctx, user, sub)
+	return user, nil
+}
+
+// checkCanModifyUser is canModifyUser for handlers, writing the error response when the
+// authenticated user may not modify the user with the given email
+func (s *Server) checkCanModifyUser(c *gin.Context, email string) bool {
+	allowed, err := s.canModifyUser(c, email)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
+		return false
+	}
+	if !allowed {
+		httputil.RespondError(c, http.StatusForbidden, "FORBIDDEN", "Not allowed to modify this user")
+		return false
+	}
+	return true
+}
+
+// adminListUsers returns the users in the database, including who created each one. With
+// ?createdBy=self it returns only users who signed up, and with ?createdBy=admin only users
+// created by an administrator.
+func (s *Server) adminListUsers(c *gin.Context) {
+	createdBy := c.Query("createdBy")
+	if createdBy != "" && createdBy != "self" && createdBy != "admin" {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_PARAMETER", "Invalid createdBy, expected 'self' or 'admin'")
+		return
+	}
+
+	users, err := s.userStore.GetAll(c.Request.Context())
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve users")
+		return
+	}
+
+	responses := make([]*model.UserResponse, 0, len(users))
+	for _, user := range users {
+		if createdBy != "" && user.SelfCreated() != (createdBy == "self") {
+			continue
+		}
+		responses = append(responses, user.ToAdminResponse())
+	}
+
+	httputil.RespondList(c, responses)
+}
+
+// adminGetUser returns a user's database record merged with their Cognito account state
+func (s *Server) adminGetUser(c *gin.Context) {
+	email := c.Param("email")
+
+	user, err := s.userStore.GetByEmail(c.Request.Context(), email)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve user")
+		return
+	}
+
+	cognitoUser, err := s.cognitoClient.AdminGetUser(c.Request.Context(), email)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		respondCognitoError(c, err, "Failed to retrieve user from Cognito")
+		return
+	}
+
+	if user == nil && cognitoUser == nil {
+		httputil.RespondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+		return
+	}
+
+	response := &model.AdminUserResponse{Email: email}
+	if user != nil {
+		response.InDatabase = true
+		response.FirstName = user.FirstName
+		response.LastName = user.LastName
+		response.Status = user.Status
+		response.CreatedAt = &user.CreatedAt
+		response.UpdatedAt = &user.UpdatedAt
+	}
+	if cognitoUser != nil {
+		response.InCognito = true
+		response.Enabled = cognitoUser["enabled"] == "true"
+		response.UserStatus = cognitoUser["userStatus"]
+		response.LastModified = cognitoUser["lastModified"]
+
+		// Fall back to the Cognito name attributes when there is no database record
+		if user == nil {
+			response.FirstName = cognitoUser["given_name"]
+			response.LastName = cognitoUser["family_name"]
+		}
+	}
+
+	httputil.RespondJSON(c, http.StatusOK, response)
+}
+
+// maxActivityEvents is the largest limit accepted by the activity feed
+const maxActivityEvents = 200
+
+// maxActivitySummaryText is how much of a message's text appears in its activity summary
+const maxActivitySummaryText = 80
+
+// getActivity returns recent signups and messages as one feed, newest first. Messages come from
+// msgsvc using the administrator's own token; without MESSAGES_SERVICE_URL only signups appear.
+func (s *Server) getActivity(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > maxActivityEvents {
+		httputil.RespondError(c, http.StatusBadRequest, "INVALID_LIMIT", fmt.Sprintf("Invalid limit, expected a number from 1 to %d", maxActivityEvents))
+		return
+	}
+
+	users, err := s.userStore.GetRecent(c.Request.Context(), limit)
+	if err != nil {
+		httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to retrieve users")
+		return
+	}
+
+	var recentMessages []messages.Message
+	if s.messageFeed != nil {
+		accessToken, _ := auth.GetAccessTokenFromContext(c)
+		recentMessages, err = s.messageFeed.Recent(c.Request.Context(), accessToken, limit)
+		if err != nil {
+			log.Printf("Error getting recent messages: %v", err)
+			httputil.RespondError(c, http.StatusBadGateway, "UPSTREAM_ERROR", "Failed to retrieve messages")
+			return
+		}
+	}
+
+	httputil.RespondList(c, mergeActivity(users, recentMessages, limit, s.config.DisplayNameFallback))
+}
+
+// mergeActivity merges users and messages, each sorted newest first, into up to limit activity
+// events, newest first. displayNameFallback names users without a first or last name by their
+// email local-part or sub.
+func mergeActivity(users []*model.User, recentMessages []messages.Message, limit int, displayNameFallback bool) []*model.ActivityEvent {
+	events := make([]*model.ActivityEvent, 0, min(limit, len(users)+len(recentMessages)))
+	i, j := 0, 0
+	for len(events) < limit && (i < len(users) || j < len(recentMessages)) {
+		if j == len(recentMessages) || (i < len(users) && !users[i].CreatedAt.Time().Before(recentMessages[j].Timestamp.Time())) {
+			user := users[i]
+			events = append(events, &model.ActivityEvent{
+				Type:    model.ActivityUserCreated,
+				At:      user.CreatedAt,
+				Summary: fmt.Sprintf("%s (%s) signed up", auth.DisplayName(user.FirstName, user.LastName, user.Email, user.Sub, displayNameFallback), user.Email),
+			})
+			i++
+		} else {
+			message := recentMessages[j]
+			events = append(events, &model.ActivityEvent{
+				Type:    model.ActivityMessageCreated,
+				At:      message.Timestamp,
+				Summary: fmt.Sprintf("%s posted: %s", message.Owner, abbreviate(message.Text, maxActivitySummaryText)),
+			})
+			j++
+		}
+	}
+	return events
+}
+
+// abbreviate shortens text to at most maxRunes runes, marking any cut with an ellipsis
+func abbreviate(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return string(runes[:maxRunes-1]) + "…"
+}
+
+// resendInvitation re-sends the invitation email to a user created by an administrator
+func (s *Server) resendInvitation(c *gin.Context) {
+	email := c.Param("email")
+
+	err := s.cognitoClient.ResendInvitation(c.Request.Context(), email)
+	if err != nil {
+		// Cognito only re-sends invitations to users who have not yet confirmed their account
+		var unsupportedStateErr *types.UnsupportedUserStateException
+		if errors.As(err, &unsupportedStateErr) {
+			httputil.RespondError(c, http.StatusConflict, "USER_ALREADY_CONFIRMED", "User has already confirmed their account")
+			return
+		}
+		respondCognitoError(c, err, "Failed to resend invitation")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	httputil.RespondJSON(c, http.StatusOK, gin.H{"message": "Invitation resent successfully"})
 }
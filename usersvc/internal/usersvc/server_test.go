@@ -0,0 +1,1997 @@
+package usersvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws_e2e_test/shared/auth"
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/aws_e2e_test/usersvc/internal/config"
+	"github.com/aws_e2e_test/usersvc/internal/messages"
+	"github.com/aws_e2e_test/usersvc/internal/model"
+	"github.com/aws_e2e_test/usersvc/internal/store"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeCognitoClient fails each operation with the configured error
+type fakeCognitoClient struct {
+	CognitoClient
+	loginErr                 error
+	confirmForgotPasswordErr error
+	resendErr                error
+	inviteErr                error
+	signUps                  int
+	resends                  int
+	confirmSignUps           int
+	refreshedWith            string
+	adminDeleted             []string
+	invited                  []string
+	poolUsers                map[string]map[string]string
+	tokenSubs                map[string]string
+	adminGetErr              error
+	updatedAttributes        map[string]string
+	adminUpdated             map[string]map[string]string
+}
+
+func (f *fakeCognitoClient) SignUp(_ context.Context, email, password, firstName, lastName string) (string, error) {
+	f.signUps++
+	return "sub-" + email, nil
+}
+
+func (f *fakeCognitoClient) ConfirmSignUp(_ context.Context, email, confirmationCode string) error {
+	f.confirmSignUps++
+	return nil
+}
+
+func (f *fakeCognitoClient) ResendConfirmationCode(_ context.Context, email string) error {
+	f.resends++
+	return f.resendErr
+}
+
+func (f *fakeCognitoClient) Login(_ context.Context, email, password string) (*model.AuthResponse, error) {
+	if f.loginErr != nil {
+		return nil, f.loginErr
+	}
+	return &model.AuthResponse{AccessToken: "token-" + email, TokenType: "Bearer"}, nil
+}
+
+func (f *fakeCognitoClient) GetUser(_ context.Context, accessToken string) (map[string]string, error) {
+	sub, ok := f.tokenSubs[accessToken]
+	if !ok {
+		return nil, errors.New("invalid access token")
+	}
+	return map[string]string{"sub": sub}, nil
+}
+
+func (f *fakeCognitoClient) RefreshToken(_ context.Context, refreshToken string) (*model.AuthResponse, error) {
+	f.refreshedWith = refreshToken
+	return &model.AuthResponse{AccessToken: "access", RefreshToken: "rotated-" + refreshToken}, nil
+}
+
+func (f *fakeCognitoClient) ConfirmForgotPassword(_ context.Context, email, confirmationCode, newPassword string) error {
+	return f.confirmForgotPasswordErr
+}
+
+func (f *fakeCognitoClient) AdminDeleteUser(_ context.Context, email string) error {
+	f.adminDeleted = append(f.adminDeleted, email)
+	return nil
+}
+
+func (f *fakeCognitoClient) ResendInvitation(_ context.Context, email string) error {
+	f.invited = append(f.invited, email)
+	return f.inviteErr
+}
+
+func (f *fakeCognitoClient) AdminUpdateUserAttributes(_ context.Context, email string, attributes map[string]string) error {
+	if f.adminUpdated == nil {
+		f.adminUpdated = make(map[string]map[string]string)
+	}
+	f.adminUpdated[email] = attributes
+	return nil
+}
+
+func (f *fakeCognitoClient) AdminGetUser(_ context.Context, email string) (map[string]string, error) {
+	return f.poolUsers[email], f.adminGetErr
+}
+
+func (f *fakeCognitoClient) UpdateUserAttributes(_ context.Context, accessToken string, attributes map[string]string) error {
+	f.updatedAttributes = attributes
+	return nil
+}
+
+func TestLoginFailureIncrementsCounter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{cognitoClient: &fakeCognitoClient{
+		loginErr: &types.NotAuthorizedException{Message: aws.String("Incorrect username or password.")},
+	}}
+	router := gin.New()
+	router.POST("/auth/login", s.login)
+
+	counter := authRequestsTotal.WithLabelValues(operationLogin, outcomeInvalidCredentials)
+	before := testutil.ToFloat64(counter)
+
+	body := `{"email":"alice@example.com","password":"wrong-password"}`
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("invalid_credentials login counter increased by %v, want 1", got)
+	}
+}
+
+func TestEmptyBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{config: &config.Config{}, cognitoClient: &fakeCognitoClient{}}
+	router := gin.New()
+	router.POST("/auth/signup", s.signUp)
+	router.POST("/auth/login", s.login)
+
+	for _, path := range []string{"/auth/signup", "/auth/login"} {
+		t.Run(path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if want := `{"code":"EMPTY_BODY","error":"request body is required"}`; rec.Body.String() != want {
+				t.Errorf("got body %s, want %s", rec.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestConfirmForgotPasswordErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		newPassword string
+		cognitoErr  error
+		wantStatus  int
+		wantCode    string
+	}{
+		{"short password", "short", nil, http.StatusBadRequest, "WEAK_PASSWORD"},
+		{"password policy", "longenough", &types.InvalidPasswordException{}, http.StatusBadRequest, "WEAK_PASSWORD"},
+		{"code mismatch", "longenough", &types.CodeMismatchException{}, http.StatusBadRequest, "CODE_MISMATCH"},
+		{"code expired", "longenough", &types.ExpiredCodeException{}, http.StatusBadRequest, "CODE_EXPIRED"},
+		{"other error", "longenough", errors.New("boom"), http.StatusInternalServerError, "INTERNAL_ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				config:                &config.Config{PasswordMinLength: 8, ConfirmationCodeLength: 6},
+				cognitoClient:         &fakeCognitoClient{confirmForgotPasswordErr: tt.cognitoErr},
+				forgotPasswordTracker: store.NewAttemptTracker(5, time.Minute),
+			}
+			router := gin.New()
+			router.POST("/auth/confirm-forgot-password", s.confirmForgotPassword)
+
+			body := `{"email":"alice@example.com","confirmationCode":"123456","newPassword":"` + tt.newPassword + `"}`
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/confirm-forgot-password", strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			var response struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if response.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", response.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSignUpAllowedDomains(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		allowedDomains []string
+		email          string
+		wantStatus     int
+	}{
+		{"allowed domain", []string{"example.com"}, "alice@Example.COM", http.StatusCreated},
+		{"disallowed domain", []string{"example.com"}, "mallory@example.net", http.StatusForbidden},
+		{"unset allows any domain", nil, "bob@example.org", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cognito := &fakeCognitoClient{}
+			s := &Server{
+				config:        &config.Config{PasswordMinLength: 8, SignupAllowedDomains: tt.allowedDomains},
+				cognitoClient: cognito,
+				userStore:     store.NewUserStore(),
+			}
+			router := gin.New()
+			router.POST("/auth/signup", s.signUp)
+
+			body := `{"email":"` + tt.email + `","password":"longenough","firstName":"Test","lastName":"User"}`
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/signup", strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			if tt.wantStatus == http.StatusForbidden {
+				if !strings.Contains(rec.Body.String(), `"DOMAIN_NOT_ALLOWED"`) {
+					t.Errorf("response %s missing DOMAIN_NOT_ALLOWED code", rec.Body.String())
+				}
+				if cognito.signUps != 0 {
+					t.Error("Cognito was called for a disallowed domain")
+				}
+			}
+		})
+	}
+}
+
+func TestSignUpReservedEmails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		localParts []string
+		emails     []string
+		email      string
+		wantStatus int
+	}{
+		{"reserved local-part", []string{"admin", "root"}, nil, "Admin@example.com", http.StatusForbidden},
+		{"reserved local-part with tag", []string{"admin", "root"}, nil, "root+test@example.org", http.StatusForbidden},
+		{"allowed address", []string{"admin", "root"}, nil, "alice@example.com", http.StatusCreated},
+		{"configured local-part", []string{"admin", "support"}, nil, "support@example.net", http.StatusForbidden},
+		{"configured address", nil, []string{"ceo@example.com"}, "CEO@example.com", http.StatusForbidden},
+		{"configured address on other domain", nil, []string{"ceo@example.com"}, "ceo@example.org", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cognito := &fakeCognitoClient{}
+			s := &Server{
+				config: &config.Config{
+					PasswordMinLength:  8,
+					ReservedLocalParts: tt.localParts,
+					ReservedEmails:     tt.emails,
+				},
+				cognitoClient: cognito,
+				userStore:     store.NewUserStore(),
+			}
+			router := gin.New()
+			router.POST("/auth/signup", s.signUp)
+
+			body := `{"email":"` + tt.email + `","password":"longenough","firstName":"Test","lastName":"User"}`
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/signup", strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			if tt.wantStatus == http.StatusForbidden {
+				if !strings.Contains(rec.Body.String(), `"code":"RESERVED_EMAIL"`) {
+					t.Errorf("response %s missing RESERVED_EMAIL code", rec.Body.String())
+				}
+				if cognito.signUps != 0 {
+					t.Error("Cognito was called for a reserved email")
+				}
+			}
+		})
+	}
+}
+
+func TestResendConfirmationCodeThrottle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cognitoClient := &fakeCognitoClient{}
+	s := &Server{
+		config:        &config.Config{ResendMinInterval: 30 * time.Second},
+		cognitoClient: cognitoClient,
+		resendTracker: store.NewAttemptTracker(1, 30*time.Second),
+	}
+	router := gin.New()
+	router.POST("/auth/resend-code", s.resendConfirmationCode)
+
+	resend := func(email string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/resend-code", strings.NewReader(`{"email":"`+email+`"}`)))
+		return rec
+	}
+
+	if rec := resend("alice@example.com"); rec.Code != http.StatusOK {
+		t.Fatalf("first resend: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := resend("Alice@Example.com")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("repeat resend: got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+	if rec := resend("bob@example.com"); rec.Code != http.StatusOK {
+		t.Errorf("resend for another email: got status %d", rec.Code)
+	}
+	if cognitoClient.resends != 2 {
+		t.Errorf("Cognito called %d times, want 2", cognitoClient.resends)
+	}
+}
+
+func TestResendConfirmationCodeCognitoLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{
+		config:        &config.Config{},
+		cognitoClient: &fakeCognitoClient{resendErr: &types.LimitExceededException{Message: aws.String("Attempt limit exceeded")}},
+	}
+	router := gin.New()
+	router.POST("/auth/resend-code", s.resendConfirmationCode)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/resend-code", strings.NewReader(`{"email":"alice@example.com"}`)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusTooManyRequests, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"LIMIT_EXCEEDED"`) {
+		t.Errorf("response %s does not have code LIMIT_EXCEEDED", rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+}
+
+func TestAdminGetUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	for _, email := range []string{"alice@example.com", "carol@example.com"} {
+		if err := userStore.Create(context.Background(), model.NewUser(email, "Db", "Name")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cognito := &fakeCognitoClient{poolUsers: map[string]map[string]string{
+		"alice@example.com": {"enabled": "true", "userStatus": "CONFIRMED", "lastModified": "2024-01-02T03:04:05Z", "given_name": "Pool"},
+		"bob@example.com":   {"enabled": "false", "userStatus": "UNCONFIRMED", "given_name": "Bob", "family_name": "Pool"},
+	}}
+	s := &Server{config: &config.Config{}, userStore: userStore, cognitoClient: cognito}
+	router := gin.New()
+	router.GET("/admin/users/:email", s.adminGetUser)
+
+	tests := []struct {
+		name       string
+		email      string
+		wantStatus int
+		want       model.AdminUserResponse
+	}{
+		{"in both", "alice@example.com", http.StatusOK, model.AdminUserResponse{
+			Email: "alice@example.com", FirstName: "Db", LastName: "Name", Status: string(model.UserStatusActive),
+			InDatabase: true, InCognito: true, Enabled: true, UserStatus: "CONFIRMED", LastModified: "2024-01-02T03:04:05Z",
+		}},
+		{"only in Cognito", "bob@example.com", http.StatusOK, model.AdminUserResponse{
+			Email: "bob@example.com", FirstName: "Bob", LastName: "Pool",
+			InCognito: true, UserStatus: "UNCONFIRMED",
+		}},
+		{"only in database", "carol@example.com", http.StatusOK, model.AdminUserResponse{
+			Email: "carol@example.com", FirstName: "Db", LastName: "Name", Status: string(model.UserStatusActive), InDatabase: true,
+		}},
+		{"in neither", "dave@example.com", http.StatusNotFound, model.AdminUserResponse{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/users/"+tt.email, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), `"code":"USER_NOT_FOUND"`) {
+					t.Errorf("response %s does not have code USER_NOT_FOUND", rec.Body.String())
+				}
+				return
+			}
+
+			var got model.AdminUserResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if (got.CreatedAt != nil) != tt.want.InDatabase {
+				t.Errorf("createdAt = %v, want it set only for database records", got.CreatedAt)
+			}
+			got.CreatedAt, got.UpdatedAt = nil, nil
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// A Cognito failure is not mistaken for a missing user
+	cognito.adminGetErr = errors.New("connection reset")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/users/dave@example.com", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Cognito failure: got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestResendInvitation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		inviteErr  error
+		wantStatus int
+		wantCode   string
+	}{
+		{"resent", nil, http.StatusOK, ""},
+		{"already confirmed", &types.UnsupportedUserStateException{Message: aws.String("User is already confirmed")}, http.StatusConflict, "USER_ALREADY_CONFIRMED"},
+		{"unknown user", &types.UserNotFoundException{Message: aws.String("User does not exist")}, http.StatusNotFound, "USER_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cognito := &fakeCognitoClient{inviteErr: tt.inviteErr}
+			s := &Server{config: &config.Config{}, cognitoClient: cognito}
+			router := gin.New()
+			router.POST("/admin/users/:email/resend-invite", s.resendInvitation)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/users/alice@example.com/resend-invite", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" && !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("response %s does not have code %s", rec.Body.String(), tt.wantCode)
+			}
+			if len(cognito.invited) != 1 || cognito.invited[0] != "alice@example.com" {
+				t.Errorf("invitations resent to %v, want [alice@example.com]", cognito.invited)
+			}
+		})
+	}
+}
+
+func TestConfirmSignUpCodeFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		code       string
+		wantStatus int
+		wantCalled bool
+	}{
+		{"well-formed", "123456", http.StatusOK, true},
+		{"too short", "12345", http.StatusBadRequest, false},
+		{"too long", "1234567", http.StatusBadRequest, false},
+		{"not numeric", "12a456", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cognitoClient := &fakeCognitoClient{}
+			s := &Server{config: &config.Config{ConfirmationCodeLength: 6}, cognitoClient: cognitoClient}
+			router := gin.New()
+			router.POST("/auth/confirm", s.confirmSignUp)
+
+			body := `{"email":"alice@example.com","confirmationCode":"` + tt.code + `"}`
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/confirm", strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusBadRequest && !strings.Contains(rec.Body.String(), `"code":"INVALID_CODE_FORMAT"`) {
+				t.Errorf("response %s does not have code INVALID_CODE_FORMAT", rec.Body.String())
+			}
+			if called := cognitoClient.confirmSignUps > 0; called != tt.wantCalled {
+				t.Errorf("Cognito called = %v, want %v", called, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestEmptyListResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{config: &config.Config{}, userStore: store.NewUserStore()}
+	router := gin.New()
+	router.GET("/users", s.getUsers)
+	router.GET("/admin/users", s.adminListUsers)
+	router.GET("/admin/activity", s.getActivity)
+
+	// Every list endpoint answers with the envelope, even when there is nothing to list
+	for _, path := range []string{"/users", "/admin/users", "/admin/activity"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"items":[],"count":0}` {
+			t.Errorf("GET %s: got status %d and body %s, want an empty list", path, rec.Code, rec.Body.String())
+		}
+		if cacheControl := rec.Header().Get("Cache-Control"); cacheControl != "no-cache, no-store, must-revalidate" {
+			t.Errorf("GET %s: got Cache-Control %q", path, cacheControl)
+		}
+	}
+}
+
+// fakeMessageFeed serves a fixed list of messages, newest first
+type fakeMessageFeed struct {
+	messages []messages.Message
+}
+
+func (f *fakeMessageFeed) Recent(_ context.Context, accessToken string, limit int) ([]messages.Message, error) {
+	return f.messages[:min(limit, len(f.messages))], nil
+}
+
+func TestGetActivity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) httputil.Timestamp {
+		return httputil.Timestamp(base.Add(time.Duration(minutes) * time.Minute))
+	}
+
+	userStore := store.NewUserStore()
+	for email, minutes := range map[string]int{"alice@example.com": 1, "bob@example.com": 4} {
+		user := model.NewUser(email, "Test", "User")
+		user.CreatedAt = at(minutes)
+		if err := userStore.Create(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	feed := &fakeMessageFeed{messages: []messages.Message{
+		{ID: "m3", Text: "third", Owner: "sub-bob", Timestamp: at(5)},
+		{ID: "m2", Text: "second", Owner: "sub-alice", Timestamp: at(3)},
+		{ID: "m1", Text: "first", Owner: "sub-alice", Timestamp: at(2)},
+	}}
+	s := &Server{config: &config.Config{}, userStore: userStore, messageFeed: feed}
+	router := gin.New()
+	router.GET("/admin/activity", s.getActivity)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantTimes  []int
+	}{
+		{"", http.StatusOK, []int{5, 4, 3, 2, 1}},
+		{"?limit=3", http.StatusOK, []int{5, 4, 3}},
+		{"?limit=0", http.StatusBadRequest, nil},
+		{"?limit=201", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/activity"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantTimes == nil {
+				return
+			}
+
+			var events []model.ActivityEvent
+			if err := decodeList(rec.Body.Bytes(), &events); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if len(events) != len(tt.wantTimes) {
+				t.Fatalf("got %d events, want %d", len(events), len(tt.wantTimes))
+			}
+			for i, event := range events {
+				if !event.At.Time().Equal(at(tt.wantTimes[i]).Time()) {
+					t.Errorf("event %d at %v, want %v", i, event.At.Time(), at(tt.wantTimes[i]).Time())
+				}
+				wantType := model.ActivityMessageCreated
+				if tt.wantTimes[i] == 1 || tt.wantTimes[i] == 4 {
+					wantType = model.ActivityUserCreated
+				}
+				if event.Type != wantType {
+					t.Errorf("event %d type = %q, want %q", i, event.Type, wantType)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeActivityDisplayNames(t *testing.T) {
+	fullName := model.NewUser("alice@example.com", "Alice", "Smith")
+	fullName.Sub = "sub-alice"
+	emailOnly := model.NewUser("bob.jones@example.com", "", "")
+	emailOnly.Sub = "sub-bob"
+	subOnly := model.NewUser("", "", "")
+	subOnly.Sub = "sub-carol"
+	users := []*model.User{fullName, emailOnly, subOnly}
+
+	tests := []struct {
+		name     string
+		fallback bool
+		want     []string
+	}{
+		{"with fallback", true, []string{
+			"Alice Smith (alice@example.com) signed up",
+			"bob.jones (bob.jones@example.com) signed up",
+			"sub-carol () signed up",
+		}},
+		{"without fallback", false, []string{
+			"Alice Smith (alice@example.com) signed up",
+			" (bob.jones@example.com) signed up",
+			" () signed up",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := mergeActivity(users, nil, len(users), tt.fallback)
+			if len(events) != len(tt.want) {
+				t.Fatalf("got %d events, want %d", len(events), len(tt.want))
+			}
+			for i, event := range events {
+				if event.Summary != tt.want[i] {
+					t.Errorf("event %d summary = %q, want %q", i, event.Summary, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// countingUserStore counts calls to CountUsers
+type countingUserStore struct {
+	store.UserStore
+	counts int
+}
+
+func (s *countingUserStore) CountUsers(_ context.Context) (int64, error) {
+	s.counts++
+	return s.UserStore.CountUsers(context.Background())
+}
+
+func TestSignUpMaxUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		maxUsers    int
+		existing    int
+		wantStatus  int
+		wantCounted bool
+	}{
+		{"below the cap", 3, 2, http.StatusCreated, true},
+		{"at the cap", 2, 2, http.StatusForbidden, true},
+		{"above the cap", 1, 2, http.StatusForbidden, true},
+		{"no cap", 0, 2, http.StatusCreated, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStore := &countingUserStore{UserStore: store.NewUserStore()}
+			for i := 0; i < tt.existing; i++ {
+				if err := userStore.Create(context.Background(), model.NewUser(fmt.Sprintf("user%d@example.com", i), "Test", "User")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cognito := &fakeCognitoClient{}
+			s := &Server{
+				config:        &config.Config{PasswordMinLength: 8, MaxUsers: tt.maxUsers},
+				cognitoClient: cognito,
+				userStore:     userStore,
+			}
+			router := gin.New()
+			router.POST("/auth/signup", s.signUp)
+
+			body := `{"email":"new@example.com","password":"longenough","firstName":"Test","lastName":"User"}`
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/signup", strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusForbidden {
+				if !strings.Contains(rec.Body.String(), `"code":"CAPACITY_REACHED"`) {
+					t.Errorf("response %s missing CAPACITY_REACHED code", rec.Body.String())
+				}
+				if cognito.signUps != 0 {
+					t.Error("Cognito was called beyond the cap")
+				}
+			}
+			if counted := userStore.counts > 0; counted != tt.wantCounted {
+				t.Errorf("users counted = %v, want %v", counted, tt.wantCounted)
+			}
+		})
+	}
+}
+
+func TestUserCounterCachesCount(t *testing.T) {
+	userStore := &countingUserStore{UserStore: store.NewUserStore()}
+	var counter userCounter
+
+	for i := 0; i < 3; i++ {
+		if _, err := counter.get(context.Background(), userStore); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if userStore.counts != 1 {
+		t.Errorf("store counted %d times, want 1", userStore.counts)
+	}
+
+	counter.add(1)
+	if count, _ := counter.get(context.Background(), userStore); count != 1 {
+		t.Errorf("count after add = %d, want 1", count)
+	}
+}
+
+func TestRefreshTokenSources(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		body          string
+		cookie        string
+		useCookie     bool
+		wantStatus    int
+		wantRefreshed string
+		wantSetCookie string
+	}{
+		{"body only", `{"refreshToken":"from-body"}`, "", false, http.StatusOK, "from-body", ""},
+		{"cookie only", "", "from-cookie", false, http.StatusOK, "from-cookie", ""},
+		{"body preferred over cookie", `{"refreshToken":"from-body"}`, "from-cookie", false, http.StatusOK, "from-body", ""},
+		{"cookie set on response", "", "from-cookie", true, http.StatusOK, "from-cookie", "rotated-from-cookie"},
+		{"neither", "", "", false, http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cognito := &fakeCognitoClient{}
+			s := &Server{
+				config:        &config.Config{RefreshCookieName: "refresh_token", UseRefreshCookie: tt.useCookie},
+				cognitoClient: cognito,
+			}
+			router := gin.New()
+			router.POST("/auth/refresh", s.refreshToken)
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh", strings.NewReader(tt.body))
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "refresh_token", Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if cognito.refreshedWith != tt.wantRefreshed {
+				t.Errorf("refreshed with %q, want %q", cognito.refreshedWith, tt.wantRefreshed)
+			}
+
+			var setCookie *http.Cookie
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name == "refresh_token" {
+					setCookie = cookie
+				}
+			}
+			if tt.wantSetCookie == "" {
+				if setCookie != nil {
+					t.Errorf("unexpected cookie set: %v", setCookie)
+				}
+				return
+			}
+			if setCookie == nil {
+				t.Fatal("refresh cookie not set")
+			}
+			if setCookie.Value != tt.wantSetCookie || !setCookie.HttpOnly || !setCookie.Secure || setCookie.SameSite != http.SameSiteStrictMode {
+				t.Errorf("got cookie %+v, want an HttpOnly, Secure, SameSite=Strict cookie with value %q", setCookie, tt.wantSetCookie)
+			}
+		})
+	}
+}
+
+// subLookupCountingStore counts lookups by sub
+type subLookupCountingStore struct {
+	store.UserStore
+	lookups int
+}
+
+func (s *subLookupCountingStore) GetBySub(_ context.Context, sub string) (*model.User, error) {
+	s.lookups++
+	return s.UserStore.GetBySub(context.Background(), sub)
+}
+
+func TestEnforceUserStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := &subLookupCountingStore{UserStore: store.NewUserStore()}
+	for sub, status := range map[string]model.UserStatus{"sub-active": model.UserStatusActive, "sub-inactive": model.UserStatusInactive} {
+		user := model.NewUser(sub+"@example.com", "Test", "User")
+		user.Sub = sub
+		user.Status = string(status)
+		if err := userStore.Create(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &Server{userStore: userStore}
+
+	router := gin.New()
+	router.GET("/protected", func(c *gin.Context) {
+		c.Set("user_sub", c.Query("sub"))
+	}, s.enforceUserStatus, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		sub        string
+		wantStatus int
+	}{
+		{"active", "sub-active", http.StatusOK},
+		{"inactive", "sub-inactive", http.StatusForbidden},
+		{"missing user", "sub-missing", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/protected?sub="+tt.sub, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusForbidden && !strings.Contains(rec.Body.String(), `"code":"ACCOUNT_DISABLED"`) {
+				t.Errorf("response %s missing ACCOUNT_DISABLED code", rec.Body.String())
+			}
+		})
+	}
+
+	// Repeated requests are answered from the cache
+	lookups := userStore.lookups
+	for i := 0; i < 3; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/protected?sub=sub-active", nil))
+	}
+	if userStore.lookups != lookups {
+		t.Errorf("store looked up %d more times, want 0", userStore.lookups-lookups)
+	}
+}
+
+func TestGetPermissions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		claims     jwt.MapClaims
+		wantGroups []string
+		wantAdmin  bool
+	}{
+		{"admin", jwt.MapClaims{"sub": "sub-1", "cognito:groups": []interface{}{"admin", "editors"}}, []string{"admin", "editors"}, true},
+		{"other groups", jwt.MapClaims{"sub": "sub-2", "cognito:groups": []interface{}{"editors"}}, []string{"editors"}, false},
+		{"no groups", jwt.MapClaims{"sub": "sub-3"}, []string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			router := gin.New()
+			// Stands in for the JWT middleware, which sets the same values from validated claims
+			router.GET("/auth/permissions", func(c *gin.Context) {
+				sub, _ := auth.GetUserSubFromClaims(tt.claims)
+				c.Set("user_sub", sub)
+				if groups, ok := auth.GetUserGroupsFromClaims(tt.claims); ok {
+					c.Set("user_groups", groups)
+				}
+			}, s.getPermissions)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/permissions", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+
+			var response struct {
+				Sub     string   `json:"sub"`
+				Groups  []string `json:"groups"`
+				IsAdmin bool     `json:"isAdmin"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Sub != tt.claims["sub"] {
+				t.Errorf("sub = %q, want %q", response.Sub, tt.claims["sub"])
+			}
+			if response.Groups == nil || strings.Join(response.Groups, ",") != strings.Join(tt.wantGroups, ",") {
+				t.Errorf("groups = %#v, want %v", response.Groups, tt.wantGroups)
+			}
+			if response.IsAdmin != tt.wantAdmin {
+				t.Errorf("isAdmin = %t, want %t", response.IsAdmin, tt.wantAdmin)
+			}
+		})
+	}
+}
+
+// fakeMFACognito keeps the MFA state of one user, as Cognito does for the access token's owner
+type fakeMFACognito struct {
+	CognitoClient
+	secret      string
+	validCode   string
+	totpEnabled bool
+	smsEnabled  bool
+	tokens      []string
+}
+
+func (f *fakeMFACognito) GetUserMFAStatus(_ context.Context, accessToken string) (bool, string, error) {
+	f.tokens = append(f.tokens, accessToken)
+	switch {
+	case f.totpEnabled:
+		return true, "totp", nil
+	case f.smsEnabled:
+		return true, "sms", nil
+	}
+	return false, "", nil
+}
+
+func (f *fakeMFACognito) AssociateSoftwareToken(_ context.Context, accessToken string) (string, error) {
+	f.tokens = append(f.tokens, accessToken)
+	f.secret = "JBSWY3DPEHPK3PXP"
+	return f.secret, nil
+}
+
+func (f *fakeMFACognito) VerifySoftwareToken(_ context.Context, accessToken, code, deviceName string) error {
+	f.tokens = append(f.tokens, accessToken)
+	if f.secret == "" {
+		return &types.SoftwareTokenMFANotFoundException{Message: aws.String("Software token not found")}
+	}
+	if code != f.validCode {
+		return &types.EnableSoftwareTokenMFAException{Message: aws.String("Code mismatch")}
+	}
+	f.totpEnabled = true
+	return nil
+}
+
+func TestMFAEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cognito := &fakeMFACognito{validCode: "123456"}
+	s := &Server{config: &config.Config{}, cognitoClient: cognito}
+	router := gin.New()
+	// Stands in for the JWT middleware
+	authenticate := func(c *gin.Context) {
+		c.Set("access_token", "token-alice")
+		c.Set("user_email", "alice@example.com")
+	}
+	router.GET("/auth/mfa/status", authenticate, s.getMFAStatus)
+	router.POST("/auth/mfa/setup", authenticate, s.setupMFA)
+	router.POST("/auth/mfa/verify", authenticate, s.verifyMFA)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	status := func() (bool, string) {
+		t.Helper()
+		rec := request(http.MethodGet, "/auth/mfa/status", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status: got %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var response struct {
+			Enabled bool   `json:"enabled"`
+			Method  string `json:"method"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Enabled, response.Method
+	}
+
+	if enabled, method := status(); enabled || method != "" {
+		t.Errorf("before setup: got enabled=%t method=%q, want disabled", enabled, method)
+	}
+
+	// Verifying before setup has started is a conflict
+	if rec := request(http.MethodPost, "/auth/mfa/verify", `{"code":"123456"}`); rec.Code != http.StatusConflict {
+		t.Errorf("verify before setup: got %d, want 409: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := request(http.MethodPost, "/auth/mfa/setup", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("setup: got %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var setup struct {
+		SecretCode string `json:"secretCode"`
+		OTPAuthURI string `json:"otpauthUri"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &setup); err != nil {
+		t.Fatal(err)
+	}
+	if setup.SecretCode != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("secretCode = %q", setup.SecretCode)
+	}
+	if want := "otpauth://totp/aws_e2e_test:alice@example.com?issuer=aws_e2e_test&secret=JBSWY3DPEHPK3PXP"; setup.OTPAuthURI != want {
+		t.Errorf("otpauthUri = %q, want %q", setup.OTPAuthURI, want)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"malformed code", `{"code":"12ab56"}`, http.StatusBadRequest, "INVALID_CODE_FORMAT"},
+		{"wrong code", `{"code":"654321"}`, http.StatusBadRequest, "CODE_MISMATCH"},
+		{"correct code", `{"code":"123456","deviceName":"phone"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		rec := request(http.MethodPost, "/auth/mfa/verify", tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+		if tt.wantCode != "" && !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+			t.Errorf("%s: got body %s, want code %s", tt.name, rec.Body.String(), tt.wantCode)
+		}
+	}
+
+	if enabled, method := status(); !enabled || method != "totp" {
+		t.Errorf("after verify: got enabled=%t method=%q, want TOTP enabled", enabled, method)
+	}
+	for _, token := range cognito.tokens {
+		if token != "token-alice" {
+			t.Errorf("Cognito called with access token %q, want the caller's", token)
+		}
+	}
+}
+
+func TestPurgeUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	deletedAt := func(age time.Duration) *httputil.Timestamp {
+		ts := httputil.Timestamp(time.Now().Add(-age))
+		return &ts
+	}
+	newStore := func() store.UserStore {
+		userStore := store.NewUserStore()
+		users := []*model.User{
+			model.NewUser("eligible@example.com", "Old", "Deleted"),
+			model.NewUser("recent@example.com", "Recently", "Deleted"),
+			model.NewUser("active@example.com", "Still", "Here"),
+		}
+		users[0].Status, users[0].DeletedAt = string(model.UserStatusDeleted), deletedAt(40*24*time.Hour)
+		users[1].Status, users[1].DeletedAt = string(model.UserStatusDeleted), deletedAt(24*time.Hour)
+		users[2].CreatedAt = httputil.Timestamp(time.Now().Add(-365 * 24 * time.Hour))
+		for _, user := range users {
+			if err := userStore.Create(context.Background(), user); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return userStore
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantPurged  int
+		wantDeleted []string
+	}{
+		{"purge", "", 1, []string{"eligible@example.com"}},
+		{"dry run", "?dryRun=true", 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStore := newStore()
+			cognito := &fakeCognitoClient{}
+			s := &Server{
+				config:        &config.Config{UserPurgeAfter: 30 * 24 * time.Hour},
+				userStore:     userStore,
+				cognitoClient: cognito,
+			}
+			router := gin.New()
+			router.POST("/admin/users/purge", s.purgeUsers)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/users/purge"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			var response struct {
+				Purged int  `json:"purged"`
+				DryRun bool `json:"dryRun"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Purged != tt.wantPurged {
+				t.Errorf("purged = %d, want %d", response.Purged, tt.wantPurged)
+			}
+			if response.DryRun != (tt.query != "") {
+				t.Errorf("dryRun = %t", response.DryRun)
+			}
+			if strings.Join(cognito.adminDeleted, ",") != strings.Join(tt.wantDeleted, ",") {
+				t.Errorf("deleted from Cognito: %v, want %v", cognito.adminDeleted, tt.wantDeleted)
+			}
+
+			// Only the user deleted for longer than USER_PURGE_AFTER is gone from the database
+			for _, email := range []string{"eligible@example.com", "recent@example.com", "active@example.com"} {
+				exists, err := userStore.Exists(context.Background(), email)
+				if err != nil {
+					t.Fatal(err)
+				}
+				wantExists := email != "eligible@example.com" || tt.query != ""
+				if exists != wantExists {
+					t.Errorf("%s exists = %t, want %t", email, exists, wantExists)
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeEligible(t *testing.T) {
+	now := time.Now()
+	retention := 30 * 24 * time.Hour
+	at := func(age time.Duration) *httputil.Timestamp {
+		ts := httputil.Timestamp(now.Add(-age))
+		return &ts
+	}
+
+	tests := []struct {
+		name string
+		user *model.User
+		want bool
+	}{
+		{"deleted past retention", &model.User{Status: "DELETED", DeletedAt: at(31 * 24 * time.Hour)}, true},
+		{"deleted within retention", &model.User{Status: "DELETED", DeletedAt: at(29 * 24 * time.Hour)}, false},
+		{"active", &model.User{Status: "ACTIVE", UpdatedAt: *at(365 * 24 * time.Hour)}, false},
+		{"no DeletedAt, old update", &model.User{Status: "DELETED", UpdatedAt: *at(31 * 24 * time.Hour)}, true},
+		{"no DeletedAt, recent update", &model.User{Status: "DELETED", UpdatedAt: *at(time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := purgeEligible(tt.user, retention, now); got != tt.want {
+				t.Errorf("purgeEligible() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorResponseShape(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	alice := model.NewUser("alice@example.com", "Alice", "Smith")
+	alice.Sub = "sub-alice"
+	if err := userStore.Create(context.Background(), alice); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config:        &config.Config{PasswordMinLength: 8, RefreshCookieName: "refreshToken"},
+		cognitoClient: &fakeCognitoClient{},
+		userStore:     userStore,
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_sub", "sub-alice") })
+	router.POST("/auth/signup", s.signUp)
+	router.POST("/auth/refresh", s.refreshToken)
+	router.GET("/users/:email", s.getUserByEmail)
+	router.PUT("/users/:email", s.updateUser)
+	router.PATCH("/users/:email", s.patchUser)
+	router.POST("/admin/users/purge", s.purgeUsers)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+	}{
+		{"weak password", http.MethodPost, "/auth/signup", "application/json", `{"email":"alice@example.com","password":"short","firstName":"A","lastName":"B"}`, http.StatusBadRequest, "WEAK_PASSWORD"},
+		{"no refresh token", http.MethodPost, "/auth/refresh", "application/json", "", http.StatusBadRequest, "MISSING_REFRESH_TOKEN"},
+		{"not found", http.MethodGet, "/users/nobody@example.com", "", "", http.StatusNotFound, "USER_NOT_FOUND"},
+		{"another user", http.MethodPut, "/users/bob@example.com", "application/json", `{}`, http.StatusForbidden, "FORBIDDEN"},
+		{"wrong content type", http.MethodPatch, "/users/alice@example.com", "text/plain", `{}`, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE"},
+		{"bad parameter", http.MethodPost, "/admin/users/purge?dryRun=maybe", "", "", http.StatusBadRequest, "INVALID_PARAMETER"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			// Every error has exactly a code and a message
+			var body httputil.ErrorResponse
+			decoder := json.NewDecoder(rec.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&body); err != nil {
+				t.Fatalf("decoding error response: %v", err)
+			}
+			if body.Code != tt.wantCode || body.Error == "" {
+				t.Errorf("got code %q and error %q, want code %s and a message", body.Code, body.Error, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGetUserBySub(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	alice := model.NewUser("alice@example.com", "Alice", "Smith")
+	alice.Sub = "sub-alice"
+	if err := userStore.Create(context.Background(), alice); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: &config.Config{}, userStore: userStore}
+
+	tests := []struct {
+		name       string
+		admin      bool
+		sub        string
+		wantStatus int
+		wantCode   string
+	}{
+		{"found", true, "sub-alice", http.StatusOK, ""},
+		{"not found", true, "sub-nobody", http.StatusNotFound, "USER_NOT_FOUND"},
+		{"not an admin", false, "sub-alice", http.StatusForbidden, "ADMIN_REQUIRED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Set("user_sub", "sub-caller")
+				if tt.admin {
+					c.Set("user_groups", []string{auth.AdminGroup})
+				}
+			})
+			router.GET("/users/by-sub/:sub", auth.RequireAdminMiddleware(), s.getUserBySub)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/by-sub/"+tt.sub, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				if !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+					t.Errorf("response %s does not have code %s", rec.Body.String(), tt.wantCode)
+				}
+				return
+			}
+
+			var user model.UserResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+				t.Fatal(err)
+			}
+			if user.Email != "alice@example.com" || user.Sub != "sub-alice" {
+				t.Errorf("got user %+v, want alice", user)
+			}
+		})
+	}
+}
+
+func TestCanModifyUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	alice := model.NewUser("alice@example.com", "Alice", "Smith")
+	alice.Sub = "sub-alice"
+	if err := userStore.Create(context.Background(), alice); err != nil {
+		t.Fatal(err)
+	}
+	// Created with POST /users, so stored without a sub
+	bob := model.NewUser("bob@example.com", "Bob", "Jones")
+	if err := userStore.Create(context.Background(), bob); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: &config.Config{}, userStore: userStore}
+
+	tests := []struct {
+		name   string
+		sub    string
+		email  string
+		admin  bool
+		target string
+		want   bool
+	}{
+		{"own record", "sub-alice", "", false, "alice@example.com", true},
+		{"own record, other case", "sub-alice", "", false, "Alice@Example.com", true},
+		{"another user", "sub-alice", "", false, "bob@example.com", false},
+		{"unknown sub", "sub-nobody", "", false, "alice@example.com", false},
+		{"no sub", "", "", false, "alice@example.com", false},
+		{"no stored sub, access token", "sub-bob", "", false, "bob@example.com", false},
+		{"no stored sub, email claim", "sub-bob", "bob@example.com", false, "bob@example.com", true},
+		{"email claim of a user with another sub", "sub-mallory", "alice@example.com", false, "alice@example.com", false},
+		{"admin", "sub-admin", "", true, "bob@example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Access tokens carry the sub but no email claim; ID tokens carry both
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPut, "/users/"+tt.target, nil)
+			if tt.sub != "" {
+				c.Set("user_sub", tt.sub)
+			}
+			if tt.email != "" {
+				c.Set("user_email", tt.email)
+			}
+			if tt.admin {
+				c.Set("user_groups", []string{auth.AdminGroup})
+			}
+
+			got, err := s.canModifyUser(c, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("canModifyUser(%q) = %t, want %t", tt.target, got, tt.want)
+			}
+		})
+	}
+
+	// Finding bob by his email claim records his sub, so his access token finds him from now on
+	if user, _ := userStore.GetByEmail(context.Background(), "bob@example.com"); user == nil || user.Sub != "sub-bob" {
+		t.Errorf("stored bob = %+v, want sub sub-bob", user)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPut, "/users/bob@example.com", nil)
+	c.Set("user_sub", "sub-bob")
+	if got, err := s.canModifyUser(c, "bob@example.com"); err != nil || !got {
+		t.Errorf("canModifyUser with bob's access token = %t, %v, want true", got, err)
+	}
+}
+
+func TestLoginRecordsSub(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	// Created with POST /users, so stored without a sub
+	if err := userStore.Create(context.Background(), model.NewUser("bob@example.com", "Bob", "Jones")); err != nil {
+		t.Fatal(err)
+	}
+	cognito := &fakeCognitoClient{tokenSubs: map[string]string{"token-bob@example.com": "sub-bob"}}
+	s := &Server{config: &config.Config{}, cognitoClient: cognito, userStore: userStore}
+
+	router := gin.New()
+	router.POST("/auth/login", s.login)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"bob@example.com","password":"Password1!"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got status %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	user, err := userStore.GetBySub(context.Background(), "sub-bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.Email != "bob@example.com" {
+		t.Errorf("GetBySub(sub-bob) = %+v, want bob", user)
+	}
+}
+
+func TestUpdateCurrentUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	ada := model.NewUser("ada@example.com", "Ada", "Byron")
+	ada.Sub = "sub-ada"
+	if err := userStore.Create(context.Background(), ada); err != nil {
+		t.Fatal(err)
+	}
+	cognito := &fakeCognitoClient{}
+	s := &Server{config: &config.Config{}, cognitoClient: cognito, userStore: userStore}
+
+	request := func(sub string) *httptest.ResponseRecorder {
+		router := gin.New()
+		// Stands in for the JWT middleware with an access token, which has no email claim
+		router.Use(func(c *gin.Context) {
+			c.Set("access_token", "token-ada")
+			if sub != "" {
+				c.Set("user_sub", sub)
+			}
+		})
+		router.PUT("/users/me", s.updateCurrentUser)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/users/me", strings.NewReader(`{"lastName":"Lovelace"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := request(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no sub: got status %d, want 401: %s", rec.Code, rec.Body.String())
+	}
+	if rec := request("sub-nobody"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown sub: got status %d, want 404: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := request("sub-ada")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if got := cognito.updatedAttributes["family_name"]; got != "Lovelace" {
+		t.Errorf("Cognito family_name = %q, want Lovelace", got)
+	}
+	if user, _ := userStore.GetByEmail(context.Background(), "ada@example.com"); user == nil || user.LastName != "Lovelace" {
+		t.Errorf("stored user = %+v, want last name Lovelace", user)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		admin         bool
+		body          string
+		wantFirstName string
+		wantLastName  string
+		wantCognito   map[string]string
+	}{
+		{"set", false, `{"firstName":"Augusta"}`, "Augusta", "Byron", map[string]string{"given_name": "Augusta"}},
+		{"clear", false, `{"lastName":null}`, "Ada", "", map[string]string{"family_name": ""}},
+		{"clear with empty string", false, `{"lastName":""}`, "Ada", "", map[string]string{"family_name": ""}},
+		{"omit", false, `{}`, "Ada", "Byron", nil},
+		{"admin patching another user", true, `{"lastName":"Lovelace"}`, "Ada", "Lovelace", map[string]string{"family_name": "Lovelace"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStore := store.NewUserStore()
+			ada := model.NewUser("ada@example.com", "Ada", "Byron")
+			ada.Sub = "sub-ada"
+			if err := userStore.Create(context.Background(), ada); err != nil {
+				t.Fatal(err)
+			}
+			cognito := &fakeCognitoClient{}
+			s := &Server{config: &config.Config{}, cognitoClient: cognito, userStore: userStore}
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Set("access_token", "token")
+				if tt.admin {
+					c.Set("user_sub", "sub-admin")
+					c.Set("user_groups", []string{auth.AdminGroup})
+				} else {
+					c.Set("user_sub", "sub-ada")
+				}
+			})
+			router.PATCH("/users/:email", s.patchUser)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPatch, "/users/ada@example.com", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+
+			user, _ := userStore.GetByEmail(context.Background(), "ada@example.com")
+			if user.FirstName != tt.wantFirstName || user.LastName != tt.wantLastName {
+				t.Errorf("stored name %q %q, want %q %q", user.FirstName, user.LastName, tt.wantFirstName, tt.wantLastName)
+			}
+
+			// Users update their own attributes with their access token; administrators use the admin API
+			gotCognito, otherAPI := cognito.updatedAttributes, cognito.adminUpdated["ada@example.com"]
+			if tt.admin {
+				gotCognito, otherAPI = otherAPI, gotCognito
+			}
+			if fmt.Sprint(gotCognito) != fmt.Sprint(tt.wantCognito) {
+				t.Errorf("Cognito attributes = %v, want %v", gotCognito, tt.wantCognito)
+			}
+			if otherAPI != nil {
+				t.Errorf("attributes %v were also sent through the other Cognito API", otherAPI)
+			}
+		})
+	}
+}
+
+// fakeMessageDeleter stands in for the message service, holding the number of messages each
+// access token's user owns
+type fakeMessageDeleter struct {
+	messages map[string]int
+	err      error
+}
+
+func (f *fakeMessageDeleter) DeleteMine(_ context.Context, accessToken string) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	deleted := f.messages[accessToken]
+	delete(f.messages, accessToken)
+	return deleted, nil
+}
+
+func TestDeleteCurrentUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		sub          string
+		deleter      *fakeMessageDeleter
+		wantStatus   int
+		wantDeleted  any
+		wantUserGone bool
+	}{
+		{"no cascade", "sub-ada", nil, http.StatusOK, nil, true},
+		{"cascade, several messages", "sub-ada", &fakeMessageDeleter{messages: map[string]int{"token-sub-ada": 3}}, http.StatusOK, float64(3), true},
+		{"cascade, no messages", "sub-ada", &fakeMessageDeleter{messages: map[string]int{}}, http.StatusOK, float64(0), true},
+		{"cascade fails", "sub-ada", &fakeMessageDeleter{err: errors.New("connection refused")}, http.StatusBadGateway, nil, false},
+		{"unknown sub", "sub-nobody", nil, http.StatusNotFound, nil, false},
+		{"no sub", "", nil, http.StatusUnauthorized, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStore := store.NewUserStore()
+			ada := model.NewUser("ada@example.com", "Ada", "Lovelace")
+			ada.Sub = "sub-ada"
+			if err := userStore.Create(context.Background(), ada); err != nil {
+				t.Fatal(err)
+			}
+			cognito := &fakeCognitoClient{}
+			s := &Server{config: &config.Config{}, cognitoClient: cognito, userStore: userStore}
+			if tt.deleter != nil {
+				s.messageDeleter = tt.deleter
+			}
+
+			router := gin.New()
+			// Stands in for the JWT middleware with an access token, which has no email claim
+			router.Use(func(c *gin.Context) {
+				c.Set("access_token", "token-"+tt.sub)
+				if tt.sub != "" {
+					c.Set("user_sub", tt.sub)
+				}
+			})
+			router.DELETE("/users/me", s.deleteCurrentUser)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/users/me", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				var body map[string]any
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if got := body["messagesDeleted"]; got != tt.wantDeleted {
+					t.Errorf("messagesDeleted = %v, want %v", got, tt.wantDeleted)
+				}
+			}
+
+			user, err := userStore.GetByEmail(context.Background(), "ada@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gone := user == nil; gone != tt.wantUserGone {
+				t.Errorf("user deleted = %t, want %t", gone, tt.wantUserGone)
+			}
+			if tt.wantUserGone && !slices.Equal(cognito.adminDeleted, []string{"ada@example.com"}) {
+				t.Errorf("deleted from Cognito %v, want [ada@example.com]", cognito.adminDeleted)
+			}
+			if !tt.wantUserGone && len(cognito.adminDeleted) != 0 {
+				t.Errorf("deleted from Cognito %v, want nothing", cognito.adminDeleted)
+			}
+		})
+	}
+}
+
+func TestUpdateUserStrictJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		strict       bool
+		wantStatus   int
+		wantLastName string
+	}{
+		{"lenient ignores the typo", false, http.StatusOK, "Lovelace"},
+		{"strict rejects the typo", true, http.StatusBadRequest, "Byron"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStore := store.NewUserStore()
+			ada := model.NewUser("ada@example.com", "Ada", "Byron")
+			ada.Sub = "sub-ada"
+			if err := userStore.Create(context.Background(), ada); err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{config: &config.Config{StrictJSON: tt.strict}, userStore: userStore}
+			router := gin.New()
+			router.Use(func(c *gin.Context) { c.Set("user_sub", "sub-ada") })
+			router.PUT("/users/:email", s.updateUser)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/users/ada@example.com", strings.NewReader(`{"frstName":"Augusta","lastName":"Lovelace"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			if tt.strict {
+				var body struct {
+					Code  string `json:"code"`
+					Field string `json:"field"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Code != "UNKNOWN_FIELD" || body.Field != "frstName" {
+					t.Errorf("got code %q and field %q, want UNKNOWN_FIELD and frstName", body.Code, body.Field)
+				}
+			}
+
+			user, err := userStore.GetByEmail(context.Background(), "ada@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.FirstName != "Ada" || user.LastName != tt.wantLastName {
+				t.Errorf("stored name %s %s, want Ada %s", user.FirstName, user.LastName, tt.wantLastName)
+			}
+		})
+	}
+}
+
+func TestUpdateUserStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		admin      bool
+		wantStatus int
+		wantUser   string
+	}{
+		{"own record", false, http.StatusForbidden, string(model.UserStatusInactive)},
+		{"admin", true, http.StatusOK, string(model.UserStatusActive)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userStore := store.NewUserStore()
+			ada := model.NewUser("ada@example.com", "Ada", "Byron")
+			ada.Sub = "sub-ada"
+			ada.Status = string(model.UserStatusInactive)
+			if err := userStore.Create(context.Background(), ada); err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{config: &config.Config{}, userStore: userStore}
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				if tt.admin {
+					c.Set("user_sub", "sub-admin")
+					c.Set("user_groups", []string{auth.AdminGroup})
+				} else {
+					c.Set("user_sub", "sub-ada")
+				}
+			})
+			router.PUT("/users/:email", s.updateUser)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/users/ada@example.com", strings.NewReader(`{"status":"ACTIVE"}`))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusForbidden && !strings.Contains(rec.Body.String(), `"code":"FORBIDDEN"`) {
+				t.Errorf("got body %s, want code FORBIDDEN", rec.Body.String())
+			}
+
+			user, _ := userStore.GetByEmail(context.Background(), "ada@example.com")
+			if user.Status != tt.wantUser {
+				t.Errorf("stored status %s, want %s", user.Status, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestSignUpReturnsCreatedUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	s := &Server{
+		config:        &config.Config{PasswordMinLength: 8},
+		cognitoClient: &fakeCognitoClient{},
+		userStore:     userStore,
+	}
+	router := gin.New()
+	router.POST("/auth/signup", s.signUp)
+
+	body := `{"email":"ada+test@example.com","password":"longenough","firstName":"Ada","lastName":"Lovelace"}`
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/signup", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	if location := rec.Header().Get("Location"); location != "/users/ada+test@example.com" {
+		t.Errorf("got Location %q, want /users/ada+test@example.com", location)
+	}
+
+	// The body is the stored record, so clients need not fetch it again
+	stored, err := userStore.GetByEmail(context.Background(), "ada+test@example.com")
+	if err != nil || stored == nil {
+		t.Fatalf("user not stored: %v", err)
+	}
+	want, err := json.Marshal(stored.ToResponse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Body.String() != string(want) {
+		t.Errorf("got body %s, want the stored user %s", rec.Body.String(), want)
+	}
+}
+
+// fakeEmailCognito keeps the email of one user, as Cognito does for the access token's owner
+type fakeEmailCognito struct {
+	CognitoClient
+	email        string
+	pendingEmail string
+	validCode    string
+}
+
+func (f *fakeEmailCognito) ChangeEmail(_ context.Context, accessToken, newEmail string) (*model.CodeDelivery, error) {
+	f.pendingEmail = newEmail
+	return &model.CodeDelivery{Destination: "b***@e***", DeliveryMedium: "EMAIL", AttributeName: "email"}, nil
+}
+
+func (f *fakeEmailCognito) VerifyEmail(_ context.Context, accessToken, code string) (string, error) {
+	if code != f.validCode {
+		return "", &types.CodeMismatchException{Message: aws.String("Invalid verification code")}
+	}
+	if f.pendingEmail != "" {
+		f.email, f.pendingEmail = f.pendingEmail, ""
+	}
+	return f.email, nil
+}
+
+func TestChangeEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	alice := model.NewUser("alice@example.com", "Alice", "Smith")
+	alice.Sub = "sub-alice"
+	if err := userStore.Create(context.Background(), alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := userStore.Create(context.Background(), model.NewUser("carol@example.com", "Carol", "Jones")); err != nil {
+		t.Fatal(err)
+	}
+
+	cognito := &fakeEmailCognito{email: "alice@example.com", validCode: "123456"}
+	s := &Server{
+		config:        &config.Config{ConfirmationCodeLength: 6},
+		cognitoClient: cognito,
+		userStore:     userStore,
+	}
+	router := gin.New()
+	// Stands in for the JWT middleware with an access token, which has no email claim
+	authenticate := func(c *gin.Context) {
+		c.Set("access_token", "token-alice")
+		c.Set("user_sub", "sub-alice")
+	}
+	router.POST("/users/me/email", authenticate, s.changeEmail)
+	router.POST("/users/me/email/verify", authenticate, s.verifyEmail)
+
+	request := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid email", `{"email":"not-an-email"}`, http.StatusBadRequest, "INVALID_BODY"},
+		{"same email", `{"email":"Alice@example.com"}`, http.StatusBadRequest, "EMAIL_UNCHANGED"},
+		{"taken email", `{"email":"carol@example.com"}`, http.StatusConflict, "USER_EXISTS"},
+	}
+	for _, tt := range tests {
+		rec := request("/users/me/email", tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+			t.Errorf("%s: got body %s, want code %s", tt.name, rec.Body.String(), tt.wantCode)
+		}
+	}
+	if cognito.pendingEmail != "" {
+		t.Fatalf("rejected requests reached Cognito with %q", cognito.pendingEmail)
+	}
+
+	rec := request("/users/me/email", `{"email":"bob@example.com"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("change: got status %d, want 202: %s", rec.Code, rec.Body.String())
+	}
+	var change struct {
+		CodeDelivery model.CodeDelivery `json:"codeDelivery"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &change); err != nil {
+		t.Fatal(err)
+	}
+	if change.CodeDelivery.DeliveryMedium != "EMAIL" || change.CodeDelivery.Destination != "b***@e***" {
+		t.Errorf("got code delivery %+v", change.CodeDelivery)
+	}
+
+	// The record keeps the old email until the change is verified
+	if user, _ := userStore.GetByEmail(context.Background(), "alice@example.com"); user == nil {
+		t.Fatal("user moved before verification")
+	}
+
+	for _, tt := range []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"malformed code", `{"code":"12ab56"}`, http.StatusBadRequest, "INVALID_CODE_FORMAT"},
+		{"wrong code", `{"code":"654321"}`, http.StatusBadRequest, "CODE_MISMATCH"},
+	} {
+		rec := request("/users/me/email/verify", tt.body)
+		if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+			t.Errorf("%s: got status %d body %s, want %d %s", tt.name, rec.Code, rec.Body.String(), tt.wantStatus, tt.wantCode)
+		}
+	}
+
+	// Verifying twice is harmless, the second call finds the record already moved
+	for i := 0; i < 2; i++ {
+		rec = request("/users/me/email/verify", `{"code":"123456"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("verify %d: got status %d, want 200: %s", i+1, rec.Code, rec.Body.String())
+		}
+		var user model.UserResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "bob@example.com" || user.FirstName != "Alice" {
+			t.Errorf("verify %d: got user %s %s, want Alice at bob@example.com", i+1, user.FirstName, user.Email)
+		}
+	}
+
+	if user, _ := userStore.GetByEmail(context.Background(), "alice@example.com"); user != nil {
+		t.Error("old email still has a record after verification")
+	}
+	moved, err := userStore.GetBySub(context.Background(), "sub-alice")
+	if err != nil || moved == nil {
+		t.Fatalf("user not found by sub after verification: %v", err)
+	}
+	if moved.Email != "bob@example.com" || moved.CreatedAt != alice.CreatedAt {
+		t.Errorf("got moved user %+v, want bob@example.com with the original CreatedAt", moved)
+	}
+}
+
+func TestCreatedBy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userStore := store.NewUserStore()
+	// A record from before CreatedBy was tracked counts as a signup
+	if err := userStore.Create(context.Background(), model.NewUser("legacy@example.com", "Old", "Timer")); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config:        &config.Config{PasswordMinLength: 8},
+		cognitoClient: &fakeCognitoClient{},
+		userStore:     userStore,
+	}
+	router := gin.New()
+	// Stands in for the JWT middleware: X-Test-Sub is the caller, X-Test-Admin makes them an admin
+	router.Use(func(c *gin.Context) {
+		if sub := c.GetHeader("X-Test-Sub"); sub != "" {
+			c.Set("user_sub", sub)
+		}
+		if c.GetHeader("X-Test-Admin") == "true" {
+			c.Set("user_groups", []string{auth.AdminGroup})
+		}
+	})
+	router.POST("/auth/signup", s.signUp)
+	router.POST("/users", s.createUser)
+	router.GET("/users/:email", s.getUserByEmail)
+	router.GET("/admin/users", s.adminListUsers)
+
+	request := func(method, path, body, sub string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Test-Sub", sub)
+		if admin {
+			req.Header.Set("X-Test-Admin", "true")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+		}
+	}
+
+	rec := request(http.MethodPost, "/auth/signup", `{"email":"self@example.com","password":"longenough","firstName":"Sel","lastName":"F"}`, "", false)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = request(http.MethodPost, "/users", `{"email":"made@example.com","firstName":"Ma","lastName":"De"}`, "sub-admin", true)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var created model.UserResponse
+	decode(rec, &created)
+	if created.CreatedBy != "sub-admin" {
+		t.Errorf("create response has createdBy %q, want the admin's sub", created.CreatedBy)
+	}
+	// A user created by someone who is not an administrator is not recorded as created by one
+	rec = request(http.MethodPost, "/users", `{"email":"other@example.com","firstName":"Ot","lastName":"Her"}`, "sub-someone", false)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create as non-admin: got status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for email, want := range map[string]string{"self@example.com": model.CreatedBySelf, "made@example.com": "sub-admin", "other@example.com": model.CreatedBySelf} {
+		user, err := userStore.GetByEmail(context.Background(), email)
+		if err != nil || user == nil {
+			t.Fatalf("%s not stored: %v", email, err)
+		}
+		if user.CreatedBy != want {
+			t.Errorf("%s stored with CreatedBy %q, want %q", email, user.CreatedBy, want)
+		}
+	}
+
+	// Only administrators see who created a user
+	rec = request(http.MethodGet, "/users/made@example.com", "", "sub-someone", false)
+	if strings.Contains(rec.Body.String(), "createdBy") {
+		t.Errorf("non-admin response shows createdBy: %s", rec.Body.String())
+	}
+	rec = request(http.MethodGet, "/users/legacy@example.com", "", "sub-admin", true)
+	var legacy model.UserResponse
+	decode(rec, &legacy)
+	if legacy.CreatedBy != model.CreatedBySelf {
+		t.Errorf("legacy user shown to admin with createdBy %q, want self", legacy.CreatedBy)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"legacy@example.com", "self@example.com", "made@example.com", "other@example.com"}},
+		{"?createdBy=self", []string{"legacy@example.com", "self@example.com", "other@example.com"}},
+		{"?createdBy=admin", []string{"made@example.com"}},
+	}
+	for _, tt := range tests {
+		rec := request(http.MethodGet, "/admin/users"+tt.query, "", "sub-admin", true)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /admin/users%s: got status %d: %s", tt.query, rec.Code, rec.Body.String())
+		}
+		var users []model.UserResponse
+		if err := decodeList(rec.Body.Bytes(), &users); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+		}
+		var emails []string
+		for _, user := range users {
+			emails = append(emails, user.Email)
+			if user.CreatedBy == "" {
+				t.Errorf("GET /admin/users%s: %s has no createdBy", tt.query, user.Email)
+			}
+		}
+		if !slices.Equal(emails, tt.want) {
+			t.Errorf("GET /admin/users%s = %v, want %v", tt.query, emails, tt.want)
+		}
+	}
+
+	if rec := request(http.MethodGet, "/admin/users?createdBy=robot", "", "sub-admin", true); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid createdBy: got status %d, want 400", rec.Code)
+	}
+}
+
+// decodeList decodes a list response, checking that its count matches its items
+func decodeList[T any](body []byte, items *[]T) error {
+	var list httputil.ListResponse[T]
+	if err := json.Unmarshal(body, &list); err != nil {
+		return err
+	}
+	if list.Count != len(list.Items) {
+		return fmt.Errorf("count is %d, but there are %d items", list.Count, len(list.Items))
+	}
+	*items = list.Items
+	return nil
+}
@@ -0,0 +1,96 @@
+package usersvc
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws_e2e_test/shared/auth"
+	"github.com/aws_e2e_test/shared/httputil"
+	"github.com/aws_e2e_test/usersvc/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// userStatusTTL is how long a looked-up account status is reused, so a disabled account may
+// keep access for up to this long
+const userStatusTTL = 30 * time.Second
+
+// maxUserStatusEntries bounds the status cache; it is cleared when full
+const maxUserStatusEntries = 10000
+
+// userStatusCache caches account statuses by token identity, so ENFORCE_USER_STATUS does not
+// read the user store on every authenticated request
+type userStatusCache struct {
+	mutex   sync.Mutex
+	entries map[string]userStatusEntry
+}
+
+// userStatusEntry is a cached status; an empty status means the user was not found
+type userStatusEntry struct {
+	status    string
+	fetchedAt time.Time
+}
+
+// get returns the status cached for key, if it has not expired
+func (u *userStatusCache) get(key string) (string, bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	entry, ok := u.entries[key]
+	if !ok || time.Since(entry.fetchedAt) >= userStatusTTL {
+		return "", false
+	}
+	return entry.status, true
+}
+
+// set caches the status for key
+func (u *userStatusCache) set(key, status string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.entries == nil || len(u.entries) >= maxUserStatusEntries {
+		u.entries = make(map[string]userStatusEntry)
+	}
+	u.entries[key] = userStatusEntry{status: status, fetchedAt: time.Now()}
+}
+
+// enforceUserStatus is a post-validation hook that rejects tokens of users who are missing from
+// the user store or whose status is not ACTIVE. Users are looked up by sub, or by email for
+// tokens without a sub.
+func (s *Server) enforceUserStatus(c *gin.Context) {
+	var key string
+	var lookup func() (*model.User, error)
+	if sub, ok := auth.GetUserSubFromContext(c); ok && sub != "" {
+		key = "sub:" + sub
+		lookup = func() (*model.User, error) { return s.userStore.GetBySub(c.Request.Context(), sub) }
+	} else if email, ok := auth.GetUserEmailFromContext(c); ok && email != "" {
+		key = "email:" + email
+		lookup = func() (*model.User, error) { return s.userStore.GetByEmail(c.Request.Context(), email) }
+	} else {
+		httputil.RespondError(c, http.StatusForbidden, "ACCOUNT_DISABLED", "Token does not identify a user")
+		c.Abort()
+		return
+	}
+
+	status, ok := s.userStatuses.get(key)
+	if !ok {
+		user, err := lookup()
+		if err != nil {
+			log.Printf("Error looking up user status for %s: %v", key, err)
+			httputil.RespondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to check account status")
+			c.Abort()
+			return
+		}
+		status = ""
+		if user != nil {
+			status = user.Status
+		}
+		s.userStatuses.set(key, status)
+	}
+
+	if status != string(model.UserStatusActive) {
+		httputil.RespondError(c, http.StatusForbidden, "ACCOUNT_DISABLED", "Account is disabled")
+		c.Abort()
+	}
+}
@@ -0,0 +1,76 @@
+package usersvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws_e2e_test/shared/awsutil"
+	"github.com/aws_e2e_test/usersvc/internal/config"
+	"github.com/aws_e2e_test/usersvc/internal/store"
+)
+
+// NewStore creates the user store selected by STORAGE_BACKEND, wrapped in the decorators
+// enabled by configuration
+func NewStore(cfg *config.Config) (UserStore, error) {
+	var userStore UserStore
+
+	switch cfg.StorageBackend {
+	case "dynamodb":
+		dynamoDBStore, err := store.NewDynamoDBUserStore(store.DynamoDBUserStoreConfig{
+			TableName:       cfg.DynamoDBTableName,
+			TablePrefix:     cfg.DynamoDBTablePrefix,
+			TableSuffix:     cfg.DynamoDBTableSuffix,
+			AutoCreateTable: cfg.DynamoDBAutoCreateTable,
+			Endpoint:        cfg.DynamoDBEndpoint,
+			HTTPClient:      cfg.DynamoDBHTTPClient,
+		})
+		if errors.Is(err, awsutil.ErrNoCredentials) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to create DynamoDB user store: %v", err)
+			log.Printf("ERROR: Stack trace: %+v", err)
+			log.Printf("CRITICAL: Falling back to in-memory user store (WARNING: not suitable for multiple instances)")
+			userStore = store.NewUserStore()
+		} else {
+			// Optionally verify read/write access so permission problems fail the deploy
+			if cfg.StartupSelfTest {
+				if err := dynamoDBStore.SelfTest(context.Background()); err != nil {
+					log.Printf("ERROR: Startup self-test failed: %v", err)
+					return nil, err
+				}
+			}
+			userStore = dynamoDBStore
+		}
+	case "memory":
+		log.Println("STORAGE: Using in-memory user store (suitable for local development only)")
+		log.Println("STORAGE: Set STORAGE_BACKEND=dynamodb for production/multi-instance deployments")
+		userStore = store.NewUserStore()
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_BACKEND '%s': expected 'memory' or 'dynamodb'", cfg.StorageBackend)
+	}
+
+	if cfg.StoreMetrics {
+		userStore = &metricsStore{next: userStore}
+	}
+	return userStore, nil
+}
+
+// storeUnwrapper is implemented by decorators, so that optional interfaces of the underlying
+// store (such as readinessChecker) can still be found
+type storeUnwrapper interface {
+	Unwrap() UserStore
+}
+
+// baseStore returns the store underneath any decorators
+func baseStore(userStore UserStore) UserStore {
+	for {
+		unwrapper, ok := userStore.(storeUnwrapper)
+		if !ok {
+			return userStore
+		}
+		userStore = unwrapper.Unwrap()
+	}
+}
@@ -0,0 +1,61 @@
+package usersvc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws_e2e_test/usersvc/internal/config"
+	"github.com/aws_e2e_test/usersvc/internal/model"
+	"github.com/aws_e2e_test/usersvc/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNewStore(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		userStore, err := NewStore(&config.Config{StorageBackend: "memory"})
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		if _, ok := userStore.(*store.InMemoryUserStore); !ok {
+			t.Errorf("got %T, want *store.InMemoryUserStore", userStore)
+		}
+	})
+
+	t.Run("memory with metrics", func(t *testing.T) {
+		userStore, err := NewStore(&config.Config{StorageBackend: "memory", StoreMetrics: true})
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		if _, ok := userStore.(*metricsStore); !ok {
+			t.Fatalf("got %T, want *metricsStore", userStore)
+		}
+		if _, ok := baseStore(userStore).(*store.InMemoryUserStore); !ok {
+			t.Errorf("base store is %T, want *store.InMemoryUserStore", baseStore(userStore))
+		}
+
+		before := storeCallCount(t, "create")
+		if err := userStore.Create(context.Background(), model.NewUser("alice@example.com", "Alice", "Example")); err != nil {
+			t.Fatal(err)
+		}
+		if got := storeCallCount(t, "create") - before; got != 1 {
+			t.Errorf("recorded %d create calls, want 1", got)
+		}
+	})
+
+	t.Run("unknown backend", func(t *testing.T) {
+		if _, err := NewStore(&config.Config{StorageBackend: "postgres"}); err == nil {
+			t.Error("NewStore accepted an unknown backend")
+		}
+	})
+}
+
+// storeCallCount returns the number of successful store calls recorded for operation
+func storeCallCount(t *testing.T, operation string) uint64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := storeCallDuration.WithLabelValues(operation, "success").(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
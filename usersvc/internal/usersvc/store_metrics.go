@@ -0,0 +1,98 @@
+package usersvc
+
+import (
+	"context"
+	"time"
+
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws_e2e_test/usersvc/internal/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// storeCallDuration measures user store calls by operation and outcome, when STORE_METRICS is
+// enabled
+var storeCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "usersvc_store_call_duration_seconds",
+	Help:    "Duration of user store calls, by operation and outcome.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation", "outcome"})
+
+// observeStoreCall records a store call that started at start and returned *err
+func observeStoreCall(operation string, start time.Time, err *error) {
+	outcome := "success"
+	if *err != nil {
+		outcome = "error"
+	}
+	storeCallDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// metricsStore is a UserStore decorator that records the duration of every call
+type metricsStore struct {
+	next UserStore
+}
+
+// Unwrap returns the decorated store
+func (m *metricsStore) Unwrap() UserStore {
+	return m.next
+}
+
+func (m *metricsStore) GetByEmail(ctx context.Context, email string) (user *model.User, err error) {
+	defer observeStoreCall("get_by_email", time.Now(), &err)
+	return m.next.GetByEmail(ctx, email)
+}
+
+func (m *metricsStore) Exists(ctx context.Context, email string) (exists bool, err error) {
+	defer observeStoreCall("exists", time.Now(), &err)
+	return m.next.Exists(ctx, email)
+}
+
+func (m *metricsStore) GetBySub(ctx context.Context, sub string) (user *model.User, err error) {
+	defer observeStoreCall("get_by_sub", time.Now(), &err)
+	return m.next.GetBySub(ctx, sub)
+}
+
+func (m *metricsStore) GetAll(ctx context.Context) (users []*model.User, err error) {
+	defer observeStoreCall("get_all", time.Now(), &err)
+	return m.next.GetAll(ctx)
+}
+
+func (m *metricsStore) GetRecent(ctx context.Context, limit int) (users []*model.User, err error) {
+	defer observeStoreCall("get_recent", time.Now(), &err)
+	return m.next.GetRecent(ctx, limit)
+}
+
+func (m *metricsStore) CountUsers(ctx context.Context) (count int64, err error) {
+	defer observeStoreCall("count_users", time.Now(), &err)
+	return m.next.CountUsers(ctx)
+}
+
+func (m *metricsStore) Create(ctx context.Context, user *model.User) (err error) {
+	defer observeStoreCall("create", time.Now(), &err)
+	return m.next.Create(ctx, user)
+}
+
+func (m *metricsStore) GetOrCreate(ctx context.Context, user *model.User) (existing *model.User, created bool, err error) {
+	defer observeStoreCall("get_or_create", time.Now(), &err)
+	return m.next.GetOrCreate(ctx, user)
+}
+
+func (m *metricsStore) CreateWithInit(ctx context.Context, user *model.User, initItems ...map[string]dynamodbtypes.AttributeValue) (err error) {
+	defer observeStoreCall("create_with_init", time.Now(), &err)
+	return m.next.CreateWithInit(ctx, user, initItems...)
+}
+
+func (m *metricsStore) Update(ctx context.Context, user *model.User) (err error) {
+	defer observeStoreCall("update", time.Now(), &err)
+	return m.next.Update(ctx, user)
+}
+
+func (m *metricsStore) ChangeEmail(ctx context.Context, oldEmail string, user *model.User) (err error) {
+	defer observeStoreCall("change_email", time.Now(), &err)
+	return m.next.ChangeEmail(ctx, oldEmail, user)
+}
+
+func (m *metricsStore) Delete(ctx context.Context, email string) (err error) {
+	defer observeStoreCall("delete", time.Now(), &err)
+	return m.next.Delete(ctx, email)
+}